@@ -0,0 +1,540 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRequestBody - наибольший размер тела JSON-запроса
+const maxRequestBody = 1 << 20
+
+// registerRequest - тело запроса регистрации посылки
+type registerRequest struct {
+	Client       int    `json:"client"` // Для роли client можно не указывать
+	Address      string `json:"address"`
+	ServiceLevel string `json:"service_level,omitempty"` // По умолчанию standard
+}
+
+// addressRequest - тело запроса смены адреса
+type addressRequest struct {
+	Address string `json:"address"`
+}
+
+// apiKeyRequest - тело запроса выпуска API-ключа
+type apiKeyRequest struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Client int    `json:"client"`
+}
+
+// apiKeyResponse - выпущенный API-ключ. Значение ключа возвращается только один раз
+type apiKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// webhookRequest - тело запроса подписки на уведомления
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"` // Ключ подписи, по умолчанию генерируется
+	Events []string `json:"events,omitempty"` // Типы событий, по умолчанию все события
+}
+
+// handleRegister - POST /parcels: регистрация посылки
+func (srv *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if id, ok := IdentityFromContext(r.Context()); ok && id.Role == RoleClient && req.Client == 0 {
+		req.Client = id.Client
+	}
+	if req.Client <= 0 || req.Address == "" {
+		writeError(w, http.StatusBadRequest, "client and address are required")
+		return
+	}
+
+	// Повтор запроса с тем же заголовком Idempotency-Key возвращает ранее зарегистрированную посылку
+	var opts []RegisterOption
+	if req.ServiceLevel != "" {
+		opts = append(opts, WithServiceLevel(req.ServiceLevel))
+	}
+	var parcel Parcel
+	var err error
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		var replayed bool
+		parcel, replayed, err = srv.service.RegisterIdempotent(r.Context(), key, req.Client, req.Address, opts...)
+		if replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+	} else {
+		parcel, err = srv.service.RegisterContext(r.Context(), req.Client, req.Address, opts...)
+	}
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, parcel)
+}
+
+// handleListParcels - GET /parcels?client=N: посылки клиента. Для роли client по умолчанию - свои посылки.
+// GET /parcels?address=...: посылки с адресом, клиенту - только свои
+func (srv *Server) handleListParcels(w http.ResponseWriter, r *http.Request) {
+	if address := r.URL.Query().Get("address"); address != "" {
+		parcels, err := srv.service.FindByAddress(r.Context(), address)
+		if err != nil {
+			srv.writeServiceError(w, r, err)
+			return
+		}
+		if parcels == nil {
+			parcels = []Parcel{}
+		}
+		writeJSON(w, http.StatusOK, parcels)
+		return
+	}
+
+	client := 0
+	if id, ok := IdentityFromContext(r.Context()); ok && id.Role == RoleClient {
+		client = id.Client
+	}
+	if v := r.URL.Query().Get("client"); v != "" {
+		var err error
+		if client, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid client id %q", v))
+			return
+		}
+	}
+	if client == 0 {
+		writeError(w, http.StatusBadRequest, "client query parameter is required")
+		return
+	}
+
+	parcels, err := srv.service.ClientParcels(r.Context(), client)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	if parcels == nil {
+		parcels = []Parcel{}
+	}
+	writeJSON(w, http.StatusOK, parcels)
+}
+
+// handleSearchParcels - GET /parcels/search?q=...&limit=N: полнотекстовый поиск посылок по части адреса
+func (srv *Server) handleSearchParcels(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q", v))
+			return
+		}
+	}
+
+	matches, err := srv.service.SearchAddress(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	if matches == nil {
+		matches = []AddressMatch{}
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// handleReport - GET /reports?from=2006-01-02&to=2006-01-02&client=N&format=json|csv: статистика посылок за период.
+// Клиент получает отчёт только по своим посылкам
+func (srv *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	client := 0
+	if v := q.Get("client"); v != "" {
+		var err error
+		if client, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid client id %q", v))
+			return
+		}
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = ReportFormatJSON
+	}
+	if format != ReportFormatJSON && format != ReportFormatCSV {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown report format %q, expected json or csv", format))
+		return
+	}
+
+	filter, err := ParseReportFilter(q.Get("from"), q.Get("to"), client, time.Now())
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	report, err := srv.service.Report(r.Context(), filter)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+
+	if format == ReportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := report.Write(w, format); err != nil {
+		srv.logger.ErrorContext(r.Context(), "failed to write report", slog.Any(attrError, err))
+	}
+}
+
+// handleOverdueParcels - GET /parcels/overdue: недоставленные посылки с истекшим сроком доставки
+func (srv *Server) handleOverdueParcels(w http.ResponseWriter, r *http.Request) {
+	parcels, err := srv.service.OverdueParcels(r.Context())
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, parcels)
+}
+
+// handleGetParcel - GET /parcels/{number}: посылка по номеру
+func (srv *Server) handleGetParcel(w http.ResponseWriter, r *http.Request) {
+	number, ok := parcelNumber(w, r)
+	if !ok {
+		return
+	}
+
+	parcel, err := srv.service.Parcel(r.Context(), number)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, parcel)
+}
+
+// handleNextStatus - POST /parcels/{number}/next-status: перевод посылки в следующий статус
+func (srv *Server) handleNextStatus(w http.ResponseWriter, r *http.Request) {
+	number, ok := parcelNumber(w, r)
+	if !ok {
+		return
+	}
+
+	advanced, err := srv.service.nextStatus(r.Context(), number)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	if !advanced {
+		writeError(w, http.StatusConflict, fmt.Sprintf("parcel %d is already delivered", number))
+		return
+	}
+	srv.handleGetParcel(w, r)
+}
+
+// handleChangeAddress - PUT /parcels/{number}/address: смена адреса зарегистрированной посылки
+func (srv *Server) handleChangeAddress(w http.ResponseWriter, r *http.Request) {
+	number, ok := parcelNumber(w, r)
+	if !ok {
+		return
+	}
+	var req addressRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Address == "" {
+		writeError(w, http.StatusBadRequest, "address is required")
+		return
+	}
+
+	updated, err := srv.service.changeAddress(r.Context(), number, req.Address)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	if !updated {
+		srv.writeDenied(w, r, number)
+		return
+	}
+	srv.handleGetParcel(w, r)
+}
+
+// handleDeleteParcel - DELETE /parcels/{number}: удаление зарегистрированной посылки
+func (srv *Server) handleDeleteParcel(w http.ResponseWriter, r *http.Request) {
+	number, ok := parcelNumber(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := srv.service.delete(r.Context(), number)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	if !deleted {
+		srv.writeDenied(w, r, number)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateAPIKey - POST /api-keys: выпуск API-ключа, только для администратора
+func (srv *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeRole(r.Context(), RoleAdmin); err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	var req apiKeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	role, err := ParseRole(req.Role)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name == "" || (role == RoleClient && req.Client <= 0) {
+		writeError(w, http.StatusBadRequest, "name is required, role client also requires client id")
+		return
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	k := APIKey{Name: req.Name, Role: role, Client: req.Client}
+	k.ID, err = srv.service.store.AddAPIKey(r.Context(), k, key)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, apiKeyResponse{APIKey: k, Key: key})
+}
+
+// handleRevokeAPIKey - DELETE /api-keys/{id}: отзыв API-ключа, только для администратора
+func (srv *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeRole(r.Context(), RoleAdmin); err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid api key id %q", r.PathValue("id")))
+		return
+	}
+
+	if err := srv.service.store.RevokeAPIKey(r.Context(), id); err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// quotaRequest - тело запроса установки дневной квоты регистраций
+type quotaRequest struct {
+	DailyRegistrations *int `json:"daily_registrations"` // null снимает квоту
+}
+
+// handleSetClientQuota - PUT /clients/{client}/quota: дневная квота регистраций клиента, только для администратора
+func (srv *Server) handleSetClientQuota(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeRole(r.Context(), RoleAdmin); err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	client, ok := clientID(w, r)
+	if !ok {
+		return
+	}
+	var req quotaRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	limit := -1
+	if req.DailyRegistrations != nil {
+		if limit = *req.DailyRegistrations; limit < 0 {
+			writeError(w, http.StatusBadRequest, "daily_registrations must not be negative")
+			return
+		}
+	}
+	if err := srv.service.store.SetClientQuota(r.Context(), client, limit); err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleExportClient - GET /clients/{client}/export: все данные клиента одним документом JSON.
+// Клиент может выгрузить только свои данные
+func (srv *Server) handleExportClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientID(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := srv.service.ExportClientData(r.Context(), client)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="client-%d.json"`, client))
+	writeJSON(w, http.StatusOK, export)
+}
+
+// handleAnonymizeClient - POST /clients/{client}/anonymize: необратимое обезличивание посылок клиента, только для администратора
+func (srv *Server) handleAnonymizeClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientID(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := srv.service.AnonymizeClient(r.Context(), client)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"anonymized_parcels": count})
+}
+
+// handleCreateWebhook - POST /clients/{client}/webhooks: подписка клиента на уведомления.
+// Ключ подписи возвращается только в этом ответе
+func (srv *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientID(w, r)
+	if !ok {
+		return
+	}
+	var req webhookRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	sub, err := srv.service.AddWebhook(r.Context(), WebhookSubscription{Client: client, URL: req.URL, Secret: req.Secret, Events: req.Events})
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+// handleListWebhooks - GET /clients/{client}/webhooks: подписки клиента без ключей подписи
+func (srv *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientID(w, r)
+	if !ok {
+		return
+	}
+
+	subs, err := srv.service.Webhooks(r.Context(), client)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	if subs == nil {
+		subs = []WebhookSubscription{}
+	}
+	writeJSON(w, http.StatusOK, subs)
+}
+
+// handleDeleteWebhook - DELETE /clients/{client}/webhooks/{id}: удаление подписки клиента
+func (srv *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	client, ok := clientID(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid webhook id %q", r.PathValue("id")))
+		return
+	}
+
+	if err := srv.service.DeleteWebhook(r.Context(), client, id); err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeDenied - объясняет отказ в изменении посылки: её нет или она уже отправлена
+func (srv *Server) writeDenied(w http.ResponseWriter, r *http.Request, number int) {
+	parcel, err := srv.service.Parcel(r.Context(), number)
+	if err != nil {
+		srv.writeServiceError(w, r, err)
+		return
+	}
+	writeError(w, http.StatusConflict, fmt.Sprintf("parcel %d has status %s and can no longer be changed", number, parcel.Status))
+}
+
+// writeServiceError - отвечает кодом HTTP, соответствующим ошибке сервиса
+func (srv *Server) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, ErrForbidden):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrInvalidIdempotencyKey), errors.Is(err, ErrInvalidSearchQuery), errors.Is(err, ErrInvalidReportRange),
+		errors.Is(err, ErrInvalidServiceLevel), errors.Is(err, ErrInvalidWebhook), errors.Is(err, ErrReservedAddress):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrSearchUnavailable):
+		writeError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrParcelsInTransit):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrRateLimited):
+		var limited *RateLimitError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", retryAfterSeconds(limited.RetryAfter))
+		}
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	default:
+		srv.logger.ErrorContext(r.Context(), "request failed", slog.Any(attrError, err))
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// parcelNumber - читает номер посылки из пути запроса
+func parcelNumber(w http.ResponseWriter, r *http.Request) (int, bool) {
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid parcel number %q", r.PathValue("number")))
+		return 0, false
+	}
+	return number, true
+}
+
+// clientID - читает идентификатор клиента из пути запроса
+func clientID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	client, err := strconv.Atoi(r.PathValue("client"))
+	if err != nil || client <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid client id %q", r.PathValue("client")))
+		return 0, false
+	}
+	return client, true
+}
+
+// readJSON - читает тело запроса в v, при ошибке отвечает 400
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON - отвечает значением v в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError - отвечает ошибкой в формате JSON
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Role - роль вызывающего, определяющая доступные ему операции
+type Role string
+
+const (
+	RoleClient   Role = "client"   // Клиент: только собственные посылки
+	RoleOperator Role = "operator" // Оператор: все посылки и смена статуса
+	RoleAdmin    Role = "admin"    // Администратор: права оператора и управление API-ключами
+)
+
+// ParseRole - проверяет, что роль известна
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleClient, RoleOperator, RoleAdmin:
+		return role, nil
+	}
+	return "", fmt.Errorf("unknown role %q", s)
+}
+
+// ErrForbidden - вызывающему не разрешена операция
+var ErrForbidden = errors.New("forbidden")
+
+// ErrUnauthenticated - запрос без учётных данных или с недействительными учётными данными
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity - вызывающий, установленный по API-ключу или JWT
+type Identity struct {
+	Subject    string // Имя API-ключа или sub из JWT
+	Role       Role
+	Client     int    // Идентификатор клиента для роли client
+	Credential string // Учётные данные запроса: apikey:<номер ключа> или jwt:<sub>
+}
+
+// identityKey - ключ контекста для Identity
+type identityKey struct{}
+
+// WithIdentity - возвращает контекст с вызывающим. Операции сервиса с таким контекстом
+// проверяют права: клиент работает только со своими посылками, статус меняет только оператор
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext - возвращает вызывающего из контекста.
+// Контекст без вызывающего принадлежит доверенному внутреннему коду, права не проверяются
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// authorizeRole - проверяет, что роль вызывающего входит в roles
+func authorizeRole(ctx context.Context, roles ...Role) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	for _, role := range roles {
+		if id.Role == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("role %s may not perform this operation: %w", id.Role, ErrForbidden)
+}
+
+// authorizeClient - проверяет, что вызывающий может работать с посылками клиента
+func authorizeClient(ctx context.Context, client int) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Role != RoleClient || id.Client == client {
+		return nil
+	}
+	return fmt.Errorf("client %d may not access parcels of client %d: %w", id.Client, client, ErrForbidden)
+}
+
+// authorizeParcel - проверяет, что посылка принадлежит вызывающему клиенту.
+// Чужая посылка считается ненайденной, чтобы не раскрывать её существование
+func authorizeParcel(ctx context.Context, p Parcel) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Role != RoleClient || id.Client == p.Client {
+		return nil
+	}
+	return fmt.Errorf("parcel %d does not belong to client %d: %w", p.Number, id.Client, sql.ErrNoRows)
+}
+
+// authorizeParcelNumber - проверяет, что посылка с номером number принадлежит вызывающему клиенту.
+// Отсутствие посылки не считается ошибкой: его обрабатывает сама операция
+func authorizeParcelNumber(ctx context.Context, store ParcelStore, number int) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Role != RoleClient {
+		return nil
+	}
+
+	p, err := store.GetContext(ctx, number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return authorizeParcel(ctx, p)
+}
+
+// scopeEventFilter - ограничивает фильтр событий посылками вызывающего клиента
+func scopeEventFilter(ctx context.Context, filter EventFilter) (EventFilter, error) {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Role != RoleClient {
+		return filter, nil
+	}
+	if filter.Client != 0 && filter.Client != id.Client {
+		return filter, fmt.Errorf("client %d may not access events of client %d: %w", id.Client, filter.Client, ErrForbidden)
+	}
+	filter.Client = id.Client
+	return filter, nil
+}
+
+// APIKey - API-ключ. Хранится только SHA-256 хеш ключа
+type APIKey struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Role      Role   `json:"role"`
+	Client    int    `json:"client,omitempty"`
+	CreatedAt string `json:"created_at"`
+	RevokedAt string `json:"revoked_at,omitempty"`
+}
+
+// apiKeyPrefix - префикс выдаваемых API-ключей, упрощает их поиск в конфигурации и журналах
+const apiKeyPrefix = "trk_"
+
+// newAPIKey - создаёт случайный API-ключ
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate api key: error: %w", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashAPIKey - хеш API-ключа, по которому ключ ищется в базе данных
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddAPIKey - метод для добавления API-ключа. Возвращает номер ключа
+func (s ParcelStore) AddAPIKey(ctx context.Context, k APIKey, key string) (id int, err error) {
+	ctx, op := s.begin(ctx, "add_api_key", "INSERT")
+	defer func() {
+		op.end(err, slog.String("role", string(k.Role)), slog.Int(attrClient, k.Client))
+	}()
+
+	err = s.q.QueryRowContext(ctx, "INSERT INTO api_key (key_hash, name, role, client, created_at) VALUES (:key_hash, :name, :role, :client, :created_at) RETURNING id",
+		sql.Named("key_hash", hashAPIKey(key)),
+		sql.Named("name", k.Name),
+		sql.Named("role", string(k.Role)),
+		sql.Named("client", k.Client),
+		sql.Named("created_at", time.Now().UTC().Format(time.RFC3339))).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add api key %q: error: %w", k.Name, err)
+	}
+	return id, nil
+}
+
+// GetAPIKey - метод для получения API-ключа по его значению
+func (s ParcelStore) GetAPIKey(ctx context.Context, key string) (k APIKey, err error) {
+	ctx, op := s.begin(ctx, "get_api_key", "SELECT")
+	defer func() {
+		op.end(err, slog.Int("api_key", k.ID))
+	}()
+
+	var revokedAt sql.NullString
+	err = s.reader().QueryRowContext(ctx, "SELECT id, name, role, client, created_at, revoked_at FROM api_key WHERE key_hash = :key_hash",
+		sql.Named("key_hash", hashAPIKey(key))).
+		Scan(&k.ID, &k.Name, &k.Role, &k.Client, &k.CreatedAt, &revokedAt)
+	if err != nil {
+		return k, fmt.Errorf("failed to retrieve api key: error: %w", err)
+	}
+	k.RevokedAt = revokedAt.String
+	return k, nil
+}
+
+// GetAPIKeysByClient - метод для получения всех API-ключей клиента, включая отозванные
+func (s ParcelStore) GetAPIKeysByClient(ctx context.Context, client int) (res []APIKey, err error) {
+	ctx, op := s.begin(ctx, "get_api_keys_by_client", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT id, name, role, client, created_at, revoked_at FROM api_key WHERE client = :client ORDER BY id",
+		sql.Named("client", client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve api keys of client %d: error: %w", client, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k APIKey
+		var revokedAt sql.NullString
+		if err = rows.Scan(&k.ID, &k.Name, &k.Role, &k.Client, &k.CreatedAt, &revokedAt); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving api keys of client %d: error: %w", client, err)
+		}
+		k.RevokedAt = revokedAt.String
+		res = append(res, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving api keys of client %d: %w", client, err)
+	}
+	return res, nil
+}
+
+// RevokeAPIKey - метод для отзыва API-ключа
+func (s ParcelStore) RevokeAPIKey(ctx context.Context, id int) (err error) {
+	ctx, op := s.begin(ctx, "revoke_api_key", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int("api_key", id))
+	}()
+
+	res, err := s.q.ExecContext(ctx, "UPDATE api_key SET revoked_at = :revoked_at WHERE id = :id AND revoked_at IS NULL",
+		sql.Named("revoked_at", time.Now().UTC().Format(time.RFC3339)),
+		sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("api key revocation error №%d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("api key №%d is not found or already revoked: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// JWTClaims - утверждения JWT, по которым устанавливается вызывающий
+type JWTClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	Client    int    `json:"client,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+// jwtLeeway - допустимое расхождение часов при проверке exp и nbf
+const jwtLeeway = 30 * time.Second
+
+// jwtHeader - заголовок JWT с подписью HS256
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// SignJWT - создаёт JWT с подписью HS256
+func SignJWT(key []byte, claims JWTClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwt claims: error: %w", err)
+	}
+
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signHS256(key, signed)), nil
+}
+
+// ParseJWT - проверяет подпись HS256 и срок действия JWT и возвращает его утверждения
+func ParseJWT(key []byte, token string, now time.Time) (JWTClaims, error) {
+	var claims JWTClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed jwt")
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return claims, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	// Принимается только HS256, в том числе чтобы исключить подмену на alg=none
+	if h.Alg != "HS256" {
+		return claims, fmt.Errorf("unsupported jwt algorithm %q", h.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("malformed jwt signature: %w", err)
+	}
+	if !hmac.Equal(signature, signHS256(key, parts[0]+"."+parts[1])) {
+		return claims, errors.New("invalid jwt signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("malformed jwt payload: %w", err)
+	}
+
+	if claims.ExpiresAt == 0 {
+		return claims, errors.New("jwt has no expiration time")
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return claims, errors.New("jwt is expired")
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return claims, errors.New("jwt is not valid yet")
+	}
+	return claims, nil
+}
+
+// signHS256 - подпись HMAC-SHA256
+func signHS256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Authenticator - устанавливает вызывающего HTTP-запроса или вызова gRPC по API-ключу (заголовок или
+// метаданные X-API-Key) или JWT с подписью HS256 (Authorization: Bearer)
+type Authenticator struct {
+	store  ParcelStore
+	jwtKey []byte // Ключ подписи JWT, пустой - JWT не принимаются
+	now    func() time.Time
+}
+
+// NewAuthenticator - конструктор аутентификации. Если jwtKey пуст, принимаются только API-ключи
+func NewAuthenticator(store ParcelStore, jwtKey []byte) *Authenticator {
+	return &Authenticator{store: store, jwtKey: jwtKey, now: time.Now}
+}
+
+// Authenticate - устанавливает вызывающего по учётным данным запроса
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	return a.authenticate(r.Context(), r.Header.Get("X-API-Key"), r.Header.Get("Authorization"))
+}
+
+// authenticate - устанавливает вызывающего по API-ключу или значению Authorization: Bearer <JWT>.
+// Общая проверка для HTTP-заголовков и метаданных gRPC
+func (a *Authenticator) authenticate(ctx context.Context, apiKey, authorization string) (Identity, error) {
+	if apiKey != "" {
+		return a.authenticateAPIKey(ctx, apiKey)
+	}
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return a.authenticateJWT(token)
+	}
+	return Identity{}, fmt.Errorf("no credentials: %w", ErrUnauthenticated)
+}
+
+// authenticateAPIKey - устанавливает вызывающего по API-ключу
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, key string) (Identity, error) {
+	k, err := a.store.GetAPIKey(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, fmt.Errorf("unknown api key: %w", ErrUnauthenticated)
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	if k.RevokedAt != "" {
+		return Identity{}, fmt.Errorf("api key %q is revoked: %w", k.Name, ErrUnauthenticated)
+	}
+	return Identity{Subject: k.Name, Role: k.Role, Client: k.Client, Credential: fmt.Sprintf("apikey:%d", k.ID)}, nil
+}
+
+// authenticateJWT - устанавливает вызывающего по JWT
+func (a *Authenticator) authenticateJWT(token string) (Identity, error) {
+	if len(a.jwtKey) == 0 {
+		return Identity{}, fmt.Errorf("jwt authentication is disabled: %w", ErrUnauthenticated)
+	}
+
+	claims, err := ParseJWT(a.jwtKey, token, a.now())
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	if _, err := ParseRole(string(claims.Role)); err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	if claims.Role == RoleClient && claims.Client <= 0 {
+		return Identity{}, fmt.Errorf("jwt of role client has no client id: %w", ErrUnauthenticated)
+	}
+	return Identity{Subject: claims.Subject, Role: claims.Role, Client: claims.Client, Credential: "jwt:" + claims.Subject}, nil
+}
+
+// Middleware - пропускает к next только запросы с действительными учётными данными,
+// вызывающий передаётся в контексте запроса
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r)
+		if err != nil {
+			if errors.Is(err, ErrUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tracker"`)
+				writeError(w, http.StatusUnauthorized, ErrUnauthenticated.Error())
+				return
+			}
+			a.store.logger.ErrorContext(r.Context(), "authentication failed", slog.Any(attrError, err))
+			writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
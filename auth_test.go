@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJWTKey - ключ подписи JWT в тестах
+var testJWTKey = []byte("test-jwt-key")
+
+// testToken - возвращает действующий JWT вызывающего с ролью role
+func testToken(t *testing.T, role Role, client int) string {
+	token, err := SignJWT(testJWTKey, JWTClaims{
+		Subject:   fmt.Sprintf("%s-%d", role, client),
+		Role:      role,
+		Client:    client,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err, "failed to sign jwt. Error: %v", err)
+	return token
+}
+
+// apiCall - выполняет запрос к API с учётными данными в заголовке header и возвращает код ответа и тело
+func apiCall(t *testing.T, method, url, header, credentials string, body any) (int, []byte) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, r)
+	require.NoError(t, err)
+	if credentials != "" {
+		req.Header.Set(header, credentials)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "failed to call %s %s. Error: %v", method, url, err)
+	defer resp.Body.Close()
+
+	res, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, res
+}
+
+// TestParseJWT - тест для проверки подписи и срока действия JWT
+func TestParseJWT(t *testing.T) {
+	now := time.Now()
+	valid, err := SignJWT(testJWTKey, JWTClaims{Subject: "op", Role: RoleOperator, ExpiresAt: now.Add(time.Minute).Unix()})
+	require.NoError(t, err)
+	expired, err := SignJWT(testJWTKey, JWTClaims{Subject: "op", Role: RoleOperator, ExpiresAt: now.Add(-time.Hour).Unix()})
+	require.NoError(t, err)
+	noExpiry, err := SignJWT(testJWTKey, JWTClaims{Subject: "op", Role: RoleOperator})
+	require.NoError(t, err)
+
+	// Токен с alg=none и прежней нагрузкой
+	parts := strings.Split(valid, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		key   []byte
+		token string
+		ok    bool
+	}{
+		{name: "valid", key: testJWTKey, token: valid, ok: true},
+		{name: "wrong key", key: []byte("other"), token: valid, ok: false},
+		{name: "expired", key: testJWTKey, token: expired, ok: false},
+		{name: "no expiration", key: testJWTKey, token: noExpiry, ok: false},
+		{name: "alg none", key: testJWTKey, token: none, ok: false},
+		{name: "malformed", key: testJWTKey, token: "abc", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseJWT(tt.key, tt.token, now)
+			if !tt.ok {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, RoleOperator, claims.Role)
+		})
+	}
+}
+
+// TestAPIAuthorization - тест для проверки прав ролей в API посылок
+func TestAPIAuthorization(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	owner := "Bearer " + testToken(t, RoleClient, 1000)
+	stranger := "Bearer " + testToken(t, RoleClient, 2000)
+	operator := "Bearer " + testToken(t, RoleOperator, 0)
+
+	// Без учётных данных API недоступно
+	status, _ := apiCall(t, http.MethodGet, server.URL+"/parcels", "", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Клиент регистрирует посылку на себя, на другого клиента - нельзя
+	status, body := apiCall(t, http.MethodPost, server.URL+"/parcels", "Authorization", owner, registerRequest{Address: "test"})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var parcel Parcel
+	require.NoError(t, json.Unmarshal(body, &parcel))
+	assert.Equal(t, 1000, parcel.Client)
+
+	status, _ = apiCall(t, http.MethodPost, server.URL+"/parcels", "Authorization", owner, registerRequest{Client: 2000, Address: "test"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	parcelURL := fmt.Sprintf("%s/parcels/%d", server.URL, parcel.Number)
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		credentials string
+		body        any
+		status      int
+	}{
+		{name: "owner gets parcel", method: http.MethodGet, url: parcelURL, credentials: owner, status: http.StatusOK},
+		{name: "stranger does not see parcel", method: http.MethodGet, url: parcelURL, credentials: stranger, status: http.StatusNotFound},
+		{name: "owner lists own parcels", method: http.MethodGet, url: server.URL + "/parcels", credentials: owner, status: http.StatusOK},
+		{name: "stranger lists foreign parcels", method: http.MethodGet, url: server.URL + "/parcels?client=1000", credentials: stranger, status: http.StatusForbidden},
+		{name: "stranger changes address", method: http.MethodPut, url: parcelURL + "/address", credentials: stranger, body: addressRequest{Address: "other"}, status: http.StatusNotFound},
+		{name: "stranger deletes parcel", method: http.MethodDelete, url: parcelURL, credentials: stranger, status: http.StatusNotFound},
+		{name: "owner changes address", method: http.MethodPut, url: parcelURL + "/address", credentials: owner, body: addressRequest{Address: "new test address"}, status: http.StatusOK},
+		{name: "owner may not advance status", method: http.MethodPost, url: parcelURL + "/next-status", credentials: owner, status: http.StatusForbidden},
+		{name: "operator advances status", method: http.MethodPost, url: parcelURL + "/next-status", credentials: operator, status: http.StatusOK},
+		{name: "owner may not delete sent parcel", method: http.MethodDelete, url: parcelURL, credentials: owner, status: http.StatusConflict},
+		{name: "operator may not issue api keys", method: http.MethodPost, url: server.URL + "/api-keys", credentials: operator, body: apiKeyRequest{Name: "k", Role: "admin"}, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := apiCall(t, tt.method, tt.url, "Authorization", tt.credentials, tt.body)
+			assert.Equal(t, tt.status, status, string(body))
+		})
+	}
+
+	// Адрес изменён владельцем
+	got, err := store.Get(parcel.Number)
+	require.NoError(t, err)
+	assert.Equal(t, "new test address", got.Address)
+	assert.Equal(t, ParcelStatusSent, got.Status)
+}
+
+// TestAPIKeys - тест для проверки выпуска, использования и отзыва API-ключей
+func TestAPIKeys(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, nil), nil).Handler())
+	defer server.Close()
+
+	// Первый ключ администратора выпускается из командной строки
+	adminKey, err := newAPIKey()
+	require.NoError(t, err)
+	_, err = store.AddAPIKey(context.Background(), APIKey{Name: "admin", Role: RoleAdmin}, adminKey)
+	require.NoError(t, err)
+
+	// Администратор выпускает ключ клиента
+	status, body := apiCall(t, http.MethodPost, server.URL+"/api-keys", "X-API-Key", adminKey, apiKeyRequest{Name: "shop", Role: "client", Client: 1000})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var issued apiKeyResponse
+	require.NoError(t, json.Unmarshal(body, &issued))
+	assert.True(t, strings.HasPrefix(issued.Key, apiKeyPrefix))
+
+	// Ключ клиента действует только для его посылок
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels", "X-API-Key", issued.Key, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels?client=2000", "X-API-Key", issued.Key, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// JWT не принимаются без ключа подписи, неизвестный ключ отклоняется
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels", "Authorization", "Bearer "+testToken(t, RoleAdmin, 0), nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels", "X-API-Key", "trk_unknown", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Отозванный ключ больше не действует
+	status, _ = apiCall(t, http.MethodDelete, fmt.Sprintf("%s/api-keys/%d", server.URL, issued.ID), "X-API-Key", adminKey, nil)
+	require.Equal(t, http.StatusNoContent, status)
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels", "X-API-Key", issued.Key, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+// TestAuthConfig - тест для проверки ключа подписи JWT в конфигурации
+func TestAuthConfig(t *testing.T) {
+	key := strings.Repeat("k", minJWTKeyLength)
+
+	cfg, err := LoadConfig(writeConfig(t, ""), testEnv(nil))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Auth.JWTKey, "jwt is disabled by default")
+
+	cfg, err = LoadConfig(writeConfig(t, "auth:\n  jwt_key: file-"+key+"\n"), testEnv(map[string]string{"TRACKER_JWT_KEY": key}))
+	require.NoError(t, err)
+	assert.Equal(t, key, cfg.Auth.JWTKey, "environment overrides file")
+
+	// Ключ не выводится вместе с конфигурацией
+	var out bytes.Buffer
+	require.NoError(t, cfg.Print(&out))
+	assert.NotContains(t, out.String(), key)
+	assert.Contains(t, out.String(), "jwt_key: "+redactedKey)
+	assert.Equal(t, key, cfg.Auth.JWTKey)
+
+	// Короткий ключ отклоняется
+	_, err = LoadConfig(writeConfig(t, ""), testEnv(map[string]string{"TRACKER_JWT_KEY": key[1:]}))
+	require.Error(t, err)
+	_, err = LoadConfig(writeConfig(t, "auth:\n  jwt_key: secret\n"), testEnv(nil))
+	require.Error(t, err)
+}
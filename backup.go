@@ -0,0 +1,283 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// backupPrefix - начало имени файла резервной копии, за ним следует время создания в UTC
+	backupPrefix = "tracker-"
+	// backupTimeFormat - формат времени в имени резервной копии: имена сортируются в порядке создания
+	backupTimeFormat = "20060102T150405.000Z"
+	backupExt        = ".db"
+	gzipExt          = ".gz"
+)
+
+// BackupConfig - резервное копирование базы SQLite
+type BackupConfig struct {
+	Dir      string `yaml:"dir"`      // Каталог резервных копий
+	Keep     int    `yaml:"keep"`     // Сколько последних копий хранить, 0 - хранить все
+	Compress bool   `yaml:"compress"` // Сжимать копии gzip
+}
+
+// Backup - создаёт резервную копию базы в каталоге cfg.Dir, не останавливая работу сервиса, и возвращает путь к ней.
+// Копия создаётся командой VACUUM INTO через подключение пула чтения, поэтому в режиме WAL запись не блокируется,
+// а копия содержит согласованный снимок базы. После создания копии удаляются все, кроме cfg.Keep последних
+func (s ParcelStore) Backup(ctx context.Context, cfg BackupConfig) (path string, err error) {
+	ctx, op := s.begin(ctx, "backup", "VACUUM")
+	defer func() {
+		op.end(err, slog.String(attrPath, path))
+	}()
+
+	if s.dialect != sqliteDialect {
+		return "", fmt.Errorf("backup is supported only for sqlite, use pg_dump for %s", s.dialect.name)
+	}
+	if s.tx != nil {
+		return "", errors.New("backup cannot run inside a transaction")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: error: %w", err)
+	}
+
+	name := backupPrefix + time.Now().UTC().Format(backupTimeFormat) + backupExt
+	// копия пишется во временный файл и появляется под своим именем только целиком
+	tmp := filepath.Join(cfg.Dir, "."+name+".tmp")
+	defer os.Remove(tmp)
+
+	if err := s.vacuumInto(ctx, tmp); err != nil {
+		return "", err
+	}
+
+	path = filepath.Join(cfg.Dir, name)
+	if cfg.Compress {
+		path += gzipExt
+		if err := gzipFile(tmp, tmp+gzipExt); err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp + gzipExt)
+		tmp += gzipExt
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to save backup: error: %w", err)
+	}
+
+	if err := pruneBackups(cfg.Dir, cfg.Keep); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// vacuumInto - записывает снимок базы в файл path. Подключения пула чтения работают с PRAGMA query_only,
+// которая запрещает и VACUUM INTO, поэтому на время копирования запрет снимается. Подключение,
+// на котором запрет не удалось вернуть, закрывается и не возвращается в пул
+func (s ParcelStore) vacuumInto(ctx context.Context, path string) error {
+	if s.read == nil {
+		if _, err := s.db.ExecContext(ctx, "VACUUM INTO :path", sql.Named("path", path)); err != nil {
+			return fmt.Errorf("failed to back up database: error: %w", err)
+		}
+		return nil
+	}
+
+	conn, err := s.read.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to back up database: error: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = 0"); err != nil {
+		return fmt.Errorf("failed to back up database: error: %w", err)
+	}
+	defer func() {
+		if _, rerr := conn.ExecContext(context.Background(), "PRAGMA query_only = 1"); rerr != nil {
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, "VACUUM INTO :path", sql.Named("path", path)); err != nil {
+		return fmt.Errorf("failed to back up database: error: %w", err)
+	}
+	return nil
+}
+
+// gzipFile - сжимает файл src в новый файл dst
+func gzipFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: error: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed backup: error: %w", err)
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to write compressed backup: error: %w", cerr)
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return fmt.Errorf("failed to compress backup: error: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress backup: error: %w", err)
+	}
+	return out.Sync()
+}
+
+// ListBackups - резервные копии в каталоге dir от старых к новым
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: error: %w", err)
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) &&
+			(strings.HasSuffix(name, backupExt) || strings.HasSuffix(name, backupExt+gzipExt)) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	// время в имени записано так, что порядок имён совпадает с порядком создания
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// pruneBackups - удаляет из каталога dir все резервные копии, кроме keep последних. При keep = 0 копии не удаляются
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("failed to remove old backup: error: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// RestoreDatabase - заменяет базу SQLite из cfg резервной копией backup (сжатой gzip, если имя оканчивается на .gz).
+// Копия распаковывается рядом с базой и проверяется PRAGMA integrity_check, повреждённая копия базу не заменяет.
+// Сервис, работающий с базой, на время восстановления должен быть остановлен: открытые подключения
+// продолжили бы работать с заменённым файлом. Миграции применяются при следующем открытии базы
+func RestoreDatabase(ctx context.Context, cfg DatabaseConfig, backup string) error {
+	if cfg.postgres() || cfg.inMemory() {
+		return errors.New("restore is supported only for sqlite database files")
+	}
+	target := cfg.path()
+
+	in, err := os.Open(backup)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: error: %w", err)
+	}
+	defer in.Close()
+
+	var src io.Reader = in
+	if strings.HasSuffix(backup, gzipExt) {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to decompress backup %s: error: %w", backup, err)
+		}
+		defer zr.Close()
+		src = zr
+	}
+
+	tmp := target + ".restore"
+	defer os.Remove(tmp)
+	if err := copyToFile(tmp, src); err != nil {
+		return err
+	}
+
+	if err := checkIntegrity(ctx, tmp); err != nil {
+		return fmt.Errorf("backup %s is corrupted: %w", backup, err)
+	}
+
+	// журнал WAL и разделяемая память относятся к заменяемой базе и не должны применяться к восстановленной
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(target + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: error: %w", target+suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("failed to replace database: error: %w", err)
+	}
+	return nil
+}
+
+// copyToFile - записывает содержимое src в новый файл path
+func copyToFile(path string, src io.Reader) (err error) {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: error: %w", path, err)
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to write %s: error: %w", path, cerr)
+		}
+	}()
+
+	if _, err := io.Copy(out, src); err != nil {
+		return fmt.Errorf("failed to restore backup: error: %w", err)
+	}
+	return out.Sync()
+}
+
+// checkIntegrity - выполняет PRAGMA integrity_check для файла базы SQLite path
+func checkIntegrity(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", path+"?_pragma=query_only(1)")
+	if err != nil {
+		return fmt.Errorf("failed to open database: error: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to check integrity: error: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return fmt.Errorf("failed to check integrity: error: %w", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check integrity: error: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// path - путь к файлу базы SQLite без префикса file: и параметров подключения
+func (d DatabaseConfig) path() string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(d.DSN, "file:"), "?")
+	return path
+}
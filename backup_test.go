@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBackupRestore - тест для проверки резервного копирования работающей базы и восстановления из копии
+func TestBackupRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{name: "plain"},
+		{name: "gzip", compress: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := DefaultConfig().Database
+			cfg.DSN = filepath.Join(t.TempDir(), "tracker.db")
+
+			store, err := OpenParcelStore(ctx, cfg, WithOutput(io.Discard))
+			require.NoError(t, err)
+			number, err := store.AddContext(ctx, getTestParcel())
+			require.NoError(t, err)
+
+			backups := BackupConfig{Dir: filepath.Join(t.TempDir(), "backups"), Keep: 3, Compress: tt.compress}
+			path, err := store.Backup(ctx, backups)
+			require.NoError(t, err)
+			assert.Equal(t, tt.compress, filepath.Ext(path) == ".gz")
+
+			// Пул чтения после копирования по-прежнему не может изменять данные
+			_, err = store.read.ExecContext(ctx, "DELETE FROM parcel")
+			require.Error(t, err)
+
+			// Изменения после копирования теряются при восстановлении
+			_, err = store.AddContext(ctx, getTestParcel())
+			require.NoError(t, err)
+			require.NoError(t, store.Close())
+
+			require.NoError(t, RestoreDatabase(ctx, cfg, path))
+
+			store, err = OpenParcelStore(ctx, cfg, WithOutput(io.Discard))
+			require.NoError(t, err)
+			defer store.Close()
+			counts, err := store.CountByStatusContext(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, counts[ParcelStatusRegistered])
+			_, err = store.GetContext(ctx, number)
+			require.NoError(t, err)
+		})
+	}
+}
+
+// TestBackupRetention - тест для проверки хранения только последних копий
+func TestBackupRetention(t *testing.T) {
+	store := openTestStore(t)
+	cfg := BackupConfig{Dir: t.TempDir(), Keep: 2}
+
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path, err := store.Backup(context.Background(), cfg)
+		require.NoError(t, err)
+		paths = append(paths, path)
+	}
+	// Посторонние файлы в каталоге копий не удаляются
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Dir, "notes.txt"), nil, 0o600))
+
+	backups, err := ListBackups(cfg.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, paths[2:], backups)
+	assert.FileExists(t, filepath.Join(cfg.Dir, "notes.txt"))
+}
+
+// TestRestoreCorruptedBackup - тест для проверки, что повреждённая копия не заменяет базу
+func TestRestoreCorruptedBackup(t *testing.T) {
+	ctx := context.Background()
+	cfg := DefaultConfig().Database
+	cfg.DSN = filepath.Join(t.TempDir(), "tracker.db")
+	store, err := OpenParcelStore(ctx, cfg, WithOutput(io.Discard))
+	require.NoError(t, err)
+	_, err = store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+	path, err := store.Backup(ctx, BackupConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// Повреждение страниц в середине файла копии
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for i := len(data) / 2; i < len(data); i++ {
+		data[i] = 0xff
+	}
+	corrupted := filepath.Join(t.TempDir(), "tracker-corrupted.db")
+	require.NoError(t, os.WriteFile(corrupted, data, 0o600))
+	before, err := os.ReadFile(cfg.DSN)
+	require.NoError(t, err)
+
+	require.Error(t, RestoreDatabase(ctx, cfg, corrupted))
+
+	after, err := os.ReadFile(cfg.DSN)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.NoFileExists(t, cfg.DSN+".restore")
+}
@@ -0,0 +1,215 @@
+// Package calendar - производственный календарь: выходные, праздники и переносы рабочих дней по странам
+// и регионам, рабочие часы и арифметика рабочих дней в часовом поясе календаря
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DateFormat - формат дат календаря
+const DateFormat = "2006-01-02"
+
+// regionPattern - код страны ISO 3166-1 (RU) или региона ISO 3166-2 (RU-MOW)
+var regionPattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// Holidays - исключения из недели с выходными в субботу и воскресенье по странам и регионам:
+// для каждого региона дата 2006-01-02 отображается в true для праздника и в false для рабочего дня,
+// перенесённого на выходной
+type Holidays map[string]map[string]bool
+
+// LoadHolidays - читает файл праздников. В каждой строке - код страны или региона, дата 2006-01-02 и,
+// необязательно, название: "RU 2025-06-12 День России". Дата с + в начале - рабочий выходной день
+// (перенос): "RU +2025-11-01". Текст после # и пустые строки пропускаются
+func LoadHolidays(path string) (Holidays, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open holidays file: error: %w", err)
+	}
+	defer f.Close()
+
+	h := Holidays{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || !regionPattern.MatchString(fields[0]) {
+			return nil, fmt.Errorf("invalid holiday in %s:%d: expected region code and date", path, line)
+		}
+		date, working := strings.CutPrefix(fields[1], "+")
+		if _, err := time.Parse(DateFormat, date); err != nil {
+			return nil, fmt.Errorf("invalid holiday date %q in %s:%d", fields[1], path, line)
+		}
+		if h[fields[0]] == nil {
+			h[fields[0]] = map[string]bool{}
+		}
+		h[fields[0]][date] = !working
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read holidays file: error: %w", err)
+	}
+	return h, nil
+}
+
+// Has - есть ли праздники для региона или его страны
+func (h Holidays) Has(region string) bool {
+	country, _, _ := strings.Cut(region, "-")
+	return h[region] != nil || h[country] != nil
+}
+
+// Calendar - производственный календарь в часовом поясе. Дни определяются по местному времени,
+// рабочее время - с open до close каждого рабочего дня
+type Calendar struct {
+	location    *time.Location
+	days        map[string]bool // Исключения: true - праздник, false - рабочий выходной
+	open, close time.Duration
+}
+
+// Option - функциональная опция календаря
+type Option func(*Calendar)
+
+// WithHolidays - праздники и переносы страны региона и самого региона. Переносы региона
+// имеют приоритет над переносами страны
+func WithHolidays(h Holidays, region string) Option {
+	return func(c *Calendar) {
+		country, _, _ := strings.Cut(region, "-")
+		for _, code := range []string{country, region} {
+			for date, holiday := range h[code] {
+				c.days[date] = holiday
+			}
+		}
+	}
+}
+
+// WithWorkingHours - рабочее время как смещения от полуночи, по умолчанию весь день
+func WithWorkingHours(open, close time.Duration) Option {
+	return func(c *Calendar) {
+		c.open, c.close = open, close
+	}
+}
+
+// New - создаёт календарь в часовом поясе location, nil - UTC. Без опций выходные - суббота и воскресенье,
+// рабочее время - весь день
+func New(location *time.Location, opts ...Option) *Calendar {
+	if location == nil {
+		location = time.UTC
+	}
+	c := &Calendar{location: location, days: map[string]bool{}, close: 24 * time.Hour}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Location - часовой пояс календаря
+func (c *Calendar) Location() *time.Location {
+	return c.location
+}
+
+// IsBusinessDay - рабочий ли день, на который приходится t по местному времени
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	t = t.In(c.location)
+	if holiday, ok := c.days[t.Format(DateFormat)]; ok {
+		return !holiday
+	}
+	wd := t.Weekday()
+	return wd != time.Saturday && wd != time.Sunday
+}
+
+// StartOfDay - местная полночь дня, на который приходится t
+func (c *Calendar) StartOfDay(t time.Time) time.Time {
+	t = t.In(c.location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.location)
+}
+
+// NextBusinessDay - начало ближайшего рабочего дня после дня, на который приходится t
+func (c *Calendar) NextBusinessDay(t time.Time) time.Time {
+	day := c.addDays(c.StartOfDay(t), 1)
+	for !c.IsBusinessDay(day) {
+		day = c.addDays(day, 1)
+	}
+	return day
+}
+
+// AddBusinessDays - момент через n рабочих дней после t с тем же местным временем суток. Из выходного дня
+// первый шаг ведёт в ближайший рабочий день; отрицательное n отсчитывается назад, n = 0 возвращает t
+func (c *Calendar) AddBusinessDays(t time.Time, n int) time.Time {
+	t = t.In(c.location)
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		t = c.addDays(t, step)
+		if c.IsBusinessDay(t) {
+			n--
+		}
+	}
+	return t
+}
+
+// BusinessDaysBetween - количество рабочих дней после дня from до дня to включительно,
+// отрицательное, если to раньше from. Для рабочего дня from верно BusinessDaysBetween(from, AddBusinessDays(from, n)) == n
+func (c *Calendar) BusinessDaysBetween(from, to time.Time) int {
+	from, to = c.StartOfDay(from), c.StartOfDay(to)
+	sign := 1
+	if to.Before(from) {
+		from, to, sign = to, from, -1
+	}
+	n := 0
+	for day := c.addDays(from, 1); !day.After(to); day = c.addDays(day, 1) {
+		if c.IsBusinessDay(day) {
+			n++
+		}
+	}
+	return sign * n
+}
+
+// WorkingTime - рабочее время рабочих дней между from и to, ноль, если to не позже from
+func (c *Calendar) WorkingTime(from, to time.Time) time.Duration {
+	var total time.Duration
+	for day := c.StartOfDay(from); day.Before(to); day = c.addDays(day, 1) {
+		if !c.IsBusinessDay(day) {
+			continue
+		}
+		start, end := c.clock(day, c.open), c.clock(day, c.close)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// EndOfBusiness - окончание рабочего времени дня, на который приходится t
+func (c *Calendar) EndOfBusiness(t time.Time) time.Time {
+	return c.clock(t, c.close)
+}
+
+// IsWorkingTime - приходится ли t на рабочее время рабочего дня
+func (c *Calendar) IsWorkingTime(t time.Time) bool {
+	return c.IsBusinessDay(t) && !t.Before(c.clock(t, c.open)) && t.Before(c.clock(t, c.close))
+}
+
+// clock - момент со смещением d от полуночи дня t по местному времени, с учётом перехода на летнее время
+func (c *Calendar) clock(t time.Time, d time.Duration) time.Time {
+	t = t.In(c.location)
+	return time.Date(t.Year(), t.Month(), t.Day(), int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, c.location)
+}
+
+// addDays - сдвиг на n календарных дней с сохранением местного времени суток
+func (c *Calendar) addDays(t time.Time, n int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+n, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), c.location)
+}
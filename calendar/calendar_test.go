@@ -0,0 +1,136 @@
+package calendar
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHolidays - праздники России и Татарстана в июне 2025 года и рабочая суббота 1 ноября
+const testHolidays = `# Россия
+RU 2025-06-12 День России
+RU 2025-06-13
+RU +2025-11-01 перенос
+
+RU-TA 2025-06-06 Курбан-байрам # только в Татарстане
+`
+
+// loadTestHolidays - записывает testHolidays во временный файл и читает его
+func loadTestHolidays(t *testing.T) Holidays {
+	path := filepath.Join(t.TempDir(), "holidays.txt")
+	require.NoError(t, os.WriteFile(path, []byte(testHolidays), 0o600))
+	h, err := LoadHolidays(path)
+	require.NoError(t, err)
+	return h
+}
+
+// date - полночь дня s в часовом поясе location
+func date(t *testing.T, s string, location *time.Location) time.Time {
+	d, err := time.ParseInLocation(DateFormat, s, location)
+	require.NoError(t, err)
+	return d
+}
+
+// TestLoadHolidays - тест для проверки чтения файла праздников
+func TestLoadHolidays(t *testing.T) {
+	h := loadTestHolidays(t)
+	assert.Equal(t, Holidays{
+		"RU":    {"2025-06-12": true, "2025-06-13": true, "2025-11-01": false},
+		"RU-TA": {"2025-06-06": true},
+	}, h)
+	assert.True(t, h.Has("RU"))
+	assert.True(t, h.Has("RU-MOW"))
+	assert.False(t, h.Has("KZ"))
+
+	for _, content := range []string{"2025-06-12\n", "ru 2025-06-12\n", "RU 12.06.2025\n", "RU\n"} {
+		path := filepath.Join(t.TempDir(), "holidays.txt")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadHolidays(path)
+		assert.Error(t, err, content)
+	}
+	_, err := LoadHolidays(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+// TestBusinessDays - тест для проверки рабочих дней и арифметики рабочих дней
+func TestBusinessDays(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	h := loadTestHolidays(t)
+	ru := New(moscow, WithHolidays(h, "RU-MOW"))
+	ta := New(moscow, WithHolidays(h, "RU-TA"))
+
+	assert.True(t, ru.IsBusinessDay(date(t, "2025-06-11", moscow)))
+	assert.False(t, ru.IsBusinessDay(date(t, "2025-06-12", moscow)))
+	assert.False(t, ru.IsBusinessDay(date(t, "2025-06-14", moscow)))
+	assert.True(t, ru.IsBusinessDay(date(t, "2025-11-01", moscow)))
+	assert.True(t, ru.IsBusinessDay(date(t, "2025-06-06", moscow)))
+	assert.False(t, ta.IsBusinessDay(date(t, "2025-06-06", moscow)))
+	// 22:00 UTC 5 июня - уже 6 июня в Москве
+	assert.False(t, ta.IsBusinessDay(time.Date(2025, 6, 5, 22, 0, 0, 0, time.UTC)))
+
+	tests := []struct {
+		name string
+		from string
+		n    int
+		want string
+	}{
+		{name: "zero", from: "2025-06-10", n: 0, want: "2025-06-10"},
+		{name: "over holidays and weekend", from: "2025-06-11", n: 1, want: "2025-06-16"},
+		{name: "from weekend", from: "2025-06-14", n: 1, want: "2025-06-16"},
+		{name: "several days", from: "2025-06-10", n: 5, want: "2025-06-19"},
+		{name: "working saturday", from: "2025-10-31", n: 1, want: "2025-11-01"},
+		{name: "backwards", from: "2025-06-16", n: -2, want: "2025-06-10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := date(t, tt.from, moscow).Add(10*time.Hour + 30*time.Minute)
+			got := ru.AddBusinessDays(from, tt.n)
+			assert.Equal(t, date(t, tt.want, moscow).Add(10*time.Hour+30*time.Minute), got)
+			if tt.n != 0 && ru.IsBusinessDay(from) {
+				assert.Equal(t, tt.n, ru.BusinessDaysBetween(from, got))
+			}
+		})
+	}
+
+	assert.Equal(t, 0, ru.BusinessDaysBetween(date(t, "2025-06-11", moscow), date(t, "2025-06-15", moscow)))
+	assert.Equal(t, 3, ru.BusinessDaysBetween(date(t, "2025-06-09", moscow), date(t, "2025-06-16", moscow)))
+	assert.Equal(t, -3, ru.BusinessDaysBetween(date(t, "2025-06-16", moscow), date(t, "2025-06-09", moscow)))
+	assert.Equal(t, date(t, "2025-06-16", moscow), ru.NextBusinessDay(date(t, "2025-06-11", moscow).Add(23*time.Hour)))
+}
+
+// TestWorkingHours - тест для проверки рабочего времени с переходом на летнее время
+func TestWorkingHours(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	c := New(berlin, WithWorkingHours(9*time.Hour, 18*time.Hour))
+
+	// 28 марта 2025 года - пятница перед переходом на летнее время
+	friday := time.Date(2025, 3, 28, 12, 0, 0, 0, berlin)
+	assert.True(t, c.IsWorkingTime(friday))
+	assert.False(t, c.IsWorkingTime(time.Date(2025, 3, 28, 18, 0, 0, 0, berlin)))
+	assert.False(t, c.IsWorkingTime(time.Date(2025, 3, 29, 12, 0, 0, 0, berlin)))
+	assert.Equal(t, time.Date(2025, 3, 28, 18, 0, 0, 0, berlin), c.EndOfBusiness(friday))
+
+	// время суток сохраняется после перехода на летнее время
+	monday := c.AddBusinessDays(friday, 1)
+	assert.Equal(t, time.Date(2025, 3, 31, 12, 0, 0, 0, berlin), monday)
+	assert.Equal(t, 71*time.Hour, monday.Sub(friday))
+
+	// рабочее время считается только в рабочие часы рабочих дней
+	assert.Equal(t, 6*time.Hour+2*time.Hour, c.WorkingTime(friday, monday.Add(-time.Hour)))
+	assert.Equal(t, 9*time.Hour, c.WorkingTime(time.Date(2025, 3, 28, 0, 0, 0, 0, berlin), time.Date(2025, 3, 31, 0, 0, 0, 0, berlin)))
+	assert.Zero(t, c.WorkingTime(time.Date(2025, 3, 29, 8, 0, 0, 0, berlin), time.Date(2025, 3, 30, 20, 0, 0, 0, berlin)))
+	assert.Zero(t, c.WorkingTime(monday, friday))
+
+	// без опций - весь день в UTC
+	utc := New(nil)
+	assert.Equal(t, time.UTC, utc.Location())
+	assert.True(t, utc.IsWorkingTime(time.Date(2025, 3, 28, 23, 59, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2025, 3, 29, 0, 0, 0, 0, time.UTC), utc.EndOfBusiness(time.Date(2025, 3, 28, 8, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 16*time.Hour, utc.WorkingTime(time.Date(2025, 3, 28, 8, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)))
+}
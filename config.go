@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath - файл конфигурации, который читается, если не задана переменная TRACKER_CONFIG
+const defaultConfigPath = "tracker.yaml"
+
+// Config - конфигурация приложения. Значения читаются из YAML-файла и переопределяются переменными окружения
+type Config struct {
+	Database   DatabaseConfig   `yaml:"database"`
+	HTTP       ListenConfig     `yaml:"http"`
+	GRPC       ListenConfig     `yaml:"grpc"`
+	Log        LogConfig        `yaml:"log"`
+	Locale     Locale           `yaml:"locale"`
+	Status     StatusRules      `yaml:"status"`
+	Retention  RetentionConfig  `yaml:"retention"`
+	Backup     BackupConfig     `yaml:"backup"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+	Auth       AuthConfig       `yaml:"auth"`
+	SLA        SLAConfig        `yaml:"sla"`
+	Scheduler  SchedulerConfig  `yaml:"scheduler"`
+	Calendar   CalendarConfig   `yaml:"calendar"`
+	ETA        ETAConfig        `yaml:"eta"`
+}
+
+// DatabaseConfig - подключение к базе данных
+type DatabaseConfig struct {
+	DSN          string            `yaml:"dsn"`            // Путь к файлу SQLite или строка подключения postgres://
+	Pragmas      map[string]string `yaml:"pragmas"`        // PRAGMA SQLite, выполняемые для каждого подключения
+	MaxReadConns int               `yaml:"max_read_conns"` // Размер пула подключений для чтения
+}
+
+// ListenConfig - адрес, на котором сервер принимает запросы
+type ListenConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// LogConfig - параметры журнала
+type LogConfig struct {
+	Level string `yaml:"level"` // debug, info, warn или error
+}
+
+// RetentionConfig - сроки хранения служебных и персональных данных
+type RetentionConfig struct {
+	IdempotencyKeys Duration `yaml:"idempotency_keys"` // Срок хранения ключей идемпотентности регистрации
+	AnonymizeAfter  Duration `yaml:"anonymize_after"`  // Через сколько после доставки стирается адрес посылки, 0 - не стирается
+	PurgeAfter      Duration `yaml:"purge_after"`      // Через сколько после доставки посылка удаляется, 0 - не удаляется
+	BatchSize       int      `yaml:"batch_size"`       // Сколько посылок обрабатывается в одной транзакции
+	ReportFile      string   `yaml:"report_file"`      // Файл, в который дописываются отчёты о применении правил, пусто - только журнал
+}
+
+// Policy - правила хранения доставленных посылок
+func (r RetentionConfig) Policy() RetentionPolicy {
+	return RetentionPolicy{
+		AnonymizeAfter: time.Duration(r.AnonymizeAfter),
+		PurgeAfter:     time.Duration(r.PurgeAfter),
+		BatchSize:      r.BatchSize,
+	}
+}
+
+// Duration - длительность, которая в конфигурации записывается строкой вида 24h, 90m или 180d
+type Duration time.Duration
+
+// MarshalText - записывает длительность в виде строки time.Duration
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText - читает длительность в формате time.ParseDuration или целое число дней с суффиксом d
+func (d *Duration) UnmarshalText(text []byte) error {
+	if days, ok := strings.CutSuffix(string(text), "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", text, err)
+		}
+		*d = Duration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", text, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig - конфигурация по умолчанию: база tracker.db, HTTP на :8080, gRPC на :9090, журнал уровня warn
+func DefaultConfig() Config {
+	return Config{
+		Database: DatabaseConfig{
+			DSN: "tracker.db",
+			// WAL позволяет читать во время записи, а busy_timeout - дождаться блокировки,
+			// которую держит другой процесс, вместо ошибки "database is locked"
+			Pragmas: map[string]string{
+				"journal_mode": "wal",
+				"busy_timeout": "5000",
+				"foreign_keys": "on",
+				"synchronous":  "normal",
+			},
+			MaxReadConns: 4,
+		},
+		HTTP:   ListenConfig{Addr: ":8080"},
+		GRPC:   ListenConfig{Addr: ":9090"},
+		Log:    LogConfig{Level: "warn"},
+		Locale: LocaleRU,
+		Status: DefaultStatusRules(),
+		Retention: RetentionConfig{
+			// правила хранения посылок необратимы, поэтому включаются только явно
+			IdempotencyKeys: Duration(24 * time.Hour),
+			BatchSize:       500,
+		},
+		Backup: BackupConfig{Dir: "backups", Keep: 7, Compress: true},
+		SLA: SLAConfig{
+			Rules:      DefaultSLAPolicy().Rules,
+			WarnBefore: Duration(DefaultSLAPolicy().WarnBefore),
+		},
+		Scheduler: SchedulerConfig{
+			LockTTL: Duration(10 * time.Minute),
+			History: Duration(7 * 24 * time.Hour),
+			Jobs: map[string]string{
+				JobRetention:       "@every 1h",
+				JobSLA:             "*/15 * * * *",
+				JobOutbox:          "@every 1s",
+				JobIdempotencyKeys: "@hourly",
+				JobReport:          "5 0 * * *",
+			},
+			ReportDir: "reports",
+		},
+		Calendar: CalendarConfig{
+			TimeZone:     "UTC",
+			WorkingHours: "09:00-18:00",
+		},
+		ETA: ETAConfig{
+			CutOff:      "14:00",
+			OriginZone:  "other",
+			DefaultZone: "other",
+			Transit:     []TransitRule{{From: anyZone, To: anyZone, ServiceLevel: anyZone, Days: 5}},
+		},
+	}
+}
+
+// SLAConfig - сроки доставки по уровням обслуживания и их проверка
+type SLAConfig struct {
+	Rules      []SLARule `yaml:"rules"`       // По одному правилу на уровень обслуживания
+	WarnBefore Duration  `yaml:"warn_before"` // За сколько до истечения срока посылка отмечается как at_risk
+}
+
+// Policy - сроки доставки для проверки и поиска просроченных посылок. Сроки в рабочих днях считаются по календарю cal
+func (c SLAConfig) Policy(cal *calendar.Calendar) SLAPolicy {
+	return SLAPolicy{Rules: c.Rules, WarnBefore: time.Duration(c.WarnBefore), Calendar: cal}
+}
+
+// SchedulerConfig - фоновые задачи режима serve
+type SchedulerConfig struct {
+	LockTTL   Duration          `yaml:"lock_ttl"`   // Наибольшее время выполнения задачи, на которое она блокируется для других экземпляров
+	History   Duration          `yaml:"history"`    // Срок хранения истории запусков, 0 - история не удаляется
+	Jobs      map[string]string `yaml:"jobs"`       // Расписания задач: cron, @daily или @every 1h; пустая строка отключает задачу
+	ReportDir string            `yaml:"report_dir"` // Каталог ежедневных отчётов задачи report
+}
+
+// Schedules - расписания включённых задач
+func (c SchedulerConfig) Schedules() (map[string]Schedule, error) {
+	res := map[string]Schedule{}
+	for name, spec := range c.Jobs {
+		if !slices.Contains(jobNames, name) {
+			return nil, fmt.Errorf("unknown scheduler job %q, expected one of %s", name, strings.Join(jobNames, ", "))
+		}
+		if spec == "" {
+			continue
+		}
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("scheduler job %s: %w", name, err)
+		}
+		res[name] = schedule
+	}
+	return res, nil
+}
+
+// CalendarConfig - производственный календарь, по которому считаются рабочие дни
+type CalendarConfig struct {
+	TimeZone     string `yaml:"time_zone"`     // Часовой пояс сортировочного центра, в котором считаются дни
+	Region       string `yaml:"region"`        // Страна или регион ISO 3166: RU или RU-MOW, праздники которых учитываются
+	HolidaysFile string `yaml:"holidays_file"` // Файл праздников по регионам, пусто - выходные только суббота и воскресенье
+	WorkingHours string `yaml:"working_hours"` // Рабочее время ЧЧ:ММ-ЧЧ:ММ
+}
+
+// Calendar - производственный календарь. Проверяет параметры и читает файл праздников
+func (c CalendarConfig) Calendar() (*calendar.Calendar, error) {
+	location, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar time zone %q: %w", c.TimeZone, err)
+	}
+	openText, closeText, ok := strings.Cut(c.WorkingHours, "-")
+	if !ok {
+		return nil, fmt.Errorf("calendar working_hours %q must be in the form 09:00-18:00", c.WorkingHours)
+	}
+	open, err := parseClock(openText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar working_hours: %w", err)
+	}
+	closing, err := parseClock(closeText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar working_hours: %w", err)
+	}
+	if closing <= open {
+		return nil, fmt.Errorf("calendar working_hours %q must end after start", c.WorkingHours)
+	}
+	opts := []calendar.Option{calendar.WithWorkingHours(open, closing)}
+
+	if c.HolidaysFile != "" {
+		if c.Region == "" {
+			return nil, errors.New("calendar region is required with holidays_file")
+		}
+		holidays, err := calendar.LoadHolidays(c.HolidaysFile)
+		if err != nil {
+			return nil, err
+		}
+		if !holidays.Has(c.Region) {
+			return nil, fmt.Errorf("no holidays for calendar region %q in %s", c.Region, c.HolidaysFile)
+		}
+		opts = append(opts, calendar.WithHolidays(holidays, c.Region))
+	}
+	return calendar.New(location, opts...), nil
+}
+
+// ETAConfig - параметры расчёта ожидаемой даты доставки
+type ETAConfig struct {
+	CutOff      string        `yaml:"cut_off"`         // Время окончания приёма ЧЧ:ММ: позже посылка уходит на следующий рабочий день
+	OriginZone  string        `yaml:"origin_zone"`     // Зона, из которой отправляются посылки
+	DefaultZone string        `yaml:"default_zone"`    // Зона адреса, не подошедшего ни под одно правило zones
+	Zones       []ZoneRule    `yaml:"zones,omitempty"` // Правила определения зоны по адресу, проверяются по порядку
+	Transit     []TransitRule `yaml:"transit"`         // Сроки в пути, выбирается первое подходящее правило
+}
+
+// Calculator - калькулятор ожидаемой даты доставки по рабочим дням календаря cal. Проверяет параметры
+func (c ETAConfig) Calculator(cal *calendar.Calendar) (*ETACalculator, error) {
+	cutOff, err := parseClock(c.CutOff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid eta cut_off: %w", err)
+	}
+
+	if c.DefaultZone == "" {
+		return nil, errors.New("eta default_zone is required")
+	}
+	zones := map[string]bool{anyZone: true, c.DefaultZone: true}
+	for _, z := range c.Zones {
+		if z.Name == "" || len(z.Match) == 0 {
+			return nil, errors.New("eta zone must have a name and at least one address match")
+		}
+		zones[z.Name] = true
+	}
+	if !zones[c.OriginZone] || c.OriginZone == anyZone {
+		return nil, fmt.Errorf("unknown eta origin zone %q", c.OriginZone)
+	}
+	for _, r := range c.Transit {
+		if !zones[r.From] || !zones[r.To] {
+			return nil, fmt.Errorf("eta transit rule %s -> %s refers to unknown zone", r.From, r.To)
+		}
+		if r.ServiceLevel != anyZone && !serviceLevelPattern.MatchString(r.ServiceLevel) {
+			return nil, fmt.Errorf("eta transit rule %s -> %s: %w", r.From, r.To, ErrInvalidServiceLevel)
+		}
+		if r.Days < 0 {
+			return nil, fmt.Errorf("eta transit rule %s -> %s must not have negative days", r.From, r.To)
+		}
+	}
+
+	return &ETACalculator{
+		origin:   c.OriginZone,
+		zones:    c.Zones,
+		fallback: c.DefaultZone,
+		transit:  c.Transit,
+		cutOff:   cutOff,
+		calendar: cal,
+	}, nil
+}
+
+// parseClock - время суток ЧЧ:ММ в виде длительности от полуночи
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("time of day %q must be in the form HH:MM", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// EncryptionConfig - ключи шифрования адресов в базе. Без ключей адреса хранятся открыто
+type EncryptionConfig struct {
+	Keys     []EncryptionKey `yaml:"keys,omitempty"`      // Ключи AES-256 в base64, первый - текущий, остальные только для чтения
+	IndexKey string          `yaml:"index_key,omitempty"` // Ключ HMAC слепого индекса адресов в base64, не менее 32 байт
+}
+
+// EncryptionKey - ключ шифрования с идентификатором, который записывается вместе с зашифрованным значением
+type EncryptionKey struct {
+	ID  string `yaml:"id"`
+	Key string `yaml:"key"`
+}
+
+// redactedKey - значение, которым заменяются ключи при выводе конфигурации
+const redactedKey = "REDACTED"
+
+// Cipher - шифр полей из ключей конфигурации, nil - если ключи не заданы
+func (e EncryptionConfig) Cipher() (*FieldCipher, error) {
+	if len(e.Keys) == 0 && e.IndexKey == "" {
+		return nil, nil
+	}
+
+	keys := make([]FieldKey, len(e.Keys))
+	for i, k := range e.Keys {
+		key, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: must be base64: error: %w", k.ID, err)
+		}
+		keys[i] = FieldKey{ID: k.ID, Key: key}
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(e.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption index key: must be base64: error: %w", err)
+	}
+	return NewFieldCipher(keys, indexKey)
+}
+
+// setKeys - разбирает список ключей вида id=base64,id=base64
+func (e *EncryptionConfig) setKeys(list string) error {
+	e.Keys = nil
+	for _, item := range strings.Split(list, ",") {
+		// идентификатор не содержит '=', а ключ в base64 может оканчиваться на '='
+		id, key, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return fmt.Errorf("invalid encryption key %q: expected id=base64", item)
+		}
+		e.Keys = append(e.Keys, EncryptionKey{ID: id, Key: key})
+	}
+	return nil
+}
+
+// minJWTKeyLength - минимальная длина ключа подписи JWT: ключ HS256 не должен быть короче хеша SHA-256
+const minJWTKeyLength = 32
+
+// AuthConfig - параметры аутентификации запросов
+type AuthConfig struct {
+	JWTKey string `yaml:"jwt_key,omitempty"` // Ключ подписи JWT (HS256), не короче 32 байт. Без ключа JWT не принимаются
+}
+
+// validate - проверяет длину ключа подписи JWT
+func (a AuthConfig) validate() error {
+	if a.JWTKey != "" && len(a.JWTKey) < minJWTKeyLength {
+		return fmt.Errorf("auth jwt_key must be at least %d bytes, got %d", minJWTKeyLength, len(a.JWTKey))
+	}
+	return nil
+}
+
+// LoadConfig - читает конфигурацию из файла path поверх значений по умолчанию и применяет переопределения
+// из переменных окружения, которые возвращает lookupEnv. Отсутствующий файл по умолчанию не считается ошибкой
+func LoadConfig(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path == "" {
+		path = defaultConfigPath
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			path = ""
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: error: %w", err)
+		}
+		if err := cfg.decode(data); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: error: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// decode - читает YAML поверх текущих значений. Неизвестные поля считаются ошибкой.
+// Словари (PRAGMA, переходы статусов) дополняют значения по умолчанию, списки заменяют их
+func (c *Config) decode(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// configEnv - переменные окружения, переопределяющие значения конфигурации
+var configEnv = []struct {
+	name  string
+	apply func(c *Config, v string) error
+}{
+	{"TRACKER_DB_DSN", func(c *Config, v string) error { c.Database.DSN = v; return nil }},
+	{"TRACKER_DB_PRAGMAS", func(c *Config, v string) error { return c.Database.setPragmas(v) }},
+	{"TRACKER_DB_MAX_READ_CONNS", func(c *Config, v string) (err error) { c.Database.MaxReadConns, err = strconv.Atoi(v); return err }},
+	{"TRACKER_HTTP_ADDR", func(c *Config, v string) error { c.HTTP.Addr = v; return nil }},
+	{"TRACKER_GRPC_ADDR", func(c *Config, v string) error { c.GRPC.Addr = v; return nil }},
+	{"TRACKER_LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"TRACKER_LOCALE", func(c *Config, v string) error { c.Locale = Locale(v); return nil }},
+	{"TRACKER_IDEMPOTENCY_TTL", func(c *Config, v string) error { return c.Retention.IdempotencyKeys.UnmarshalText([]byte(v)) }},
+	{"TRACKER_RETENTION_ANONYMIZE_AFTER", func(c *Config, v string) error { return c.Retention.AnonymizeAfter.UnmarshalText([]byte(v)) }},
+	{"TRACKER_RETENTION_PURGE_AFTER", func(c *Config, v string) error { return c.Retention.PurgeAfter.UnmarshalText([]byte(v)) }},
+	{"TRACKER_RETENTION_REPORT_FILE", func(c *Config, v string) error { c.Retention.ReportFile = v; return nil }},
+	{"TRACKER_BACKUP_DIR", func(c *Config, v string) error { c.Backup.Dir = v; return nil }},
+	{"TRACKER_BACKUP_KEEP", func(c *Config, v string) (err error) { c.Backup.Keep, err = strconv.Atoi(v); return err }},
+	{"TRACKER_ENCRYPTION_KEYS", func(c *Config, v string) error { return c.Encryption.setKeys(v) }},
+	{"TRACKER_ENCRYPTION_INDEX_KEY", func(c *Config, v string) error { c.Encryption.IndexKey = v; return nil }},
+	{"TRACKER_JWT_KEY", func(c *Config, v string) error { c.Auth.JWTKey = v; return nil }},
+	{"TRACKER_CALENDAR_TIME_ZONE", func(c *Config, v string) error { c.Calendar.TimeZone = v; return nil }},
+	{"TRACKER_CALENDAR_REGION", func(c *Config, v string) error { c.Calendar.Region = v; return nil }},
+	{"TRACKER_CALENDAR_HOLIDAYS_FILE", func(c *Config, v string) error { c.Calendar.HolidaysFile = v; return nil }},
+}
+
+// applyEnv - переопределяет значения конфигурации заданными переменными окружения
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	for _, env := range configEnv {
+		v, ok := lookupEnv(env.name)
+		if !ok {
+			continue
+		}
+		if err := env.apply(c, v); err != nil {
+			return fmt.Errorf("invalid %s: %w", env.name, err)
+		}
+	}
+	return nil
+}
+
+// setPragmas - добавляет PRAGMA из списка вида name=value,name=value, заменяя одноимённые
+func (d *DatabaseConfig) setPragmas(list string) error {
+	pragmas := maps.Clone(d.Pragmas)
+	if pragmas == nil {
+		pragmas = map[string]string{}
+	}
+	for _, item := range strings.Split(list, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return fmt.Errorf("pragma %q must be in the form name=value", item)
+		}
+		pragmas[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	d.Pragmas = pragmas
+	return nil
+}
+
+// pragmaPattern - допустимые имена и значения PRAGMA: они подставляются в строку подключения без экранирования
+var pragmaPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Validate - проверяет согласованность конфигурации
+func (c Config) Validate() error {
+	if c.Database.DSN == "" {
+		return errors.New("database dsn is required")
+	}
+	for name, value := range c.Database.Pragmas {
+		if !pragmaPattern.MatchString(name) || !pragmaPattern.MatchString(value) {
+			return fmt.Errorf("invalid database pragma %s=%s", name, value)
+		}
+	}
+	if c.Database.MaxReadConns <= 0 {
+		return errors.New("database max_read_conns must be positive")
+	}
+	if c.HTTP.Addr == "" || c.GRPC.Addr == "" {
+		return errors.New("http and grpc listen addresses are required")
+	}
+	if _, err := ParseLogLevel(c.Log.Level); err != nil {
+		return err
+	}
+	if _, err := ParseLocale(string(c.Locale)); err != nil {
+		return err
+	}
+	if err := c.Status.Validate(); err != nil {
+		return err
+	}
+	if c.Retention.IdempotencyKeys <= 0 {
+		return errors.New("idempotency key retention must be positive")
+	}
+	if c.Retention.AnonymizeAfter < 0 || c.Retention.PurgeAfter < 0 {
+		return errors.New("parcel retention periods must not be negative")
+	}
+	if c.Retention.AnonymizeAfter > 0 && c.Retention.PurgeAfter > 0 && c.Retention.PurgeAfter <= c.Retention.AnonymizeAfter {
+		return errors.New("parcels must be purged later than anonymized")
+	}
+	if c.Retention.BatchSize <= 0 {
+		return errors.New("retention batch size must be positive")
+	}
+	if c.Backup.Dir == "" {
+		return errors.New("backup dir is required")
+	}
+	if c.Backup.Keep < 0 {
+		return errors.New("backup keep must not be negative")
+	}
+	if _, err := c.Encryption.Cipher(); err != nil {
+		return err
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	cal, err := c.Calendar.Calendar()
+	if err != nil {
+		return err
+	}
+	if err := c.SLA.Policy(cal).Validate(); err != nil {
+		return err
+	}
+	if _, err := c.ETA.Calculator(cal); err != nil {
+		return err
+	}
+	if c.Scheduler.LockTTL <= 0 || c.Scheduler.History < 0 {
+		return errors.New("scheduler lock_ttl must be positive and history must not be negative")
+	}
+	if _, err := c.Scheduler.Schedules(); err != nil {
+		return err
+	}
+	if c.Scheduler.Jobs[JobReport] != "" && c.Scheduler.ReportDir == "" {
+		return errors.New("scheduler report_dir is required for report job")
+	}
+	return nil
+}
+
+// DataSource - строка подключения database/sql: DSN с параметрами _pragma в порядке имён
+func (d DatabaseConfig) DataSource() string {
+	return d.dataSource(d.Pragmas)
+}
+
+// dataSource - DSN с параметрами _pragma для pragmas в порядке имён и дополнительными параметрами params
+func (d DatabaseConfig) dataSource(pragmas map[string]string, params ...string) string {
+	for _, name := range slices.Sorted(maps.Keys(pragmas)) {
+		params = append(params, fmt.Sprintf("_pragma=%s(%s)", name, pragmas[name]))
+	}
+	if len(params) == 0 {
+		return d.DSN
+	}
+	sep := "?"
+	if strings.Contains(d.DSN, "?") {
+		sep = "&"
+	}
+	return d.DSN + sep + strings.Join(params, "&")
+}
+
+// Options - опции сервиса и хранилища, заданные конфигурацией. Сроки доставки считаются по календарю cal
+func (c Config) Options(cal *calendar.Calendar) []Option {
+	return []Option{
+		WithLocale(c.Locale),
+		WithStatusRules(c.Status),
+		WithIdempotencyTTL(time.Duration(c.Retention.IdempotencyKeys)),
+		WithSLAPolicy(c.SLA.Policy(cal)),
+	}
+}
+
+// Print - выводит действующую конфигурацию в формате YAML. Пароль в строке подключения, ключи шифрования
+// и ключ подписи JWT скрываются
+func (c Config) Print(w io.Writer) error {
+	c.Database.DSN = c.Database.redactedDSN()
+	c.Encryption.Keys = slices.Clone(c.Encryption.Keys)
+	for i := range c.Encryption.Keys {
+		c.Encryption.Keys[i].Key = redactedKey
+	}
+	if c.Encryption.IndexKey != "" {
+		c.Encryption.IndexKey = redactedKey
+	}
+	if c.Auth.JWTKey != "" {
+		c.Auth.JWTKey = redactedKey
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("failed to print configuration: error: %w", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig - записывает файл конфигурации во временный каталог и возвращает путь к нему
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tracker.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// testEnv - источник переменных окружения для LoadConfig
+func testEnv(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+// TestLoadConfig - тест для проверки чтения файла конфигурации и переопределения переменными окружения
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+database:
+  dsn: /var/lib/tracker/tracker.db
+  pragmas:
+    synchronous: full
+http:
+  addr: ":8081"
+log:
+  level: info
+locale: en
+status:
+  transitions:
+    registered: delivered
+  editable: [registered, sent]
+retention:
+  idempotency_keys: 48h
+  anonymize_after: 90d
+scheduler:
+  jobs:
+    report: ""
+    sla: "@every 5m"
+`)
+
+	cfg, err := LoadConfig(path, testEnv(map[string]string{
+		"TRACKER_HTTP_ADDR":  ":9000",
+		"TRACKER_DB_PRAGMAS": "busy_timeout=1000, cache_size=-2000",
+	}))
+	require.NoError(t, err)
+
+	// Значения из файла дополняют значения по умолчанию, переменные окружения имеют приоритет
+	assert.Equal(t, ":9000", cfg.HTTP.Addr)
+	assert.Equal(t, ":9090", cfg.GRPC.Addr)
+	assert.Equal(t, "info", cfg.Log.Level)
+	assert.Equal(t, LocaleEN, cfg.Locale)
+	assert.Equal(t, Duration(48*time.Hour), cfg.Retention.IdempotencyKeys)
+	assert.Equal(t, Duration(90*24*time.Hour), cfg.Retention.AnonymizeAfter)
+	// удаление посылок не включается без явного срока
+	assert.Zero(t, cfg.Retention.PurgeAfter)
+	assert.Zero(t, DefaultConfig().Retention.Policy().AnonymizeAfter)
+	assert.Equal(t, []string{ParcelStatusRegistered, ParcelStatusSent}, cfg.Status.Editable)
+	assert.Equal(t, ParcelStatusDelivered, cfg.Status.Transitions[ParcelStatusRegistered])
+	schedules, err := cfg.Scheduler.Schedules()
+	require.NoError(t, err)
+	assert.NotContains(t, schedules, JobReport)
+	assert.Equal(t, everySchedule(5*time.Minute), schedules[JobSLA])
+	assert.Contains(t, schedules, JobRetention)
+	assert.Equal(t, "/var/lib/tracker/tracker.db?_pragma=busy_timeout(1000)&_pragma=cache_size(-2000)&_pragma=foreign_keys(on)&_pragma=journal_mode(wal)&_pragma=synchronous(full)",
+		cfg.Database.DataSource())
+
+	// Действующая конфигурация выводится в формате, который снова читается LoadConfig
+	var out bytes.Buffer
+	require.NoError(t, cfg.Print(&out))
+	assert.Contains(t, out.String(), "idempotency_keys: 48h0m0s")
+	printed, err := LoadConfig(writeConfig(t, out.String()), testEnv(nil))
+	require.NoError(t, err)
+	assert.Equal(t, cfg, printed)
+}
+
+// TestLoadConfigInvalid - тест для проверки отказа при некорректной конфигурации
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{name: "unknown field", file: "htp:\n  addr: \":80\"\n"},
+		{name: "malformed yaml", file: "http: [\n"},
+		{name: "invalid log level", env: map[string]string{"TRACKER_LOG_LEVEL": "loud"}},
+		{name: "unknown locale", file: "locale: de\n"},
+		{name: "invalid duration", env: map[string]string{"TRACKER_IDEMPOTENCY_TTL": "day"}},
+		{name: "non-positive retention", file: "retention:\n  idempotency_keys: 0s\n"},
+		{name: "purge before anonymize", env: map[string]string{"TRACKER_RETENTION_ANONYMIZE_AFTER": "180d", "TRACKER_RETENTION_PURGE_AFTER": "30d"}},
+		{name: "invalid days", env: map[string]string{"TRACKER_RETENTION_ANONYMIZE_AFTER": "halfd"}},
+		{name: "empty dsn", env: map[string]string{"TRACKER_DB_DSN": ""}},
+		{name: "no read connections", file: "database:\n  max_read_conns: 0\n"},
+		{name: "negative backup keep", env: map[string]string{"TRACKER_BACKUP_KEEP": "-1"}},
+		{name: "pragma injection", env: map[string]string{"TRACKER_DB_PRAGMAS": "busy_timeout=1)&_pragma=x(1"}},
+		{name: "unknown status", file: "status:\n  editable: [lost]\n"},
+		{name: "status cycle", file: "status:\n  transitions:\n    sent: registered\n"},
+		{name: "duplicate sla rule", file: "sla:\n  rules:\n    - {service_level: standard, from: sent, within: 5d}\n    - {service_level: standard, from: registered, within: 7d}\n"},
+		{name: "sla from delivered", file: "sla:\n  rules:\n    - {service_level: express, from: delivered, within: 1d}\n"},
+		{name: "unknown job", file: "scheduler:\n  jobs:\n    backup: \"@daily\"\n"},
+		{name: "invalid job schedule", file: "scheduler:\n  jobs:\n    sla: \"*/15 * * *\"\n"},
+		{name: "non-positive job lock", file: "scheduler:\n  lock_ttl: 0s\n"},
+		{name: "unknown eta zone", file: "eta:\n  origin_zone: nowhere\n"},
+		{name: "missing holidays file", env: map[string]string{"TRACKER_CALENDAR_REGION": "RU", "TRACKER_CALENDAR_HOLIDAYS_FILE": "/nonexistent/holidays.txt"}},
+		{name: "holidays without region", env: map[string]string{"TRACKER_CALENDAR_HOLIDAYS_FILE": "/nonexistent/holidays.txt"}},
+		{name: "unknown calendar time zone", env: map[string]string{"TRACKER_CALENDAR_TIME_ZONE": "Mars/Olympus"}},
+		{name: "invalid working hours", file: "calendar:\n  working_hours: \"18:00-09:00\"\n"},
+		{name: "sla within and business days", file: "sla:\n  rules:\n    - {service_level: standard, from: sent, within: 5d, business_days: 3}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.file)
+			_, err := LoadConfig(path, testEnv(tt.env))
+			require.Error(t, err)
+		})
+	}
+
+	// Явно указанный файл должен существовать
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), testEnv(nil))
+	require.Error(t, err)
+}
+
+// TestStatusRules - тест для проверки применения правил статусов сервисом и хранилищем
+func TestStatusRules(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	// Посылка доставляется без промежуточного статуса, адрес можно менять и после доставки
+	rules := StatusRules{
+		Transitions: map[string]string{ParcelStatusRegistered: ParcelStatusDelivered},
+		Editable:    []string{ParcelStatusRegistered, ParcelStatusDelivered},
+	}
+	require.NoError(t, rules.Validate())
+
+	store := NewParcelStore(db, WithStatusRules(rules))
+	service := NewParcelService(store, WithOutput(io.Discard), WithStatusRules(rules))
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err)
+	require.NoError(t, service.NextStatus(parcel.Number))
+
+	got, err := store.Get(parcel.Number)
+	require.NoError(t, err)
+	assert.Equal(t, ParcelStatusDelivered, got.Status)
+
+	require.NoError(t, service.ChangeAddress(parcel.Number, "new test address"))
+	got, err = store.Get(parcel.Number)
+	require.NoError(t, err)
+	assert.Equal(t, "new test address", got.Address)
+
+	// Без статусов, допускающих изменение, посылку нельзя удалить
+	locked := NewParcelStore(db, WithStatusRules(StatusRules{Transitions: rules.Transitions}))
+	require.NoError(t, locked.Delete(parcel.Number))
+	_, err = store.Get(parcel.Number)
+	require.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// dialect - особенности SQL базы данных хранилища. Запросы хранилища пишутся с именованными параметрами :name
+// и синтаксисом, общим для SQLite и PostgreSQL (RETURNING, ON CONFLICT), а диалект приводит их к формату драйвера
+type dialect struct {
+	name       string // Значение атрибута db.system
+	positional bool   // Драйвер принимает только позиционные параметры $1, $2...
+	// Запрос, выполняемый в начале транзакции миграции, чтобы несколько экземпляров не применяли миграции одновременно
+	migrationLock string
+}
+
+var (
+	sqliteDialect   = dialect{name: "sqlite"}
+	postgresDialect = dialect{name: "postgresql", positional: true, migrationLock: "SELECT pg_advisory_xact_lock(72617201)"}
+)
+
+// dialectOf - определяет диалект по драйверу подключения
+func dialectOf(db *sql.DB) dialect {
+	if _, ok := db.Driver().(*stdlib.Driver); ok {
+		return postgresDialect
+	}
+	return sqliteDialect
+}
+
+// wrap - возвращает querier, который выполняет запросы с именованными параметрами в формате драйвера
+func (d dialect) wrap(q querier) querier {
+	if !d.positional {
+		return q
+	}
+	return positionalQuerier{q: q}
+}
+
+// positionalQuerier - querier, заменяющий именованные параметры позиционными
+type positionalQuerier struct {
+	q querier
+}
+
+// ExecContext - выполняет запрос, не возвращающий строк
+func (p positionalQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = bindPositional(query, args)
+	return p.q.ExecContext(ctx, query, args...)
+}
+
+// QueryContext - выполняет запрос, возвращающий строки
+func (p positionalQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = bindPositional(query, args)
+	return p.q.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext - выполняет запрос, возвращающий не больше одной строки
+func (p positionalQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = bindPositional(query, args)
+	return p.q.QueryRowContext(ctx, query, args...)
+}
+
+// bindPositional - заменяет именованные параметры :name на $1, $2... в порядке первого появления
+// и возвращает значения в том же порядке. Повторное использование имени даёт тот же номер.
+// Строковые литералы и приведения типов PostgreSQL (::text) не затрагиваются.
+// Запрос с неименованными аргументами возвращается без изменений
+func bindPositional(query string, args []any) (string, []any) {
+	if len(args) == 0 {
+		return query, args
+	}
+	named := make(map[string]any, len(args))
+	for _, arg := range args {
+		n, ok := arg.(sql.NamedArg)
+		if !ok {
+			return query, args
+		}
+		named[n.Name] = n.Value
+	}
+
+	var b strings.Builder
+	positions := map[string]int{}
+	var values []any
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == ':' && !inString && i+1 < len(query) && query[i+1] == ':':
+			// приведение типа: оба двоеточия переносятся как есть
+			b.WriteString("::")
+			i++
+			continue
+		case c == ':' && !inString && i+1 < len(query) && isIdentStart(query[i+1]):
+			j := i + 1
+			for j < len(query) && isIdentPart(query[j]) {
+				j++
+			}
+			name := query[i+1 : j]
+			if value, ok := named[name]; ok {
+				pos, seen := positions[name]
+				if !seen {
+					values = append(values, value)
+					pos = len(values)
+					positions[name] = pos
+				}
+				b.WriteString("$" + strconv.Itoa(pos))
+				i = j - 1
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), values
+}
+
+// isIdentStart - символ может начинать имя параметра
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isIdentPart - символ может входить в имя параметра
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
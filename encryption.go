@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// encryptedPrefix - начало зашифрованного значения: enc:v1:<ключ>:<base64(nonce + шифротекст)>.
+// Значения без префикса записаны до включения шифрования и читаются как есть
+const encryptedPrefix = "enc:v1:"
+
+// Поля, шифруемые в базе данных. Имя поля входит в дополнительные данные AES-GCM,
+// поэтому шифротекст одного поля нельзя подставить в другое
+const (
+	fieldAddress           = "address"
+	fieldDeadLetterPayload = "webhook_dead_letter.payload"
+	fieldWebhookPayload    = "webhook_delivery.payload"
+	fieldWebhookSecret     = "webhook_subscription.secret"
+)
+
+// ErrNoEncryptionKey - значение зашифровано ключом, которого нет в конфигурации
+var ErrNoEncryptionKey = errors.New("encryption key is not configured")
+
+// ErrReservedAddress - адрес начинается с префикса зашифрованного значения и был бы прочитан как шифротекст
+var ErrReservedAddress = errors.New("address must not start with " + encryptedPrefix)
+
+// checkAddress - проверяет, что адрес посылки не принимается за зашифрованное значение
+func checkAddress(address string) error {
+	if strings.HasPrefix(address, encryptedPrefix) {
+		return ErrReservedAddress
+	}
+	return nil
+}
+
+// FieldKey - ключ шифрования AES-256 с идентификатором, который записывается рядом с шифротекстом
+type FieldKey struct {
+	ID  string
+	Key []byte
+}
+
+// FieldCipher - шифрование персональных данных в полях базы данных с помощью AES-256-GCM.
+// Новые значения шифруются текущим ключом, прежние ключи нужны только для чтения до перешифрования.
+// Для поиска по точному совпадению вместо открытого значения хранится слепой индекс - HMAC-SHA256
+// нормализованного значения. Nil-указатель означает, что шифрование отключено
+type FieldCipher struct {
+	current string                 // Идентификатор ключа для новых значений
+	aeads   map[string]cipher.AEAD // Все известные ключи по идентификаторам
+	index   []byte                 // Ключ HMAC слепого индекса
+}
+
+// NewFieldCipher - создаёт шифр с ключами keys, первый из которых используется для новых значений,
+// и ключом слепого индекса indexKey
+func NewFieldCipher(keys []FieldKey, indexKey []byte) (*FieldCipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+	if len(indexKey) < 32 {
+		return nil, errors.New("blind index key must be at least 32 bytes long")
+	}
+
+	c := &FieldCipher{current: keys[0].ID, aeads: make(map[string]cipher.AEAD, len(keys)), index: indexKey}
+	for _, k := range keys {
+		if k.ID == "" || strings.Contains(k.ID, ":") {
+			return nil, fmt.Errorf("invalid encryption key id %q", k.ID)
+		}
+		if _, ok := c.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key id %q", k.ID)
+		}
+		if len(k.Key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes long", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", k.ID, err)
+		}
+		c.aeads[k.ID] = aead
+	}
+	return c, nil
+}
+
+// Encrypt - шифрует значение поля field текущим ключом. Пустое значение не шифруется
+func (c *FieldCipher) Encrypt(field, value string) (string, error) {
+	if c == nil || value == "" {
+		return value, nil
+	}
+
+	aead := c.aeads[c.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: error: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return encryptedPrefix + c.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt - расшифровывает значение поля field. Значение без префикса возвращается как есть
+func (c *FieldCipher) Decrypt(field, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	keyID, data, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted %s", field)
+	}
+	if c == nil {
+		return "", fmt.Errorf("%s is encrypted with key %q: %w", field, keyID, ErrNoEncryptionKey)
+	}
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with key %q: %w", field, keyID, ErrNoEncryptionKey)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted %s", field)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s with key %q: %w", field, keyID, err)
+	}
+	return string(plain), nil
+}
+
+// isCurrent - значение зашифровано текущим ключом, либо пусто и не требует шифрования
+func (c *FieldCipher) isCurrent(value string) bool {
+	if c == nil || value == "" {
+		return true
+	}
+	return strings.HasPrefix(value, encryptedPrefix+c.current+":")
+}
+
+// BlindIndex - слепой индекс значения поля field для поиска по точному совпадению.
+// Значение нормализуется: регистр и повторяющиеся пробелы не влияют на индекс.
+// Без шифрования и для пустого значения индекс пуст
+func (c *FieldCipher) BlindIndex(field, value string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(value), " "))
+	if c == nil || normalized == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.index)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// reencryptBatchSize - сколько строк перешифровывается в одной транзакции
+const reencryptBatchSize = 500
+
+// ReencryptReport - количество строк, перезаписанных при перешифровании
+type ReencryptReport struct {
+	Parcels     int `json:"parcels"`
+	Events      int `json:"events"`
+	DeadLetters int `json:"dead_letters"`
+	Deliveries  int `json:"deliveries"`
+	Webhooks    int `json:"webhooks"`
+}
+
+// Reencrypt - перешифровывает текущим ключом все значения, записанные открыто или прежними ключами,
+// и пересчитывает слепые индексы адресов. Строки обрабатываются пакетами в отдельных транзакциях,
+// поэтому сервис может работать во время перешифрования. После завершения прежние ключи можно убрать из конфигурации
+func (s ParcelStore) Reencrypt(ctx context.Context) (report ReencryptReport, err error) {
+	ctx, op := s.begin(ctx, "reencrypt", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int("parcels", report.Parcels), slog.Int("events", report.Events), slog.Int("dead_letters", report.DeadLetters),
+			slog.Int("deliveries", report.Deliveries), slog.Int("webhooks", report.Webhooks))
+	}()
+
+	if s.cipher == nil {
+		return report, errors.New("encryption is not configured")
+	}
+
+	if report.Parcels, err = s.reencryptBatches(ctx, "SELECT number, address, address_index FROM parcel", "number", s.reencryptParcel); err != nil {
+		return report, err
+	}
+	if report.Events, err = s.reencryptBatches(ctx, "SELECT id, payload, '' FROM outbox", "id", s.reencryptEvent); err != nil {
+		return report, err
+	}
+	if report.DeadLetters, err = s.reencryptBatches(ctx, "SELECT id, payload, '' FROM webhook_dead_letter", "id", s.reencryptDeadLetter); err != nil {
+		return report, err
+	}
+	if report.Deliveries, err = s.reencryptBatches(ctx, "SELECT id, payload, '' FROM webhook_delivery", "id", s.reencryptDelivery); err != nil {
+		return report, err
+	}
+	if report.Webhooks, err = s.reencryptBatches(ctx, "SELECT id, secret, '' FROM webhook_subscription", "id", s.reencryptWebhookSecret); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// reencryptRow - перешифровывает строку с номером id и значениями value и index в транзакции tx.
+// Возвращает true, если строка была перезаписана
+type reencryptRow func(ctx context.Context, tx ParcelStore, id int64, value, index string) (bool, error)
+
+// reencryptBatches - обходит строки запроса selectQuery (номер, значение, индекс) по возрастанию столбца idColumn
+// пакетами по reencryptBatchSize, каждый пакет - в отдельной транзакции. Возвращает количество перезаписанных строк
+func (s ParcelStore) reencryptBatches(ctx context.Context, selectQuery, idColumn string, fn reencryptRow) (changed int, err error) {
+	var after int64
+	for {
+		type row struct {
+			id           int64
+			value, index string
+		}
+		var batch []row
+
+		err = s.InTx(ctx, func(tx ParcelStore) error {
+			rows, err := tx.q.QueryContext(ctx, selectQuery+" WHERE "+idColumn+" > :after ORDER BY "+idColumn+" LIMIT :limit",
+				sql.Named("after", after), sql.Named("limit", reencryptBatchSize))
+			if err != nil {
+				return fmt.Errorf("failed to select rows to reencrypt: error: %w", err)
+			}
+			for rows.Next() {
+				var r row
+				if err := rows.Scan(&r.id, &r.value, &r.index); err != nil {
+					rows.Close()
+					return fmt.Errorf("row scanning error while reencrypting: error: %w", err)
+				}
+				batch = append(batch, r)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("error iterating through rows while reencrypting: %w", err)
+			}
+
+			for _, r := range batch {
+				ok, err := fn(ctx, tx, r.id, r.value, r.index)
+				if err != nil {
+					return err
+				}
+				if ok {
+					changed++
+				}
+			}
+			return nil
+		})
+		if err != nil || len(batch) < reencryptBatchSize {
+			return changed, err
+		}
+		after = batch[len(batch)-1].id
+	}
+}
+
+// reencryptParcel - перешифровывает адрес посылки и пересчитывает его слепой индекс
+func (s ParcelStore) reencryptParcel(ctx context.Context, tx ParcelStore, number int64, address, index string) (bool, error) {
+	plain, err := s.cipher.Decrypt(fieldAddress, address)
+	if err != nil {
+		return false, fmt.Errorf("parcel %d: %w", number, err)
+	}
+	newIndex := s.cipher.BlindIndex(fieldAddress, plain)
+	if s.cipher.isCurrent(address) && index == newIndex {
+		return false, nil
+	}
+
+	encrypted, err := s.cipher.Encrypt(fieldAddress, plain)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.q.ExecContext(ctx, "UPDATE parcel SET address = :address, address_index = :address_index WHERE number = :number",
+		sql.Named("address", encrypted), sql.Named("address_index", newIndex), sql.Named("number", number))
+	if err != nil {
+		return false, fmt.Errorf("failed to reencrypt parcel %d: error: %w", number, err)
+	}
+	return true, nil
+}
+
+// reencryptEvent - перешифровывает адрес в событии outbox
+func (s ParcelStore) reencryptEvent(ctx context.Context, tx ParcelStore, id int64, payload, _ string) (bool, error) {
+	var event ParcelEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return false, fmt.Errorf("failed to decode event %d: %w", id, err)
+	}
+	if s.cipher.isCurrent(event.Address) {
+		return false, nil
+	}
+
+	plain, err := s.cipher.Decrypt(fieldAddress, event.Address)
+	if err != nil {
+		return false, fmt.Errorf("event %d: %w", id, err)
+	}
+	if event.Address, err = s.cipher.Encrypt(fieldAddress, plain); err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode event %d: %w", id, err)
+	}
+	_, err = tx.q.ExecContext(ctx, "UPDATE outbox SET payload = :payload WHERE id = :id", sql.Named("payload", string(b)), sql.Named("id", id))
+	if err != nil {
+		return false, fmt.Errorf("failed to reencrypt event %d: error: %w", id, err)
+	}
+	return true, nil
+}
+
+// reencryptDeadLetter - перешифровывает тело недоставленного уведомления
+func (s ParcelStore) reencryptDeadLetter(ctx context.Context, tx ParcelStore, id int64, payload, _ string) (bool, error) {
+	if s.cipher.isCurrent(payload) {
+		return false, nil
+	}
+
+	plain, err := s.cipher.Decrypt(fieldDeadLetterPayload, payload)
+	if err != nil {
+		return false, fmt.Errorf("dead letter %d: %w", id, err)
+	}
+	encrypted, err := s.cipher.Encrypt(fieldDeadLetterPayload, plain)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.q.ExecContext(ctx, "UPDATE webhook_dead_letter SET payload = :payload WHERE id = :id", sql.Named("payload", encrypted), sql.Named("id", id))
+	if err != nil {
+		return false, fmt.Errorf("failed to reencrypt dead letter %d: error: %w", id, err)
+	}
+	return true, nil
+}
+
+// reencryptDelivery - перешифровывает тело уведомления, ожидающего доставки
+func (s ParcelStore) reencryptDelivery(ctx context.Context, tx ParcelStore, id int64, payload, _ string) (bool, error) {
+	if s.cipher.isCurrent(payload) {
+		return false, nil
+	}
+
+	plain, err := s.cipher.Decrypt(fieldWebhookPayload, payload)
+	if err != nil {
+		return false, fmt.Errorf("webhook delivery %d: %w", id, err)
+	}
+	encrypted, err := s.cipher.Encrypt(fieldWebhookPayload, plain)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.q.ExecContext(ctx, "UPDATE webhook_delivery SET payload = :payload WHERE id = :id", sql.Named("payload", encrypted), sql.Named("id", id))
+	if err != nil {
+		return false, fmt.Errorf("failed to reencrypt webhook delivery %d: error: %w", id, err)
+	}
+	return true, nil
+}
+
+// reencryptWebhookSecret - перешифровывает ключ подписи уведомлений
+func (s ParcelStore) reencryptWebhookSecret(ctx context.Context, tx ParcelStore, id int64, secret, _ string) (bool, error) {
+	if s.cipher.isCurrent(secret) {
+		return false, nil
+	}
+
+	plain, err := s.cipher.Decrypt(fieldWebhookSecret, secret)
+	if err != nil {
+		return false, fmt.Errorf("webhook %d: %w", id, err)
+	}
+	encrypted, err := s.cipher.Encrypt(fieldWebhookSecret, plain)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.q.ExecContext(ctx, "UPDATE webhook_subscription SET secret = :secret WHERE id = :id", sql.Named("secret", encrypted), sql.Named("id", id))
+	if err != nil {
+		return false, fmt.Errorf("failed to reencrypt webhook %d: error: %w", id, err)
+	}
+	return true, nil
+}
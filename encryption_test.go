@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFieldKey - ключ шифрования из 32 одинаковых байт b
+func testFieldKey(id string, b byte) FieldKey {
+	return FieldKey{ID: id, Key: bytes.Repeat([]byte{b}, 32)}
+}
+
+// testIndexKey - ключ слепого индекса для тестов
+var testIndexKey = bytes.Repeat([]byte{'i'}, 32)
+
+// newTestCipher - шифр полей с ключами keys, первый ключ - текущий
+func newTestCipher(t *testing.T, keys ...FieldKey) *FieldCipher {
+	c, err := NewFieldCipher(keys, testIndexKey)
+	require.NoError(t, err)
+	return c
+}
+
+// openEncryptedStore - открывает базу dsn с шифрованием адресов
+func openEncryptedStore(t *testing.T, dsn string, c *FieldCipher) ParcelStore {
+	cfg := DefaultConfig().Database
+	cfg.DSN = dsn
+	store, err := OpenParcelStore(context.Background(), cfg, WithOutput(io.Discard), WithFieldCipher(c))
+	require.NoError(t, err, "failed to open store. Error: %v", err)
+	t.Cleanup(func() {
+		assert.NoError(t, store.Close())
+	})
+	return store
+}
+
+// TestFieldCipher - тест для проверки шифрования значений и слепого индекса
+func TestFieldCipher(t *testing.T) {
+	c := newTestCipher(t, testFieldKey("k2", 2), testFieldKey("k1", 1))
+
+	enc, err := c.Encrypt(fieldAddress, "Москва, ул. Ленина 1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enc, encryptedPrefix+"k2:"))
+	assert.NotContains(t, enc, "Ленина")
+	assert.True(t, c.isCurrent(enc))
+
+	// Одинаковые значения шифруются по-разному
+	again, err := c.Encrypt(fieldAddress, "Москва, ул. Ленина 1")
+	require.NoError(t, err)
+	assert.NotEqual(t, enc, again)
+
+	plain, err := c.Decrypt(fieldAddress, enc)
+	require.NoError(t, err)
+	assert.Equal(t, "Москва, ул. Ленина 1", plain)
+
+	// Значение одного поля нельзя расшифровать как значение другого
+	_, err = c.Decrypt(fieldDeadLetterPayload, enc)
+	require.Error(t, err)
+
+	// Открытые значения, записанные до включения шифрования, читаются как есть
+	plain, err = c.Decrypt(fieldAddress, "old address")
+	require.NoError(t, err)
+	assert.Equal(t, "old address", plain)
+	assert.False(t, c.isCurrent("old address"))
+
+	// Значение, зашифрованное прежним ключом, читается, но не считается текущим
+	old, err := newTestCipher(t, testFieldKey("k1", 1)).Encrypt(fieldAddress, "test")
+	require.NoError(t, err)
+	plain, err = c.Decrypt(fieldAddress, old)
+	require.NoError(t, err)
+	assert.Equal(t, "test", plain)
+	assert.False(t, c.isCurrent(old))
+
+	// Без ключа значение не расшифровывается
+	_, err = newTestCipher(t, testFieldKey("k3", 3)).Decrypt(fieldAddress, enc)
+	require.ErrorIs(t, err, ErrNoEncryptionKey)
+
+	// Слепой индекс не зависит от регистра и лишних пробелов
+	assert.Equal(t, c.BlindIndex(fieldAddress, "Москва, ул. Ленина 1"), c.BlindIndex(fieldAddress, "  москва,  УЛ. ленина 1 "))
+	assert.NotEqual(t, c.BlindIndex(fieldAddress, "test"), c.BlindIndex(fieldAddress, "test 2"))
+	assert.Empty(t, c.BlindIndex(fieldAddress, ""))
+}
+
+// TestNewFieldCipherInvalid - тест для проверки отказа при некорректных ключах
+func TestNewFieldCipherInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []FieldKey
+		indexKey []byte
+	}{
+		{name: "no keys", indexKey: testIndexKey},
+		{name: "short key", keys: []FieldKey{{ID: "k1", Key: []byte("short")}}, indexKey: testIndexKey},
+		{name: "empty id", keys: []FieldKey{testFieldKey("", 1)}, indexKey: testIndexKey},
+		{name: "id with colon", keys: []FieldKey{testFieldKey("k:1", 1)}, indexKey: testIndexKey},
+		{name: "duplicate id", keys: []FieldKey{testFieldKey("k1", 1), testFieldKey("k1", 2)}, indexKey: testIndexKey},
+		{name: "short index key", keys: []FieldKey{testFieldKey("k1", 1)}, indexKey: []byte("short")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFieldCipher(tt.keys, tt.indexKey)
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestEncryptedStore - тест для проверки хранения адресов в зашифрованном виде и поиска по адресу
+func TestEncryptedStore(t *testing.T) {
+	store := openEncryptedStore(t, filepath.Join(t.TempDir(), "tracker.db"), newTestCipher(t, testFieldKey("k1", 1)))
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	p, err := service.RegisterContext(ctx, 1000, "Секретный адрес 7")
+	require.NoError(t, err)
+	require.NoError(t, service.ChangeAddressContext(ctx, p.Number, "Секретный адрес 8"))
+
+	// Сервис работает с открытыми адресами
+	got, err := service.Parcel(ctx, p.Number)
+	require.NoError(t, err)
+	assert.Equal(t, "Секретный адрес 8", got.Address)
+	events, err := store.GetEventsAfter(ctx, 0, EventFilter{Parcel: p.Number}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "Секретный адрес 7", events[0].Address)
+	assert.Equal(t, "Секретный адрес 8", events[1].Address)
+
+	// В базе адрес не хранится открыто ни в посылке, ни в событиях
+	var address string
+	require.NoError(t, store.db.QueryRowContext(ctx, "SELECT address FROM parcel WHERE number = ?", p.Number).Scan(&address))
+	assert.True(t, strings.HasPrefix(address, encryptedPrefix))
+	var leaked int
+	require.NoError(t, store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM outbox WHERE payload LIKE '%Секретный%'").Scan(&leaked))
+	assert.Zero(t, leaked)
+
+	// Посылка находится по адресу через слепой индекс
+	found, err := service.FindByAddress(ctx, "секретный  АДРЕС 8")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, p.Number, found[0].Number)
+	found, err = service.FindByAddress(ctx, "Секретный адрес 7")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	// Клиент не находит чужие посылки
+	found, err = service.FindByAddress(WithIdentity(ctx, Identity{Role: RoleClient, Client: 2000}), "Секретный адрес 8")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+// TestReencrypt - тест для проверки смены ключа шифрования и шифрования ранее открытых данных
+func TestReencrypt(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "tracker.db")
+	ctx := context.Background()
+
+	// Данные, записанные до включения шифрования
+	plain := openEncryptedStore(t, dsn, nil)
+	plainParcel, err := NewParcelService(plain, WithOutput(io.Discard)).RegisterContext(ctx, 1000, "открытый адрес")
+	require.NoError(t, err)
+	hook, err := plain.AddWebhook(ctx, WebhookSubscription{Client: 1000, URL: "http://localhost/hook", Secret: "secret", Events: []string{EventRegistered}})
+	require.NoError(t, err)
+	require.NoError(t, plain.addWebhookDeadLetter(ctx, WebhookDeadLetter{Subscription: hook, URL: "http://localhost/hook",
+		EventType: EventRegistered, Payload: `{"address":"открытый адрес"}`, FailedAt: "2024-01-01T00:00:00Z"}))
+	require.NoError(t, plain.Close())
+
+	// Данные, записанные старым ключом
+	old := openEncryptedStore(t, dsn, newTestCipher(t, testFieldKey("k1", 1)))
+	oldParcel, err := NewParcelService(old, WithOutput(io.Discard)).RegisterContext(ctx, 1000, "старый адрес")
+	require.NoError(t, err)
+	require.NoError(t, old.Close())
+
+	// Новый ключ становится текущим, старый остаётся для чтения
+	store := openEncryptedStore(t, dsn, newTestCipher(t, testFieldKey("k2", 2), testFieldKey("k1", 1)))
+	got, err := store.GetContext(ctx, oldParcel.Number)
+	require.NoError(t, err)
+	assert.Equal(t, "старый адрес", got.Address)
+
+	// Открытые адреса не имеют слепого индекса до перешифрования
+	found, err := store.GetByAddressContext(ctx, "открытый адрес")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	report, err := store.Reencrypt(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ReencryptReport{Parcels: 2, Events: 2, DeadLetters: 1, Webhooks: 1}, report)
+
+	// Повторное перешифрование ничего не меняет
+	report, err = store.Reencrypt(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ReencryptReport{}, report)
+
+	found, err = store.GetByAddressContext(ctx, "открытый адрес")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, plainParcel.Number, found[0].Number)
+	require.NoError(t, store.Close())
+
+	// После перешифрования старый ключ больше не нужен
+	current := openEncryptedStore(t, dsn, newTestCipher(t, testFieldKey("k2", 2)))
+	for _, number := range []int{plainParcel.Number, oldParcel.Number} {
+		_, err := current.GetContext(ctx, number)
+		require.NoError(t, err)
+	}
+	events, err := current.GetEventsAfter(ctx, 0, EventFilter{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "открытый адрес", events[0].Address)
+	assert.Equal(t, "старый адрес", events[1].Address)
+	letters, err := current.GetWebhookDeadLetters(ctx, hook)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, `{"address":"открытый адрес"}`, letters[0].Payload)
+
+	// Ключ подписи уведомлений хранится зашифрованным
+	var secret string
+	require.NoError(t, current.db.QueryRow("SELECT secret FROM webhook_subscription WHERE id = ?", hook).Scan(&secret))
+	assert.True(t, strings.HasPrefix(secret, encryptedPrefix+"k2:"), secret)
+	hooks, err := current.GetWebhooksByClient(ctx, 1000)
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+	assert.Equal(t, "secret", hooks[0].Secret)
+}
+
+// TestReservedAddress - тест для проверки того, что адрес с префиксом шифротекста не принимается
+// и при выключенном шифровании не делает посылки клиента нечитаемыми
+func TestReservedAddress(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+	const client = 1000
+
+	_, err := service.RegisterContext(ctx, client, encryptedPrefix+"k1:AAAA")
+	require.ErrorIs(t, err, ErrReservedAddress)
+
+	parcel, err := service.RegisterContext(ctx, client, "test")
+	require.NoError(t, err)
+	err = service.ChangeAddressContext(ctx, parcel.Number, encryptedPrefix+"k1:AAAA")
+	require.ErrorIs(t, err, ErrReservedAddress)
+
+	parcels, err := store.GetByClientContext(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+	assert.Equal(t, "test", parcels[0].Address)
+
+	// Префикс в середине адреса допустим
+	_, err = service.RegisterContext(ctx, client, "дом "+encryptedPrefix)
+	require.NoError(t, err)
+}
+
+// TestEncryptionConfig - тест для проверки ключей шифрования в конфигурации
+func TestEncryptionConfig(t *testing.T) {
+	key := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	}
+
+	// Без ключей шифрование выключено
+	c, err := DefaultConfig().Encryption.Cipher()
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	cfg, err := LoadConfig(writeConfig(t, ""), testEnv(map[string]string{
+		"TRACKER_ENCRYPTION_KEYS":      "k2=" + key(2) + ", k1=" + key(1),
+		"TRACKER_ENCRYPTION_INDEX_KEY": key('i'),
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []EncryptionKey{{ID: "k2", Key: key(2)}, {ID: "k1", Key: key(1)}}, cfg.Encryption.Keys)
+
+	c, err = cfg.Encryption.Cipher()
+	require.NoError(t, err)
+	enc, err := c.Encrypt(fieldAddress, "test")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enc, encryptedPrefix+"k2:"))
+
+	// Ключи не выводятся вместе с конфигурацией
+	var out bytes.Buffer
+	require.NoError(t, cfg.Print(&out))
+	assert.NotContains(t, out.String(), key(2))
+	assert.NotContains(t, out.String(), key('i'))
+	assert.Equal(t, key(2), cfg.Encryption.Keys[0].Key)
+
+	for name, env := range map[string]map[string]string{
+		"not base64":        {"TRACKER_ENCRYPTION_KEYS": "k1=###", "TRACKER_ENCRYPTION_INDEX_KEY": key('i')},
+		"short key":         {"TRACKER_ENCRYPTION_KEYS": "k1=c2hvcnQ=", "TRACKER_ENCRYPTION_INDEX_KEY": key('i')},
+		"missing id":        {"TRACKER_ENCRYPTION_KEYS": key(1), "TRACKER_ENCRYPTION_INDEX_KEY": key('i')},
+		"missing index key": {"TRACKER_ENCRYPTION_KEYS": "k1=" + key(1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, ""), testEnv(env))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+)
+
+// anyZone - значение правила срока в пути, подходящее для любой зоны или уровня обслуживания
+const anyZone = "*"
+
+// ErrInvalidServiceLevel - уровень обслуживания не соответствует формату
+var ErrInvalidServiceLevel = errors.New("service level must be 1 to 32 lowercase letters, digits, '_' or '-'")
+
+// serviceLevelPattern - допустимые уровни обслуживания
+var serviceLevelPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// RegisterOption - дополнительный параметр регистрации посылки
+type RegisterOption func(*Parcel)
+
+// WithServiceLevel - уровень обслуживания регистрируемой посылки, по умолчанию standard
+func WithServiceLevel(level string) RegisterOption {
+	return func(p *Parcel) {
+		p.ServiceLevel = level
+	}
+}
+
+// ZoneRule - зона доставки и части адреса, по которым она определяется
+type ZoneRule struct {
+	Name  string   `yaml:"name"`
+	Match []string `yaml:"match"` // Подстроки адреса, сравниваются без учёта регистра
+}
+
+// TransitRule - срок в пути в рабочих днях из зоны From в зону To для уровня обслуживания.
+// Значение * подходит для любой зоны и любого уровня
+type TransitRule struct {
+	From         string `yaml:"from"`
+	To           string `yaml:"to"`
+	ServiceLevel string `yaml:"service_level"`
+	Days         int    `yaml:"days"`
+}
+
+// matches - подходит ли правило для зон и уровня обслуживания
+func (r TransitRule) matches(from, to, level string) bool {
+	return (r.From == anyZone || r.From == from) && (r.To == anyZone || r.To == to) &&
+		(r.ServiceLevel == anyZone || r.ServiceLevel == level)
+}
+
+// ETACalculator - расчёт ожидаемой даты доставки. Посылка передаётся в доставку в день отправки, если он
+// рабочий и посылка отправлена до времени окончания приёма (cut-off), иначе - в следующий рабочий день.
+// Дата доставки - через срок в пути в рабочих днях производственного календаря между зоной отправки и зоной адреса
+type ETACalculator struct {
+	origin   string
+	zones    []ZoneRule
+	fallback string
+	transit  []TransitRule
+	cutOff   time.Duration
+	calendar *calendar.Calendar
+}
+
+// Zone - зона доставки адреса: первая зона, одна из подстрок которой входит в адрес
+func (c *ETACalculator) Zone(address string) string {
+	address = strings.ToLower(address)
+	for _, z := range c.zones {
+		for _, m := range z.Match {
+			if strings.Contains(address, strings.ToLower(m)) {
+				return z.Name
+			}
+		}
+	}
+	return c.fallback
+}
+
+// Estimate - ожидаемая дата доставки посылки с адресом address уровня level, отправляемой в момент at.
+// Пустая строка, если для зоны и уровня нет срока в пути
+func (c *ETACalculator) Estimate(address, level string, at time.Time) string {
+	to := c.Zone(address)
+	days := -1
+	for _, r := range c.transit {
+		if r.matches(c.origin, to, level) {
+			days = r.Days
+			break
+		}
+	}
+	if days < 0 {
+		return ""
+	}
+
+	day := c.calendar.StartOfDay(at)
+	if !c.calendar.IsBusinessDay(day) || at.Sub(day) >= c.cutOff {
+		day = c.calendar.NextBusinessDay(day)
+	}
+	return c.calendar.AddBusinessDays(day, days).Format(calendar.DateFormat)
+}
+
+// estimateETA - ожидаемая дата доставки посылки, отправляемой в момент at. Без калькулятора - пустая строка
+func (s ParcelService) estimateETA(p Parcel, at time.Time) string {
+	if s.eta == nil {
+		return ""
+	}
+	return s.eta.Estimate(p.Address, p.ServiceLevel, at)
+}
+
+// SetETA - метод для записи ожидаемой даты доставки посылки, пустая строка - дата не рассчитана
+func (s ParcelStore) SetETA(ctx context.Context, number int, eta string) (err error) {
+	ctx, op := s.begin(ctx, "set_eta", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "UPDATE parcel SET eta = NULLIF(:eta, '') WHERE number = :number",
+		sql.Named("eta", eta),
+		sql.Named("number", number))
+	if err != nil {
+		return fmt.Errorf("failed to set eta of parcel %d: error: %w", number, err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCalendar - календарь Москвы с праздником 12 июня 2025 года
+func testCalendar(t *testing.T) *calendar.Calendar {
+	holidays := filepath.Join(t.TempDir(), "holidays.txt")
+	require.NoError(t, os.WriteFile(holidays, []byte("# праздники\nRU 2025-06-12 День России\n\n"), 0o600))
+	cal, err := CalendarConfig{TimeZone: "Europe/Moscow", Region: "RU-MOW", HolidaysFile: holidays, WorkingHours: "09:00-18:00"}.Calendar()
+	require.NoError(t, err)
+	return cal
+}
+
+// testETAConfig - отправка из Москвы: по Москве за день, в Петербург за 2 дня, в остальные регионы за 5,
+// срочные посылки - за день в любой регион
+func testETAConfig() ETAConfig {
+	return ETAConfig{
+		CutOff:      "14:00",
+		OriginZone:  "msk",
+		DefaultZone: "other",
+		Zones: []ZoneRule{
+			{Name: "msk", Match: []string{"Москва", "Moscow"}},
+			{Name: "spb", Match: []string{"Санкт-Петербург"}},
+		},
+		Transit: []TransitRule{
+			{From: anyZone, To: anyZone, ServiceLevel: "express", Days: 1},
+			{From: "msk", To: "msk", ServiceLevel: ServiceLevelStandard, Days: 1},
+			{From: "msk", To: "spb", ServiceLevel: ServiceLevelStandard, Days: 2},
+			{From: "msk", To: anyZone, ServiceLevel: ServiceLevelStandard, Days: 5},
+		},
+	}
+}
+
+// TestETAEstimate - тест для проверки расчёта ожидаемой даты доставки
+func TestETAEstimate(t *testing.T) {
+	calc, err := testETAConfig().Calculator(testCalendar(t))
+	require.NoError(t, err)
+	msk := time.FixedZone("MSK", 3*60*60)
+
+	tests := []struct {
+		name    string
+		address string
+		level   string
+		at      time.Time
+		want    string
+	}{
+		{name: "same zone before cut-off", address: "г. Москва, ул. Ленина", level: ServiceLevelStandard, at: time.Date(2025, 6, 2, 10, 0, 0, 0, msk), want: "2025-06-03"},
+		{name: "after cut-off", address: "г. москва, ул. Ленина", level: ServiceLevelStandard, at: time.Date(2025, 6, 2, 14, 0, 0, 0, msk), want: "2025-06-04"},
+		// 11:30 UTC - 14:30 в Москве
+		{name: "cut-off in time zone", address: "Moscow", level: ServiceLevelStandard, at: time.Date(2025, 6, 2, 11, 30, 0, 0, time.UTC), want: "2025-06-04"},
+		{name: "friday after cut-off", address: "Москва", level: ServiceLevelStandard, at: time.Date(2025, 6, 6, 18, 0, 0, 0, msk), want: "2025-06-10"},
+		{name: "weekend", address: "Санкт-Петербург", level: ServiceLevelStandard, at: time.Date(2025, 6, 7, 9, 0, 0, 0, msk), want: "2025-06-11"},
+		{name: "holiday", address: "Санкт-Петербург", level: ServiceLevelStandard, at: time.Date(2025, 6, 10, 9, 0, 0, 0, msk), want: "2025-06-13"},
+		{name: "default zone", address: "Новосибирск", level: ServiceLevelStandard, at: time.Date(2025, 6, 2, 9, 0, 0, 0, msk), want: "2025-06-09"},
+		{name: "express", address: "Новосибирск", level: "express", at: time.Date(2025, 6, 2, 9, 0, 0, 0, msk), want: "2025-06-03"},
+		{name: "no transit rule", address: "Москва", level: "economy", at: time.Date(2025, 6, 2, 9, 0, 0, 0, msk), want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, calc.Estimate(tt.address, tt.level, tt.at))
+		})
+	}
+}
+
+// TestETACalculatorInvalid - тест для проверки отказа при некорректных параметрах расчёта
+func TestETACalculatorInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *ETAConfig)
+	}{
+		{name: "invalid cut-off", modify: func(c *ETAConfig) { c.CutOff = "2pm" }},
+		{name: "no default zone", modify: func(c *ETAConfig) { c.DefaultZone = "" }},
+		{name: "unknown origin", modify: func(c *ETAConfig) { c.OriginZone = "kzn" }},
+		{name: "zone without match", modify: func(c *ETAConfig) { c.Zones = append(c.Zones, ZoneRule{Name: "kzn"}) }},
+		{name: "unknown transit zone", modify: func(c *ETAConfig) { c.Transit[1].To = "kzn" }},
+		{name: "negative days", modify: func(c *ETAConfig) { c.Transit[0].Days = -1 }},
+		{name: "invalid service level", modify: func(c *ETAConfig) { c.Transit[0].ServiceLevel = "Express!" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testETAConfig()
+			tt.modify(&cfg)
+			_, err := cfg.Calculator(calendar.New(time.UTC))
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestParcelETA - тест для проверки расчёта даты доставки при регистрации, смене адреса и статуса
+func TestParcelETA(t *testing.T) {
+	store := openTestStore(t)
+	calc, err := testETAConfig().Calculator(testCalendar(t))
+	require.NoError(t, err)
+	service := NewParcelService(store, WithOutput(io.Discard), WithETA(calc))
+	ctx := context.Background()
+
+	// expect - ожидаемая дата на текущий момент
+	expect := func(address, level string) string {
+		eta := calc.Estimate(address, level, time.Now())
+		require.NotEmpty(t, eta)
+		return eta
+	}
+
+	p, err := service.RegisterContext(ctx, 1000, "г. Москва, ул. Ленина, д. 1")
+	require.NoError(t, err)
+	assert.Equal(t, ServiceLevelStandard, p.ServiceLevel)
+	assert.Equal(t, expect(p.Address, ServiceLevelStandard), p.ETA)
+	stored, err := store.GetContext(ctx, p.Number)
+	require.NoError(t, err)
+	assert.Equal(t, p, stored)
+
+	express, err := service.RegisterContext(ctx, 1000, "Новосибирск", WithServiceLevel("express"))
+	require.NoError(t, err)
+	assert.Equal(t, "express", express.ServiceLevel)
+	assert.Equal(t, expect(express.Address, "express"), express.ETA)
+
+	// Новый адрес в другой зоне меняет дату доставки, событие содержит новую дату
+	require.NoError(t, service.ChangeAddressContext(ctx, p.Number, "Новосибирск"))
+	stored, err = store.GetContext(ctx, p.Number)
+	require.NoError(t, err)
+	assert.Equal(t, expect("Новосибирск", ServiceLevelStandard), stored.ETA)
+	events, err := store.GetPendingEvents(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, stored.ETA, events[len(events)-1].ETA)
+
+	// Дата пересчитывается при отправке и сохраняется при доставке
+	_, err = store.db.ExecContext(ctx, "UPDATE parcel SET eta = ? WHERE number = ?", "2000-01-01", p.Number)
+	require.NoError(t, err)
+	require.NoError(t, service.NextStatusContext(ctx, p.Number))
+	stored, err = store.GetContext(ctx, p.Number)
+	require.NoError(t, err)
+	assert.Equal(t, expect("Новосибирск", ServiceLevelStandard), stored.ETA)
+	require.NoError(t, service.NextStatusContext(ctx, p.Number))
+	delivered, err := store.GetContext(ctx, p.Number)
+	require.NoError(t, err)
+	assert.Equal(t, stored.ETA, delivered.ETA)
+
+	// Уровень без срока в пути регистрируется без даты, некорректный уровень отклоняется
+	economy, err := service.RegisterContext(ctx, 1000, "Москва", WithServiceLevel("economy"))
+	require.NoError(t, err)
+	assert.Empty(t, economy.ETA)
+	_, err = service.RegisterContext(ctx, 1000, "Москва", WithServiceLevel("Express"))
+	require.ErrorIs(t, err, ErrInvalidServiceLevel)
+
+	// Повтор по ключу идемпотентности с другим уровнем отклоняется
+	_, _, err = service.RegisterIdempotent(ctx, "order-1", 1000, "Москва")
+	require.NoError(t, err)
+	_, _, err = service.RegisterIdempotent(ctx, "order-1", 1000, "Москва", WithServiceLevel("express"))
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+}
+
+// TestAPIRegisterServiceLevel - тест для проверки регистрации с уровнем обслуживания через HTTP API
+func TestAPIRegisterServiceLevel(t *testing.T) {
+	store := openTestStore(t)
+	calc, err := testETAConfig().Calculator(testCalendar(t))
+	require.NoError(t, err)
+	service := NewParcelService(store, WithOutput(io.Discard), WithETA(calc))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+	owner := "Bearer " + testToken(t, RoleClient, 1000)
+
+	status, body := apiCall(t, http.MethodPost, server.URL+"/parcels", "Authorization", owner,
+		map[string]string{"address": "Москва", "service_level": "express"})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var p Parcel
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, "express", p.ServiceLevel)
+	assert.Equal(t, calc.Estimate("Москва", "express", time.Now()), p.ETA)
+
+	status, _ = apiCall(t, http.MethodPost, server.URL+"/parcels", "Authorization", owner,
+		map[string]string{"address": "Москва", "service_level": "VIP"})
+	assert.Equal(t, http.StatusBadRequest, status)
+}
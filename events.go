@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Типы событий посылки
+const (
+	EventRegistered     = "parcel.registered"
+	EventStatusChanged  = "parcel.status_changed"
+	EventAddressChanged = "parcel.address_changed"
+	EventDeleted        = "parcel.deleted"
+	EventSLAAtRisk      = "parcel.sla_at_risk"  // Срок доставки скоро истечёт
+	EventSLABreached    = "parcel.sla_breached" // Срок доставки истёк
+)
+
+// ParcelEvent - событие изменения посылки, о котором уведомляются подписчики
+type ParcelEvent struct {
+	ID         int64     `json:"event_id"`             // Номер события в outbox, возрастает с каждым событием
+	Type       string    `json:"type"`                 // Тип события
+	Number     int       `json:"number"`               // Номер посылки
+	Client     int       `json:"client"`               // Идентификатор клиента
+	Status     string    `json:"status"`               // Статус посылки после изменения
+	OldStatus  string    `json:"old_status,omitempty"` // Статус посылки до изменения
+	Address    string    `json:"address"`              // Адрес посылки после изменения
+	OccurredAt time.Time `json:"occurred_at"`          // Время изменения
+	Deadline   string    `json:"deadline,omitempty"`   // Срок доставки для событий SLA
+	ETA        string    `json:"eta,omitempty"`        // Ожидаемая дата доставки после изменения
+}
+
+// EventSink - приёмник событий посылок.
+// Publish не должен надолго блокировать вызывающего: доставка выполняется асинхронно
+type EventSink interface {
+	Publish(ctx context.Context, event ParcelEvent) error
+}
+
+// newParcelEvent - создаёт событие типа eventType по текущему состоянию посылки
+func newParcelEvent(eventType string, p Parcel, oldStatus string) ParcelEvent {
+	return ParcelEvent{
+		Type:       eventType,
+		Number:     p.Number,
+		Client:     p.Client,
+		Status:     p.Status,
+		OldStatus:  oldStatus,
+		Address:    p.Address,
+		OccurredAt: time.Now().UTC(),
+		ETA:        p.ETA,
+	}
+}
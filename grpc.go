@@ -0,0 +1,428 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/trackerpb"
+)
+
+// GRPCServer - gRPC-интерфейс сервиса посылок по контракту trackerpb/tracker.proto
+type GRPCServer struct {
+	trackerpb.UnimplementedParcelTrackerServer
+
+	service ParcelService
+	broker  *EventBroker
+	auth    *Authenticator
+	limiter *RateLimiter
+	logger  *slog.Logger
+}
+
+// NewGRPCServer - конструктор gRPC-интерфейса. broker - источник новых событий для WatchParcels.
+// Вызовы принимаются только с учётными данными в метаданных x-api-key или authorization, как в HTTP API;
+// auth может быть nil, тогда все вызовы отклоняются с кодом Unauthenticated.
+// limiter может быть nil, тогда частота запросов не ограничивается
+func NewGRPCServer(service ParcelService, broker *EventBroker, auth *Authenticator, limiter *RateLimiter) *GRPCServer {
+	return &GRPCServer{service: service, broker: broker, auth: auth, limiter: limiter, logger: service.logger}
+}
+
+// Serve - обслуживает gRPC-запросы на lis, пока не отменён ctx, затем корректно останавливает сервер.
+// Если активные потоки не завершились за shutdownTimeout, они прерываются
+func (srv *GRPCServer) Serve(ctx context.Context, lis net.Listener) error {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(srv.traceUnary, srv.authUnary, srv.limitUnary),
+		grpc.ChainStreamInterceptor(srv.traceStream, srv.authStream, srv.limitStream),
+	)
+	trackerpb.RegisterParcelTrackerServer(server, srv)
+
+	errCh := make(chan error, 1)
+	go func() {
+		srv.logger.Info("grpc server started", slog.String("addr", lis.Addr().String()))
+		errCh <- server.Serve(lis)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("grpc server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		server.Stop()
+	}
+
+	if err := <-errCh; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("grpc server failed: %w", err)
+	}
+	return nil
+}
+
+// ListenAndServe - обслуживает gRPC-запросы на адресе addr, пока не отменён ctx
+func (srv *GRPCServer) ListenAndServe(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return srv.Serve(ctx, lis)
+}
+
+// RegisterParcel - регистрирует новую посылку клиента
+func (srv *GRPCServer) RegisterParcel(ctx context.Context, req *trackerpb.RegisterParcelRequest) (*trackerpb.Parcel, error) {
+	if req.GetClient() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "client must be positive")
+	}
+	if req.GetAddress() == "" {
+		return nil, status.Error(codes.InvalidArgument, "address is required")
+	}
+
+	var parcel Parcel
+	var err error
+	if key := req.GetIdempotencyKey(); key != "" {
+		parcel, _, err = srv.service.RegisterIdempotent(ctx, key, int(req.GetClient()), req.GetAddress())
+	} else {
+		parcel, err = srv.service.RegisterContext(ctx, int(req.GetClient()), req.GetAddress())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoParcel(parcel), nil
+}
+
+// GetParcel - возвращает посылку по номеру
+func (srv *GRPCServer) GetParcel(ctx context.Context, req *trackerpb.GetParcelRequest) (*trackerpb.Parcel, error) {
+	if req.GetNumber() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "number must be positive")
+	}
+
+	parcel, err := srv.service.Parcel(ctx, int(req.GetNumber()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoParcel(parcel), nil
+}
+
+// ListClientParcels - передаёт все посылки клиента по одной
+func (srv *GRPCServer) ListClientParcels(req *trackerpb.ListClientParcelsRequest, stream grpc.ServerStreamingServer[trackerpb.Parcel]) error {
+	if req.GetClient() <= 0 {
+		return status.Error(codes.InvalidArgument, "client must be positive")
+	}
+
+	parcels, err := srv.service.ClientParcels(stream.Context(), int(req.GetClient()))
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, parcel := range parcels {
+		if err := stream.Send(toProtoParcel(parcel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdvanceStatus - переводит посылку в следующий статус и возвращает её
+func (srv *GRPCServer) AdvanceStatus(ctx context.Context, req *trackerpb.AdvanceStatusRequest) (*trackerpb.Parcel, error) {
+	if req.GetNumber() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "number must be positive")
+	}
+
+	advanced, err := srv.service.nextStatus(ctx, int(req.GetNumber()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !advanced {
+		return nil, status.Errorf(codes.FailedPrecondition, "parcel %d is already delivered", req.GetNumber())
+	}
+	return srv.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: req.GetNumber()})
+}
+
+// ChangeAddress - меняет адрес зарегистрированной посылки и возвращает её
+func (srv *GRPCServer) ChangeAddress(ctx context.Context, req *trackerpb.ChangeAddressRequest) (*trackerpb.Parcel, error) {
+	if req.GetNumber() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "number must be positive")
+	}
+	if req.GetAddress() == "" {
+		return nil, status.Error(codes.InvalidArgument, "address is required")
+	}
+
+	updated, err := srv.service.changeAddress(ctx, int(req.GetNumber()), req.GetAddress())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !updated {
+		return nil, srv.deniedError(ctx, int(req.GetNumber()))
+	}
+	return srv.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: req.GetNumber()})
+}
+
+// DeleteParcel - удаляет зарегистрированную посылку
+func (srv *GRPCServer) DeleteParcel(ctx context.Context, req *trackerpb.DeleteParcelRequest) (*trackerpb.DeleteParcelResponse, error) {
+	if req.GetNumber() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "number must be positive")
+	}
+
+	deleted, err := srv.service.delete(ctx, int(req.GetNumber()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !deleted {
+		return nil, srv.deniedError(ctx, int(req.GetNumber()))
+	}
+	return &trackerpb.DeleteParcelResponse{}, nil
+}
+
+// WatchParcels - поток событий посылки или всех посылок клиента.
+// Сначала передаются события из истории после last_event_id, затем новые события
+func (srv *GRPCServer) WatchParcels(req *trackerpb.WatchParcelsRequest, stream grpc.ServerStreamingServer[trackerpb.ParcelEvent]) error {
+	filter := EventFilter{Parcel: int(req.GetParcel()), Client: int(req.GetClient()), Types: req.GetTypes()}
+	if filter.Parcel == 0 && filter.Client == 0 {
+		return status.Error(codes.InvalidArgument, "parcel or client is required")
+	}
+	if len(filter.Types) == 0 {
+		filter.Types = sseEventTypes
+	}
+
+	ctx := stream.Context()
+	live, unsubscribe := srv.broker.Subscribe(filter)
+	defer unsubscribe()
+
+	err := followEvents(ctx, srv.service, live, filter, req.GetLastEventId(),
+		func(event ParcelEvent) error {
+			return stream.Send(toProtoEvent(event))
+		}, nil)
+	switch {
+	case errors.Is(err, errSubscriberDropped):
+		return status.Error(codes.Unavailable, "subscriber is too slow, resume with last_event_id")
+	case err != nil:
+		return grpcError(err)
+	}
+	return nil
+}
+
+// deniedError - объясняет отказ в изменении посылки: её нет или она уже отправлена
+func (srv *GRPCServer) deniedError(ctx context.Context, number int) error {
+	parcel, err := srv.service.Parcel(ctx, number)
+	if err != nil {
+		return grpcError(err)
+	}
+	return status.Errorf(codes.FailedPrecondition, "parcel %d has status %s and can no longer be changed", number, parcel.Status)
+}
+
+// grpcError - преобразует ошибку сервиса в gRPC-статус
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "parcel not found")
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, ErrUnauthenticated.Error())
+	case errors.Is(err, ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrInvalidIdempotencyKey), errors.Is(err, ErrReservedAddress):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrRateLimited):
+		return rateLimitStatus(err)
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// authenticate - устанавливает вызывающего по метаданным вызова и возвращает контекст с ним
+func (srv *GRPCServer) authenticate(ctx context.Context) (context.Context, error) {
+	if srv.auth == nil {
+		return nil, status.Error(codes.Unauthenticated, ErrUnauthenticated.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	id, err := srv.auth.authenticate(ctx, first("x-api-key"), first("authorization"))
+	if err != nil {
+		if !errors.Is(err, ErrUnauthenticated) {
+			srv.logger.ErrorContext(ctx, "authentication failed", slog.Any(attrError, err))
+		}
+		return nil, grpcError(err)
+	}
+	return WithIdentity(ctx, id), nil
+}
+
+// authUnary - пропускает к handler только вызовы с действительными учётными данными
+func (srv *GRPCServer) authUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := srv.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStream - пропускает к handler только потоки с действительными учётными данными
+func (srv *GRPCServer) authStream(srvImpl any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := srv.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srvImpl, &identityStream{ServerStream: ss, ctx: ctx})
+}
+
+// identityStream - поток с контекстом, содержащим вызывающего или его трассу
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context - контекст потока с вызывающим
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// traceContext - продолжает в ctx трассу вызывающего из метаданных traceparent и baggage
+func (srv *GRPCServer) traceContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return srv.service.tracer.Extract(ctx, metadataCarrier(md))
+}
+
+// traceUnary - продолжает трассу вызывающего в вызове
+func (srv *GRPCServer) traceUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(srv.traceContext(ctx), req)
+}
+
+// traceStream - продолжает трассу вызывающего в потоке
+func (srv *GRPCServer) traceStream(srvImpl any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srvImpl, &identityStream{ServerStream: ss, ctx: srv.traceContext(ss.Context())})
+}
+
+// metadataCarrier - метаданные gRPC как носитель контекста трассы OpenTelemetry
+type metadataCarrier metadata.MD
+
+// Get - первое значение ключа key
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Set - заменяет значения ключа key
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys - ключи метаданных
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// limitUnary - отклоняет вызовы вызывающего, исчерпавшего корзины своих учётных данных или клиента
+func (srv *GRPCServer) limitUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := srv.allow(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// limitStream - отклоняет потоки вызывающего, исчерпавшего корзины, поток расходует один токен при открытии
+func (srv *GRPCServer) limitStream(srvImpl any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := srv.allow(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srvImpl, ss)
+}
+
+// allow - расходует токены корзин аутентифицированного вызывающего, как rateLimited в HTTP API
+func (srv *GRPCServer) allow(ctx context.Context) error {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if err := srv.limiter.allowIdentity(id); err != nil {
+		return rateLimitStatus(err)
+	}
+	return nil
+}
+
+// rateLimitStatus - статус ResourceExhausted с подсказкой RetryInfo, когда повторить вызов
+func rateLimitStatus(err error) error {
+	st := status.New(codes.ResourceExhausted, err.Error())
+	var limited *RateLimitError
+	if !errors.As(err, &limited) {
+		return st.Err()
+	}
+	if detailed, derr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(limited.RetryAfter)}); derr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// protoStatuses - соответствие статусов посылки значениям перечисления ParcelStatus
+var protoStatuses = map[string]trackerpb.ParcelStatus{
+	ParcelStatusRegistered: trackerpb.ParcelStatus_PARCEL_STATUS_REGISTERED,
+	ParcelStatusSent:       trackerpb.ParcelStatus_PARCEL_STATUS_SENT,
+	ParcelStatusDelivered:  trackerpb.ParcelStatus_PARCEL_STATUS_DELIVERED,
+}
+
+// toProtoParcel - преобразует посылку в сообщение protobuf
+func toProtoParcel(p Parcel) *trackerpb.Parcel {
+	msg := &trackerpb.Parcel{
+		Number:       int64(p.Number),
+		Client:       int64(p.Client),
+		Status:       protoStatuses[p.Status],
+		Address:      p.Address,
+		TrackingCode: p.TrackingCode,
+	}
+	if createdAt, err := time.Parse(time.RFC3339, p.CreatedAt); err == nil {
+		msg.CreatedAt = timestamppb.New(createdAt)
+	}
+	return msg
+}
+
+// toProtoEvent - преобразует событие посылки в сообщение protobuf
+func toProtoEvent(e ParcelEvent) *trackerpb.ParcelEvent {
+	return &trackerpb.ParcelEvent{
+		EventId:    e.ID,
+		Type:       e.Type,
+		Number:     int64(e.Number),
+		Client:     int64(e.Client),
+		Status:     protoStatuses[e.Status],
+		OldStatus:  protoStatuses[e.OldStatus],
+		Address:    e.Address,
+		OccurredAt: timestamppb.New(e.OccurredAt),
+	}
+}
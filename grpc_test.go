@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/trackerpb"
+)
+
+// startGRPC - запускает gRPC-сервер на bufconn и возвращает подключённого к нему клиента с JWT администратора
+func startGRPC(t *testing.T, service ParcelService, broker *EventBroker, limiter *RateLimiter) trackerpb.ParcelTrackerClient {
+	return dialGRPC(t, startGRPCServer(t, service, broker, limiter), bearer(t, RoleAdmin, 0))
+}
+
+// startGRPCServer - запускает gRPC-сервер на bufconn с аутентификацией по testJWTKey
+func startGRPCServer(t *testing.T, service ParcelService, broker *EventBroker, limiter *RateLimiter) *bufconn.Listener {
+	lis := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewGRPCServer(service, broker, NewAuthenticator(service.store, testJWTKey), limiter).Serve(ctx, lis)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return lis
+}
+
+// dialGRPC - подключает клиента к серверу на lis. Каждый вызов передаёт creds в метаданных,
+// nil - вызовы без учётных данных
+func dialGRPC(t *testing.T, lis *bufconn.Listener, creds grpcCredentials) trackerpb.ParcelTrackerClient {
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
+	conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+	require.NoError(t, err, "failed to create grpc client. Error: %v", err)
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return trackerpb.NewParcelTrackerClient(conn)
+}
+
+// grpcCredentials - метаданные с учётными данными, передаваемые в каждом вызове
+type grpcCredentials map[string]string
+
+// GetRequestMetadata - метаданные вызова
+func (c grpcCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return c, nil
+}
+
+// RequireTransportSecurity - bufconn в тестах работает без TLS
+func (c grpcCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// bearer - метаданные с JWT вызывающего с ролью role
+func bearer(t *testing.T, role Role, client int) grpcCredentials {
+	return grpcCredentials{"authorization": "Bearer " + testToken(t, role, client)}
+}
+
+// assertCode - проверяет gRPC-код ошибки
+func assertCode(t *testing.T, want codes.Code, err error) {
+	t.Helper()
+	require.Error(t, err)
+	assert.Equal(t, want, status.Code(err), "unexpected error: %v", err)
+}
+
+// TestGRPCParcelLifecycle - тест для проверки регистрации, изменения и удаления посылки через gRPC
+func TestGRPCParcelLifecycle(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	service := NewParcelService(NewParcelStore(db), WithOutput(io.Discard))
+	client := startGRPC(t, service, NewEventBroker(), nil)
+	ctx := context.Background()
+
+	// Регистрация
+	parcel, err := client.RegisterParcel(ctx, &trackerpb.RegisterParcelRequest{Client: 1000, Address: "test"})
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NotZero(t, parcel.GetNumber())
+	assert.Equal(t, trackerpb.ParcelStatus_PARCEL_STATUS_REGISTERED, parcel.GetStatus())
+	assert.NotNil(t, parcel.GetCreatedAt())
+
+	// Получение
+	got, err := client.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: parcel.GetNumber()})
+	require.NoError(t, err)
+	assert.Equal(t, parcel.GetAddress(), got.GetAddress())
+
+	// Смена адреса
+	got, err = client.ChangeAddress(ctx, &trackerpb.ChangeAddressRequest{Number: parcel.GetNumber(), Address: "new test address"})
+	require.NoError(t, err)
+	assert.Equal(t, "new test address", got.GetAddress())
+
+	// Список посылок клиента передаётся потоком
+	list, err := client.ListClientParcels(ctx, &trackerpb.ListClientParcelsRequest{Client: 1000})
+	require.NoError(t, err)
+	var numbers []int64
+	for {
+		p, err := list.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		numbers = append(numbers, p.GetNumber())
+	}
+	assert.Contains(t, numbers, parcel.GetNumber())
+
+	// Смена статуса до доставки, дальше статус не меняется
+	got, err = client.AdvanceStatus(ctx, &trackerpb.AdvanceStatusRequest{Number: parcel.GetNumber()})
+	require.NoError(t, err)
+	assert.Equal(t, trackerpb.ParcelStatus_PARCEL_STATUS_SENT, got.GetStatus())
+	got, err = client.AdvanceStatus(ctx, &trackerpb.AdvanceStatusRequest{Number: parcel.GetNumber()})
+	require.NoError(t, err)
+	assert.Equal(t, trackerpb.ParcelStatus_PARCEL_STATUS_DELIVERED, got.GetStatus())
+	_, err = client.AdvanceStatus(ctx, &trackerpb.AdvanceStatusRequest{Number: parcel.GetNumber()})
+	assertCode(t, codes.FailedPrecondition, err)
+
+	// Доставленную посылку нельзя изменить или удалить
+	_, err = client.ChangeAddress(ctx, &trackerpb.ChangeAddressRequest{Number: parcel.GetNumber(), Address: "other"})
+	assertCode(t, codes.FailedPrecondition, err)
+	_, err = client.DeleteParcel(ctx, &trackerpb.DeleteParcelRequest{Number: parcel.GetNumber()})
+	assertCode(t, codes.FailedPrecondition, err)
+
+	// Удаление зарегистрированной посылки
+	parcel, err = client.RegisterParcel(ctx, &trackerpb.RegisterParcelRequest{Client: 1000, Address: "test"})
+	require.NoError(t, err)
+	_, err = client.DeleteParcel(ctx, &trackerpb.DeleteParcelRequest{Number: parcel.GetNumber()})
+	require.NoError(t, err)
+	_, err = client.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: parcel.GetNumber()})
+	assertCode(t, codes.NotFound, err)
+}
+
+// TestGRPCErrors - тест для проверки кодов ошибок gRPC
+func TestGRPCErrors(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	service := NewParcelService(NewParcelStore(db), WithOutput(io.Discard))
+	client := startGRPC(t, service, NewEventBroker(), nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{
+			name: "register without address",
+			call: func() error {
+				_, err := client.RegisterParcel(ctx, &trackerpb.RegisterParcelRequest{Client: 1})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "get unknown parcel",
+			call: func() error {
+				_, err := client.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: 1 << 40})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "advance unknown parcel",
+			call: func() error {
+				_, err := client.AdvanceStatus(ctx, &trackerpb.AdvanceStatusRequest{Number: 1 << 40})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "change address of unknown parcel",
+			call: func() error {
+				_, err := client.ChangeAddress(ctx, &trackerpb.ChangeAddressRequest{Number: 1 << 40, Address: "test"})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "delete unknown parcel",
+			call: func() error {
+				_, err := client.DeleteParcel(ctx, &trackerpb.DeleteParcelRequest{Number: 1 << 40})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "watch without filter",
+			call: func() error {
+				stream, err := client.WatchParcels(ctx, &trackerpb.WatchParcelsRequest{})
+				if err != nil {
+					return err
+				}
+				_, err = stream.Recv()
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertCode(t, tt.code, tt.call())
+		})
+	}
+}
+
+// TestGRPCWatch - тест для проверки потока событий и возобновления по last_event_id
+func TestGRPCWatch(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	broker := NewEventBroker()
+	dispatcher := NewOutboxDispatcher(store, DefaultOutboxConfig(), broker)
+	client := startGRPC(t, service, broker, nil)
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.ChangeAddress(parcel.Number, "new test address"))
+	dispatchAll(t, dispatcher)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchParcels(ctx, &trackerpb.WatchParcelsRequest{Parcel: int64(parcel.Number)})
+	require.NoError(t, err)
+
+	// Событие из истории
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, EventAddressChanged, event.GetType())
+	assert.Equal(t, "new test address", event.GetAddress())
+	addressID := event.GetEventId()
+
+	// Новое событие приходит в открытый поток
+	require.NoError(t, service.NextStatus(parcel.Number))
+	dispatchAll(t, dispatcher)
+
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, EventStatusChanged, event.GetType())
+	assert.Equal(t, trackerpb.ParcelStatus_PARCEL_STATUS_SENT, event.GetStatus())
+	assert.Equal(t, trackerpb.ParcelStatus_PARCEL_STATUS_REGISTERED, event.GetOldStatus())
+	statusID := event.GetEventId()
+	cancel()
+
+	// При переподключении с last_event_id поток продолжается со следующего события
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stream, err = client.WatchParcels(ctx, &trackerpb.WatchParcelsRequest{Parcel: int64(parcel.Number), LastEventId: addressID})
+	require.NoError(t, err)
+
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, statusID, event.GetEventId())
+}
+
+// TestGRPCAuthentication - тест для проверки аутентификации вызовов gRPC и прав вызывающего
+func TestGRPCAuthentication(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	lis := startGRPCServer(t, service, NewEventBroker(), nil)
+	ctx := context.Background()
+
+	parcel, err := service.RegisterContext(ctx, 1000, "test")
+	require.NoError(t, err)
+
+	// Вызовы без учётных данных или с недействительными учётными данными отклоняются
+	foreign, err := SignJWT([]byte("other-key"), JWTClaims{Subject: "admin", Role: RoleAdmin, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	for name, creds := range map[string]grpcCredentials{
+		"no credentials":  nil,
+		"invalid jwt":     {"authorization": "Bearer invalid"},
+		"foreign jwt":     {"authorization": "Bearer " + foreign},
+		"unknown api key": {"x-api-key": "trk_unknown"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			anonymous := dialGRPC(t, lis, creds)
+			_, err := anonymous.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: int64(parcel.Number)})
+			assertCode(t, codes.Unauthenticated, err)
+			_, err = anonymous.DeleteParcel(ctx, &trackerpb.DeleteParcelRequest{Number: int64(parcel.Number)})
+			assertCode(t, codes.Unauthenticated, err)
+
+			stream, err := anonymous.ListClientParcels(ctx, &trackerpb.ListClientParcelsRequest{Client: 1000})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			assertCode(t, codes.Unauthenticated, err)
+			watch, err := anonymous.WatchParcels(ctx, &trackerpb.WatchParcelsRequest{Client: 1000})
+			require.NoError(t, err)
+			_, err = watch.Recv()
+			assertCode(t, codes.Unauthenticated, err)
+		})
+	}
+
+	// API-ключ передаётся в метаданных x-api-key
+	key := "trk_grpc_test_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err = store.AddAPIKey(ctx, APIKey{Name: "grpc", Role: RoleClient, Client: 1000}, key)
+	require.NoError(t, err)
+	got, err := dialGRPC(t, lis, grpcCredentials{"x-api-key": key}).GetParcel(ctx, &trackerpb.GetParcelRequest{Number: int64(parcel.Number)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), got.GetClient())
+
+	// Клиент работает только со своими посылками и не меняет статус, как в HTTP API
+	other := dialGRPC(t, lis, bearer(t, RoleClient, 2000))
+	_, err = other.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: int64(parcel.Number)})
+	assertCode(t, codes.NotFound, err)
+	_, err = other.DeleteParcel(ctx, &trackerpb.DeleteParcelRequest{Number: int64(parcel.Number)})
+	assertCode(t, codes.NotFound, err)
+	_, err = other.RegisterParcel(ctx, &trackerpb.RegisterParcelRequest{Client: 1000, Address: "test"})
+	assertCode(t, codes.PermissionDenied, err)
+	stream, err := other.ListClientParcels(ctx, &trackerpb.ListClientParcelsRequest{Client: 1000})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assertCode(t, codes.PermissionDenied, err)
+
+	owner := dialGRPC(t, lis, bearer(t, RoleClient, 1000))
+	_, err = owner.AdvanceStatus(ctx, &trackerpb.AdvanceStatusRequest{Number: int64(parcel.Number)})
+	assertCode(t, codes.PermissionDenied, err)
+	_, err = owner.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: int64(parcel.Number)})
+	require.NoError(t, err)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// maxIdempotencyKeyLength - наибольшая длина ключа идемпотентности
+const maxIdempotencyKeyLength = 255
+
+// ErrIdempotencyKeyReused - ключ идемпотентности уже использован для запроса с другими данными
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+
+// ErrInvalidIdempotencyKey - ключ идемпотентности пуст или слишком длинный
+var ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+
+// idempotencyRecord - ключ идемпотентности регистрации и зарегистрированная по нему посылка.
+// Ключи действуют в пределах одного клиента
+type idempotencyRecord struct {
+	Key         string
+	Client      int
+	RequestHash string // Хеш данных запроса, повтор с другими данными отклоняется
+	Parcel      int
+	CreatedAt   string
+	ExpiresAt   string
+}
+
+// newIdempotencyRecord - создаёт запись ключа для запроса регистрации, действующую ttl
+func newIdempotencyRecord(key string, client int, address, serviceLevel string, ttl time.Duration) idempotencyRecord {
+	now := time.Now().UTC()
+	request := strconv.Itoa(client) + "\n" + address
+	// уровень по умолчанию не входит в хеш, чтобы не менялись хеши ключей, сохранённых до появления уровней
+	if serviceLevel != ServiceLevelStandard {
+		request += "\n" + serviceLevel
+	}
+	sum := sha256.Sum256([]byte(request))
+	return idempotencyRecord{
+		Key:         key,
+		Client:      client,
+		RequestHash: hex.EncodeToString(sum[:]),
+		CreatedAt:   now.Format(time.RFC3339),
+		ExpiresAt:   now.Add(ttl).Format(time.RFC3339),
+	}
+}
+
+// claimIdempotencyKey - сохраняет ключ, если он ещё не использован или истёк.
+// Возвращает false, если ключ уже занят действующей записью
+func (s ParcelStore) claimIdempotencyKey(ctx context.Context, r idempotencyRecord) (claimed bool, err error) {
+	ctx, op := s.begin(ctx, "claim_idempotency_key", "INSERT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, r.Client), slog.Bool("claimed", claimed))
+	}()
+
+	// Истёкшая запись освобождает ключ. Запись выполняется первой, чтобы транзакция сразу заняла блокировку
+	_, err = s.q.ExecContext(ctx, "DELETE FROM idempotency_key WHERE client = :client AND key = :key AND expires_at <= :now",
+		sql.Named("client", r.Client),
+		sql.Named("key", r.Key),
+		sql.Named("now", r.CreatedAt))
+	if err != nil {
+		return false, fmt.Errorf("failed to release expired idempotency key of client %d: error: %w", r.Client, err)
+	}
+
+	res, err := s.q.ExecContext(ctx, `INSERT INTO idempotency_key (client, key, request_hash, parcel, created_at, expires_at)
+VALUES (:client, :key, :request_hash, 0, :created_at, :expires_at)
+ON CONFLICT (client, key) DO NOTHING`,
+		sql.Named("client", r.Client),
+		sql.Named("key", r.Key),
+		sql.Named("request_hash", r.RequestHash),
+		sql.Named("created_at", r.CreatedAt),
+		sql.Named("expires_at", r.ExpiresAt))
+	if err != nil {
+		return false, fmt.Errorf("failed to claim idempotency key of client %d: error: %w", r.Client, err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// replayIdempotencyKey - возвращает посылку, ранее зарегистрированную по ключу.
+// Если данные запроса отличаются от исходных, возвращает ErrIdempotencyKeyReused
+func (s ParcelStore) replayIdempotencyKey(ctx context.Context, r idempotencyRecord) (Parcel, error) {
+	stored, err := s.getIdempotencyKey(ctx, r.Client, r.Key)
+	if err != nil {
+		return Parcel{}, err
+	}
+	if stored.RequestHash != r.RequestHash {
+		return Parcel{}, fmt.Errorf("client %d, key %q: %w", r.Client, r.Key, ErrIdempotencyKeyReused)
+	}
+	return s.GetContext(ctx, stored.Parcel)
+}
+
+// getIdempotencyKey - метод для получения записи ключа идемпотентности клиента
+func (s ParcelStore) getIdempotencyKey(ctx context.Context, client int, key string) (r idempotencyRecord, err error) {
+	ctx, op := s.begin(ctx, "get_idempotency_key", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrParcel, r.Parcel))
+	}()
+
+	err = s.q.QueryRowContext(ctx, "SELECT client, key, request_hash, parcel, created_at, expires_at FROM idempotency_key WHERE client = :client AND key = :key",
+		sql.Named("client", client),
+		sql.Named("key", key)).
+		Scan(&r.Client, &r.Key, &r.RequestHash, &r.Parcel, &r.CreatedAt, &r.ExpiresAt)
+	if err != nil {
+		return r, fmt.Errorf("failed to retrieve idempotency key of client %d: error: %w", client, err)
+	}
+	return r, nil
+}
+
+// setIdempotencyParcel - метод для привязки зарегистрированной посылки к ключу идемпотентности
+func (s ParcelStore) setIdempotencyParcel(ctx context.Context, client int, key string, number int) (err error) {
+	ctx, op := s.begin(ctx, "set_idempotency_parcel", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrParcel, number))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "UPDATE idempotency_key SET parcel = :parcel WHERE client = :client AND key = :key",
+		sql.Named("parcel", number),
+		sql.Named("client", client),
+		sql.Named("key", key))
+	if err != nil {
+		return fmt.Errorf("failed to bind parcel %d to idempotency key of client %d: error: %w", number, client, err)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys - метод для удаления ключей идемпотентности, срок хранения которых истёк
+func (s ParcelStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (deleted int, err error) {
+	ctx, op := s.begin(ctx, "delete_expired_idempotency_keys", "DELETE")
+	defer func() {
+		op.end(err, slog.Int(attrCount, deleted))
+	}()
+
+	res, err := s.q.ExecContext(ctx, "DELETE FROM idempotency_key WHERE expires_at <= :now",
+		sql.Named("now", now.UTC().Format(time.RFC3339)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: error: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// deleteClientIdempotencyKeys - удаляет все ключи идемпотентности клиента
+func (s ParcelStore) deleteClientIdempotencyKeys(ctx context.Context, client int) (err error) {
+	ctx, op := s.begin(ctx, "delete_client_idempotency_keys", "DELETE")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "DELETE FROM idempotency_key WHERE client = :client", sql.Named("client", client))
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency keys of client %d: error: %w", client, err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeysJob - задача планировщика, удаляющая истёкшие ключи идемпотентности
+func PurgeIdempotencyKeysJob(store ParcelStore) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := store.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+		return err
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegisterIdempotent - тест для проверки повторной регистрации с ключом идемпотентности
+func TestRegisterIdempotent(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+	key := "order-" + time.Now().Format(time.RFC3339Nano)
+
+	// Первый запрос регистрирует посылку
+	parcel, replayed, err := service.RegisterIdempotent(ctx, key, 1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	assert.False(t, replayed)
+
+	// Повтор возвращает ту же посылку без новой записи
+	again, replayed, err := service.RegisterIdempotent(ctx, key, 1000, "test")
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, parcel, again)
+
+	parcels, err := store.GetByClient(1000)
+	require.NoError(t, err)
+	assert.Len(t, parcels, 1)
+
+	// Тот же ключ с другими данными отклоняется
+	_, _, err = service.RegisterIdempotent(ctx, key, 1000, "other address")
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Ключи разных клиентов не пересекаются
+	other, replayed, err := service.RegisterIdempotent(ctx, key, 2000, "test")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.NotEqual(t, parcel.Number, other.Number)
+
+	// Некорректный ключ
+	_, _, err = service.RegisterIdempotent(ctx, strings.Repeat("k", maxIdempotencyKeyLength+1), 1000, "test")
+	require.ErrorIs(t, err, ErrInvalidIdempotencyKey)
+}
+
+// TestRegisterIdempotentExpired - тест для проверки освобождения ключа после срока хранения
+func TestRegisterIdempotentExpired(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	// Ключ истекает сразу после регистрации
+	service := NewParcelService(store, WithOutput(io.Discard), WithIdempotencyTTL(-time.Second))
+	ctx := context.Background()
+	key := "order-" + time.Now().Format(time.RFC3339Nano)
+
+	first, _, err := service.RegisterIdempotent(ctx, key, 1000, "test")
+	require.NoError(t, err)
+
+	// Истёкший ключ можно использовать снова, даже с другими данными
+	second, replayed, err := service.RegisterIdempotent(ctx, key, 1000, "other address")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.NotEqual(t, first.Number, second.Number)
+
+	deleted, err := store.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, 1)
+}
+
+// TestRegisterIdempotencyKeyHeader - тест для проверки заголовка Idempotency-Key в API
+func TestRegisterIdempotencyKeyHeader(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	token := testToken(t, RoleClient, 1000)
+	key := "order-" + time.Now().Format(time.RFC3339Nano)
+	register := func(body string) (*http.Response, Parcel) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/parcels", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", key)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var p Parcel
+		if resp.StatusCode == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+		}
+		return resp, p
+	}
+
+	resp, first := register(`{"address":"test"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+
+	resp, second := register(`{"address":"test"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Number, second.Number)
+
+	resp, _ = register(`{"address":"other address"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
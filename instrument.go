@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Ключи атрибутов span, описывающих запрос к базе данных
+const (
+	attrDBSystem    = "db.system"
+	attrDBOperation = "db.operation"
+)
+
+// operation - выполняемая операция хранилища или сервиса.
+// По завершении результат записывается в span, журнал и метрики
+type operation struct {
+	ctx     context.Context
+	layer   string       // Слой: store или service
+	name    string       // Название операции
+	level   slog.Level   // Уровень журнала для успешной операции
+	start   time.Time    // Время начала операции
+	span    *Span        // Span операции, nil при отключённой трассировке
+	logger  *slog.Logger // Журнал операций
+	metrics *Metrics     // Реестр метрик, nil при отключённых метриках
+}
+
+// end - завершает операцию с результатом err и атрибутами attrs
+func (o operation) end(err error, attrs ...slog.Attr) {
+	o.span.SetAttributes(attrs...)
+	o.span.End(err)
+	logOperation(o.ctx, o.logger, o.level, o.layer, o.name, o.start, err, attrs...)
+	o.metrics.observe(o.layer, o.name, o.start, err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Ключи атрибутов журнала, общие для хранилища и сервиса
+const (
+	attrLayer     = "layer"
+	attrOp        = "op"
+	attrParcel    = "parcel"
+	attrClient    = "client"
+	attrOldStatus = "old_status"
+	attrNewStatus = "new_status"
+	attrCount     = "count"
+	attrDuration  = "duration"
+	attrError     = "error"
+	attrPath      = "path"
+)
+
+// ParseLogLevel - преобразует название уровня журнала (debug, info, warn, error) в slog.Level
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: %w", s, err)
+	}
+	return level, nil
+}
+
+// NewLogger - создаёт текстовый журнал, пишущий в w записи не ниже заданного уровня
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// logOperation - записывает в журнал результат операции слоя layer.
+// Успешная операция и ожидаемый отказ пишутся с уровнем level, непредвиденная ошибка - с уровнем Error.
+// У неудачной операции добавляется атрибут ошибки
+func logOperation(ctx context.Context, logger *slog.Logger, level slog.Level, layer, op string, start time.Time, err error, attrs ...slog.Attr) {
+	msg := layer + " operation completed"
+	if err != nil {
+		if !expectedError(err) {
+			level = slog.LevelError
+		}
+		msg = layer + " operation failed"
+		attrs = append(attrs, slog.Any(attrError, err))
+	}
+
+	attrs = append(attrs,
+		slog.String(attrLayer, layer),
+		slog.String(attrOp, op),
+		slog.Duration(attrDuration, time.Since(start)))
+
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// expectedError - ошибка, вызванная запросом, а не сбоем: объект не найден, вызывающий не опознан
+// или не имеет прав, запрос не прошёл проверку или превысил лимит
+func expectedError(err error) bool {
+	for _, target := range []error{
+		sql.ErrNoRows, ErrUnauthenticated, ErrForbidden, ErrRateLimited,
+		ErrInvalidIdempotencyKey, ErrIdempotencyKeyReused, ErrInvalidSearchQuery, ErrInvalidReportRange,
+		ErrInvalidServiceLevel, ErrInvalidWebhook, ErrReservedAddress, ErrSearchUnavailable, ErrParcelsInTransit,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
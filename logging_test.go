@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logRecorder - обработчик slog, сохраняющий записи журнала в памяти для проверки в тестах
+type logRecorder struct {
+	mu      *sync.Mutex
+	records *[]slog.Record
+	attrs   []slog.Attr
+}
+
+// newTestLogger - создаёт журнал, все записи которого (включая Debug) сохраняются в logRecorder
+func newTestLogger() (*slog.Logger, *logRecorder) {
+	rec := &logRecorder{mu: &sync.Mutex{}, records: &[]slog.Record{}}
+	return slog.New(rec), rec
+}
+
+func (h *logRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *logRecorder) Handle(_ context.Context, r slog.Record) error {
+	r = r.Clone()
+	r.AddAttrs(h.attrs...)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, r)
+	return nil
+}
+
+func (h *logRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logRecorder{mu: h.mu, records: h.records, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *logRecorder) WithGroup(string) slog.Handler { return h }
+
+// find - возвращает атрибуты первой записи с заданным сообщением, у которой атрибут op равен op
+func (h *logRecorder) find(msg, op string) (map[string]slog.Value, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range *h.records {
+		attrs := map[string]slog.Value{}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		if r.Message == msg && attrs[attrOp].String() == op {
+			return attrs, true
+		}
+	}
+	return nil, false
+}
+
+// level - возвращает уровень первой записи с заданным сообщением
+func (h *logRecorder) level(msg string) (slog.Level, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range *h.records {
+		if r.Message == msg {
+			return r.Level, true
+		}
+	}
+	return 0, false
+}
+
+// TestStoreLogging - тест для проверки записей журнала хранилища
+func TestStoreLogging(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	logger, rec := newTestLogger()
+	store := NewParcelStore(db, WithLogger(logger))
+	parcel := getTestParcel()
+
+	// Успешная операция записывается с номером посылки и длительностью
+	number, err := store.Add(parcel)
+	require.NoError(t, err, "failed to insert parcel into database. Error: %v", err)
+
+	attrs, ok := rec.find("store operation completed", "add")
+	require.True(t, ok, "no log record for add operation")
+	assert.Equal(t, int64(number), attrs[attrParcel].Int64())
+	assert.Equal(t, int64(parcel.Client), attrs[attrClient].Int64())
+	assert.Contains(t, attrs, attrDuration)
+
+	// Отклонённое изменение адреса записывается предупреждением
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	require.NoError(t, store.SetAddress(number, "new test address"))
+
+	level, ok := rec.level("update denied: invalid status or parcel not found")
+	require.True(t, ok, "no log record for denied address update")
+	assert.Equal(t, slog.LevelWarn, level)
+
+	// Ошибка запроса записывается с атрибутом ошибки
+	_, err = store.Get(-1)
+	require.Error(t, err)
+
+	attrs, ok = rec.find("store operation failed", "get")
+	require.True(t, ok, "no log record for failed get operation")
+	assert.Equal(t, int64(-1), attrs[attrParcel].Int64())
+	assert.Contains(t, attrs, attrError)
+}
+
+// TestStoreErrorsWithoutAddress - тест для проверки того, что адрес не попадает в ошибки и журнал
+// при сбое записи посылки и изменения адреса
+func TestStoreErrorsWithoutAddress(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	logger, rec := newTestLogger()
+	store := NewParcelStore(db, WithLogger(logger))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err, "failed to insert parcel into database. Error: %v", err)
+
+	// запросы с отменённым контекстом завершаются ошибкой
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	const address = "Privet Drive 4"
+	parcel := getTestParcel()
+	parcel.Address = address
+	_, addErr := store.AddContext(ctx, parcel)
+	require.Error(t, addErr)
+	setErr := store.SetAddressContext(ctx, number, address)
+	require.Error(t, setErr)
+	assert.Contains(t, setErr.Error(), fmt.Sprintf("parcel №%d", number))
+
+	for _, err := range []error{addErr, setErr} {
+		assert.NotContains(t, err.Error(), address)
+	}
+	for _, op := range []string{"add", "set_address"} {
+		attrs, ok := rec.find("store operation failed", op)
+		require.True(t, ok, "no log record for failed %s operation", op)
+		assert.NotContains(t, attrs[attrError].String(), address)
+	}
+}
+
+// TestServiceLogging - тест для проверки записей журнала сервиса о смене статуса
+func TestServiceLogging(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	logger, rec := newTestLogger()
+	service := NewParcelService(NewParcelStore(db), WithOutput(io.Discard), WithLogger(logger))
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.NextStatus(parcel.Number))
+
+	attrs, ok := rec.find("service operation completed", "next_status")
+	require.True(t, ok, "no log record for next_status operation")
+	assert.Equal(t, int64(parcel.Number), attrs[attrParcel].Int64())
+	assert.Equal(t, int64(1000), attrs[attrClient].Int64())
+	assert.Equal(t, ParcelStatusRegistered, attrs[attrOldStatus].String())
+	assert.Equal(t, ParcelStatusSent, attrs[attrNewStatus].String())
+}
+
+// TestOperationErrorLevel - тест для проверки уровня журнала неудачной операции:
+// ожидаемые отказы пишутся с уровнем операции, непредвиденные ошибки - с уровнем Error
+func TestOperationErrorLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		level slog.Level
+	}{
+		{"not found", fmt.Errorf("failed to get parcel: %w", sql.ErrNoRows), slog.LevelInfo},
+		{"unknown api key", fmt.Errorf("unknown api key: %w", ErrUnauthenticated), slog.LevelInfo},
+		{"forbidden", fmt.Errorf("client 1 may not access parcels of client 2: %w", ErrForbidden), slog.LevelInfo},
+		{"validation", ErrReservedAddress, slog.LevelInfo},
+		{"unexpected", errors.New("disk I/O error"), slog.LevelError},
+		{"canceled", context.Canceled, slog.LevelError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, rec := newTestLogger()
+			logOperation(context.Background(), logger, slog.LevelInfo, "service", "get", time.Now(), tt.err)
+
+			level, ok := rec.level("service operation failed")
+			require.True(t, ok, "no log record for failed operation")
+			assert.Equal(t, tt.level, level)
+		})
+	}
+
+	// Ожидаемый отказ запроса хранилища пишется с уровнем Debug
+	logger, rec := newTestLogger()
+	logOperation(context.Background(), logger, slog.LevelDebug, "store", "get", time.Now(), sql.ErrNoRows)
+	level, ok := rec.level("store operation failed")
+	require.True(t, ok)
+	assert.Equal(t, slog.LevelDebug, level)
+}
+
+// TestParseLogLevel - тест для проверки разбора уровня журнала
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug, level)
+
+	_, err = ParseLogLevel("verbose")
+	assert.Error(t, err)
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
 	_ "modernc.org/sqlite"
 )
 
@@ -16,96 +27,670 @@ const (
 )
 
 type Parcel struct {
-	Number    int
-	Client    int
-	Status    string
-	Address   string
-	CreatedAt string
+	Number       int    `json:"number"`
+	Client       int    `json:"client"`
+	Status       string `json:"status"`
+	Address      string `json:"address"`
+	CreatedAt    string `json:"created_at"`
+	TrackingCode string `json:"tracking_code"` // Код для отслеживания посылки на публичной странице
+	ServiceLevel string `json:"service_level"` // Уровень обслуживания, определяет сроки доставки
+	ETA          string `json:"eta,omitempty"` // Ожидаемая дата доставки 2006-01-02, пусто - не рассчитана
 }
 
 type ParcelService struct {
-	store ParcelStore
+	store          ParcelStore
+	out            io.Writer
+	locale         Locale
+	logger         *slog.Logger
+	metrics        *Metrics
+	tracer         *Tracer
+	idempotencyTTL time.Duration
+	rules          StatusRules
+	slaPolicy      SLAPolicy
+	eta            *ETACalculator
 }
 
-func NewParcelService(store ParcelStore) ParcelService {
-	return ParcelService{store: store}
+func NewParcelService(store ParcelStore, opts ...Option) ParcelService {
+	o := newOptions(opts)
+	return ParcelService{store: store, out: o.out, locale: o.locale, logger: o.logger, metrics: o.metrics, tracer: o.tracer,
+		idempotencyTTL: o.idempotencyTTL, rules: o.statusRules, slaPolicy: o.slaPolicy, eta: o.eta}
+}
+
+func (s ParcelService) begin(ctx context.Context, op string) (context.Context, operation) {
+	ctx, span := s.tracer.Start(ctx, "service."+op)
+	return ctx, operation{
+		ctx:     ctx,
+		layer:   "service",
+		name:    op,
+		level:   slog.LevelInfo,
+		start:   time.Now(),
+		span:    span,
+		logger:  s.logger,
+		metrics: s.metrics,
+	}
 }
 
 func (s ParcelService) Register(client int, address string) (Parcel, error) {
-	parcel := Parcel{
-		Client:    client,
-		Status:    ParcelStatusRegistered,
-		Address:   address,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	return s.RegisterContext(context.Background(), client, address)
+}
+
+func (s ParcelService) RegisterContext(ctx context.Context, client int, address string, opts ...RegisterOption) (Parcel, error) {
+	parcel, _, err := s.register(ctx, client, address, "", opts)
+	return parcel, err
+}
+
+// RegisterIdempotent - вариант RegisterContext с ключом идемпотентности.
+// Повторный запрос с тем же ключом и теми же данными в течение срока хранения ключа возвращает
+// ранее зарегистрированную посылку (replayed = true), с другими данными - ErrIdempotencyKeyReused
+func (s ParcelService) RegisterIdempotent(ctx context.Context, key string, client int, address string, opts ...RegisterOption) (parcel Parcel, replayed bool, err error) {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return parcel, false, fmt.Errorf("idempotency key must be 1 to %d characters long: %w", maxIdempotencyKeyLength, ErrInvalidIdempotencyKey)
+	}
+	return s.register(ctx, client, address, key, opts)
+}
+
+// register - регистрирует посылку. Если задан ключ идемпотентности, ключ и посылка сохраняются в одной транзакции
+func (s ParcelService) register(ctx context.Context, client int, address, key string, opts []RegisterOption) (parcel Parcel, replayed bool, err error) {
+	ctx, op := s.begin(ctx, "register")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, parcel.Number), slog.Int(attrClient, client), slog.Bool("replayed", replayed))
+	}()
+
+	if err = authorizeClient(ctx, client); err != nil {
+		return parcel, false, err
+	}
+	if err = checkAddress(address); err != nil {
+		return parcel, false, err
 	}
 
-	id, err := s.store.Add(parcel)
+	code, err := newTrackingCode()
 	if err != nil {
-		return parcel, err
+		return parcel, false, err
 	}
 
-	parcel.Number = id
+	now := time.Now()
+	parcel = Parcel{
+		Client:       client,
+		Status:       ParcelStatusRegistered,
+		Address:      address,
+		CreatedAt:    now.UTC().Format(time.RFC3339),
+		TrackingCode: code,
+		ServiceLevel: ServiceLevelStandard,
+	}
+	for _, opt := range opts {
+		opt(&parcel)
+	}
+	if !serviceLevelPattern.MatchString(parcel.ServiceLevel) {
+		return Parcel{}, false, fmt.Errorf("service level %q: %w", parcel.ServiceLevel, ErrInvalidServiceLevel)
+	}
+	parcel.ETA = s.estimateETA(parcel, now)
 
-	fmt.Printf("Новая посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s\n",
-		parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt)
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		if key != "" {
+			record := newIdempotencyRecord(key, client, address, parcel.ServiceLevel, s.idempotencyTTL)
+			claimed, err := tx.claimIdempotencyKey(ctx, record)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				parcel, err = tx.replayIdempotencyKey(ctx, record)
+				replayed = err == nil
+				return err
+			}
+		}
 
-	return parcel, nil
-}
+		// повтор по ключу идемпотентности не расходует квоту, превышение квоты отменяет транзакцию
+		if err = checkDailyQuota(ctx, tx, client, time.Now()); err != nil {
+			return err
+		}
 
-func (s ParcelService) PrintClientParcels(client int) error {
-	parcels, err := s.store.GetByClient(client)
-	if err != nil {
+		parcel.Number, err = tx.AddContext(ctx, parcel)
+		if err != nil {
+			return err
+		}
+
+		if key != "" {
+			if err = tx.setIdempotencyParcel(ctx, client, key, parcel.Number); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.AppendEvent(ctx, newParcelEvent(EventRegistered, parcel, ""))
 		return err
+	})
+	if err != nil {
+		parcel.Number = 0
+		return parcel, false, err
+	}
+	if replayed {
+		return parcel, true, nil
 	}
 
-	fmt.Printf("Посылки клиента %d:\n", client)
-	for _, parcel := range parcels {
-		fmt.Printf("Посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s, статус %s\n",
-			parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt, parcel.Status)
+	s.printMessage(ctx, msgParcelRegistered, parcel)
+	return parcel, false, nil
+}
+
+// printMessage - выводит сообщение о выполненной операции. Операция к этому моменту уже зафиксирована в базе,
+// поэтому ошибка вывода только записывается в журнал: иначе вызывающий повторил бы успешную операцию
+func (s ParcelService) printMessage(ctx context.Context, key messageKey, data any) {
+	if err := renderMessage(s.out, s.locale, key, data); err != nil {
+		s.logger.WarnContext(ctx, "failed to print message", slog.String("message", string(key)), slog.Any(attrError, err))
 	}
-	fmt.Println()
+}
 
-	return nil
+func (s ParcelService) PrintClientParcels(client int) error {
+	return s.PrintClientParcelsContext(context.Background(), client)
 }
 
-func (s ParcelService) NextStatus(number int) error {
-	parcel, err := s.store.Get(number)
+func (s ParcelService) PrintClientParcelsContext(ctx context.Context, client int) (err error) {
+	ctx, op := s.begin(ctx, "print_client_parcels")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client))
+	}()
+
+	if err = authorizeClient(ctx, client); err != nil {
+		return err
+	}
+
+	parcels, err := s.store.GetByClientContext(ctx, client)
 	if err != nil {
 		return err
 	}
 
+	return renderMessage(s.out, s.locale, msgClientParcels, struct {
+		Client  int
+		Parcels []Parcel
+	}{client, parcels})
+}
+
+func (s ParcelService) NextStatus(number int) error {
+	return s.NextStatusContext(context.Background(), number)
+}
+
+func (s ParcelService) NextStatusContext(ctx context.Context, number int) error {
+	_, err := s.nextStatus(ctx, number)
+	return err
+}
+
+// nextStatus - переводит посылку в следующий статус и сообщает, был ли он изменён.
+// Доставленная посылка остаётся в прежнем статусе, это не считается ошибкой
+func (s ParcelService) nextStatus(ctx context.Context, number int) (advanced bool, err error) {
+	var parcel Parcel
 	var nextStatus string
-	switch parcel.Status {
-	case ParcelStatusRegistered:
-		nextStatus = ParcelStatusSent
-	case ParcelStatusSent:
-		nextStatus = ParcelStatusDelivered
-	case ParcelStatusDelivered:
-		return nil
+	ctx, op := s.begin(ctx, "next_status")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number), slog.Int(attrClient, parcel.Client),
+			slog.String(attrOldStatus, parcel.Status), slog.String(attrNewStatus, nextStatus))
+	}()
+
+	// статус посылок меняют только операторы
+	if err = authorizeRole(ctx, RoleOperator, RoleAdmin); err != nil {
+		return false, err
 	}
 
-	fmt.Printf("У посылки № %d новый статус: %s\n", number, nextStatus)
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		parcel, err = tx.GetContext(ctx, number)
+		if err != nil {
+			return err
+		}
+
+		next, ok := s.rules.next(parcel.Status)
+		if !ok {
+			return nil
+		}
+		nextStatus = next
+
+		err = tx.SetStatusContext(ctx, number, nextStatus)
+		if err != nil {
+			return err
+		}
+
+		changed := parcel
+		changed.Status = nextStatus
+		// срок доставки отсчитывается заново от смены статуса; дата доставленной посылки сохраняется
+		if s.eta != nil && nextStatus != ParcelStatusDelivered {
+			changed.ETA = s.estimateETA(changed, time.Now())
+			if err = tx.SetETA(ctx, number, changed.ETA); err != nil {
+				return err
+			}
+		}
+		_, err = tx.AppendEvent(ctx, newParcelEvent(EventStatusChanged, changed, parcel.Status))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if nextStatus == "" {
+		return false, nil
+	}
 
-	return s.store.SetStatus(number, nextStatus)
+	s.printMessage(ctx, msgStatusChanged, Parcel{Number: number, Status: nextStatus})
+	return true, nil
 }
 
 func (s ParcelService) ChangeAddress(number int, address string) error {
-	return s.store.SetAddress(number, address)
+	return s.ChangeAddressContext(context.Background(), number, address)
+}
+
+func (s ParcelService) ChangeAddressContext(ctx context.Context, number int, address string) error {
+	_, err := s.changeAddress(ctx, number, address)
+	return err
+}
+
+// changeAddress - меняет адрес посылки и сообщает, был ли он изменён.
+// Отказ в изменении (посылка не найдена или уже отправлена) не считается ошибкой
+func (s ParcelService) changeAddress(ctx context.Context, number int, address string) (updated bool, err error) {
+	ctx, op := s.begin(ctx, "change_address")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
+
+	if err = checkAddress(address); err != nil {
+		return false, err
+	}
+
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		if err := authorizeParcelNumber(ctx, tx, number); err != nil {
+			return err
+		}
+
+		updated, err = tx.setAddress(ctx, number, address)
+		if err != nil || !updated {
+			return err
+		}
+
+		parcel, err := tx.GetContext(ctx, number)
+		if err != nil {
+			return err
+		}
+
+		// новый адрес может относиться к другой зоне доставки
+		if s.eta != nil {
+			parcel.ETA = s.estimateETA(parcel, time.Now())
+			if err = tx.SetETA(ctx, number, parcel.ETA); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.AppendEvent(ctx, newParcelEvent(EventAddressChanged, parcel, ""))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return updated, nil
 }
 
 func (s ParcelService) Delete(number int) error {
-	return s.store.Delete(number)
+	return s.DeleteContext(context.Background(), number)
+}
+
+func (s ParcelService) DeleteContext(ctx context.Context, number int) error {
+	_, err := s.delete(ctx, number)
+	return err
+}
+
+// delete - удаляет посылку и сообщает, была ли она удалена.
+// Отказ в удалении (посылка не найдена или уже отправлена) не считается ошибкой
+func (s ParcelService) delete(ctx context.Context, number int) (deleted bool, err error) {
+	ctx, op := s.begin(ctx, "delete")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
+
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		parcel, err := tx.GetContext(ctx, number)
+		if errors.Is(err, sql.ErrNoRows) {
+			// удалять нечего, как и при отказе в удалении это не ошибка
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := authorizeParcel(ctx, parcel); err != nil {
+			return err
+		}
+
+		deleted, err = tx.delete(ctx, number)
+		if err != nil || !deleted {
+			return err
+		}
+
+		_, err = tx.AppendEvent(ctx, newParcelEvent(EventDeleted, parcel, ""))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// Parcel - возвращает посылку по номеру. Если посылки нет, ошибка оборачивает sql.ErrNoRows
+func (s ParcelService) Parcel(ctx context.Context, number int) (parcel Parcel, err error) {
+	ctx, op := s.begin(ctx, "get")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
+
+	parcel, err = s.store.GetContext(ctx, number)
+	if err != nil {
+		return parcel, err
+	}
+	if err = authorizeParcel(ctx, parcel); err != nil {
+		return Parcel{}, err
+	}
+	return parcel, nil
+}
+
+// ClientParcels - возвращает все посылки клиента
+func (s ParcelService) ClientParcels(ctx context.Context, client int) (parcels []Parcel, err error) {
+	ctx, op := s.begin(ctx, "client_parcels")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(parcels)))
+	}()
+
+	if err = authorizeClient(ctx, client); err != nil {
+		return nil, err
+	}
+	return s.store.GetByClientContext(ctx, client)
+}
+
+// FindByAddress - возвращает посылки с адресом address. Клиент получает только свои посылки
+func (s ParcelService) FindByAddress(ctx context.Context, address string) (parcels []Parcel, err error) {
+	ctx, op := s.begin(ctx, "find_by_address")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(parcels)))
+	}()
+
+	found, err := s.store.GetByAddressContext(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range found {
+		if authorizeParcel(ctx, p) == nil {
+			parcels = append(parcels, p)
+		}
+	}
+	return parcels, nil
+}
+
+func (s ParcelService) EventHistory(ctx context.Context, afterID int64, filter EventFilter, limit int) (events []ParcelEvent, err error) {
+	ctx, op := s.begin(ctx, "event_history")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, filter.Parcel), slog.Int(attrClient, filter.Client), slog.Int(attrCount, len(events)))
+	}()
+
+	filter, err = scopeEventFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetEventsAfter(ctx, afterID, filter, limit)
+}
+
+// Track - возвращает посылку по коду отслеживания и историю её событий.
+// Если посылки нет, ошибка оборачивает sql.ErrNoRows
+func (s ParcelService) Track(ctx context.Context, code string) (parcel Parcel, events []ParcelEvent, err error) {
+	ctx, op := s.begin(ctx, "track")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, parcel.Number), slog.Int(attrCount, len(events)))
+	}()
+
+	parcel, err = s.store.GetByTrackingCodeContext(ctx, code)
+	if err != nil {
+		return parcel, nil, err
+	}
+
+	events, err = s.store.GetEventsAfter(ctx, 0, EventFilter{Parcel: parcel.Number, Types: trackingEventTypes}, trackingTimelineLimit)
+	if err != nil {
+		return parcel, nil, err
+	}
+	return parcel, events, nil
 }
 
 func main() {
-	db, err := sql.Open("sqlite", "tracker.db")
+	// конфигурация читается из файла TRACKER_CONFIG (по умолчанию tracker.yaml, если он есть)
+	// и переопределяется переменными окружения
+	cfg, err := LoadConfig(os.Getenv("TRACKER_CONFIG"), os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// config - вывод действующей конфигурации
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// по умолчанию в журнал выводятся только предупреждения и ошибки
+	level, err := ParseLogLevel(cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := NewLogger(os.Stderr, level)
+
+	// трассировка OpenTelemetry включается переменной окружения: stdout, file:<путь> или otlp
+	tracer, shutdownTracer, err := OpenTracer(context.Background(), os.Getenv("TRACKER_TRACE"), logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracer(ctx); err != nil {
+			logger.Warn("failed to flush traces", slog.Any(attrError, err))
+		}
+	}()
+
+	// restore <файл> - восстановление базы из резервной копии, сервис на это время должен быть остановлен
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if len(os.Args) != 3 {
+			log.Fatal("usage: restore <backup file>")
+		}
+		if err := RestoreDatabase(context.Background(), cfg.Database, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("database restored from", os.Args[2])
+		return
+	}
+
+	// без ключей шифрования адреса хранятся открыто
+	cipher, err := cfg.Encryption.Cipher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// рабочие дни для сроков доставки и ожидаемой даты доставки
+	cal, err := cfg.Calendar.Calendar()
 	if err != nil {
-		log.Fatalf("database connection error: %v", err)
+		log.Fatal(err)
+	}
+	eta, err := cfg.ETA.Calculator(cal)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	metrics := NewMetrics()
+	opts := append(cfg.Options(cal), WithLogger(logger), WithTracer(tracer), WithMetrics(metrics), WithFieldCipher(cipher), WithETA(eta))
+	store, err := OpenParcelStore(context.Background(), cfg.Database, opts...)
+	if err != nil {
+		log.Fatalf("database error: %v", err)
+	}
+	defer store.Close()
+
+	// события посылок из outbox доставляются в фоне подписчикам webhook
+	// и, если задана переменная окружения, в дополнительный приёмник (http(s)://... или file:<путь>)
+	notifier := NewWebhookNotifier(store, DefaultWebhookConfig())
+	broker := NewEventBroker()
+	sinks := []EventSink{notifier, broker}
+	if spec := os.Getenv("TRACKER_EVENT_SINK"); spec != "" {
+		sink, closeSink, err := OpenEventSink(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeSink()
+		sinks = append(sinks, sink)
+	}
+	dispatcher := NewOutboxDispatcher(store, DefaultOutboxConfig(), sinks...)
+
+	// в режиме serve события доставляет задача планировщика outbox, чтобы из нескольких экземпляров
+	// сервиса с общей базой их доставлял только один
+	serve := len(os.Args) > 1 && os.Args[1] == "serve"
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	dispatchDone := make(chan struct{})
+	go func() {
+		if !serve {
+			dispatcher.Run(dispatchCtx)
+		}
+		close(dispatchDone)
+	}()
+	defer func() {
+		stopDispatch()
+		<-dispatchDone
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		// события, записанные после последнего опроса, доставляются перед завершением
+		if _, err := dispatcher.DispatchPending(ctx); err != nil {
+			logger.Warn("outbox dispatch failed", slog.Any(attrError, err))
+		}
+		_ = notifier.Shutdown(ctx)
+	}()
+
+	service := NewParcelService(store, opts...)
+
+	// backup - резервная копия базы в каталоге backup.dir, может выполняться во время работы сервиса
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		path, err := store.Backup(context.Background(), cfg.Backup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("backup saved to", path)
+		return
+	}
+
+	// retention [-dry-run] - применение правил хранения доставленных посылок с выводом отчёта
+	if len(os.Args) > 1 && os.Args[1] == "retention" {
+		dryRun := len(os.Args) > 2 && os.Args[2] == "-dry-run"
+		report, err := store.ApplyRetention(context.Background(), cfg.Retention.Policy(), time.Now(), dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// reencrypt - перешифрование адресов текущим ключом после смены ключа или включения шифрования
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		report, err := store.Reencrypt(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// sla - проверка сроков доставки: отметка посылок с истекающим и истекшим сроком и уведомления о них
+	if len(os.Args) > 1 && os.Args[1] == "sla" {
+		report, err := store.CheckSLA(context.Background(), cfg.SLA.Policy(cal), time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// report [-from дата] [-to дата] [-client N] [-format json|csv] - статистика посылок за период
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := printReport(context.Background(), store, cal, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// apikey <имя> <роль> [клиент] - выпуск API-ключа, например первого ключа администратора
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := createAPIKey(context.Background(), store, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// jobs [задача] - история запусков фоновых задач, от последних
+	if len(os.Args) > 1 && os.Args[1] == "jobs" {
+		job := ""
+		if len(os.Args) > 2 {
+			job = os.Args[2]
+		}
+		runs, err := store.JobRuns(context.Background(), job, 50)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := WriteJobRuns(os.Stdout, runs); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	defer db.Close()
 
-	store := NewParcelStore(db)
-	service := NewParcelService(store)
+	// в режиме serve приложение работает как HTTP- и gRPC-сервер до сигнала SIGINT/SIGTERM,
+	// иначе выполняет демонстрационный сценарий
+	if serve {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		go metrics.CollectStatusCounts(ctx, store, 15*time.Second)
+
+		// отчёты о применении правил хранения дописываются в файл, если он задан
+		var retentionReport io.Writer
+		if cfg.Retention.ReportFile != "" {
+			f, err := os.OpenFile(cfg.Retention.ReportFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				log.Fatalf("failed to open retention report file: %v", err)
+			}
+			defer f.Close()
+			retentionReport = f
+		}
+		scheduler, err := newScheduler(cfg, cal, store, dispatcher, retentionReport)
+		if err != nil {
+			log.Fatal(err)
+		}
+		schedulerDone := make(chan struct{})
+		go func() {
+			defer close(schedulerDone)
+			scheduler.Run(ctx)
+		}()
+
+		// общие ограничения частоты для HTTP и gRPC: корзины ключей и клиентов не зависят от протокола
+		limiter := NewRateLimiter(10, 20)
+
+		// HTTP и gRPC принимают одни и те же API-ключи и JWT
+		auth := NewAuthenticator(store, []byte(cfg.Auth.JWTKey))
+
+		// при ошибке одного из серверов останавливается и второй
+		grpcDone := make(chan struct{})
+		go func() {
+			defer close(grpcDone)
+			if err := NewGRPCServer(service, broker, auth, limiter).ListenAndServe(ctx, cfg.GRPC.Addr); err != nil {
+				logger.Error("grpc server stopped with error", slog.Any(attrError, err))
+				stop()
+			}
+		}()
+		if err := NewServer(service, broker, metrics, auth, limiter).ListenAndServe(ctx, cfg.HTTP.Addr); err != nil {
+			logger.Error("server stopped with error", slog.Any(attrError, err))
+			stop()
+		}
+		<-grpcDone
+		// выполняющиеся задачи завершаются до закрытия базы
+		logger.Info("waiting for running jobs")
+		<-schedulerDone
+		return
+	}
 
 	// регистрация посылки
 	client := 1
@@ -175,3 +760,83 @@ func main() {
 		return
 	}
 }
+
+// newScheduler - планировщик с задачами, расписания которых заданы в конфигурации. Сроки доставки считаются по календарю cal
+func newScheduler(cfg Config, cal *calendar.Calendar, store ParcelStore, dispatcher *OutboxDispatcher, retentionReport io.Writer) (*Scheduler, error) {
+	schedules, err := cfg.Scheduler.Schedules()
+	if err != nil {
+		return nil, err
+	}
+	runs := map[string]func(ctx context.Context) error{
+		JobRetention: RetentionJob(store, cfg.Retention.Policy(), retentionReport),
+		JobSLA:       SLACheckJob(store, cfg.SLA.Policy(cal)),
+		JobOutbox: func(ctx context.Context) error {
+			_, err := dispatcher.DispatchPending(ctx)
+			return err
+		},
+		JobIdempotencyKeys: PurgeIdempotencyKeysJob(store),
+		JobReport:          DailyReportJob(store, cal, cfg.Scheduler.ReportDir),
+	}
+
+	scheduler := NewScheduler(store, schedulerOwner(), time.Duration(cfg.Scheduler.LockTTL), time.Duration(cfg.Scheduler.History))
+	for _, name := range jobNames {
+		if schedule, ok := schedules[name]; ok {
+			scheduler.Add(name, schedule, runs[name])
+		}
+	}
+	return scheduler, nil
+}
+
+// printReport - составляет отчёт по аргументам командной строки и выводит его в out.
+// Время доставки считается по календарю cal
+func printReport(ctx context.Context, store ParcelStore, cal *calendar.Calendar, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	from := fs.String("from", "", "start date 2006-01-02 or RFC3339, default 30 days before end")
+	to := fs.String("to", "", "end date (exclusive) 2006-01-02 or RFC3339, default tomorrow")
+	client := fs.Int("client", 0, "client id, 0 - all clients")
+	format := fs.String("format", ReportFormatJSON, "output format: json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter, err := ParseReportFilter(*from, *to, *client, time.Now())
+	if err != nil {
+		return err
+	}
+	report, err := BuildReport(ctx, store, filter, cal)
+	if err != nil {
+		return err
+	}
+	return report.Write(out, *format)
+}
+
+// createAPIKey - выпускает API-ключ по аргументам командной строки <имя> <роль> [клиент] и печатает его
+func createAPIKey(ctx context.Context, store ParcelStore, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: apikey <name> <client|operator|admin> [client id]")
+	}
+
+	role, err := ParseRole(args[1])
+	if err != nil {
+		return err
+	}
+	k := APIKey{Name: args[0], Role: role}
+	if len(args) > 2 {
+		if k.Client, err = strconv.Atoi(args[2]); err != nil {
+			return fmt.Errorf("invalid client id %q", args[2])
+		}
+	}
+	if role == RoleClient && k.Client <= 0 {
+		return errors.New("role client requires client id")
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	if _, err := store.AddAPIKey(ctx, k, key); err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServiceOutput - тест для проверки сообщений сервиса на разных языках
+func TestServiceOutput(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	// Структура для хранения тестовых кейсов
+	tests := []struct {
+		locale     Locale // Язык сообщений сервиса
+		registered string // Шаблон ожидаемого сообщения о регистрации посылки
+		status     string // Шаблон ожидаемого сообщения о смене статуса
+		header     string // Шаблон ожидаемого заголовка списка посылок
+	}{
+		{
+			locale:     LocaleRU,
+			registered: "Новая посылка № %d на адрес test от клиента с идентификатором 1000 зарегистрирована %s\n",
+			status:     "У посылки № %d новый статус: sent\n",
+			header:     "Посылки клиента 1000:\n",
+		},
+		{
+			locale:     LocaleEN,
+			registered: "New parcel #%d to test from client 1000 registered at %s\n",
+			status:     "Parcel #%d has a new status: sent\n",
+			header:     "Parcels of client 1000:\n",
+		},
+	}
+	// Итерируемся по всем тестовым кейсам
+	for _, tt := range tests {
+		t.Run(string(tt.locale), func(t *testing.T) {
+			require.NoError(t, cleanDatabase(db))
+
+			// Сервис пишет сообщения в буфер вместо stdout
+			var out bytes.Buffer
+			service := NewParcelService(NewParcelStore(db), WithOutput(&out), WithLocale(tt.locale))
+
+			parcel, err := service.Register(1000, "test")
+			require.NoError(t, err, "failed to register parcel. Error: %v", err)
+			assert.Equal(t, fmt.Sprintf(tt.registered, parcel.Number, parcel.CreatedAt), out.String())
+
+			out.Reset()
+			err = service.NextStatus(parcel.Number)
+			require.NoError(t, err, "failed to change status of parcel with ID %d. Error: %v", parcel.Number, err)
+			assert.Equal(t, fmt.Sprintf(tt.status, parcel.Number), out.String())
+
+			out.Reset()
+			err = service.PrintClientParcels(1000)
+			require.NoError(t, err, "failed to print parcels of client 1000. Error: %v", err)
+			assert.Contains(t, out.String(), tt.header)
+			assert.Contains(t, out.String(), fmt.Sprint(parcel.Number))
+		})
+	}
+}
+
+// failingWriter - приёмник сообщений, всегда возвращающий ошибку
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("output is closed")
+}
+
+// TestServiceOutputAfterCommit - тест для проверки, что сообщение выводится только после фиксации изменений,
+// а ошибка вывода не превращает успешную операцию в ошибку
+func TestServiceOutputAfterCommit(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	logger, rec := newTestLogger()
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(failingWriter{}), WithLogger(logger))
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	parcels, err := store.GetByClient(1000)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1, "registration should not be repeated")
+
+	require.NoError(t, service.NextStatus(parcel.Number))
+	p, err := store.Get(parcel.Number)
+	require.NoError(t, err)
+	assert.Equal(t, ParcelStatusSent, p.Status)
+
+	level, ok := rec.level("failed to print message")
+	require.True(t, ok, "no log record for failed output")
+	assert.Equal(t, slog.LevelWarn, level)
+
+	// При откате смены статуса сообщение не выводится
+	_, err = db.Exec(`CREATE TRIGGER parcel_status_locked BEFORE UPDATE OF status ON parcel BEGIN
+		SELECT RAISE(ABORT, 'status is locked');
+	END`)
+	require.NoError(t, err)
+	defer db.Exec("DROP TRIGGER parcel_status_locked")
+
+	var out bytes.Buffer
+	service = NewParcelService(store, WithOutput(&out))
+	require.Error(t, service.NextStatus(parcel.Number))
+	assert.Empty(t, out.String())
+}
+
+// TestCatalogueComplete - тест для проверки, что каталоги всех языков содержат одинаковый набор сообщений
+func TestCatalogueComplete(t *testing.T) {
+	for locale, messages := range catalogue {
+		assert.Len(t, messages, len(catalogue[LocaleRU]), "catalogue %q has a different set of messages", locale)
+		for key := range catalogue[LocaleRU] {
+			assert.Contains(t, messages, key, "message %q is missing in catalogue %q", key, locale)
+		}
+	}
+
+	_, err := ParseLocale("de")
+	assert.Error(t, err, "expected error for unsupported locale")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+)
+
+// Locale - язык, на котором сервис выводит сообщения
+type Locale string
+
+const (
+	LocaleRU Locale = "ru"
+	LocaleEN Locale = "en"
+)
+
+// ParseLocale - проверяет, что для языка есть каталог сообщений
+func ParseLocale(s string) (Locale, error) {
+	locale := Locale(s)
+	if _, ok := catalogue[locale]; !ok {
+		return "", fmt.Errorf("unsupported locale %q", s)
+	}
+	return locale, nil
+}
+
+// messageKey - идентификатор шаблона сообщения в каталоге
+type messageKey string
+
+const (
+	msgParcelRegistered messageKey = "parcel_registered"
+	msgClientParcels    messageKey = "client_parcels"
+	msgClientParcel     messageKey = "client_parcel"
+	msgStatusChanged    messageKey = "status_changed"
+)
+
+// catalogue - каталог шаблонов сообщений по языкам
+var catalogue = map[Locale]map[messageKey]*template.Template{
+	LocaleRU: parseMessages(LocaleRU, map[messageKey]string{
+		msgParcelRegistered: "Новая посылка № {{.Number}} на адрес {{.Address}} от клиента с идентификатором {{.Client}} зарегистрирована {{.CreatedAt}}\n",
+		msgClientParcels:    "Посылки клиента {{.Client}}:\n{{range .Parcels}}{{template \"client_parcel\" .}}{{end}}\n",
+		msgClientParcel:     "Посылка № {{.Number}} на адрес {{.Address}} от клиента с идентификатором {{.Client}} зарегистрирована {{.CreatedAt}}, статус {{.Status}}\n",
+		msgStatusChanged:    "У посылки № {{.Number}} новый статус: {{.Status}}\n",
+	}),
+	LocaleEN: parseMessages(LocaleEN, map[messageKey]string{
+		msgParcelRegistered: "New parcel #{{.Number}} to {{.Address}} from client {{.Client}} registered at {{.CreatedAt}}\n",
+		msgClientParcels:    "Parcels of client {{.Client}}:\n{{range .Parcels}}{{template \"client_parcel\" .}}{{end}}\n",
+		msgClientParcel:     "Parcel #{{.Number}} to {{.Address}} from client {{.Client}} registered at {{.CreatedAt}}, status {{.Status}}\n",
+		msgStatusChanged:    "Parcel #{{.Number}} has a new status: {{.Status}}\n",
+	}),
+}
+
+// parseMessages - разбирает шаблоны одного языка.
+// Все шаблоны языка связаны между собой, поэтому могут ссылаться друг на друга по ключу
+func parseMessages(locale Locale, texts map[messageKey]string) map[messageKey]*template.Template {
+	root := template.New(string(locale))
+	for key, text := range texts {
+		template.Must(root.New(string(key)).Parse(text))
+	}
+
+	res := make(map[messageKey]*template.Template, len(texts))
+	for key := range texts {
+		res[key] = root.Lookup(string(key))
+	}
+	return res
+}
+
+// renderMessage - выводит сообщение из каталога в w на заданном языке.
+// Для неизвестного языка используется русский каталог
+func renderMessage(w io.Writer, locale Locale, key messageKey, data any) error {
+	messages, ok := catalogue[locale]
+	if !ok {
+		messages = catalogue[LocaleRU]
+	}
+
+	tmpl, ok := messages[key]
+	if !ok {
+		return fmt.Errorf("message %q not found in catalogue %q", key, locale)
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("failed to render message %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Исходы операций для метрики parcel_operations_total
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+)
+
+// durationBuckets - границы корзин гистограммы длительности операций в секундах.
+// Запросы к SQLite обычно занимают доли миллисекунды, поэтому нижние корзины мельче стандартных
+var durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// operationKey - набор меток операции
+type operationKey struct {
+	layer string // Слой: store или service
+	op    string // Название операции
+}
+
+// histogram - накопленные наблюдения длительности одной операции
+type histogram struct {
+	counts []uint64 // Количество наблюдений в каждой корзине (не накопительно)
+	sum    float64  // Сумма наблюдений в секундах
+	count  uint64   // Общее количество наблюдений
+}
+
+// Metrics - реестр метрик операций с посылками, отдаваемый в текстовом формате Prometheus
+type Metrics struct {
+	mu         sync.Mutex
+	operations map[operationKey]map[string]uint64 // Счётчики операций по исходам
+	durations  map[operationKey]*histogram        // Гистограммы длительности операций
+	statuses   map[string]float64                 // Количество посылок в каждом статусе
+}
+
+// NewMetrics - конструктор пустого реестра метрик
+func NewMetrics() *Metrics {
+	return &Metrics{
+		operations: map[operationKey]map[string]uint64{},
+		durations:  map[operationKey]*histogram{},
+		statuses:   map[string]float64{},
+	}
+}
+
+// observe - учитывает выполненную операцию: увеличивает счётчик исхода и добавляет длительность в гистограмму.
+// Метод безопасен для nil-реестра, чтобы хранилище и сервис работали без метрик
+func (m *Metrics) observe(layer, op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+	}
+	seconds := time.Since(start).Seconds()
+	key := operationKey{layer: layer, op: op}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.operations[key] == nil {
+		m.operations[key] = map[string]uint64{}
+	}
+	m.operations[key][outcome]++
+
+	h := m.durations[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[key] = h
+	}
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// SetStatusCounts - заменяет значения метрики количества посылок по статусам.
+// Известные статусы, которых нет в counts, получают значение 0
+func (m *Metrics) SetStatusCounts(counts map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.statuses = map[string]float64{
+		ParcelStatusRegistered: 0,
+		ParcelStatusSent:       0,
+		ParcelStatusDelivered:  0,
+	}
+	for status, n := range counts {
+		m.statuses[status] = float64(n)
+	}
+}
+
+// CollectStatusCounts - периодически пересчитывает количество посылок по статусам, пока не отменён ctx.
+// Первый подсчёт выполняется сразу при запуске
+func (m *Metrics) CollectStatusCounts(ctx context.Context, store ParcelStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		counts, err := store.CountByStatusContext(ctx)
+		if err != nil {
+			store.logger.Error("failed to collect parcel status counts", slog.Any(attrError, err))
+		} else {
+			m.SetStatusCounts(counts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WritePrometheus - записывает все метрики в out в текстовом формате Prometheus (version 0.0.4)
+func (m *Metrics) WritePrometheus(out io.Writer) error {
+	w := bufio.NewWriter(out)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]operationKey, 0, len(m.operations))
+	for key := range m.operations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].layer != keys[j].layer {
+			return keys[i].layer < keys[j].layer
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	fmt.Fprintln(w, "# HELP parcel_operations_total Number of parcel operations by layer, operation and outcome.")
+	fmt.Fprintln(w, "# TYPE parcel_operations_total counter")
+	for _, key := range keys {
+		outcomes := make([]string, 0, len(m.operations[key]))
+		for outcome := range m.operations[key] {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(w, "parcel_operations_total{%s} %d\n",
+				formatLabels("layer", key.layer, "op", key.op, "outcome", outcome), m.operations[key][outcome])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP parcel_operation_duration_seconds Duration of parcel operations in seconds.")
+	fmt.Fprintln(w, "# TYPE parcel_operation_duration_seconds histogram")
+	for _, key := range keys {
+		h := m.durations[key]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "parcel_operation_duration_seconds_bucket{%s} %d\n",
+				formatLabels("layer", key.layer, "op", key.op, "le", formatFloat(bound)), cumulative)
+		}
+		labels := formatLabels("layer", key.layer, "op", key.op)
+		fmt.Fprintf(w, "parcel_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "parcel_operation_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "parcel_operation_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	statuses := make([]string, 0, len(m.statuses))
+	for status := range m.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	fmt.Fprintln(w, "# HELP parcels_by_status Number of parcels in each status.")
+	fmt.Fprintln(w, "# TYPE parcels_by_status gauge")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "parcels_by_status{%s} %s\n", formatLabels("status", status), formatFloat(m.statuses[status]))
+	}
+
+	return w.Flush()
+}
+
+// Handler - HTTP-обработчик для эндпоинта /metrics
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := m.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// formatLabels - форматирует пары имя-значение меток Prometheus с экранированием значений
+func formatLabels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// labelEscaper - экранирование значений меток согласно текстовому формату Prometheus
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat - форматирует число в кратчайшем виде, принятом в формате Prometheus
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
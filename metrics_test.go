@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrapeMetrics - запрашивает эндпоинт /metrics и возвращает тело ответа
+func scrapeMetrics(t *testing.T, m *Metrics) string {
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err, "failed to scrape metrics. Error: %v", err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+// TestMetrics - тест для проверки счётчиков и гистограмм операций хранилища и сервиса
+func TestMetrics(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	metrics := NewMetrics()
+	store := NewParcelStore(db, WithMetrics(metrics))
+	service := NewParcelService(store, WithOutput(io.Discard), WithMetrics(metrics))
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.NextStatus(parcel.Number))
+	require.Error(t, service.NextStatus(-1))
+
+	body := scrapeMetrics(t, metrics)
+
+	// Ожидаемые строки в ответе
+	expected := []string{
+		"# TYPE parcel_operations_total counter",
+		`parcel_operations_total{layer="service",op="register",outcome="success"} 1`,
+		`parcel_operations_total{layer="service",op="next_status",outcome="success"} 1`,
+		`parcel_operations_total{layer="service",op="next_status",outcome="error"} 1`,
+		`parcel_operations_total{layer="store",op="get",outcome="success"} 1`,
+		`parcel_operations_total{layer="store",op="get",outcome="error"} 1`,
+		`parcel_operations_total{layer="store",op="add",outcome="success"} 1`,
+		"# TYPE parcel_operation_duration_seconds histogram",
+		`parcel_operation_duration_seconds_bucket{layer="store",op="add",le="+Inf"} 1`,
+		`parcel_operation_duration_seconds_count{layer="store",op="get"} 2`,
+	}
+	for _, line := range expected {
+		assert.Contains(t, body, line+"\n", "metrics output does not contain %q", line)
+	}
+}
+
+// TestCollectStatusCounts - тест для проверки периодического подсчёта посылок по статусам
+func TestCollectStatusCounts(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	for i := 0; i < 2; i++ {
+		_, err := store.Add(getTestParcel())
+		require.NoError(t, err, "failed to insert parcel into database. Error: %v", err)
+	}
+
+	metrics := NewMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		metrics.CollectStatusCounts(ctx, store, time.Hour)
+		close(done)
+	}()
+
+	// Первый подсчёт выполняется сразу после запуска
+	require.Eventually(t, func() bool {
+		return strings.Contains(scrapeMetrics(t, metrics), `parcels_by_status{status="registered"} 2`+"\n")
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrapeMetrics(t, metrics), `parcels_by_status{status="delivered"} 0`+"\n")
+
+	cancel()
+	<-done
+}
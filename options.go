@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Option - функциональная опция для настройки ParcelService и ParcelStore
+type Option func(*options)
+
+// options - набор параметров, задаваемых при создании сервиса и хранилища
+type options struct {
+	out     io.Writer    // Приёмник сообщений сервиса
+	locale  Locale       // Язык сообщений сервиса
+	logger  *slog.Logger // Журнал операций
+	metrics *Metrics     // Реестр метрик операций, nil - метрики не собираются
+	tracer  *Tracer      // Трассировщик операций, nil - трассировка отключена
+	cipher  *FieldCipher // Шифрование персональных данных в базе, nil - данные хранятся открыто
+
+	idempotencyTTL time.Duration  // Срок хранения ключей идемпотентности регистрации
+	statusRules    StatusRules    // Правила жизненного цикла посылки
+	slaPolicy      SLAPolicy      // Сроки доставки по уровням обслуживания
+	eta            *ETACalculator // Расчёт ожидаемой даты доставки, nil - дата не рассчитывается
+}
+
+// defaultOptions - параметры по умолчанию: вывод в stdout на русском языке, стандартный журнал,
+// хранение ключей идемпотентности в течение суток, правила статусов и сроки доставки по умолчанию
+func defaultOptions() options {
+	return options{
+		out:    os.Stdout,
+		locale: LocaleRU,
+		logger: slog.Default(),
+
+		idempotencyTTL: 24 * time.Hour,
+		statusRules:    DefaultStatusRules(),
+		slaPolicy:      DefaultSLAPolicy(),
+	}
+}
+
+// newOptions - применяет опции к параметрам по умолчанию
+func newOptions(opts []Option) options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithOutput - задаёт приёмник, в который сервис пишет сообщения
+func WithOutput(w io.Writer) Option {
+	return func(o *options) {
+		if w == nil {
+			w = io.Discard
+		}
+		o.out = w
+	}
+}
+
+// WithLocale - задаёт язык сообщений сервиса
+func WithLocale(locale Locale) Option {
+	return func(o *options) {
+		o.locale = locale
+	}
+}
+
+// WithLogger - задаёт журнал операций
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger == nil {
+			logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		}
+		o.logger = logger
+	}
+}
+
+// WithMetrics - задаёт реестр, в который записываются метрики операций
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithTracer - задаёт трассировщик, открывающий span для каждой операции
+func WithTracer(t *Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithIdempotencyTTL - задаёт срок, в течение которого повторная регистрация с тем же ключом идемпотентности
+// возвращает ранее зарегистрированную посылку
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.idempotencyTTL = ttl
+	}
+}
+
+// WithStatusRules - задаёт правила жизненного цикла посылки. Правила должны пройти StatusRules.Validate
+func WithStatusRules(rules StatusRules) Option {
+	return func(o *options) {
+		o.statusRules = rules
+	}
+}
+
+// WithFieldCipher - задаёт шифрование адресов и других персональных данных, которые хранилище записывает в базу
+func WithFieldCipher(c *FieldCipher) Option {
+	return func(o *options) {
+		o.cipher = c
+	}
+}
+
+// WithSLAPolicy - задаёт сроки доставки, по которым сервис находит просроченные посылки
+func WithSLAPolicy(p SLAPolicy) Option {
+	return func(o *options) {
+		o.slaPolicy = p
+	}
+}
+
+// WithETA - задаёт расчёт ожидаемой даты доставки при регистрации, смене адреса и статуса посылки
+func WithETA(c *ETACalculator) Option {
+	return func(o *options) {
+		o.eta = c
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AppendEvent - метод для записи события в outbox.
+// Вызывается в той же транзакции, что и изменение посылки, чтобы событие не терялось при сбое
+func (s ParcelStore) AppendEvent(ctx context.Context, event ParcelEvent) (id int64, err error) {
+	ctx, op := s.begin(ctx, "append_event", "INSERT")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, event.Number), slog.String("event", event.Type))
+	}()
+
+	// адрес в событии шифруется так же, как в таблице parcel
+	stored := event
+	if stored.Address, err = s.cipher.Encrypt(fieldAddress, event.Address); err != nil {
+		return 0, err
+	}
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode event %s of parcel №%d: %w", event.Type, event.Number, err)
+	}
+
+	err = s.q.QueryRowContext(ctx, "INSERT INTO outbox (event_type, parcel, client, payload, created_at) VALUES (:event_type, :parcel, :client, :payload, :created_at) RETURNING id",
+		sql.Named("event_type", event.Type),
+		sql.Named("parcel", event.Number),
+		sql.Named("client", event.Client),
+		sql.Named("payload", string(payload)),
+		sql.Named("created_at", event.OccurredAt.Format(time.RFC3339Nano))).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to append event %s of parcel №%d to outbox: error: %w", event.Type, event.Number, err)
+	}
+	return id, nil
+}
+
+// GetPendingEvents - метод для получения недоставленных и не отложенных событий в порядке их записи
+func (s ParcelStore) GetPendingEvents(ctx context.Context, limit int) (res []ParcelEvent, err error) {
+	ctx, op := s.begin(ctx, "get_pending_events", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.q.QueryContext(ctx, "SELECT id, payload FROM outbox WHERE delivered_at IS NULL AND parked_at IS NULL ORDER BY id LIMIT :limit",
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve pending events: error: %w", err)
+	}
+	defer rows.Close()
+
+	return s.scanEvents(rows)
+}
+
+// scanEvents - читает события из строк (id, payload) результата запроса к outbox и расшифровывает адреса
+func (s ParcelStore) scanEvents(rows *sql.Rows) ([]ParcelEvent, error) {
+	var res []ParcelEvent
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving events: error: %w", err)
+		}
+
+		var event ParcelEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode event %d: %w", id, err)
+		}
+		address, err := s.cipher.Decrypt(fieldAddress, event.Address)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", id, err)
+		}
+		event.Address = address
+		event.ID = id
+		res = append(res, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving events: %w", err)
+	}
+	return res, nil
+}
+
+// MarkEventDelivered - метод для отметки события доставленным во все приёмники.
+// Отметки о доставке в отдельные приёмники больше не нужны и удаляются
+func (s ParcelStore) MarkEventDelivered(ctx context.Context, id int64) (err error) {
+	ctx, op := s.begin(ctx, "mark_event_delivered", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int64("event_id", id))
+	}()
+
+	return s.InTx(ctx, func(tx ParcelStore) error {
+		_, err := tx.q.ExecContext(ctx, "UPDATE outbox SET delivered_at = :delivered_at, attempts = attempts + 1 WHERE id = :id",
+			sql.Named("delivered_at", time.Now().UTC().Format(time.RFC3339Nano)),
+			sql.Named("id", id))
+		if err != nil {
+			return fmt.Errorf("failed to mark event %d delivered: error: %w", id, err)
+		}
+		if _, err = tx.q.ExecContext(ctx, "DELETE FROM outbox_sink_delivery WHERE event = :id", sql.Named("id", id)); err != nil {
+			return fmt.Errorf("failed to remove sink deliveries of event %d: error: %w", id, err)
+		}
+		return nil
+	})
+}
+
+// deliveredSinks - метод для получения приёмников, в которые событие id уже опубликовано
+func (s ParcelStore) deliveredSinks(ctx context.Context, id int64) (res map[string]bool, err error) {
+	ctx, op := s.begin(ctx, "delivered_sinks", "SELECT")
+	defer func() {
+		op.end(err, slog.Int64("event_id", id), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.q.QueryContext(ctx, "SELECT sink FROM outbox_sink_delivery WHERE event = :id", sql.Named("id", id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sink deliveries of event %d: error: %w", id, err)
+	}
+	defer rows.Close()
+
+	res = map[string]bool{}
+	for rows.Next() {
+		var sink string
+		if err = rows.Scan(&sink); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving sink deliveries of event %d: error: %w", id, err)
+		}
+		res[sink] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving sink deliveries of event %d: %w", id, err)
+	}
+	return res, nil
+}
+
+// markSinkDelivered - метод для отметки события id опубликованным в приёмник sink
+func (s ParcelStore) markSinkDelivered(ctx context.Context, id int64, sink string) (err error) {
+	ctx, op := s.begin(ctx, "mark_sink_delivered", "INSERT")
+	defer func() {
+		op.end(err, slog.Int64("event_id", id), slog.String("sink", sink))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "INSERT INTO outbox_sink_delivery (event, sink, delivered_at) VALUES (:id, :sink, :delivered_at) ON CONFLICT DO NOTHING",
+		sql.Named("id", id),
+		sql.Named("sink", sink),
+		sql.Named("delivered_at", time.Now().UTC().Format(time.RFC3339Nano)))
+	if err != nil {
+		return fmt.Errorf("failed to mark event %d delivered to sink %s: error: %w", id, sink, err)
+	}
+	return nil
+}
+
+// getDueEvents - метод для получения порции событий, готовых к публикации, в порядке их записи.
+// Событие готово, если оно не доставлено и не отложено, время его повторной попытки наступило
+// и у той же посылки нет более раннего события, ожидающего повторной попытки
+func (s ParcelStore) getDueEvents(ctx context.Context, now time.Time, limit int) (res []ParcelEvent, err error) {
+	ctx, op := s.begin(ctx, "get_due_events", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.q.QueryContext(ctx, `SELECT id, payload FROM outbox o
+WHERE delivered_at IS NULL AND parked_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= :now)
+  AND NOT EXISTS (SELECT 1 FROM outbox p
+                  WHERE p.parcel = o.parcel AND p.id < o.id AND p.delivered_at IS NULL AND p.parked_at IS NULL
+                    AND p.next_attempt_at > :now)
+ORDER BY id LIMIT :limit`,
+		sql.Named("now", now.UTC().Format(time.RFC3339)),
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve due events: error: %w", err)
+	}
+	defer rows.Close()
+
+	return s.scanEvents(rows)
+}
+
+// markEventFailed - метод для записи неудачной попытки доставки события. Следующая попытка назначается
+// через задержку cfg.backoff, после cfg.MaxAttempts попыток событие откладывается (parked_at) и больше
+// не публикуется. Возвращает true, если событие отложено
+func (s ParcelStore) markEventFailed(ctx context.Context, id int64, cause error, cfg OutboxConfig, now time.Time) (parked bool, err error) {
+	ctx, op := s.begin(ctx, "mark_event_failed", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int64("event_id", id), slog.Bool("parked", parked))
+	}()
+
+	err = s.InTx(ctx, func(tx ParcelStore) error {
+		var attempts int
+		err := tx.q.QueryRowContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = :last_error WHERE id = :id RETURNING attempts",
+			sql.Named("last_error", cause.Error()),
+			sql.Named("id", id)).Scan(&attempts)
+		if err != nil {
+			return fmt.Errorf("failed to record delivery failure of event %d: error: %w", id, err)
+		}
+
+		parked = attempts >= cfg.MaxAttempts
+		if parked {
+			_, err = tx.q.ExecContext(ctx, "UPDATE outbox SET parked_at = :parked_at WHERE id = :id",
+				sql.Named("parked_at", now.UTC().Format(time.RFC3339)),
+				sql.Named("id", id))
+		} else {
+			_, err = tx.q.ExecContext(ctx, "UPDATE outbox SET next_attempt_at = :next_attempt_at WHERE id = :id",
+				sql.Named("next_attempt_at", now.Add(cfg.backoff(attempts)).UTC().Format(time.RFC3339)),
+				sql.Named("id", id))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to schedule retry of event %d: error: %w", id, err)
+		}
+		return nil
+	})
+	return parked, err
+}
+
+// OutboxConfig - параметры доставки событий из outbox
+type OutboxConfig struct {
+	PollInterval time.Duration // Интервал опроса outbox
+	BatchSize    int           // Максимальное количество событий за один опрос
+	MaxAttempts  int           // Количество попыток публикации, после которого событие откладывается
+	BaseDelay    time.Duration // Задержка перед второй попыткой, далее удваивается
+	MaxDelay     time.Duration // Максимальная задержка между попытками
+}
+
+// DefaultOutboxConfig - параметры доставки по умолчанию
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		PollInterval: time.Second,
+		BatchSize:    100,
+		MaxAttempts:  10,
+		BaseDelay:    time.Second,
+		MaxDelay:     10 * time.Minute,
+	}
+}
+
+// backoff - задержка перед попыткой номер attempt+1 (attempt начинается с 1)
+func (c OutboxConfig) backoff(attempt int) time.Duration {
+	return retryDelay(c.BaseDelay, c.MaxDelay, attempt)
+}
+
+// retryDelay - экспоненциальная задержка перед попыткой номер attempt+1: base, 2*base, 4*base, ..., но не больше max
+func retryDelay(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// OutboxDispatcher - фоновый обработчик, публикующий события из outbox во все приёмники.
+// Публикация в каждый приёмник запоминается отдельно, и повторная попытка после сбоя публикует событие
+// только в приёмники, которые его не приняли. Событие отмечается доставленным после публикации во все
+// приёмники. Приёмник может получить событие повторно, только если процесс остановился между публикацией
+// и её отметкой (доставка at-least-once)
+type OutboxDispatcher struct {
+	store  ParcelStore
+	cfg    OutboxConfig
+	sinks  []EventSink
+	logger *slog.Logger
+}
+
+// NewOutboxDispatcher - конструктор обработчика outbox
+func NewOutboxDispatcher(store ParcelStore, cfg OutboxConfig, sinks ...EventSink) *OutboxDispatcher {
+	return &OutboxDispatcher{store: store, cfg: cfg, sinks: sinks, logger: store.logger}
+}
+
+// Run - опрашивает outbox с заданным интервалом, пока не отменён ctx
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DispatchPending(ctx); err != nil && ctx.Err() == nil {
+			d.logger.WarnContext(ctx, "outbox dispatch failed", slog.Any(attrError, err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchPending - публикует одну порцию готовых событий и возвращает количество доставленных.
+// События одной посылки публикуются по порядку: после сбоя остальные события этой посылки ждут
+// повторной попытки, а события других посылок публикуются дальше. Событие, которое не удалось
+// опубликовать за MaxAttempts попыток, откладывается, чтобы не задерживать последующие события посылки
+func (d *OutboxDispatcher) DispatchPending(ctx context.Context) (int, error) {
+	events, err := d.store.getDueEvents(ctx, time.Now(), d.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	failed := map[int]bool{} // Посылки, события которых в этой порции не публикуются после сбоя
+	var errs []error
+	for _, event := range events {
+		if failed[event.Number] {
+			continue
+		}
+
+		if err := d.publish(ctx, event); err != nil {
+			failed[event.Number] = true
+			parked, markErr := d.store.markEventFailed(ctx, event.ID, err, d.cfg, time.Now())
+			if markErr != nil {
+				err = errors.Join(err, markErr)
+			}
+			if parked {
+				d.logger.ErrorContext(ctx, "outbox event parked after failed attempts",
+					slog.Int64("event_id", event.ID), slog.Int(attrParcel, event.Number), slog.Any(attrError, err))
+			}
+			errs = append(errs, fmt.Errorf("failed to publish event %d: %w", event.ID, err))
+			continue
+		}
+
+		if err := d.store.MarkEventDelivered(ctx, event.ID); err != nil {
+			return delivered, errors.Join(append(errs, err)...)
+		}
+		delivered++
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// publish - публикует событие в приёмники, которые его ещё не приняли, и отмечает каждую успешную публикацию
+func (d *OutboxDispatcher) publish(ctx context.Context, event ParcelEvent) error {
+	delivered, err := d.store.deliveredSinks(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, sink := range d.sinks {
+		name := sinkName(i, sink)
+		if delivered[name] {
+			continue
+		}
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", name, err))
+			continue
+		}
+		if err := d.store.markSinkDelivered(ctx, event.ID, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sinkName - имя приёмника, под которым запоминается публикация в него: номер в списке приёмников и тип.
+// Если состав приёмников изменится между попытками, событие будет опубликовано в приёмник повторно, а не пропущено
+func sinkName(i int, sink EventSink) string {
+	return fmt.Sprintf("%d:%T", i, sink)
+}
+
+// ChannelSink - приёмник, передающий события в канал внутри процесса
+type ChannelSink struct {
+	ch chan ParcelEvent
+}
+
+// NewChannelSink - конструктор приёмника с буфером канала размера size
+func NewChannelSink(size int) *ChannelSink {
+	return &ChannelSink{ch: make(chan ParcelEvent, size)}
+}
+
+// Events - канал, из которого читаются опубликованные события
+func (c *ChannelSink) Events() <-chan ParcelEvent {
+	return c.ch
+}
+
+// Publish - передаёт событие в канал, ожидая свободного места в буфере, пока не отменён ctx
+func (c *ChannelSink) Publish(ctx context.Context, event ParcelEvent) error {
+	select {
+	case c.ch <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HTTPSink - приёмник, отправляющий каждое событие JSON-запросом POST на адрес url
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink - конструктор приёмника, отправляющего события на url
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Publish - отправляет событие. Успешной считается отправка с кодом ответа 2xx
+func (h *HTTPSink) Publish(ctx context.Context, event ParcelEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create event request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("event request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("event endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FileSink - приёмник, дописывающий события в w в формате JSON по одному на строку
+type FileSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewFileSink - конструктор приёмника, пишущего события в w
+func NewFileSink(w io.Writer) *FileSink {
+	return &FileSink{encoder: json.NewEncoder(w)}
+}
+
+// Publish - записывает событие отдельной строкой
+func (f *FileSink) Publish(_ context.Context, event ParcelEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.encoder.Encode(event); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+// OpenEventSink - создаёт приёмник по описанию: "http://..." или "https://..." - HTTPSink,
+// "file:<путь>" - FileSink, дописывающий события в файл.
+// Возвращаемая функция закрывает файл приёмника
+func OpenEventSink(spec string) (EventSink, func() error, error) {
+	noop := func() error { return nil }
+
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewHTTPSink(spec, 10*time.Second), noop, nil
+	case strings.HasPrefix(spec, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to open event file: %w", err)
+		}
+		return NewFileSink(f), f.Close, nil
+	default:
+		return nil, noop, fmt.Errorf("unknown event sink %q", spec)
+	}
+}
+
+// EventFilter - условие отбора событий одной посылки или всех посылок клиента.
+// Нулевое значение поля означает отсутствие условия
+type EventFilter struct {
+	Parcel int      // Номер посылки
+	Client int      // Идентификатор клиента
+	Types  []string // Типы событий, пустой список - все типы
+}
+
+// Match - проверяет, подходит ли событие под условие
+func (f EventFilter) Match(event ParcelEvent) bool {
+	if f.Parcel != 0 && event.Number != f.Parcel {
+		return false
+	}
+	if f.Client != 0 && event.Client != f.Client {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEventsAfter - метод для получения истории событий с номером больше afterID, подходящих под фильтр
+func (s ParcelStore) GetEventsAfter(ctx context.Context, afterID int64, filter EventFilter, limit int) (res []ParcelEvent, err error) {
+	ctx, op := s.begin(ctx, "get_events_after", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, filter.Parcel), slog.Int(attrClient, filter.Client), slog.Int(attrCount, len(res)))
+	}()
+
+	args := []any{
+		sql.Named("after", afterID),
+		sql.Named("parcel", filter.Parcel),
+		sql.Named("client", filter.Client),
+		sql.Named("limit", limit),
+	}
+
+	// Отбор по типам событий: event_type IN (:type0, :type1, ...)
+	typeCond := ""
+	if len(filter.Types) > 0 {
+		names := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			names[i] = fmt.Sprintf(":type%d", i)
+			args = append(args, sql.Named(fmt.Sprintf("type%d", i), t))
+		}
+		typeCond = " AND event_type IN (" + strings.Join(names, ", ") + ")"
+	}
+
+	rows, err := s.reader().QueryContext(ctx, `SELECT id, payload FROM outbox
+WHERE id > :after AND (:parcel = 0 OR parcel = :parcel) AND (:client = 0 OR client = :client)`+typeCond+`
+ORDER BY id LIMIT :limit`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve events after %d: error: %w", afterID, err)
+	}
+	defer rows.Close()
+
+	return s.scanEvents(rows)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dispatchAll - публикует все недоставленные события из outbox
+func dispatchAll(t *testing.T, d *OutboxDispatcher) {
+	for {
+		n, err := d.DispatchPending(context.Background())
+		require.NoError(t, err, "failed to dispatch outbox events. Error: %v", err)
+		if n == 0 {
+			return
+		}
+	}
+}
+
+// cleanOutbox - отмечает доставленными события, оставшиеся от предыдущих тестов
+func cleanOutbox(t *testing.T, store ParcelStore) {
+	dispatchAll(t, NewOutboxDispatcher(store, DefaultOutboxConfig()))
+}
+
+// failingSink - приёмник, возвращающий ошибку первые fails вызовов
+type failingSink struct {
+	fails int
+	calls int
+}
+
+func (f *failingSink) Publish(context.Context, ParcelEvent) error {
+	f.calls++
+	if f.calls <= f.fails {
+		return errors.New("sink is unavailable")
+	}
+	return nil
+}
+
+// TestOutboxEvents - тест для проверки записи событий в outbox при каждой операции сервиса
+func TestOutboxEvents(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	service := NewParcelService(store, WithOutput(io.Discard))
+
+	// Операции сервиса: регистрация, смена адреса и статуса, удаление второй посылки.
+	// Отклонённые операции событий не создают
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.ChangeAddress(parcel.Number, "new test address"))
+	require.NoError(t, service.NextStatus(parcel.Number))
+	require.NoError(t, service.ChangeAddress(parcel.Number, "denied address"))
+	require.NoError(t, service.Delete(parcel.Number))
+
+	other, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.Delete(other.Number))
+
+	// События публикуются в приёмники по порядку записи
+	var buf bytes.Buffer
+	channel := NewChannelSink(10)
+	dispatchAll(t, NewOutboxDispatcher(store, DefaultOutboxConfig(), channel, NewFileSink(&buf)))
+
+	expected := []struct {
+		eventType string
+		number    int
+	}{
+		{EventRegistered, parcel.Number},
+		{EventAddressChanged, parcel.Number},
+		{EventStatusChanged, parcel.Number},
+		{EventRegistered, other.Number},
+		{EventDeleted, other.Number},
+	}
+	require.Len(t, channel.Events(), len(expected))
+
+	decoder := json.NewDecoder(&buf)
+	var lastID int64
+	for _, e := range expected {
+		event := <-channel.Events()
+		assert.Equal(t, e.eventType, event.Type)
+		assert.Equal(t, e.number, event.Number)
+		assert.Greater(t, event.ID, lastID, "event IDs should increase")
+		lastID = event.ID
+
+		// Файловый приёмник получает те же события
+		var fromFile ParcelEvent
+		require.NoError(t, decoder.Decode(&fromFile))
+		assert.Equal(t, event.ID, fromFile.ID)
+	}
+
+	// Доставленные события повторно не публикуются
+	pending, err := store.GetPendingEvents(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+// TestOutboxRollback - тест для проверки, что событие и изменение посылки фиксируются в одной транзакции
+func TestOutboxRollback(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	parcel := getTestParcel()
+
+	// Ошибка после записи события откатывает и посылку, и событие
+	errAbort := errors.New("abort")
+	var err error
+	err = store.InTx(context.Background(), func(tx ParcelStore) error {
+		parcel.Number, err = tx.Add(parcel)
+		require.NoError(t, err)
+		_, err = tx.AppendEvent(context.Background(), newParcelEvent(EventRegistered, parcel, ""))
+		require.NoError(t, err)
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	_, err = store.Get(parcel.Number)
+	assert.Error(t, err, "parcel should not exist after rollback")
+
+	pending, err := store.GetPendingEvents(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending, "event should not exist after rollback")
+}
+
+// TestOutboxRedelivery - тест для проверки повторной публикации события после сбоя приёмника
+func TestOutboxRedelivery(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	service := NewParcelService(store, WithOutput(io.Discard))
+
+	_, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+
+	// повторная попытка назначается без задержки
+	cfg := DefaultOutboxConfig()
+	cfg.BaseDelay = 0
+	sink := &failingSink{fails: 1}
+	dispatcher := NewOutboxDispatcher(store, cfg, sink)
+
+	// Первая попытка завершается ошибкой, событие остаётся в outbox
+	n, err := dispatcher.DispatchPending(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	// Вторая попытка доставляет событие
+	n, err = dispatcher.DispatchPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, sink.calls)
+}
+
+// TestOutboxRetryFailedSinks - тест для проверки того, что повторная попытка публикует событие
+// только в приёмники, которые его не приняли
+func TestOutboxRetryFailedSinks(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	service := NewParcelService(store, WithOutput(io.Discard))
+
+	_, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+
+	cfg := DefaultOutboxConfig()
+	cfg.BaseDelay = 0
+	healthy := &failingSink{}
+	failing := &failingSink{fails: 1}
+	dispatcher := NewOutboxDispatcher(store, cfg, healthy, failing)
+
+	_, err = dispatcher.DispatchPending(context.Background())
+	require.Error(t, err)
+	n, err := dispatcher.DispatchPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, 1, healthy.calls, "healthy sink should not receive the event again")
+	assert.Equal(t, 2, failing.calls)
+
+	// После доставки во все приёмники отметки о доставке в отдельные приёмники удаляются
+	var marks int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM outbox_sink_delivery").Scan(&marks))
+	assert.Zero(t, marks)
+}
+
+// parcelFailingSink - приёмник, не принимающий события посылки parcel и запоминающий опубликованные события
+type parcelFailingSink struct {
+	parcel    int
+	published []ParcelEvent
+}
+
+func (f *parcelFailingSink) Publish(_ context.Context, event ParcelEvent) error {
+	if event.Number == f.parcel {
+		return errors.New("sink rejects parcel")
+	}
+	f.published = append(f.published, event)
+	return nil
+}
+
+// TestOutboxBackoff - тест для проверки задержки повторных попыток, откладывания события после
+// исчерпания попыток и публикации событий других посылок во время сбоя
+func TestOutboxBackoff(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	service := NewParcelService(store, WithOutput(io.Discard))
+
+	failing, err := service.Register(1000, "failing")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.NextStatus(failing.Number))
+	other, err := service.Register(1000, "other")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+
+	cfg := DefaultOutboxConfig()
+	cfg.MaxAttempts = 2
+	cfg.BaseDelay = time.Hour
+	cfg.MaxDelay = time.Hour
+	sink := &parcelFailingSink{parcel: failing.Number}
+	dispatcher := NewOutboxDispatcher(store, cfg, sink)
+
+	// Сбой на событии одной посылки не мешает публикации событий другой,
+	// а следующее событие той же посылки ждёт повторной попытки
+	n, err := dispatcher.DispatchPending(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, sink.published, 1)
+	assert.Equal(t, other.Number, sink.published[0].Number)
+
+	// Повторная попытка ещё не наступила, события посылки не публикуются
+	n, err = dispatcher.DispatchPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	var attempts int
+	var lastError string
+	var next sql.NullString
+	require.NoError(t, db.QueryRow("SELECT attempts, last_error, next_attempt_at FROM outbox WHERE parcel = ? AND event_type = ?",
+		failing.Number, EventRegistered).Scan(&attempts, &lastError, &next))
+	assert.Equal(t, 1, attempts)
+	assert.Contains(t, lastError, "sink rejects parcel")
+	require.True(t, next.Valid)
+	due, err := time.Parse(time.RFC3339, next.String)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), due, time.Minute)
+
+	// После последней попытки событие откладывается, и публикуется следующее событие посылки
+	_, err = db.Exec("UPDATE outbox SET next_attempt_at = ? WHERE parcel = ?", time.Now().UTC().Format(time.RFC3339), failing.Number)
+	require.NoError(t, err)
+	sink.parcel = 0
+	failAgain := &parcelFailingSink{parcel: failing.Number}
+	_, err = NewOutboxDispatcher(store, cfg, failAgain).DispatchPending(context.Background())
+	require.Error(t, err)
+
+	var parked sql.NullString
+	require.NoError(t, db.QueryRow("SELECT attempts, parked_at FROM outbox WHERE parcel = ? AND event_type = ?",
+		failing.Number, EventRegistered).Scan(&attempts, &parked))
+	assert.Equal(t, 2, attempts)
+	assert.True(t, parked.Valid, "event should be parked after max attempts")
+
+	n, err = dispatcher.DispatchPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, sink.published, 2)
+	assert.Equal(t, failing.Number, sink.published[1].Number)
+	assert.Equal(t, EventStatusChanged, sink.published[1].Type)
+}
@@ -1,56 +1,174 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// querier - методы выполнения запросов, общие для *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // ParcelStore - структура для работы с посылками в базе данных
 type ParcelStore struct {
-	db *sql.DB
+	db      *sql.DB
+	read    *sql.DB // Пул подключений только для чтения, nil - чтение через db
+	q       querier // Подключение или транзакция, через которую выполняются запросы
+	tx      *sql.Tx // Текущая транзакция, nil вне InTx
+	logger  *slog.Logger
+	metrics *Metrics
+	tracer  *Tracer
+	rules   StatusRules  // Правила статусов: в каких статусах посылку можно изменить
+	dialect dialect      // Особенности SQL базы данных
+	cipher  *FieldCipher // Шифрование адресов, nil - адреса хранятся открыто
+}
+
+// NewParcelStore - конструктор для создания нового экземпляра ParcelStore (В ней поле для хранения подключения к базе данных).
+// Диалект SQL (SQLite или PostgreSQL) определяется по драйверу подключения
+func NewParcelStore(db *sql.DB, opts ...Option) ParcelStore {
+	o := newOptions(opts)
+	d := dialectOf(db)
+	return ParcelStore{db: db, q: d.wrap(db), logger: o.logger, metrics: o.metrics, tracer: o.tracer, rules: o.statusRules, dialect: d,
+		cipher: o.cipher}
+}
+
+// reader - подключение для запросов на чтение: в транзакции - сама транзакция, иначе пул чтения, если он открыт
+func (s ParcelStore) reader() querier {
+	if s.tx != nil || s.read == nil {
+		return s.q
+	}
+	return s.dialect.wrap(s.read)
 }
 
-// NewParcelStore - конструктор для создания нового экземпляра ParcelStore (В ней поле для хранения подключения к базе данных)
-func NewParcelStore(db *sql.DB) ParcelStore {
-	return ParcelStore{db: db}
+// InTx - выполняет fn в транзакции: все запросы хранилища tx, переданного в fn, входят в одну транзакцию.
+// Транзакция фиксируется, если fn завершилась без ошибки, иначе откатывается.
+// Вызов InTx внутри транзакции выполняет fn в уже открытой транзакции
+func (s ParcelStore) InTx(ctx context.Context, fn func(tx ParcelStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	txStore := s
+	txStore.q = s.dialect.wrap(tx)
+	txStore.tx = tx
+	if err := fn(txStore); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// begin - начинает операцию хранилища: открывает дочерний span с SQL-операцией sqlOp
+func (s ParcelStore) begin(ctx context.Context, op, sqlOp string) (context.Context, operation) {
+	ctx, span := s.tracer.Start(ctx, "store."+op,
+		slog.String(attrDBSystem, s.dialect.name),
+		slog.String(attrDBOperation, sqlOp))
+	return ctx, operation{
+		ctx:     ctx,
+		layer:   "store",
+		name:    op,
+		level:   slog.LevelDebug,
+		start:   time.Now(),
+		span:    span,
+		logger:  s.logger,
+		metrics: s.metrics,
+	}
+}
+
+// parcelColumns - столбцы таблицы parcel в порядке полей, которые читает scanParcel
+const parcelColumns = "number, client, status, address, created_at, tracking_code, service_level, COALESCE(eta, '')"
+
+// rowScanner - общий метод *sql.Row и *sql.Rows для чтения строки результата
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanParcel - читает посылку из строки результата запроса по столбцам parcelColumns и расшифровывает адрес.
+// Значения столбцов, следующих за parcelColumns, читаются в extra
+func (s ParcelStore) scanParcel(row rowScanner, extra ...any) (Parcel, error) {
+	var p Parcel
+	dest := append([]any{&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt, &p.TrackingCode, &p.ServiceLevel, &p.ETA}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return p, err
+	}
+	address, err := s.cipher.Decrypt(fieldAddress, p.Address)
+	if err != nil {
+		return p, fmt.Errorf("parcel %d: %w", p.Number, err)
+	}
+	p.Address = address
+	return p, nil
 }
 
 // Add - метод для добавления новой посылки в базу данных
 func (s ParcelStore) Add(p Parcel) (int, error) {
+	return s.AddContext(context.Background(), p)
+}
 
-	// Выполняем SQL-запрос на вставку новой посылки
-	res, err := s.db.Exec("INSERT INTO parcel (client, status, address, created_at) VALUES (:client, :status, :address, :created_at)",
-		sql.Named("client", p.Client),
-		sql.Named("status", p.Status),
-		sql.Named("address", p.Address),
-		sql.Named("created_at", p.CreatedAt))
+// AddContext - вариант Add с контекстом для отмены запроса и трассировки
+func (s ParcelStore) AddContext(ctx context.Context, p Parcel) (id int, err error) {
+	ctx, op := s.begin(ctx, "add", "INSERT")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, id), slog.Int(attrClient, p.Client), slog.String(attrNewStatus, p.Status))
+	}()
+
+	// Адрес записывается зашифрованным, а для поиска по нему - слепой индекс
+	address, err := s.cipher.Encrypt(fieldAddress, p.Address)
 	if err != nil {
-		return 0, fmt.Errorf("failed to add parcel to the database: client=%d, status=%s, address=%s, error: %w", p.Client, p.Status, p.Address, err)
+		return 0, err
 	}
 
-	// Получаем ID добавленной посылки
-	id, err := res.LastInsertId()
+	// Выполняем SQL-запрос на вставку новой посылки, номер посылки возвращается через RETURNING
+	err = s.q.QueryRowContext(ctx, "INSERT INTO parcel (client, status, address, address_index, created_at, tracking_code, service_level, eta) VALUES (:client, :status, :address, :address_index, :created_at, :tracking_code, :service_level, NULLIF(:eta, '')) RETURNING number",
+		sql.Named("client", p.Client),
+		sql.Named("status", p.Status),
+		sql.Named("address", address),
+		sql.Named("address_index", s.cipher.BlindIndex(fieldAddress, p.Address)),
+		sql.Named("created_at", p.CreatedAt),
+		sql.Named("tracking_code", p.TrackingCode),
+		sql.Named("service_level", p.ServiceLevel),
+		sql.Named("eta", p.ETA)).Scan(&id)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get ID of the added parcel: error: %w", err)
+		return 0, fmt.Errorf("failed to add parcel to the database: client=%d, status=%s, error: %w", p.Client, p.Status, err)
 	}
+
 	// Возвращаем ID новой посылки
-	return int(id), nil
+	return id, nil
 }
 
 // Get - метод для получения посылки по её номеру
 func (s ParcelStore) Get(number int) (Parcel, error) {
+	return s.GetContext(context.Background(), number)
+}
 
-	// Создаем пустую структуру посылки
-	p := Parcel{}
+// GetContext - вариант Get с контекстом для отмены запроса и трассировки
+func (s ParcelStore) GetContext(ctx context.Context, number int) (p Parcel, err error) {
+	ctx, op := s.begin(ctx, "get", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
 
 	// Выполняем SQL-запрос для получения данных о посылке
-	row := s.db.QueryRow("SELECT * FROM parcel WHERE number = :number", sql.Named("number", number))
+	row := s.reader().QueryRowContext(ctx, "SELECT "+parcelColumns+" FROM parcel WHERE number = :number", sql.Named("number", number))
 
 	// Сканируем результат запроса и записываем его в структуру посылки
-	err := row.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt)
+	p, err = s.scanParcel(row)
 	if err != nil {
 		return p, fmt.Errorf("failed to retrieve parcel with number %d: error: %w", number, err)
 	}
@@ -59,14 +177,40 @@ func (s ParcelStore) Get(number int) (Parcel, error) {
 	return p, nil
 }
 
+// GetByTrackingCodeContext - метод для получения посылки по коду отслеживания
+func (s ParcelStore) GetByTrackingCodeContext(ctx context.Context, code string) (p Parcel, err error) {
+	ctx, op := s.begin(ctx, "get_by_tracking_code", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, p.Number))
+	}()
+
+	// Пустой код есть у посылок, добавленных без него, поиск по нему не выполняется
+	if code == "" {
+		return p, fmt.Errorf("failed to retrieve parcel by empty tracking code: error: %w", sql.ErrNoRows)
+	}
+
+	row := s.reader().QueryRowContext(ctx, "SELECT "+parcelColumns+" FROM parcel WHERE tracking_code = :code", sql.Named("code", code))
+	p, err = s.scanParcel(row)
+	if err != nil {
+		return p, fmt.Errorf("failed to retrieve parcel by tracking code: error: %w", err)
+	}
+	return p, nil
+}
+
 // GetByClient - метод для получения всех посылок определенного клиента
 func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
+	return s.GetByClientContext(context.Background(), client)
+}
 
-	// Создаем слайс для хранения найденных посылок
-	var res []Parcel
+// GetByClientContext - вариант GetByClient с контекстом для отмены запроса и трассировки
+func (s ParcelStore) GetByClientContext(ctx context.Context, client int) (res []Parcel, err error) {
+	ctx, op := s.begin(ctx, "get_by_client", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
 
 	// Выполняем SQL-запрос для получения всех посылок клиента
-	rows, err := s.db.Query("SELECT * FROM parcel WHERE client = :client", sql.Named("client", client))
+	rows, err := s.reader().QueryContext(ctx, "SELECT "+parcelColumns+" FROM parcel WHERE client = :client", sql.Named("client", client))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve client's parcels %d: error: %w", client, err)
 	}
@@ -75,11 +219,8 @@ func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
 
 	// Итерируемся по всем строкам результата
 	for rows.Next() {
-		// Создаем новую структуру посылки
-		p := Parcel{}
-
 		// Сканируем данные текущей строки и записываем их в структуру посылки
-		err = rows.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt)
+		p, err := s.scanParcel(rows)
 		if err != nil {
 			return nil, fmt.Errorf("row scanning error while retrieving client's parcels %d: error: %w", client, err)
 		}
@@ -96,11 +237,66 @@ func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
 	return res, nil
 }
 
+// GetByAddressContext - метод для получения посылок по адресу. При шифровании поиск идёт по слепому индексу
+// без учёта регистра и лишних пробелов, иначе - по точному совпадению адреса
+func (s ParcelStore) GetByAddressContext(ctx context.Context, address string) (res []Parcel, err error) {
+	ctx, op := s.begin(ctx, "get_by_address", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(res)))
+	}()
+
+	query, arg := "SELECT "+parcelColumns+" FROM parcel WHERE address = :address ORDER BY number", sql.Named("address", address)
+	if s.cipher != nil {
+		query, arg = "SELECT "+parcelColumns+" FROM parcel WHERE address_index = :index ORDER BY number",
+			sql.Named("index", s.cipher.BlindIndex(fieldAddress, address))
+	}
+
+	rows, err := s.reader().QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve parcels by address: error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := s.scanParcel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving parcels by address: error: %w", err)
+		}
+		res = append(res, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving parcels by address: %w", err)
+	}
+	return res, nil
+}
+
 // SetStatus - метод для обновления статуса посылки
 func (s ParcelStore) SetStatus(number int, status string) error {
+	return s.SetStatusContext(context.Background(), number, status)
+}
+
+// SetStatusContext - вариант SetStatus с контекстом для отмены запроса и трассировки
+func (s ParcelStore) SetStatusContext(ctx context.Context, number int, status string) (err error) {
+	ctx, op := s.begin(ctx, "set_status", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number), slog.String(attrNewStatus, status))
+	}()
+
+	// Время доставки запоминается для правил хранения данных, delivered - конечный статус.
+	// Время первой отправки запоминается для контроля сроков доставки (SLA)
+	now := time.Now().UTC().Format(time.RFC3339)
+	var deliveredAt, sentAt any
+	switch status {
+	case ParcelStatusDelivered:
+		deliveredAt = now
+	case ParcelStatusSent:
+		sentAt = now
+	}
 
 	// Выполняем SQL-запрос на обновление статуса
-	_, err := s.db.Exec("UPDATE parcel SET status = :status WHERE number = :number", sql.Named("status", status), sql.Named("number", number))
+	_, err = s.q.ExecContext(ctx, "UPDATE parcel SET status = :status, delivered_at = :delivered_at, sent_at = COALESCE(sent_at, :sent_at) WHERE number = :number",
+		sql.Named("status", status), sql.Named("delivered_at", deliveredAt), sql.Named("sent_at", sentAt), sql.Named("number", number))
 	if err != nil {
 		return fmt.Errorf("failed to update parcel status №%d to '%s': error: %w", number, status, err)
 	}
@@ -108,47 +304,122 @@ func (s ParcelStore) SetStatus(number int, status string) error {
 	return nil
 }
 
-// SetAddress - метод для установки нового адреса посылки при условии, что её статус зарегистрирован
+// SetAddress - метод для установки нового адреса посылки при условии, что её статус допускает изменение (по умолчанию - зарегистрирована)
 func (s ParcelStore) SetAddress(number int, address string) error {
+	return s.SetAddressContext(context.Background(), number, address)
+}
+
+// SetAddressContext - вариант SetAddress с контекстом для отмены запроса и трассировки
+func (s ParcelStore) SetAddressContext(ctx context.Context, number int, address string) error {
+	_, err := s.setAddress(ctx, number, address)
+	return err
+}
+
+// setAddress - обновляет адрес посылки и сообщает, был ли он изменён.
+// Отказ в изменении (посылка не найдена или уже отправлена) не считается ошибкой
+func (s ParcelStore) setAddress(ctx context.Context, number int, address string) (updated bool, err error) {
+	ctx, op := s.begin(ctx, "set_address", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
+
+	encrypted, err := s.cipher.Encrypt(fieldAddress, address)
+	if err != nil {
+		return false, err
+	}
 
 	// Выполняем обновление с проверкой статуса в одном запросе
-	result, err := s.db.Exec("UPDATE parcel SET address = :address WHERE number = :number AND status = :status",
-		sql.Named("address", address),
-		sql.Named("number", number),
-		sql.Named("status", ParcelStatusRegistered))
+	editable, args := s.rules.editableCondition()
+	result, err := s.q.ExecContext(ctx, "UPDATE parcel SET address = :address, address_index = :address_index WHERE number = :number AND "+editable,
+		append(args,
+			sql.Named("address", encrypted),
+			sql.Named("address_index", s.cipher.BlindIndex(fieldAddress, address)),
+			sql.Named("number", number))...)
 	if err != nil {
-		return fmt.Errorf("address update error for parcel №%d: new address '%s', error: %w", number, address, err)
+		return false, fmt.Errorf("address update error for parcel №%d: error: %w", number, err)
 	}
 
 	// Проверяем, что строка была обновлена
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		log.Printf("update denied for parcel %d: invalid status or parcel not found", number)
-		return nil
+		s.logger.WarnContext(ctx, "update denied: invalid status or parcel not found", slog.Int(attrParcel, number))
+		return false, nil
 	}
 
-	return nil
+	return true, nil
 }
 
-// SetAddress - метод для удаления посылки из базы данных при условии, что её статус зарегистрирован
+// Delete - метод для удаления посылки из базы данных при условии, что её статус допускает изменение (по умолчанию - зарегистрирована)
 func (s ParcelStore) Delete(number int) error {
+	return s.DeleteContext(context.Background(), number)
+}
+
+// DeleteContext - вариант Delete с контекстом для отмены запроса и трассировки
+func (s ParcelStore) DeleteContext(ctx context.Context, number int) error {
+	_, err := s.delete(ctx, number)
+	return err
+}
+
+// delete - удаляет посылку и сообщает, была ли она удалена.
+// Отказ в удалении (посылка не найдена или уже отправлена) не считается ошибкой
+func (s ParcelStore) delete(ctx context.Context, number int) (deleted bool, err error) {
+	ctx, op := s.begin(ctx, "delete", "DELETE")
+	defer func() {
+		op.end(err, slog.Int(attrParcel, number))
+	}()
 
 	// Выполняем удаление с проверкой статуса в одном запросе
-	result, err := s.db.Exec(
-		"DELETE FROM parcel WHERE number = :number AND status = :status",
-		sql.Named("number", number),
-		sql.Named("status", ParcelStatusRegistered),
+	editable, args := s.rules.editableCondition()
+	result, err := s.q.ExecContext(ctx,
+		"DELETE FROM parcel WHERE number = :number AND "+editable,
+		append(args, sql.Named("number", number))...,
 	)
 	if err != nil {
-		return fmt.Errorf("parcel deletion error №%d: %w", number, err)
+		return false, fmt.Errorf("parcel deletion error №%d: %w", number, err)
 	}
 
 	// Проверяем, что строка была удалена
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		log.Printf("delete denied for parcel №%d: invalid status or parcel not found", number)
-		return nil
+		s.logger.WarnContext(ctx, "delete denied: invalid status or parcel not found", slog.Int(attrParcel, number))
+		return false, nil
 	}
 
-	return nil
+	return true, nil
+}
+
+// CountByStatus - метод для подсчёта количества посылок в каждом статусе
+func (s ParcelStore) CountByStatus() (map[string]int, error) {
+	return s.CountByStatusContext(context.Background())
+}
+
+// CountByStatusContext - вариант CountByStatus с контекстом для отмены запроса и трассировки
+func (s ParcelStore) CountByStatusContext(ctx context.Context) (res map[string]int, err error) {
+	ctx, op := s.begin(ctx, "count_by_status", "SELECT")
+	defer func() {
+		op.end(err)
+	}()
+
+	// Выполняем SQL-запрос с группировкой посылок по статусу
+	rows, err := s.reader().QueryContext(ctx, "SELECT status, COUNT(*) FROM parcel GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count parcels by status: error: %w", err)
+	}
+	defer rows.Close()
+
+	res = map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err = rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("row scanning error while counting parcels by status: error: %w", err)
+		}
+		res[status] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while counting parcels by status: %w", err)
+	}
+
+	return res, nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 
@@ -45,9 +47,18 @@ func cleanDatabase(db *sql.DB) error {
 
 // setupDatabase - настройка подключения к базе данных
 func setupDatabase(t *testing.T) *sql.DB {
-	// Подключение к SQLite базе данных
-	db, err := sql.Open("sqlite", "tracker_test.db")
-	require.NoError(t, err, "failed to establish database connection: tracker_test.db. Error details: %w", err)
+	// Подключение к SQLite базе данных: по умолчанию tracker_test.db, другую базу можно задать переменной TRACKER_TEST_DB_DSN
+	cfg := DefaultConfig().Database
+	cfg.DSN = "tracker_test.db"
+	if dsn, ok := os.LookupEnv("TRACKER_TEST_DB_DSN"); ok {
+		cfg.DSN = dsn
+	}
+	db, err := sql.Open("sqlite", cfg.DataSource())
+	require.NoError(t, err, "failed to establish database connection: %s. Error details: %w", cfg.DSN, err)
+
+	// Применение миграций схемы
+	err = Migrate(context.Background(), db)
+	require.NoError(t, err, "failed to migrate database schema. Error details: %w", err)
 
 	// Очистка БД перед каждым тестом
 	err = cleanDatabase(db)
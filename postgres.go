@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgres - строка подключения указывает на PostgreSQL (postgres:// или postgresql://)
+func (d DatabaseConfig) postgres() bool {
+	return strings.HasPrefix(d.DSN, "postgres://") || strings.HasPrefix(d.DSN, "postgresql://")
+}
+
+// redactedDSN - строка подключения без пароля для вывода в журнал и на экран
+func (d DatabaseConfig) redactedDSN() string {
+	u, err := url.Parse(d.DSN)
+	if err != nil || u.User == nil {
+		return d.DSN
+	}
+	return u.Redacted()
+}
+
+// openPostgres - открывает базу PostgreSQL, применяет миграции и возвращает хранилище.
+// PostgreSQL сам поддерживает конкурентную запись, поэтому чтение и запись идут через общий пул
+// из max_read_conns подключений для чтения и одного для записи. PRAGMA SQLite не применяются
+func openPostgres(ctx context.Context, cfg DatabaseConfig, opts ...Option) (ParcelStore, error) {
+	db, err := sql.Open("pgx", cfg.DSN)
+	if err != nil {
+		return ParcelStore{}, fmt.Errorf("failed to open database %s: error: %w", cfg.redactedDSN(), err)
+	}
+	db.SetMaxOpenConns(cfg.MaxReadConns + 1)
+	db.SetMaxIdleConns(cfg.MaxReadConns + 1)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return ParcelStore{}, fmt.Errorf("failed to connect to database %s: error: %w", cfg.redactedDSN(), err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return ParcelStore{}, fmt.Errorf("failed to migrate database %s: error: %w", cfg.redactedDSN(), err)
+	}
+	return NewParcelStore(db, opts...), nil
+}
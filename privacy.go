@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Действия, записываемые в журнал аудита
+const (
+	AuditClientExport    = "client.export"
+	AuditClientAnonymize = "client.anonymize"
+)
+
+// auditSystemActor - исполнитель действий, выполненных без вызывающего (внутренним кодом или из командной строки)
+const auditSystemActor = "system"
+
+const (
+	// exportEventsPage - сколько событий читается за один запрос при выгрузке данных клиента
+	exportEventsPage = 1000
+	// anonymizeChunk - сколько посылок обезличивается одним запросом
+	anonymizeChunk = 500
+)
+
+// ErrParcelsInTransit - у клиента есть недоставленные посылки, адреса которых ещё нужны для доставки
+var ErrParcelsInTransit = errors.New("client has parcels in transit")
+
+// AuditEntry - запись журнала аудита действий с персональными данными
+type AuditEntry struct {
+	ID         int64  `json:"id"`
+	OccurredAt string `json:"occurred_at"`
+	Actor      string `json:"actor"`   // Учётные данные вызывающего: apikey:<номер>, jwt:<sub> или system
+	Action     string `json:"action"`  // Действие, например client.export
+	Client     int    `json:"client"`  // Клиент, к данным которого относится действие
+	Details    string `json:"details"` // Подробности в формате JSON
+}
+
+// ClientExport - все данные, хранимые о клиенте, для ответа на запрос субъекта персональных данных
+type ClientExport struct {
+	Client      int                   `json:"client"`
+	ExportedAt  string                `json:"exported_at"`
+	Parcels     []Parcel              `json:"parcels"`
+	Events      []ParcelEvent         `json:"events"`               // История изменений посылок
+	Webhooks    []WebhookSubscription `json:"webhooks"`             // Адреса уведомлений клиента, без секретов подписи
+	DeadLetters []WebhookDeadLetter   `json:"webhook_dead_letters"` // Недоставленные уведомления о посылках клиента
+	APIKeys     []APIKey              `json:"api_keys"`             // API-ключи клиента, без значений ключей
+	Quota       *int                  `json:"daily_quota"`          // Дневная квота регистраций, null - без ограничения
+	Audit       []AuditEntry          `json:"audit"`                // Действия с данными клиента, включая эту выгрузку
+	Anonymized  bool                  `json:"anonymized,omitempty"` // Посылки клиента были обезличены
+}
+
+// auditActor - исполнитель действия для журнала аудита
+func auditActor(ctx context.Context) string {
+	if id, ok := IdentityFromContext(ctx); ok {
+		return id.Credential
+	}
+	return auditSystemActor
+}
+
+// newAuditEntry - запись аудита действия action с данными клиента client, выполненного вызывающим из ctx
+func newAuditEntry(ctx context.Context, action string, client int, details any) (AuditEntry, error) {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to encode audit details of %s: %w", action, err)
+	}
+	return AuditEntry{
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+		Actor:      auditActor(ctx),
+		Action:     action,
+		Client:     client,
+		Details:    string(b),
+	}, nil
+}
+
+// AppendAudit - метод для добавления записи в журнал аудита. Возвращает номер записи
+func (s ParcelStore) AppendAudit(ctx context.Context, e AuditEntry) (id int64, err error) {
+	ctx, op := s.begin(ctx, "append_audit", "INSERT")
+	defer func() {
+		op.end(err, slog.String("action", e.Action), slog.Int(attrClient, e.Client))
+	}()
+
+	err = s.q.QueryRowContext(ctx, "INSERT INTO audit_log (occurred_at, actor, action, client, details) VALUES (:occurred_at, :actor, :action, :client, :details) RETURNING id",
+		sql.Named("occurred_at", e.OccurredAt),
+		sql.Named("actor", e.Actor),
+		sql.Named("action", e.Action),
+		sql.Named("client", e.Client),
+		sql.Named("details", e.Details)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to append audit entry %s for client %d: error: %w", e.Action, e.Client, err)
+	}
+	return id, nil
+}
+
+// GetAuditLog - метод для получения записей журнала аудита о данных клиента в порядке их добавления
+func (s ParcelStore) GetAuditLog(ctx context.Context, client int) (res []AuditEntry, err error) {
+	ctx, op := s.begin(ctx, "get_audit_log", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT id, occurred_at, actor, action, client, details FROM audit_log WHERE client = :client ORDER BY id",
+		sql.Named("client", client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit log of client %d: error: %w", client, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		if err = rows.Scan(&e.ID, &e.OccurredAt, &e.Actor, &e.Action, &e.Client, &e.Details); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving audit log of client %d: error: %w", client, err)
+		}
+		res = append(res, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving audit log of client %d: %w", client, err)
+	}
+	return res, nil
+}
+
+// ExportClientData - выгружает все данные, хранимые о клиенте: посылки, историю их изменений,
+// адреса уведомлений, недоставленные уведомления, API-ключи, квоту и журнал аудита. Клиент может выгрузить только свои данные.
+// Выгрузка записывается в журнал аудита в той же транзакции, что и чтение данных
+func (s ParcelService) ExportClientData(ctx context.Context, client int) (export ClientExport, err error) {
+	ctx, op := s.begin(ctx, "export_client_data")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(export.Parcels)))
+	}()
+
+	// у обезличенных посылок клиент 0, а фильтр событий с клиентом 0 выбирает события всех клиентов
+	if client == anonymousClient {
+		return export, fmt.Errorf("client %d cannot be exported: %w", client, sql.ErrNoRows)
+	}
+	if err = authorizeClient(ctx, client); err != nil {
+		return export, err
+	}
+
+	export = ClientExport{Client: client, ExportedAt: time.Now().UTC().Format(time.RFC3339)}
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		var err error
+		if export.Parcels, err = tx.GetByClientContext(ctx, client); err != nil {
+			return err
+		}
+		if export.Events, err = exportClientEvents(ctx, tx, client); err != nil {
+			return err
+		}
+		if export.Webhooks, err = tx.GetWebhooksByClient(ctx, client); err != nil {
+			return err
+		}
+		// секрет подписи - учётные данные сервиса, а не данные клиента
+		for i := range export.Webhooks {
+			export.Webhooks[i].Secret = ""
+		}
+		if export.DeadLetters, err = tx.GetWebhookDeadLettersByClient(ctx, client); err != nil {
+			return err
+		}
+		if export.APIKeys, err = tx.GetAPIKeysByClient(ctx, client); err != nil {
+			return err
+		}
+		quota, ok, err := tx.GetClientQuota(ctx, client)
+		if err != nil {
+			return err
+		}
+		if ok {
+			export.Quota = &quota
+		}
+
+		entry, err := newAuditEntry(ctx, AuditClientExport, client, map[string]int{
+			"parcels": len(export.Parcels),
+			"events":  len(export.Events),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tx.AppendAudit(ctx, entry); err != nil {
+			return err
+		}
+		if export.Audit, err = tx.GetAuditLog(ctx, client); err != nil {
+			return err
+		}
+		for _, e := range export.Audit {
+			if e.Action == AuditClientAnonymize {
+				export.Anonymized = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ClientExport{}, err
+	}
+	return export, nil
+}
+
+// exportClientEvents - все события посылок клиента постранично
+func exportClientEvents(ctx context.Context, store ParcelStore, client int) ([]ParcelEvent, error) {
+	var res []ParcelEvent
+	var after int64
+	for {
+		page, err := store.GetEventsAfter(ctx, after, EventFilter{Client: client}, exportEventsPage)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, page...)
+		if len(page) < exportEventsPage {
+			return res, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// AnonymizeClient - необратимо обезличивает посылки клиента: стирает адреса и связь с клиентом в посылках
+// и их событиях, удаляет уведомления webhook о посылках и ключи идемпотентности клиента, хранящие хеш адреса. Статус и даты посылок
+// сохраняются для статистики. Пока у клиента есть недоставленные посылки, операция отклоняется с ErrParcelsInTransit,
+// чтобы не стереть адрес, по которому посылка ещё едет. Операция доступна только администратору и записывается в журнал аудита.
+// Возвращает количество обезличенных посылок
+func (s ParcelService) AnonymizeClient(ctx context.Context, client int) (count int, err error) {
+	ctx, op := s.begin(ctx, "anonymize_client")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, count))
+	}()
+
+	if err = authorizeRole(ctx, RoleAdmin); err != nil {
+		return 0, err
+	}
+
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		parcels, err := tx.GetByClientContext(ctx, client)
+		if err != nil {
+			return err
+		}
+		numbers := make([]int, len(parcels))
+		inTransit := 0
+		for i, p := range parcels {
+			numbers[i] = p.Number
+			if p.Status != ParcelStatusDelivered {
+				inTransit++
+			}
+		}
+		if inTransit > 0 {
+			return fmt.Errorf("client %d has %d undelivered parcels: %w", client, inTransit, ErrParcelsInTransit)
+		}
+
+		// количество параметров одного запроса ограничено, поэтому посылки обезличиваются частями
+		now := time.Now()
+		for start := 0; start < len(numbers); start += anonymizeChunk {
+			end := min(start+anonymizeChunk, len(numbers))
+			if err := tx.anonymizeParcels(ctx, numbers[start:end], now, true); err != nil {
+				return err
+			}
+		}
+		if err := tx.deleteClientIdempotencyKeys(ctx, client); err != nil {
+			return err
+		}
+		if err := tx.compactSearchIndex(ctx); err != nil {
+			return err
+		}
+
+		entry, err := newAuditEntry(ctx, AuditClientAnonymize, client, map[string]int{"parcels": len(numbers)})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.AppendAudit(ctx, entry); err != nil {
+			return err
+		}
+		count = len(numbers)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExportClientData - тест для проверки выгрузки всех данных клиента и записи выгрузки в журнал аудита
+func TestExportClientData(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	const client = 1000
+	first, err := service.RegisterContext(ctx, client, "test")
+	require.NoError(t, err)
+	require.NoError(t, service.NextStatusContext(ctx, first.Number))
+	_, err = service.RegisterContext(ctx, client, "other test")
+	require.NoError(t, err)
+	_, err = service.RegisterContext(ctx, client+1, "foreign")
+	require.NoError(t, err)
+
+	_, err = store.AddWebhook(ctx, WebhookSubscription{Client: client, URL: "http://localhost/hook", Secret: "secret"})
+	require.NoError(t, err)
+	key, err := newAPIKey()
+	require.NoError(t, err)
+	_, err = store.AddAPIKey(ctx, APIKey{Name: "shop", Role: RoleClient, Client: client}, key)
+	require.NoError(t, err)
+	require.NoError(t, store.SetClientQuota(ctx, client, 10))
+
+	owner := WithIdentity(ctx, Identity{Subject: "shop", Role: RoleClient, Client: client, Credential: "jwt:shop"})
+	export, err := service.ExportClientData(owner, client)
+	require.NoError(t, err)
+
+	assert.Equal(t, client, export.Client)
+	assert.Len(t, export.Parcels, 2)
+	assert.Len(t, export.Events, 3)
+	for _, e := range export.Events {
+		assert.Equal(t, client, e.Client)
+	}
+	require.Len(t, export.Webhooks, 1)
+	assert.Empty(t, export.Webhooks[0].Secret)
+	require.Len(t, export.APIKeys, 1)
+	assert.Equal(t, "shop", export.APIKeys[0].Name)
+	require.NotNil(t, export.Quota)
+	assert.Equal(t, 10, *export.Quota)
+
+	// Выгрузка сама попадает в журнал аудита с исполнителем
+	require.Len(t, export.Audit, 1)
+	assert.Equal(t, AuditClientExport, export.Audit[0].Action)
+	assert.Equal(t, "jwt:shop", export.Audit[0].Actor)
+	assert.JSONEq(t, `{"parcels": 2, "events": 3}`, export.Audit[0].Details)
+
+	// Чужие данные клиенту недоступны
+	_, err = service.ExportClientData(owner, client+1)
+	require.ErrorIs(t, err, ErrForbidden)
+}
+
+// TestAnonymizeClient - тест для проверки необратимого обезличивания посылок клиента
+func TestAnonymizeClient(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	const client = 1000
+	var numbers []int
+	for i := 0; i < 2; i++ {
+		p, _, err := service.RegisterIdempotent(ctx, fmt.Sprintf("order-%d", i), client, "test")
+		require.NoError(t, err)
+		numbers = append(numbers, p.Number)
+	}
+	require.NoError(t, service.NextStatusContext(ctx, numbers[0]))
+	foreign, err := service.RegisterContext(ctx, client+1, "foreign")
+	require.NoError(t, err)
+
+	// Обезличивание доступно только администратору
+	operator := WithIdentity(ctx, Identity{Subject: "op", Role: RoleOperator, Credential: "jwt:op"})
+	_, err = service.AnonymizeClient(operator, client)
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// Пока посылки в пути (зарегистрирована и отправлена), их адреса не стираются
+	admin := WithIdentity(ctx, Identity{Subject: "admin", Role: RoleAdmin, Credential: "apikey:1"})
+	_, err = service.AnonymizeClient(admin, client)
+	require.ErrorIs(t, err, ErrParcelsInTransit)
+	for _, number := range numbers {
+		p, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, "test", p.Address)
+		assert.Equal(t, client, p.Client)
+	}
+	audit, err := store.GetAuditLog(ctx, client)
+	require.NoError(t, err)
+	assert.Empty(t, audit)
+
+	// Одна доставленная посылка не снимает запрет, пока другая в пути
+	require.NoError(t, service.NextStatusContext(ctx, numbers[0]))
+	_, err = service.AnonymizeClient(admin, client)
+	require.ErrorIs(t, err, ErrParcelsInTransit)
+
+	require.NoError(t, service.NextStatusContext(ctx, numbers[1]))
+	require.NoError(t, service.NextStatusContext(ctx, numbers[1]))
+	count, err := service.AnonymizeClient(admin, client)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Адрес и связь с клиентом стёрты, статус и дата регистрации сохранены
+	for _, number := range numbers {
+		p, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, anonymizedAddress, p.Address)
+		assert.Equal(t, anonymousClient, p.Client)
+		assert.NotEmpty(t, p.CreatedAt)
+
+		events, err := store.GetEventsAfter(ctx, 0, EventFilter{Parcel: number}, 10)
+		require.NoError(t, err)
+		require.NotEmpty(t, events)
+		for _, e := range events {
+			assert.Equal(t, anonymizedAddress, e.Address)
+			assert.Equal(t, anonymousClient, e.Client)
+		}
+	}
+	p, err := store.GetContext(ctx, numbers[0])
+	require.NoError(t, err)
+	assert.Equal(t, ParcelStatusDelivered, p.Status)
+
+	parcels, err := store.GetByClientContext(ctx, client)
+	require.NoError(t, err)
+	assert.Empty(t, parcels)
+	p, err = store.GetContext(ctx, foreign.Number)
+	require.NoError(t, err)
+	assert.Equal(t, "foreign", p.Address)
+
+	// Ключ идемпотентности больше не возвращает обезличенную посылку
+	again, replayed, err := service.RegisterIdempotent(ctx, "order-0", client, "test")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.NotEqual(t, numbers[0], again.Number)
+
+	audit, err = store.GetAuditLog(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, AuditClientAnonymize, audit[0].Action)
+	assert.Equal(t, "apikey:1", audit[0].Actor)
+	assert.JSONEq(t, `{"parcels": 2}`, audit[0].Details)
+}
+
+// tablesContaining - таблицы базы, в строках которых встречается needle без учёта регистра
+func tablesContaining(t *testing.T, store ParcelStore, needle string) []string {
+	ctx := context.Background()
+	rows, err := store.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table'")
+	require.NoError(t, err)
+	var tables []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		tables = append(tables, name)
+	}
+	require.NoError(t, rows.Err())
+	rows.Close()
+
+	var res []string
+	for _, table := range tables {
+		rows, err := store.db.QueryContext(ctx, "SELECT * FROM "+table)
+		require.NoError(t, err)
+		columns, err := rows.Columns()
+		require.NoError(t, err)
+		values := make([]any, len(columns))
+		for i := range values {
+			values[i] = new(any)
+		}
+		found := false
+		for rows.Next() {
+			require.NoError(t, rows.Scan(values...))
+			for _, v := range values {
+				text := fmt.Sprint(*v.(*any))
+				if b, ok := (*v.(*any)).([]byte); ok {
+					text = string(b)
+				}
+				if strings.Contains(strings.ToLower(text), strings.ToLower(needle)) {
+					found = true
+				}
+			}
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+		if found {
+			res = append(res, table)
+		}
+	}
+	return res
+}
+
+// TestAnonymizeClientNotifications - тест для проверки выгрузки недоставленных уведомлений webhook
+// и отсутствия адреса в базе после обезличивания
+func TestAnonymizeClientNotifications(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	const client = 1000
+	const address = "Privet Drive 4"
+	p, err := service.RegisterContext(ctx, client, address)
+	require.NoError(t, err)
+	hook, err := store.AddWebhook(ctx, WebhookSubscription{Client: client, URL: "http://localhost/hook", Secret: "secret"})
+	require.NoError(t, err)
+
+	// Уведомление, ожидающее доставки
+	notifier := NewWebhookNotifier(store, testWebhookConfig())
+	events, err := store.GetEventsAfter(ctx, 0, EventFilter{Parcel: p.Number}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.NoError(t, notifier.Publish(ctx, events[0]))
+	require.NoError(t, notifier.Shutdown(ctx))
+
+	// Недоставленные уведомления: записанное с посылкой и записанное до появления столбцов посылки и клиента
+	payload, err := json.Marshal(events[0])
+	require.NoError(t, err)
+	require.NoError(t, store.addWebhookDeadLetter(ctx, WebhookDeadLetter{Subscription: hook, Parcel: p.Number, Client: client,
+		URL: "http://localhost/hook", EventType: EventRegistered, Payload: string(payload), Attempts: 5, LastError: "503", FailedAt: "2025-06-10T10:00:00Z"}))
+	require.NoError(t, store.addWebhookDeadLetter(ctx, WebhookDeadLetter{Subscription: hook,
+		URL: "http://localhost/hook", EventType: EventRegistered, Payload: string(payload), Attempts: 5, LastError: "503", FailedAt: "2025-06-10T10:00:00Z"}))
+
+	// Выгрузка содержит оба недоставленных уведомления
+	owner := WithIdentity(ctx, Identity{Subject: "shop", Role: RoleClient, Client: client, Credential: "jwt:shop"})
+	export, err := service.ExportClientData(owner, client)
+	require.NoError(t, err)
+	require.Len(t, export.DeadLetters, 2)
+	for _, d := range export.DeadLetters {
+		assert.Equal(t, p.Number, d.Parcel)
+		assert.Equal(t, client, d.Client)
+		assert.Contains(t, d.Payload, address)
+	}
+
+	// После обезличивания слова адреса не хранятся ни в одной таблице, включая сегменты полнотекстового индекса
+	assert.NotEmpty(t, tablesContaining(t, store, "privet"))
+	require.NoError(t, service.NextStatusContext(ctx, p.Number))
+	require.NoError(t, service.NextStatusContext(ctx, p.Number))
+	admin := WithIdentity(ctx, Identity{Subject: "admin", Role: RoleAdmin, Credential: "apikey:1"})
+	_, err = service.AnonymizeClient(admin, client)
+	require.NoError(t, err)
+	assert.Empty(t, tablesContaining(t, store, "privet"))
+
+	letters, err := store.GetWebhookDeadLetters(ctx, hook)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+	var deliveries int
+	require.NoError(t, store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM webhook_delivery").Scan(&deliveries))
+	assert.Zero(t, deliveries)
+}
+
+// TestAPIClientPrivacy - тест для проверки HTTP API выгрузки и обезличивания данных клиента
+func TestAPIClientPrivacy(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	const client = 1000
+	p, err := service.RegisterContext(context.Background(), client, "test")
+	require.NoError(t, err)
+	owner := "Bearer " + testToken(t, RoleClient, client)
+	admin := "Bearer " + testToken(t, RoleAdmin, 0)
+
+	status, body := apiCall(t, http.MethodGet, fmt.Sprintf("%s/clients/%d/export", server.URL, client), "Authorization", owner, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var export ClientExport
+	require.NoError(t, json.Unmarshal(body, &export))
+	assert.Len(t, export.Parcels, 1)
+
+	status, _ = apiCall(t, http.MethodGet, fmt.Sprintf("%s/clients/%d/export", server.URL, client+1), "Authorization", owner, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/clients/0/export", "Authorization", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	anonymizeURL := fmt.Sprintf("%s/clients/%d/anonymize", server.URL, client)
+	status, _ = apiCall(t, http.MethodPost, anonymizeURL, "Authorization", owner, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = apiCall(t, http.MethodPost, anonymizeURL, "Authorization", admin, nil)
+	assert.Equal(t, http.StatusConflict, status)
+
+	require.NoError(t, service.NextStatusContext(context.Background(), p.Number))
+	require.NoError(t, service.NextStatusContext(context.Background(), p.Number))
+	status, body = apiCall(t, http.MethodPost, anonymizeURL, "Authorization", admin, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	assert.JSONEq(t, `{"anonymized_parcels": 1}`, string(body))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited - вызов отклонён ограничением частоты запросов или дневной квотой.
+// Ошибка всегда имеет тип *RateLimitError, из которого можно узнать, когда повторить вызов
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError - отказ по ограничению частоты запросов или квоте
+type RateLimitError struct {
+	Limit      string        // Сработавшее ограничение
+	RetryAfter time.Duration // Через сколько можно повторить вызов
+}
+
+// Error - описание отказа
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s exceeded, retry after %s", e.Limit, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap - позволяет проверять отказ через errors.Is(err, ErrRateLimited)
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// retryAfterSeconds - значение заголовка Retry-After: целое число секунд, не меньше одной
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
+
+// maxRateLimitBuckets - количество корзин, после которого из лимитера удаляются заполненные корзины
+const maxRateLimitBuckets = 10000
+
+// RateLimiter - ограничение частоты запросов по алгоритму token bucket, отдельная корзина на каждый ключ.
+// Нулевой *RateLimiter пропускает все запросы
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Пополнение корзины, токенов в секунду
+	burst   float64 // Ёмкость корзины
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// tokenBucket - корзина токенов одного ключа
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter - конструктор лимитера: rate запросов в секунду в среднем и до burst запросов подряд
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+// Allow - расходует токен корзины key. Если токенов нет, возвращает *RateLimitError
+func (l *RateLimiter) Allow(key string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.evict(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return &RateLimitError{Limit: "rate limit for " + key, RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+// evict - удаляет корзины, которые успели заполниться: для их ключей ограничение не действует
+func (l *RateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allowIdentity - расходует токены корзин учётных данных и клиента вызывающего
+func (l *RateLimiter) allowIdentity(id Identity) error {
+	if err := l.Allow(id.Credential); err != nil {
+		return err
+	}
+	if id.Role == RoleClient {
+		return l.Allow(clientLimitKey(id.Client))
+	}
+	return nil
+}
+
+// clientLimitKey - ключ корзины клиента
+func clientLimitKey(client int) string {
+	return "client:" + strconv.Itoa(client)
+}
+
+// rateLimited - пропускает к h запросы аутентифицированного вызывающего, пока не исчерпаны его корзины
+func (srv *Server) rateLimited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			if err := srv.limiter.allowIdentity(id); err != nil {
+				srv.writeServiceError(w, r, err)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// SetClientQuota - метод для установки дневной квоты регистраций клиента.
+// Отрицательное значение снимает квоту
+func (s ParcelStore) SetClientQuota(ctx context.Context, client, dailyRegistrations int) (err error) {
+	ctx, op := s.begin(ctx, "set_client_quota", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int("daily_registrations", dailyRegistrations))
+	}()
+
+	if dailyRegistrations < 0 {
+		_, err = s.q.ExecContext(ctx, "DELETE FROM client_quota WHERE client = :client", sql.Named("client", client))
+	} else {
+		_, err = s.q.ExecContext(ctx, `INSERT INTO client_quota (client, daily_registrations) VALUES (:client, :limit)
+ON CONFLICT (client) DO UPDATE SET daily_registrations = excluded.daily_registrations`,
+			sql.Named("client", client),
+			sql.Named("limit", dailyRegistrations))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set quota of client %d: error: %w", client, err)
+	}
+	return nil
+}
+
+// GetClientQuota - метод для получения дневной квоты регистраций клиента. Если квоты нет, ok = false
+func (s ParcelStore) GetClientQuota(ctx context.Context, client int) (dailyRegistrations int, ok bool, err error) {
+	ctx, op := s.begin(ctx, "get_client_quota", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client))
+	}()
+
+	err = s.reader().QueryRowContext(ctx, "SELECT daily_registrations FROM client_quota WHERE client = :client", sql.Named("client", client)).
+		Scan(&dailyRegistrations)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to retrieve quota of client %d: error: %w", client, err)
+	}
+	return dailyRegistrations, true, nil
+}
+
+// countRegistration - учитывает регистрацию посылки клиента за день day и возвращает количество регистраций за этот день.
+// Регистрации учитываются только для клиентов с квотой, для остальных limited = false
+func (s ParcelStore) countRegistration(ctx context.Context, client int, day string) (count int, limited bool, err error) {
+	ctx, op := s.begin(ctx, "count_registration", "INSERT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, count))
+	}()
+
+	// Запись выполняется первой, чтобы транзакция регистрации сразу заняла блокировку
+	err = s.q.QueryRowContext(ctx, `INSERT INTO registration_count (client, day, registrations)
+SELECT client, :day, 1 FROM client_quota WHERE client = :client
+ON CONFLICT (client, day) DO UPDATE SET registrations = registration_count.registrations + 1
+RETURNING registrations`,
+		sql.Named("client", client),
+		sql.Named("day", day)).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to count registration of client %d: error: %w", client, err)
+	}
+	return count, true, nil
+}
+
+// checkDailyQuota - учитывает регистрацию в транзакции tx и проверяет дневную квоту клиента.
+// При превышении квоты возвращает *RateLimitError до начала следующих суток (UTC), транзакция должна быть отменена
+func checkDailyQuota(ctx context.Context, tx ParcelStore, client int, now time.Time) error {
+	now = now.UTC()
+	count, limited, err := tx.countRegistration(ctx, client, now.Format(time.DateOnly))
+	if err != nil || !limited {
+		return err
+	}
+
+	limit, _, err := tx.GetClientQuota(ctx, client)
+	if err != nil {
+		return err
+	}
+	if count > limit {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return &RateLimitError{
+			Limit:      fmt.Sprintf("daily registration quota of client %d (%d)", client, limit),
+			RetryAfter: tomorrow.Sub(now),
+		}
+	}
+	return nil
+}
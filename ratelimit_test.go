@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/trackerpb"
+)
+
+// TestRateLimiter - тест для проверки расхода и пополнения корзины токенов
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	// Запросы подряд расходуют ёмкость корзины
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Allow("a"), "request %d", i)
+	}
+	err := limiter.Allow("a")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	var limited *RateLimitError
+	require.True(t, errors.As(err, &limited))
+	assert.Equal(t, 500*time.Millisecond, limited.RetryAfter)
+
+	// Корзины ключей независимы
+	require.NoError(t, limiter.Allow("b"))
+
+	// Через полсекунды появляется один токен
+	now = now.Add(500 * time.Millisecond)
+	require.NoError(t, limiter.Allow("a"))
+	require.ErrorIs(t, limiter.Allow("a"), ErrRateLimited)
+
+	// Нулевой лимитер пропускает все запросы
+	var unlimited *RateLimiter
+	require.NoError(t, unlimited.Allow("a"))
+}
+
+// TestDailyRegistrationQuota - тест для проверки дневной квоты регистраций клиента
+func TestDailyRegistrationQuota(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+	client := int(time.Now().UnixNano() % 1000000)
+
+	require.NoError(t, store.SetClientQuota(ctx, client, 2))
+	limit, ok, err := store.GetClientQuota(ctx, client)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 2, limit)
+
+	key := fmt.Sprintf("order-%d", client)
+	_, _, err = service.RegisterIdempotent(ctx, key, client, "test")
+	require.NoError(t, err)
+	_, err = service.RegisterContext(ctx, client, "test")
+	require.NoError(t, err)
+
+	// Повтор по ключу идемпотентности не расходует квоту
+	_, replayed, err := service.RegisterIdempotent(ctx, key, client, "test")
+	require.NoError(t, err)
+	assert.True(t, replayed)
+
+	// Третья регистрация за день отклоняется до следующих суток
+	_, err = service.RegisterContext(ctx, client, "test")
+	require.ErrorIs(t, err, ErrRateLimited)
+	var limited *RateLimitError
+	require.True(t, errors.As(err, &limited))
+	assert.Greater(t, limited.RetryAfter, time.Duration(0))
+	assert.LessOrEqual(t, limited.RetryAfter, 24*time.Hour)
+
+	parcels, err := store.GetByClient(client)
+	require.NoError(t, err)
+	assert.Len(t, parcels, 2)
+
+	// Без квоты регистрации не ограничены
+	require.NoError(t, store.SetClientQuota(ctx, client, -1))
+	_, ok, err = store.GetClientQuota(ctx, client)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = service.RegisterContext(ctx, client, "test")
+	require.NoError(t, err)
+}
+
+// TestAPIRateLimit - тест для проверки ответа 429 с заголовком Retry-After
+func TestAPIRateLimit(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	limiter := NewRateLimiter(0.001, 2)
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), limiter).Handler())
+	defer server.Close()
+
+	token := testToken(t, RoleClient, 1000)
+	get := func(token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/parcels?client=1000", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusOK, get(token).StatusCode)
+	}
+	resp := get(token)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	// Другие учётные данные того же клиента расходуют ту же корзину клиента
+	other, err := SignJWT(testJWTKey, JWTClaims{Subject: "other", Role: RoleClient, Client: 1000, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, get(other).StatusCode)
+
+	// Оператор ограничен только своей корзиной
+	assert.Equal(t, http.StatusOK, get(testToken(t, RoleOperator, 0)).StatusCode)
+}
+
+// TestAPIClientQuota - тест для проверки установки квоты администратором и отказа 429 при её превышении
+func TestAPIClientQuota(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	client := int(time.Now().UnixNano()%1000000) + 1
+	quotaURL := fmt.Sprintf("%s/clients/%d/quota", server.URL, client)
+	owner := "Bearer " + testToken(t, RoleClient, client)
+
+	// Квоту устанавливает только администратор
+	status, _ := apiCall(t, http.MethodPut, quotaURL, "Authorization", owner, map[string]int{"daily_registrations": 1})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, body := apiCall(t, http.MethodPut, quotaURL, "Authorization", "Bearer "+testToken(t, RoleAdmin, 0), map[string]int{"daily_registrations": 1})
+	require.Equal(t, http.StatusNoContent, status, string(body))
+
+	status, _ = apiCall(t, http.MethodPost, server.URL+"/parcels", "Authorization", owner, registerRequest{Address: "test"})
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = apiCall(t, http.MethodPost, server.URL+"/parcels", "Authorization", owner, registerRequest{Address: "test"})
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
+
+// TestGRPCRateLimit - тест для проверки кода ResourceExhausted с RetryInfo в gRPC и корзин вызывающего
+func TestGRPCRateLimit(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	service := NewParcelService(NewParcelStore(db), WithOutput(io.Discard))
+	lis := startGRPCServer(t, service, NewEventBroker(), NewRateLimiter(0.001, 1))
+	ctx := context.Background()
+
+	operator := dialGRPC(t, lis, bearer(t, RoleOperator, 0))
+	parcel, err := operator.RegisterParcel(ctx, &trackerpb.RegisterParcelRequest{Client: 1000, Address: "test"})
+	require.NoError(t, err)
+	// другой клиент в теле запроса не даёт новой корзины
+	_, err = operator.RegisterParcel(ctx, &trackerpb.RegisterParcelRequest{Client: 2000, Address: "test"})
+	assertCode(t, codes.ResourceExhausted, err)
+
+	var retry *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			retry = info
+		}
+	}
+	require.NotNil(t, retry)
+	assert.Greater(t, retry.GetRetryDelay().AsDuration(), time.Duration(0))
+
+	// Ограничение действует на все вызовы, в том числе без клиента в запросе, и на потоки
+	_, err = operator.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: parcel.GetNumber()})
+	assertCode(t, codes.ResourceExhausted, err)
+	_, err = operator.DeleteParcel(ctx, &trackerpb.DeleteParcelRequest{Number: parcel.GetNumber()})
+	assertCode(t, codes.ResourceExhausted, err)
+	stream, err := operator.ListClientParcels(ctx, &trackerpb.ListClientParcelsRequest{Client: 1000})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assertCode(t, codes.ResourceExhausted, err)
+
+	// Корзины других учётных данных не затронуты, но корзина клиента общая для всех его учётных данных
+	owner := dialGRPC(t, lis, bearer(t, RoleClient, 1000))
+	_, err = owner.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: parcel.GetNumber()})
+	require.NoError(t, err)
+	key := "trk_grpc_limit_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err = service.store.AddAPIKey(ctx, APIKey{Name: "limit", Role: RoleClient, Client: 1000}, key)
+	require.NoError(t, err)
+	_, err = dialGRPC(t, lis, grpcCredentials{"x-api-key": key}).GetParcel(ctx, &trackerpb.GetParcelRequest{Number: parcel.GetNumber()})
+	assertCode(t, codes.ResourceExhausted, err)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+)
+
+const (
+	// reportDateFormat - формат дат периода отчёта и дней ряда регистраций
+	reportDateFormat = "2006-01-02"
+	// defaultReportDays - длина периода отчёта, если начало не задано
+	defaultReportDays = 30
+	// maxReportDays - наибольшая длина периода отчёта: ряд регистраций содержит строку на каждый день
+	maxReportDays = 366 * 5
+)
+
+// Форматы вывода отчёта
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+)
+
+// ErrInvalidReportRange - некорректный период отчёта
+var ErrInvalidReportRange = errors.New("invalid report range")
+
+// ReportFilter - период и клиент отчёта. Период включает From и не включает To
+type ReportFilter struct {
+	From   time.Time
+	To     time.Time
+	Client int // 0 - все клиенты
+}
+
+// Report - статистика посылок за период
+type Report struct {
+	From         string         `json:"from"`
+	To           string         `json:"to"`
+	Client       int            `json:"client,omitempty"`
+	StatusCounts map[string]int `json:"status_counts"` // Текущие статусы посылок, зарегистрированных за период
+	Clients      []ClientVolume `json:"clients"`       // Объёмы клиентов по убыванию количества регистраций
+	Daily        []DailyCount   `json:"daily"`         // Регистрации по дням (UTC), включая дни без регистраций
+	LeadTime     LeadTimeStats  `json:"lead_time"`     // Рабочее время от регистрации до доставки посылок, доставленных за период
+}
+
+// ClientVolume - количество посылок клиента, зарегистрированных за период
+type ClientVolume struct {
+	Client     int `json:"client"`
+	Registered int `json:"registered"`
+	Delivered  int `json:"delivered"` // Из зарегистрированных за период уже доставлено
+}
+
+// DailyCount - количество регистраций за день
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// LeadTimeStats - рабочее время от регистрации до доставки в секундах по производственному календарю: среднее и процентили
+type LeadTimeStats struct {
+	Count   int   `json:"count"`
+	Average int64 `json:"avg_seconds"`
+	P50     int64 `json:"p50_seconds"`
+	P90     int64 `json:"p90_seconds"`
+	P95     int64 `json:"p95_seconds"`
+	P99     int64 `json:"p99_seconds"`
+}
+
+// ParseReportFilter - период отчёта из дат from и to в формате 2006-01-02 или RFC3339.
+// Дата без времени означает начало дня UTC, to не входит в период. Без to период заканчивается
+// началом завтрашнего дня, без from - начинается за defaultReportDays дней до конца
+func ParseReportFilter(from, to string, client int, now time.Time) (ReportFilter, error) {
+	f := ReportFilter{Client: client, To: now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)}
+	var err error
+	if to != "" {
+		if f.To, err = parseReportTime(to); err != nil {
+			return f, err
+		}
+	}
+	f.From = f.To.AddDate(0, 0, -defaultReportDays)
+	if from != "" {
+		if f.From, err = parseReportTime(from); err != nil {
+			return f, err
+		}
+	}
+	return f, f.validate()
+}
+
+// parseReportTime - время в формате 2006-01-02 или RFC3339 в UTC
+func parseReportTime(v string) (time.Time, error) {
+	t, err := time.Parse(reportDateFormat, v)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return t, fmt.Errorf("time %q must be 2006-01-02 or RFC3339: %w", v, ErrInvalidReportRange)
+		}
+	}
+	return t.UTC(), nil
+}
+
+// validate - проверяет, что период не пустой и не длиннее maxReportDays
+func (f ReportFilter) validate() error {
+	if !f.From.Before(f.To) {
+		return fmt.Errorf("report start %s must be before end %s: %w", f.From.Format(time.RFC3339), f.To.Format(time.RFC3339), ErrInvalidReportRange)
+	}
+	if f.To.Sub(f.From) > maxReportDays*24*time.Hour {
+		return fmt.Errorf("report range must not exceed %d days: %w", maxReportDays, ErrInvalidReportRange)
+	}
+	if f.Client < 0 {
+		return fmt.Errorf("invalid client id %d: %w", f.Client, ErrInvalidReportRange)
+	}
+	return nil
+}
+
+// args - именованные параметры :from, :to и :client для запросов отчёта
+func (f ReportFilter) args() []any {
+	return []any{
+		sql.Named("from", f.From.UTC().Format(time.RFC3339)),
+		sql.Named("to", f.To.UTC().Format(time.RFC3339)),
+		sql.Named("client", f.Client),
+	}
+}
+
+// ReportStatusCounts - метод для подсчёта посылок, зарегистрированных за период, по текущему статусу
+func (s ParcelStore) ReportStatusCounts(ctx context.Context, f ReportFilter) (res map[string]int, err error) {
+	ctx, op := s.begin(ctx, "report_status_counts", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, f.Client))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT status, COUNT(*) FROM parcel WHERE created_at >= :from AND created_at < :to AND (:client = 0 OR client = :client) GROUP BY status",
+		f.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count parcels by status for report: error: %w", err)
+	}
+	defer rows.Close()
+
+	res = map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err = rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("row scanning error while counting parcels by status for report: error: %w", err)
+		}
+		res[status] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while counting parcels by status for report: %w", err)
+	}
+	return res, nil
+}
+
+// ReportClientVolumes - метод для подсчёта посылок клиентов, зарегистрированных за период,
+// по убыванию количества регистраций
+func (s ParcelStore) ReportClientVolumes(ctx context.Context, f ReportFilter) (res []ClientVolume, err error) {
+	ctx, op := s.begin(ctx, "report_client_volumes", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, f.Client), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT client, COUNT(*), SUM(CASE WHEN status = :delivered THEN 1 ELSE 0 END) FROM parcel WHERE created_at >= :from AND created_at < :to AND (:client = 0 OR client = :client) GROUP BY client ORDER BY COUNT(*) DESC, client",
+		append(f.args(), sql.Named("delivered", ParcelStatusDelivered))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count parcels by client for report: error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v ClientVolume
+		if err = rows.Scan(&v.Client, &v.Registered, &v.Delivered); err != nil {
+			return nil, fmt.Errorf("row scanning error while counting parcels by client for report: error: %w", err)
+		}
+		res = append(res, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while counting parcels by client for report: %w", err)
+	}
+	return res, nil
+}
+
+// ReportDailyRegistrations - метод для подсчёта регистраций по дням UTC. Дни без регистраций не возвращаются
+func (s ParcelStore) ReportDailyRegistrations(ctx context.Context, f ReportFilter) (res []DailyCount, err error) {
+	ctx, op := s.begin(ctx, "report_daily_registrations", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, f.Client), slog.Int(attrCount, len(res)))
+	}()
+
+	// время регистрации хранится в RFC3339 UTC, поэтому первые 10 символов - дата
+	rows, err := s.reader().QueryContext(ctx, "SELECT substr(created_at, 1, 10) AS day, COUNT(*) FROM parcel WHERE created_at >= :from AND created_at < :to AND (:client = 0 OR client = :client) GROUP BY day ORDER BY day",
+		f.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count daily registrations for report: error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DailyCount
+		if err = rows.Scan(&d.Date, &d.Count); err != nil {
+			return nil, fmt.Errorf("row scanning error while counting daily registrations for report: error: %w", err)
+		}
+		res = append(res, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while counting daily registrations for report: %w", err)
+	}
+	return res, nil
+}
+
+// ReportLeadTimes - метод для получения рабочего времени по календарю cal от регистрации до доставки посылок,
+// доставленных за период: выходные, праздники и нерабочие часы не учитываются
+func (s ParcelStore) ReportLeadTimes(ctx context.Context, f ReportFilter, cal *calendar.Calendar) (res []time.Duration, err error) {
+	ctx, op := s.begin(ctx, "report_lead_times", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, f.Client), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT created_at, delivered_at FROM parcel WHERE delivered_at >= :from AND delivered_at < :to AND (:client = 0 OR client = :client)",
+		f.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve delivery times for report: error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var createdAt, deliveredAt string
+		if err = rows.Scan(&createdAt, &deliveredAt); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving delivery times for report: error: %w", err)
+		}
+		created, err := time.Parse(time.RFC3339, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parcel creation time %q: %w", createdAt, err)
+		}
+		delivered, err := time.Parse(time.RFC3339, deliveredAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parcel delivery time %q: %w", deliveredAt, err)
+		}
+		res = append(res, cal.WorkingTime(created, delivered))
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving delivery times for report: %w", err)
+	}
+	return res, nil
+}
+
+// leadTimeStats - среднее и процентили времени доставки по методу ближайшего ранга
+func leadTimeStats(durations []time.Duration) LeadTimeStats {
+	stats := LeadTimeStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	percentile := func(p int) int64 {
+		// ранг - наименьший номер, не меньше p% значений
+		rank := (p*len(sorted) + 99) / 100
+		return int64(sorted[max(rank, 1)-1].Seconds())
+	}
+
+	stats.Average = int64((total / time.Duration(len(sorted))).Seconds())
+	stats.P50 = percentile(50)
+	stats.P90 = percentile(90)
+	stats.P95 = percentile(95)
+	stats.P99 = percentile(99)
+	return stats
+}
+
+// fillDays - ряд регистраций с нулями для дней периода без регистраций
+func fillDays(f ReportFilter, counts []DailyCount) []DailyCount {
+	byDate := make(map[string]int, len(counts))
+	for _, d := range counts {
+		byDate[d.Date] = d.Count
+	}
+
+	res := []DailyCount{}
+	for day := f.From.UTC().Truncate(24 * time.Hour); day.Before(f.To); day = day.AddDate(0, 0, 1) {
+		date := day.Format(reportDateFormat)
+		res = append(res, DailyCount{Date: date, Count: byDate[date]})
+	}
+	return res
+}
+
+// BuildReport - составляет отчёт по посылкам за период из хранилища store. Время доставки считается
+// по календарю cal, nil - календарь по умолчанию в UTC
+func BuildReport(ctx context.Context, store ParcelStore, f ReportFilter, cal *calendar.Calendar) (Report, error) {
+	if err := f.validate(); err != nil {
+		return Report{}, err
+	}
+	if cal == nil {
+		cal = calendar.New(time.UTC)
+	}
+
+	report := Report{From: f.From.UTC().Format(time.RFC3339), To: f.To.UTC().Format(time.RFC3339), Client: f.Client}
+	var err error
+	if report.StatusCounts, err = store.ReportStatusCounts(ctx, f); err != nil {
+		return Report{}, err
+	}
+	if report.Clients, err = store.ReportClientVolumes(ctx, f); err != nil {
+		return Report{}, err
+	}
+	if report.Clients == nil {
+		report.Clients = []ClientVolume{}
+	}
+	daily, err := store.ReportDailyRegistrations(ctx, f)
+	if err != nil {
+		return Report{}, err
+	}
+	report.Daily = fillDays(f, daily)
+	leadTimes, err := store.ReportLeadTimes(ctx, f, cal)
+	if err != nil {
+		return Report{}, err
+	}
+	report.LeadTime = leadTimeStats(leadTimes)
+	return report, nil
+}
+
+// Report - составляет отчёт по посылкам за период. Клиент получает отчёт только по своим посылкам.
+// Время доставки считается по календарю сроков доставки
+func (s ParcelService) Report(ctx context.Context, f ReportFilter) (report Report, err error) {
+	ctx, op := s.begin(ctx, "report")
+	defer func() {
+		op.end(err, slog.Int(attrClient, f.Client))
+	}()
+
+	if id, ok := IdentityFromContext(ctx); ok && id.Role == RoleClient {
+		if f.Client != 0 && f.Client != id.Client {
+			return Report{}, fmt.Errorf("client %d may not access report of client %d: %w", id.Client, f.Client, ErrForbidden)
+		}
+		f.Client = id.Client
+	}
+	return BuildReport(ctx, s.store, f, s.slaPolicy.Calendar)
+}
+
+// DailyReportJob - задача планировщика, сохраняющая отчёт за прошедшие сутки (UTC) по всем клиентам
+// в каталог dir в файл report-<дата>.json. Время доставки считается по календарю cal
+func DailyReportJob(store ParcelStore, cal *calendar.Calendar, dir string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		f := ReportFilter{From: today.AddDate(0, 0, -1), To: today}
+		report, err := BuildReport(ctx, store, f, cal)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("failed to create report dir: error: %w", err)
+		}
+		path := filepath.Join(dir, "report-"+f.From.Format(reportDateFormat)+".json")
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: error: %w", err)
+		}
+		if err := report.Write(file, ReportFormatJSON); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to save report file: error: %w", err)
+		}
+		return nil
+	}
+}
+
+// Write - записывает отчёт в формате format: json или csv
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case ReportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("failed to write report: error: %w", err)
+		}
+		return nil
+	case ReportFormatCSV:
+		return r.writeCSV(w)
+	default:
+		return fmt.Errorf("unknown report format %q, expected json or csv", format)
+	}
+}
+
+// writeCSV - записывает отчёт таблицей CSV со столбцами metric, key, value: по строке на каждое значение
+func (r Report) writeCSV(w io.Writer) error {
+	records := [][]string{
+		{"metric", "key", "value"},
+		{"from", "", r.From},
+		{"to", "", r.To},
+	}
+	for _, status := range slices.Sorted(maps.Keys(r.StatusCounts)) {
+		records = append(records, []string{"status_count", status, strconv.Itoa(r.StatusCounts[status])})
+	}
+	for _, v := range r.Clients {
+		client := strconv.Itoa(v.Client)
+		records = append(records,
+			[]string{"client_registered", client, strconv.Itoa(v.Registered)},
+			[]string{"client_delivered", client, strconv.Itoa(v.Delivered)})
+	}
+	for _, d := range r.Daily {
+		records = append(records, []string{"daily_registrations", d.Date, strconv.Itoa(d.Count)})
+	}
+	lt := r.LeadTime
+	records = append(records,
+		[]string{"lead_time_count", "", strconv.Itoa(lt.Count)},
+		[]string{"lead_time_avg_seconds", "", strconv.FormatInt(lt.Average, 10)},
+		[]string{"lead_time_p50_seconds", "", strconv.FormatInt(lt.P50, 10)},
+		[]string{"lead_time_p90_seconds", "", strconv.FormatInt(lt.P90, 10)},
+		[]string{"lead_time_p95_seconds", "", strconv.FormatInt(lt.P95, 10)},
+		[]string{"lead_time_p99_seconds", "", strconv.FormatInt(lt.P99, 10)})
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write report: error: %w", err)
+	}
+	return nil
+}
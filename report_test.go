@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuildReport - тест для проверки статистики посылок за период
+func TestBuildReport(t *testing.T) {
+	store := openTestStore(t)
+	ctx := context.Background()
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// add - добавляет посылку клиента, зарегистрированную в created и доставленную через leadTime (0 - не доставлена)
+	add := func(client int, created time.Time, leadTime time.Duration) {
+		p := getTestParcel()
+		p.Client = client
+		p.CreatedAt = created.Format(time.RFC3339)
+		number, err := store.AddContext(ctx, p)
+		require.NoError(t, err)
+		if leadTime > 0 {
+			_, err = store.db.ExecContext(ctx, "UPDATE parcel SET status = ?, delivered_at = ? WHERE number = ?",
+				ParcelStatusDelivered, created.Add(leadTime).Format(time.RFC3339), number)
+			require.NoError(t, err)
+		}
+	}
+	add(1000, day.Add(time.Hour), 24*time.Hour)
+	add(1000, day.Add(2*time.Hour), 48*time.Hour)
+	add(1000, day.AddDate(0, 0, 2), 0)
+	add(2000, day.AddDate(0, 0, 2).Add(time.Hour), 96*time.Hour)
+	// вне периода
+	add(1000, day.AddDate(0, 0, -1), 24*time.Hour)
+	add(3000, day.AddDate(0, 0, 3), 0)
+
+	filter := ReportFilter{From: day, To: day.AddDate(0, 0, 3)}
+	report, err := BuildReport(ctx, store, filter, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2025-03-01T00:00:00Z", report.From)
+	assert.Equal(t, map[string]int{ParcelStatusDelivered: 3, ParcelStatusRegistered: 1}, report.StatusCounts)
+	assert.Equal(t, []ClientVolume{{Client: 1000, Registered: 3, Delivered: 2}, {Client: 2000, Registered: 1, Delivered: 1}}, report.Clients)
+	assert.Equal(t, []DailyCount{{"2025-03-01", 2}, {"2025-03-02", 0}, {"2025-03-03", 2}}, report.Daily)
+	// посылка, зарегистрированная до периода, доставлена в период; посылка клиента 2000 доставлена после него.
+	// 1 и 2 марта 2025 года - выходные: из суток и двух суток доставки рабочими остаются 0 и 2 часа понедельника
+	hour := int64(time.Hour.Seconds())
+	assert.Equal(t, LeadTimeStats{Count: 3, Average: 26 * hour / 3, P50: 2 * hour, P90: 24 * hour, P95: 24 * hour, P99: 24 * hour}, report.LeadTime)
+
+	// Отчёт по одному клиенту
+	filter.Client = 2000
+	report, err = BuildReport(ctx, store, filter, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{ParcelStatusDelivered: 1}, report.StatusCounts)
+	assert.Equal(t, []ClientVolume{{Client: 2000, Registered: 1, Delivered: 1}}, report.Clients)
+	assert.Zero(t, report.LeadTime.Count)
+
+	// Клиент не может получить отчёт по другому клиенту
+	service := NewParcelService(store, WithOutput(io.Discard))
+	owner := WithIdentity(ctx, Identity{Role: RoleClient, Client: 1000})
+	_, err = service.Report(owner, filter)
+	require.ErrorIs(t, err, ErrForbidden)
+	filter.Client = 0
+	report, err = service.Report(owner, filter)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, report.Client)
+	assert.Len(t, report.Clients, 1)
+}
+
+// TestReportLeadTimesCalendar - тест для проверки времени доставки в рабочих часах производственного календаря
+func TestReportLeadTimesCalendar(t *testing.T) {
+	store := openTestStore(t)
+	ctx := context.Background()
+	cal := testCalendar(t)
+	moscow := cal.Location()
+
+	// add - добавляет посылку, зарегистрированную в created и доставленную в delivered по Москве
+	add := func(created, delivered time.Time) {
+		p := getTestParcel()
+		p.CreatedAt = created.UTC().Format(time.RFC3339)
+		number, err := store.AddContext(ctx, p)
+		require.NoError(t, err)
+		_, err = store.db.ExecContext(ctx, "UPDATE parcel SET status = ?, delivered_at = ? WHERE number = ?",
+			ParcelStatusDelivered, delivered.UTC().Format(time.RFC3339), number)
+		require.NoError(t, err)
+	}
+	// через праздник 12 июня и выходные: час среды, 9 часов пятницы и час понедельника
+	add(time.Date(2025, 6, 11, 17, 0, 0, 0, moscow), time.Date(2025, 6, 16, 10, 0, 0, 0, moscow))
+	// в нерабочее время: от вечера пятницы до утра понедельника
+	add(time.Date(2025, 6, 6, 19, 0, 0, 0, moscow), time.Date(2025, 6, 9, 8, 30, 0, 0, moscow))
+	// в течение рабочего дня
+	add(time.Date(2025, 6, 17, 10, 0, 0, 0, moscow), time.Date(2025, 6, 17, 13, 30, 0, 0, moscow))
+
+	filter := ReportFilter{From: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)}
+	leadTimes, err := store.ReportLeadTimes(ctx, filter, cal)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []time.Duration{11 * time.Hour, 0, 3*time.Hour + 30*time.Minute}, leadTimes)
+
+	// отчёт сервиса считается по календарю сроков доставки
+	policy := DefaultSLAPolicy()
+	policy.Calendar = cal
+	service := NewParcelService(store, WithOutput(io.Discard), WithSLAPolicy(policy))
+	report, err := service.Report(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.LeadTime.Count)
+	assert.Equal(t, int64((11 * time.Hour).Seconds()), report.LeadTime.P99)
+}
+
+// TestLeadTimeStats - тест для проверки процентилей времени доставки
+func TestLeadTimeStats(t *testing.T) {
+	hours := func(values ...int) []time.Duration {
+		res := make([]time.Duration, len(values))
+		for i, v := range values {
+			res[i] = time.Duration(v) * time.Hour
+		}
+		return res
+	}
+	h := int64(time.Hour.Seconds())
+
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      LeadTimeStats
+	}{
+		{name: "empty", want: LeadTimeStats{}},
+		{name: "single", durations: hours(5), want: LeadTimeStats{Count: 1, Average: 5 * h, P50: 5 * h, P90: 5 * h, P95: 5 * h, P99: 5 * h}},
+		{
+			name:      "unsorted",
+			durations: hours(10, 1, 9, 2, 8, 3, 7, 4, 6, 5),
+			want:      LeadTimeStats{Count: 10, Average: 5*h + h/2, P50: 5 * h, P90: 9 * h, P95: 10 * h, P99: 10 * h},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, leadTimeStats(tt.durations))
+		})
+	}
+}
+
+// TestParseReportFilter - тест для проверки разбора периода отчёта
+func TestParseReportFilter(t *testing.T) {
+	now := time.Date(2025, 3, 15, 12, 30, 0, 0, time.UTC)
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return d
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     ReportFilter
+		wantErr  bool
+	}{
+		{name: "default", want: ReportFilter{From: date("2025-02-14T00:00:00Z"), To: date("2025-03-16T00:00:00Z")}},
+		{name: "dates", from: "2025-02-01", to: "2025-03-01", want: ReportFilter{From: date("2025-02-01T00:00:00Z"), To: date("2025-03-01T00:00:00Z")}},
+		{name: "rfc3339", from: "2025-03-01T10:00:00+03:00", want: ReportFilter{From: date("2025-03-01T07:00:00Z"), To: date("2025-03-16T00:00:00Z")}},
+		{name: "invalid date", from: "01.02.2025", wantErr: true},
+		{name: "empty range", from: "2025-03-01", to: "2025-03-01", wantErr: true},
+		{name: "too long", from: "2000-01-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReportFilter(tt.from, tt.to, 0, now)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidReportRange)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestAPIReport - тест для проверки отчёта через HTTP API в форматах JSON и CSV
+func TestAPIReport(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	for _, client := range []int{1000, 1000, 2000} {
+		_, err := service.RegisterContext(context.Background(), client, "test")
+		require.NoError(t, err)
+	}
+	operator := "Bearer " + testToken(t, RoleOperator, 0)
+	owner := "Bearer " + testToken(t, RoleClient, 2000)
+
+	status, body := apiCall(t, http.MethodGet, server.URL+"/reports", "Authorization", operator, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var report Report
+	require.NoError(t, json.Unmarshal(body, &report))
+	assert.Equal(t, map[string]int{ParcelStatusRegistered: 3}, report.StatusCounts)
+	assert.Len(t, report.Daily, defaultReportDays)
+
+	status, body = apiCall(t, http.MethodGet, server.URL+"/reports?format=csv", "Authorization", owner, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"metric", "key", "value"}, records[0])
+	assert.Contains(t, records, []string{"status_count", ParcelStatusRegistered, "1"})
+	assert.Contains(t, records, []string{"client_registered", "2000", "1"})
+
+	for url, want := range map[string]int{
+		"/reports?client=1000":    http.StatusForbidden,
+		"/reports?from=yesterday": http.StatusBadRequest,
+		"/reports?format=xml":     http.StatusBadRequest,
+	} {
+		status, _ := apiCall(t, http.MethodGet, server.URL+url, "Authorization", owner, nil)
+		assert.Equal(t, want, status, url)
+	}
+}
+
+// TestDailyReportJob - тест для проверки сохранения отчёта за прошедшие сутки задачей планировщика
+func TestDailyReportJob(t *testing.T) {
+	store := openTestStore(t)
+	p := getTestParcel()
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	p.CreatedAt = yesterday.Format(time.RFC3339)
+	_, err := store.AddContext(context.Background(), p)
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "reports")
+	require.NoError(t, DailyReportJob(store, nil, dir)(context.Background()))
+
+	data, err := os.ReadFile(filepath.Join(dir, "report-"+yesterday.Format(reportDateFormat)+".json"))
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, map[string]int{ParcelStatusRegistered: 1}, report.StatusCounts)
+	assert.Len(t, report.Daily, 1)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// anonymizedAddress - адрес посылки после обезличивания
+	anonymizedAddress = ""
+	// anonymousClient - клиент посылки после обезличивания всех данных клиента
+	anonymousClient = 0
+)
+
+// Действия правил хранения данных
+const (
+	RetentionAnonymize = "anonymize"
+	RetentionPurge     = "purge"
+)
+
+// RetentionPolicy - правила хранения доставленных посылок. Нулевой срок отключает правило
+type RetentionPolicy struct {
+	AnonymizeAfter time.Duration // Через сколько после доставки стирается адрес
+	PurgeAfter     time.Duration // Через сколько после доставки посылка удаляется вместе с событиями
+	BatchSize      int           // Сколько посылок обрабатывается в одной транзакции
+}
+
+// RetentionReport - отчёт о применении правил хранения
+type RetentionReport struct {
+	DryRun     bool                  `json:"dry_run"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at"`
+	Rules      []RetentionRuleReport `json:"rules"`
+}
+
+// RetentionRuleReport - посылки, затронутые одним правилом хранения
+type RetentionRuleReport struct {
+	Action  string    `json:"action"`  // anonymize или purge
+	Before  time.Time `json:"before"`  // Правило применено к посылкам, доставленным раньше этого времени
+	Count   int       `json:"count"`   // Количество затронутых посылок
+	Batches int       `json:"batches"` // Количество обработанных пакетов
+	Parcels []int     `json:"parcels"` // Номера затронутых посылок
+}
+
+// WriteJSON - записывает отчёт одной строкой JSON
+func (r RetentionReport) WriteJSON(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		return fmt.Errorf("failed to write retention report: error: %w", err)
+	}
+	return nil
+}
+
+// ApplyRetention - применяет правила хранения к доставленным посылкам на момент now и возвращает отчёт.
+// Сначала удаляются посылки старше PurgeAfter, затем у оставшихся посылок старше AnonymizeAfter стирается адрес,
+// в том числе в событиях outbox. Посылки, подлежащие удалению, не обезличиваются, поэтому отчёт пробного запуска
+// совпадает с отчётом настоящего. Посылки обрабатываются пакетами по BatchSize, каждый пакет - в отдельной транзакции,
+// поэтому запись других запросов блокируется ненадолго. При dryRun отчёт составляется без изменения данных
+func (s ParcelStore) ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time, dryRun bool) (report RetentionReport, err error) {
+	report = RetentionReport{DryRun: dryRun, StartedAt: now.UTC()}
+	if policy.BatchSize <= 0 {
+		return report, fmt.Errorf("invalid retention batch size %d", policy.BatchSize)
+	}
+
+	// since - начало периода доставки, к которому применяется правило: обезличиваются только посылки,
+	// доставленные не раньше срока удаления
+	var purgeBefore time.Time
+	if policy.PurgeAfter > 0 {
+		purgeBefore = now.Add(-policy.PurgeAfter).UTC()
+	}
+	rules := []struct {
+		action string
+		after  time.Duration
+		since  time.Time
+	}{
+		{RetentionPurge, policy.PurgeAfter, time.Time{}},
+		{RetentionAnonymize, policy.AnonymizeAfter, purgeBefore},
+	}
+	changed := 0
+	for _, rule := range rules {
+		if rule.after <= 0 {
+			continue
+		}
+		r, err := s.applyRetentionRule(ctx, rule.action, rule.since, now.Add(-rule.after).UTC(), policy.BatchSize, dryRun)
+		report.Rules = append(report.Rules, r)
+		if err != nil {
+			return report, err
+		}
+		changed += r.Count
+	}
+
+	// стёртые и удалённые адреса остаются в сегментах полнотекстового индекса до их объединения
+	if changed > 0 && !dryRun {
+		if err := s.compactSearchIndex(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// applyRetentionRule - применяет действие action к посылкам, доставленным раньше before и не раньше since
+// (нулевое since - без ограничения), пакетами по batchSize
+func (s ParcelStore) applyRetentionRule(ctx context.Context, action string, since, before time.Time, batchSize int, dryRun bool) (RetentionRuleReport, error) {
+	report := RetentionRuleReport{Action: action, Before: before, Parcels: []int{}}
+
+	// номера посылок растут, поэтому пакеты выбираются после последнего обработанного номера:
+	// при dryRun посылки остаются подходящими под правило, но повторно не выбираются
+	after := 0
+	for {
+		var batch []int
+		err := s.InTx(ctx, func(tx ParcelStore) error {
+			var err error
+			batch, err = tx.retentionCandidates(ctx, action, since, before, after, batchSize)
+			if err != nil || len(batch) == 0 || dryRun {
+				return err
+			}
+			if action == RetentionPurge {
+				return tx.purgeParcels(ctx, batch)
+			}
+			return tx.anonymizeParcels(ctx, batch, time.Now(), false)
+		})
+		if err != nil {
+			return report, err
+		}
+		if len(batch) == 0 {
+			return report, nil
+		}
+
+		report.Batches++
+		report.Count += len(batch)
+		report.Parcels = append(report.Parcels, batch...)
+		after = batch[len(batch)-1]
+		if len(batch) < batchSize {
+			return report, nil
+		}
+	}
+}
+
+// retentionCandidates - номера посылок, доставленных раньше before и не раньше since (нулевое since - без ограничения),
+// к которым применимо действие action
+func (s ParcelStore) retentionCandidates(ctx context.Context, action string, since, before time.Time, after, limit int) (res []int, err error) {
+	ctx, op := s.begin(ctx, "retention_candidates", "SELECT")
+	defer func() {
+		op.end(err, slog.String("action", action), slog.Int(attrCount, len(res)))
+	}()
+
+	query := "SELECT number FROM parcel WHERE delivered_at < :before AND number > :after"
+	if action == RetentionAnonymize {
+		query += " AND anonymized_at IS NULL"
+	}
+	if !since.IsZero() {
+		query += " AND delivered_at >= :since"
+	}
+	rows, err := s.reader().QueryContext(ctx, query+" ORDER BY number LIMIT :limit",
+		sql.Named("since", since.Format(time.RFC3339)),
+		sql.Named("before", before.Format(time.RFC3339)),
+		sql.Named("after", after),
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to select parcels to %s: error: %w", action, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			return nil, fmt.Errorf("row scanning error while selecting parcels to %s: error: %w", action, err)
+		}
+		res = append(res, number)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while selecting parcels to %s: %w", action, err)
+	}
+	return res, nil
+}
+
+// anonymizeParcels - стирает адрес посылок numbers в таблице parcel и в событиях outbox,
+// а при unlinkClient заменяет и клиента на anonymousClient. Статус и время событий сохраняются для статистики.
+// Уведомления webhook о посылках, ожидающие доставки и недоставленные, содержат адрес и клиента и удаляются
+func (s ParcelStore) anonymizeParcels(ctx context.Context, numbers []int, now time.Time, unlinkClient bool) (err error) {
+	ctx, op := s.begin(ctx, "anonymize_parcels", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(numbers)), slog.Bool("unlink_client", unlinkClient))
+	}()
+
+	in, args := namedList("parcel", numbers)
+	set := "address = :address, address_index = '', anonymized_at = :now"
+	setArgs := append(args, sql.Named("address", anonymizedAddress), sql.Named("now", now.UTC().Format(time.RFC3339)))
+	if unlinkClient {
+		set += ", client = :anonymous_client"
+		setArgs = append(setArgs, sql.Named("anonymous_client", anonymousClient))
+	}
+	_, err = s.q.ExecContext(ctx, "UPDATE parcel SET "+set+" WHERE number IN ("+in+")", setArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize parcels: error: %w", err)
+	}
+
+	// события хранят адрес на момент изменения, поэтому переписываются все события посылок
+	rows, err := s.q.QueryContext(ctx, "SELECT id, payload FROM outbox WHERE parcel IN ("+in+")", args...)
+	if err != nil {
+		return fmt.Errorf("failed to select events of anonymized parcels: error: %w", err)
+	}
+	events, err := s.scanEvents(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := s.backfillDeadLetters(ctx); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM webhook_dead_letter WHERE parcel IN (" + in + ")",
+		"DELETE FROM webhook_delivery WHERE parcel IN (" + in + ")",
+	} {
+		if _, err := s.q.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to delete webhook notifications of anonymized parcels: error: %w", err)
+		}
+	}
+
+	for _, event := range events {
+		event.Address = anonymizedAddress
+		if unlinkClient {
+			event.Client = anonymousClient
+		}
+		id := event.ID
+		event.ID = 0
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode anonymized event %d: %w", id, err)
+		}
+		_, err = s.q.ExecContext(ctx, "UPDATE outbox SET payload = :payload, client = :client WHERE id = :id",
+			sql.Named("payload", string(payload)), sql.Named("client", event.Client), sql.Named("id", id))
+		if err != nil {
+			return fmt.Errorf("failed to anonymize event %d: error: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// purgeParcels - удаляет посылки numbers вместе с их событиями, уведомлениями webhook, отметками о сроках
+// доставки и ключами идемпотентности
+func (s ParcelStore) purgeParcels(ctx context.Context, numbers []int) (err error) {
+	ctx, op := s.begin(ctx, "purge_parcels", "DELETE")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(numbers)))
+	}()
+
+	if err := s.backfillDeadLetters(ctx); err != nil {
+		return err
+	}
+
+	in, args := namedList("parcel", numbers)
+	for _, query := range []string{
+		"DELETE FROM outbox_sink_delivery WHERE event IN (SELECT id FROM outbox WHERE parcel IN (" + in + "))",
+		"DELETE FROM outbox WHERE parcel IN (" + in + ")",
+		"DELETE FROM webhook_dead_letter WHERE parcel IN (" + in + ")",
+		"DELETE FROM webhook_delivery WHERE parcel IN (" + in + ")",
+		"DELETE FROM parcel_sla_flag WHERE parcel IN (" + in + ")",
+		"DELETE FROM idempotency_key WHERE parcel IN (" + in + ")",
+		"DELETE FROM parcel WHERE number IN (" + in + ")",
+	} {
+		if _, err := s.q.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to purge parcels: error: %w", err)
+		}
+	}
+	return nil
+}
+
+// namedList - список именованных параметров :prefix0, :prefix1... для условия IN и их значения
+func namedList(prefix string, values []int) (string, []any) {
+	names := make([]string, len(values))
+	args := make([]any, len(values))
+	for i, v := range values {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		names[i] = ":" + name
+		args[i] = sql.Named(name, v)
+	}
+	return strings.Join(names, ", "), args
+}
+
+// RetentionJob - задача планировщика, применяющая правила хранения.
+// Краткий итог каждого запуска записывается в журнал, а полный отчёт - строкой JSON в report, если он задан
+func RetentionJob(store ParcelStore, policy RetentionPolicy, report io.Writer) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		r, err := store.ApplyRetention(ctx, policy, time.Now(), false)
+		for _, rule := range r.Rules {
+			store.logger.Info("retention policy applied", slog.String("action", rule.Action), slog.Int(attrCount, rule.Count))
+		}
+		if report != nil {
+			if err := r.WriteJSON(report); err != nil {
+				store.logger.Error("failed to write retention report", slog.Any(attrError, err))
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestApplyRetention - тест для проверки обезличивания и удаления доставленных посылок пакетами
+func TestApplyRetention(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+	now := time.Now()
+
+	// deliver - регистрирует посылку, доставляет её и переносит время доставки на age назад
+	deliver := func(age time.Duration) int {
+		p, err := service.RegisterContext(ctx, 1000, "test")
+		require.NoError(t, err)
+		require.NoError(t, service.NextStatusContext(ctx, p.Number))
+		require.NoError(t, service.NextStatusContext(ctx, p.Number))
+		_, err = store.db.ExecContext(ctx, "UPDATE parcel SET delivered_at = ? WHERE number = ?",
+			now.Add(-age).UTC().Format(time.RFC3339), p.Number)
+		require.NoError(t, err)
+		return p.Number
+	}
+
+	day := 24 * time.Hour
+	purged := []int{deliver(4 * 365 * day), deliver(5 * 365 * day), deliver(4 * 365 * day)}
+	anonymized := []int{deliver(200 * day), deliver(181 * day)}
+	recent := deliver(10 * day)
+	registered, err := service.RegisterContext(ctx, 1000, "test")
+	require.NoError(t, err)
+
+	// недоставленное уведомление webhook хранит адрес посылки
+	hook, err := store.AddWebhook(ctx, WebhookSubscription{Client: 1000, URL: "http://localhost/hook", Secret: "secret"})
+	require.NoError(t, err)
+	addDeadLetter := func(number int) {
+		require.NoError(t, store.addWebhookDeadLetter(ctx, WebhookDeadLetter{Subscription: hook, Parcel: number, Client: 1000,
+			URL: "http://localhost/hook", EventType: EventRegistered, Payload: `{"address": "test"}`, FailedAt: now.UTC().Format(time.RFC3339)}))
+	}
+	for _, number := range append(append([]int{recent}, anonymized...), purged...) {
+		addDeadLetter(number)
+	}
+	// отметки о сроках доставки удаляемых посылок, которые иначе остались бы до следующей проверки сроков
+	for _, number := range purged {
+		_, err := store.db.ExecContext(ctx, "INSERT INTO parcel_sla_flag (parcel, service_level, state, deadline, flagged_at) VALUES (?, ?, ?, ?, ?)",
+			number, "standard", SLABreached, now.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+		require.NoError(t, err)
+	}
+
+	policy := RetentionPolicy{AnonymizeAfter: 180 * day, PurgeAfter: 3 * 365 * day, BatchSize: 2}
+
+	// Пробный запуск только составляет отчёт
+	report, err := store.ApplyRetention(ctx, policy, now, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	require.Len(t, report.Rules, 2)
+	assert.Equal(t, RetentionPurge, report.Rules[0].Action)
+	assert.Equal(t, purged, report.Rules[0].Parcels)
+	assert.Equal(t, 2, report.Rules[0].Batches)
+	// посылки для удаления не обезличиваются, поэтому пробный запуск не учитывает их дважды
+	assert.Equal(t, anonymized, report.Rules[1].Parcels)
+	for _, number := range purged {
+		_, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+	}
+
+	report, err = store.ApplyRetention(ctx, policy, now, false)
+	require.NoError(t, err)
+	assert.Equal(t, purged, report.Rules[0].Parcels)
+	assert.Equal(t, anonymized, report.Rules[1].Parcels)
+
+	for _, number := range purged {
+		_, err := store.GetContext(ctx, number)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		events, err := store.GetEventsAfter(ctx, 0, EventFilter{Parcel: number}, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	}
+	for _, number := range anonymized {
+		p, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, anonymizedAddress, p.Address)
+		assert.Equal(t, ParcelStatusDelivered, p.Status)
+
+		events, err := store.GetEventsAfter(ctx, 0, EventFilter{Parcel: number}, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		for _, e := range events {
+			assert.Equal(t, anonymizedAddress, e.Address)
+		}
+	}
+	for _, number := range []int{recent, registered.Number} {
+		p, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, "test", p.Address)
+	}
+	// у удалённых и обезличенных посылок недоставленные уведомления удалены,
+	// а вместе с удалёнными посылками - и их отметки о сроках доставки
+	letters, err := store.GetWebhookDeadLetters(ctx, hook)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, recent, letters[0].Parcel)
+	for _, table := range []string{"webhook_dead_letter", "parcel_sla_flag"} {
+		in, args := namedList("parcel", purged)
+		var count int
+		require.NoError(t, store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE parcel IN ("+in+")", args...).Scan(&count))
+		assert.Zero(t, count, table)
+	}
+
+	// Повторный запуск ничего не меняет, отчёт записывается строкой JSON
+	report, err = store.ApplyRetention(ctx, policy, now, false)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, report.WriteJSON(&out))
+	var decoded RetentionReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	for _, rule := range decoded.Rules {
+		assert.Zero(t, rule.Count)
+		assert.Empty(t, rule.Parcels)
+	}
+
+	// Без правила удаления обезличиваются посылки любой давности
+	deliver(5 * 365 * day)
+	report, err = store.ApplyRetention(ctx, RetentionPolicy{AnonymizeAfter: 180 * day, BatchSize: 2}, now, true)
+	require.NoError(t, err)
+	require.Len(t, report.Rules, 1)
+	assert.Equal(t, 1, report.Rules[0].Count)
+}
+
+// TestDeliveredAt - тест для проверки записи времени доставки при смене статуса
+func TestDeliveredAt(t *testing.T) {
+	store := openTestStore(t)
+	ctx := context.Background()
+
+	number, err := store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+
+	deliveredAt := func() sql.NullString {
+		var v sql.NullString
+		require.NoError(t, store.db.QueryRowContext(ctx, "SELECT delivered_at FROM parcel WHERE number = ?", number).Scan(&v))
+		return v
+	}
+
+	require.NoError(t, store.SetStatusContext(ctx, number, ParcelStatusSent))
+	assert.False(t, deliveredAt().Valid)
+
+	require.NoError(t, store.SetStatusContext(ctx, number, ParcelStatusDelivered))
+	v := deliveredAt()
+	require.True(t, v.Valid)
+	at, err := time.Parse(time.RFC3339, v.String)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), at, time.Minute)
+}
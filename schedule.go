@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule - расписание задачи планировщика
+type Schedule interface {
+	// Next - первое время запуска строго после after
+	Next(after time.Time) time.Time
+}
+
+// scheduleAliases - сокращённые записи расписаний cron
+var scheduleAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule - разбирает расписание: выражение cron из пяти полей (минута, час, день месяца, месяц, день недели)
+// в UTC, сокращение вида @daily или интервал @every 30s. Поля cron поддерживают *, списки через запятую,
+// диапазоны a-b и шаг /n; день недели 0 и 7 - воскресенье. Запуски @every выровнены по интервалу от начала
+// отсчёта времени, поэтому у всех экземпляров сервиса совпадают
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		var d Duration
+		if err := d.UnmarshalText([]byte(strings.TrimSpace(every))); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		if time.Duration(d) < time.Second || time.Duration(d)%time.Second != 0 {
+			return nil, fmt.Errorf("invalid schedule %q: interval must be a whole number of seconds", spec)
+		}
+		return everySchedule(d), nil
+	}
+	if alias, ok := scheduleAliases[spec]; ok {
+		spec = alias
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, @every or an alias", spec)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: minute: %w", spec, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: hour: %w", spec, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of month: %w", spec, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: month: %w", spec, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of week: %w", spec, err)
+	}
+	// 7 - другое обозначение воскресенья
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDay = fields[2] == "*" || fields[4] == "*"
+	return s, nil
+}
+
+// parseCronField - разбирает поле cron в битовую маску допустимых значений от lo до hi
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var mask uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", item)
+			}
+			step = n
+		}
+
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", item)
+				}
+			} else if hasStep {
+				// a/n - от a до конца диапазона с шагом n
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("value %q out of range %d-%d", item, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+// cronSchedule - расписание cron в UTC: битовые маски допустимых значений полей
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// anyDay - день месяца или день недели не ограничен: тогда должны совпасть оба поля,
+	// иначе достаточно совпадения одного из них, как в cron
+	anyDay bool
+}
+
+// Next - первое время запуска строго после after. Если за 5 лет время не найдено (например, 30 февраля),
+// возвращается нулевое время
+func (s cronSchedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay - подходит ли день t по дню месяца и дню недели
+func (s cronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// everySchedule - запуск через равные интервалы, выровненные по началу отсчёта времени
+type everySchedule time.Duration
+
+// Next - первое время запуска строго после after
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.UTC().Truncate(time.Duration(s)).Add(time.Duration(s))
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Задачи планировщика в режиме serve
+const (
+	JobRetention       = "retention"        // Правила хранения доставленных посылок
+	JobSLA             = "sla"              // Проверка сроков доставки
+	JobOutbox          = "outbox"           // Доставка событий из outbox
+	JobIdempotencyKeys = "idempotency_keys" // Удаление истёкших ключей идемпотентности
+	JobReport          = "report"           // Отчёт за прошедшие сутки
+)
+
+// jobNames - задачи, расписание которых задаётся в конфигурации
+var jobNames = []string{JobRetention, JobSLA, JobOutbox, JobIdempotencyKeys, JobReport}
+
+// Состояния запусков задач
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// attrJob - имя задачи планировщика в журнале
+const attrJob = "job"
+
+// Job - периодическая задача планировщика
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+// JobRun - запись истории запуска задачи
+type JobRun struct {
+	ID          int64  `json:"id"`
+	Job         string `json:"job"`
+	Owner       string `json:"owner"`        // Экземпляр сервиса, выполнивший задачу
+	ScheduledAt string `json:"scheduled_at"` // Время запуска по расписанию
+	StartedAt   string `json:"started_at"`
+	FinishedAt  string `json:"finished_at,omitempty"`
+	Status      string `json:"status"` // running, succeeded или failed
+	Error       string `json:"error,omitempty"`
+}
+
+// Scheduler - планировщик периодических задач внутри процесса. Перед запуском задача блокируется в таблице
+// job_lock для времени запуска по расписанию, поэтому из нескольких экземпляров сервиса с общей базой
+// задачу выполняет только один. Блокировка действует не дольше lockTTL, на это же время ограничено
+// выполнение задачи, чтобы блокировка упавшего экземпляра не мешала следующим запускам.
+// Каждый запуск записывается в историю job_run
+type Scheduler struct {
+	store   ParcelStore
+	owner   string
+	lockTTL time.Duration
+	history time.Duration
+	jobs    []Job
+	logger  *slog.Logger
+}
+
+// NewScheduler - конструктор планировщика. owner - имя экземпляра сервиса в блокировках и истории,
+// history - срок хранения истории запусков, 0 - история не удаляется
+func NewScheduler(store ParcelStore, owner string, lockTTL, history time.Duration) *Scheduler {
+	return &Scheduler{store: store, owner: owner, lockTTL: lockTTL, history: history, logger: store.logger}
+}
+
+// Add - добавляет задачу. Вызывается до Run
+func (s *Scheduler) Add(name string, schedule Schedule, run func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, Job{Name: name, Schedule: schedule, Run: run})
+}
+
+// Run - запускает задачи по расписанию, пока не отменён ctx. После отмены новые запуски не начинаются,
+// а Run возвращается, когда завершатся уже выполняющиеся задачи
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}()
+	}
+	wg.Wait()
+}
+
+// loop - ожидает времени запуска задачи по расписанию и выполняет её. Запуски, пропущенные
+// во время выполнения задачи, не наверстываются
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	for {
+		next := job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Warn("job schedule has no next run", slog.String(attrJob, job.Name))
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := s.RunJob(ctx, job, next); err != nil {
+			s.logger.Error("scheduled job failed", slog.String(attrJob, job.Name), slog.Any(attrError, err))
+		}
+	}
+}
+
+// RunJob - выполняет задачу, запланированную на scheduled, если её не выполняет или уже не выполнил
+// для этого времени другой экземпляр. Возвращает false, если задача не запускалась.
+// Задача и запись её результата не прерываются отменой ctx, а выполнение ограничено lockTTL
+func (s *Scheduler) RunJob(ctx context.Context, job Job, scheduled time.Time) (bool, error) {
+	// запуск завершается и записывается в историю и при остановке сервиса
+	ctx = context.WithoutCancel(ctx)
+
+	now := time.Now()
+	locked, err := s.store.lockJob(ctx, job.Name, s.owner, scheduled, now, now.Add(s.lockTTL))
+	if err != nil || !locked {
+		return false, err
+	}
+
+	id, err := s.store.startJobRun(ctx, JobRun{
+		Job:         job.Name,
+		Owner:       s.owner,
+		ScheduledAt: scheduled.UTC().Format(time.RFC3339),
+		StartedAt:   now.UTC().Format(time.RFC3339),
+		Status:      JobRunning,
+	})
+	if err != nil {
+		return true, err
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, s.lockTTL)
+	runErr := job.Run(runCtx)
+	cancel()
+
+	finished := time.Now()
+	if err := s.store.finishJobRun(ctx, id, finished, runErr); err != nil {
+		return true, err
+	}
+	if err := s.store.unlockJob(ctx, job.Name, s.owner, finished); err != nil {
+		return true, err
+	}
+	if s.history > 0 {
+		if _, err := s.store.DeleteJobRuns(ctx, finished.Add(-s.history)); err != nil {
+			return true, err
+		}
+	}
+	return true, runErr
+}
+
+// lockJob - блокирует задачу для запуска по расписанию scheduled до until. Блокировка не берётся,
+// если задачу держит другой экземпляр или она уже запускалась для этого или более позднего времени
+func (s ParcelStore) lockJob(ctx context.Context, job, owner string, scheduled, now, until time.Time) (locked bool, err error) {
+	ctx, op := s.begin(ctx, "lock_job", "INSERT")
+	defer func() {
+		op.end(err, slog.String(attrJob, job), slog.Bool("locked", locked))
+	}()
+
+	res, err := s.q.ExecContext(ctx, `INSERT INTO job_lock (job, owner, scheduled_at, locked_until) VALUES (:job, :owner, :scheduled_at, :locked_until)
+		ON CONFLICT (job) DO UPDATE SET owner = excluded.owner, scheduled_at = excluded.scheduled_at, locked_until = excluded.locked_until
+		WHERE job_lock.locked_until <= :now AND job_lock.scheduled_at < excluded.scheduled_at`,
+		sql.Named("job", job),
+		sql.Named("owner", owner),
+		sql.Named("scheduled_at", scheduled.UTC().Format(time.RFC3339)),
+		sql.Named("locked_until", until.UTC().Format(time.RFC3339)),
+		sql.Named("now", now.UTC().Format(time.RFC3339)))
+	if err != nil {
+		return false, fmt.Errorf("failed to lock job %s: error: %w", job, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to lock job %s: error: %w", job, err)
+	}
+	return n == 1, nil
+}
+
+// unlockJob - снимает блокировку задачи, которую держит owner. Время запуска по расписанию сохраняется
+func (s ParcelStore) unlockJob(ctx context.Context, job, owner string, now time.Time) (err error) {
+	ctx, op := s.begin(ctx, "unlock_job", "UPDATE")
+	defer func() {
+		op.end(err, slog.String(attrJob, job))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "UPDATE job_lock SET locked_until = :now WHERE job = :job AND owner = :owner",
+		sql.Named("now", now.UTC().Format(time.RFC3339)),
+		sql.Named("job", job),
+		sql.Named("owner", owner))
+	if err != nil {
+		return fmt.Errorf("failed to unlock job %s: error: %w", job, err)
+	}
+	return nil
+}
+
+// startJobRun - записывает начало запуска задачи и возвращает идентификатор записи
+func (s ParcelStore) startJobRun(ctx context.Context, run JobRun) (id int64, err error) {
+	ctx, op := s.begin(ctx, "start_job_run", "INSERT")
+	defer func() {
+		op.end(err, slog.String(attrJob, run.Job))
+	}()
+
+	err = s.q.QueryRowContext(ctx, "INSERT INTO job_run (job, owner, scheduled_at, started_at, status) VALUES (:job, :owner, :scheduled_at, :started_at, :status) RETURNING id",
+		sql.Named("job", run.Job),
+		sql.Named("owner", run.Owner),
+		sql.Named("scheduled_at", run.ScheduledAt),
+		sql.Named("started_at", run.StartedAt),
+		sql.Named("status", run.Status)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record start of job %s: error: %w", run.Job, err)
+	}
+	return id, nil
+}
+
+// finishJobRun - записывает завершение запуска задачи: успешное, если runErr == nil
+func (s ParcelStore) finishJobRun(ctx context.Context, id int64, finished time.Time, runErr error) (err error) {
+	ctx, op := s.begin(ctx, "finish_job_run", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int64("run_id", id))
+	}()
+
+	status, message := JobSucceeded, ""
+	if runErr != nil {
+		status, message = JobFailed, runErr.Error()
+	}
+	_, err = s.q.ExecContext(ctx, "UPDATE job_run SET finished_at = :finished_at, status = :status, error = :error WHERE id = :id",
+		sql.Named("finished_at", finished.UTC().Format(time.RFC3339)),
+		sql.Named("status", status),
+		sql.Named("error", message),
+		sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("failed to record finish of job run %d: error: %w", id, err)
+	}
+	return nil
+}
+
+// JobRuns - метод для получения истории запусков, от последних. job ограничивает историю одной задачей,
+// пустая строка - все задачи
+func (s ParcelStore) JobRuns(ctx context.Context, job string, limit int) (res []JobRun, err error) {
+	ctx, op := s.begin(ctx, "job_runs", "SELECT")
+	defer func() {
+		op.end(err, slog.String(attrJob, job), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, `SELECT id, job, owner, scheduled_at, started_at, COALESCE(finished_at, ''), status, error
+		FROM job_run WHERE (:job = '' OR job = :job) ORDER BY id DESC LIMIT :limit`,
+		sql.Named("job", job),
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve job runs: error: %w", err)
+	}
+	defer rows.Close()
+
+	res = []JobRun{}
+	for rows.Next() {
+		var r JobRun
+		if err := rows.Scan(&r.ID, &r.Job, &r.Owner, &r.ScheduledAt, &r.StartedAt, &r.FinishedAt, &r.Status, &r.Error); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving job runs: error: %w", err)
+		}
+		res = append(res, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving job runs: %w", err)
+	}
+	return res, nil
+}
+
+// DeleteJobRuns - метод для удаления истории запусков, начатых раньше before. Возвращает количество удалённых записей
+func (s ParcelStore) DeleteJobRuns(ctx context.Context, before time.Time) (deleted int, err error) {
+	ctx, op := s.begin(ctx, "delete_job_runs", "DELETE")
+	defer func() {
+		op.end(err, slog.Int(attrCount, deleted))
+	}()
+
+	res, err := s.q.ExecContext(ctx, "DELETE FROM job_run WHERE started_at < :before AND status <> :running",
+		sql.Named("before", before.UTC().Format(time.RFC3339)),
+		sql.Named("running", JobRunning))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete job runs: error: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete job runs: error: %w", err)
+	}
+	return int(n), nil
+}
+
+// WriteJobRuns - выводит историю запусков в формате JSON
+func WriteJobRuns(w io.Writer, runs []JobRun) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(runs); err != nil {
+		return fmt.Errorf("failed to write job runs: error: %w", err)
+	}
+	return nil
+}
+
+// schedulerOwner - имя экземпляра сервиса для блокировок задач: имя хоста и идентификатор процесса
+func schedulerOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseSchedule - тест для проверки разбора расписаний и вычисления следующего запуска
+func TestParseSchedule(t *testing.T) {
+	// суббота
+	after := time.Date(2025, 3, 15, 10, 7, 30, 0, time.UTC)
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return d
+	}
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: date("2025-03-15T10:08:00Z")},
+		{spec: "*/15 * * * *", want: date("2025-03-15T10:15:00Z")},
+		{spec: "5 0 * * *", want: date("2025-03-16T00:05:00Z")},
+		{spec: "0 9-17/4 * * 1-5", want: date("2025-03-17T09:00:00Z")},
+		{spec: "30 8 1,20 * *", want: date("2025-03-20T08:30:00Z")},
+		// задан и день месяца, и день недели: достаточно совпадения одного из них
+		{spec: "0 0 20 * 7", want: date("2025-03-16T00:00:00Z")},
+		{spec: "0 12 29 2 *", want: date("2028-02-29T12:00:00Z")},
+		{spec: "@hourly", want: date("2025-03-15T11:00:00Z")},
+		{spec: "@monthly", want: date("2025-04-01T00:00:00Z")},
+		{spec: "@every 10s", want: date("2025-03-15T10:07:40Z")},
+		{spec: "@every 1d", want: date("2025-03-16T00:00:00Z")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, schedule.Next(after))
+		})
+	}
+
+	// Время запуска вычисляется в UTC
+	schedule, err := ParseSchedule("0 3 * * *")
+	require.NoError(t, err)
+	moscow := time.FixedZone("MSK", 3*60*60)
+	assert.Equal(t, date("2025-03-16T03:00:00Z"), schedule.Next(time.Date(2025, 3, 16, 5, 0, 0, 0, moscow)))
+
+	// 30 февраля не наступает
+	schedule, err = ParseSchedule("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(after).IsZero())
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"*/0 * * * *", "5-1 * * * *", "a * * * *", "@every", "@every 500ms", "@every -1h", "@often"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+// TestSchedulerRunJob - тест для проверки блокировки задачи между экземплярами и истории запусков
+func TestSchedulerRunJob(t *testing.T) {
+	store := openTestStore(t)
+	ctx := context.Background()
+	first := NewScheduler(store, "first", time.Minute, 0)
+	second := NewScheduler(store, "second", time.Minute, 0)
+	slot := time.Now().UTC().Truncate(time.Second)
+
+	runs := 0
+	job := Job{Name: JobSLA, Run: func(ctx context.Context) error {
+		runs++
+		// пока задача выполняется, второй экземпляр не может её запустить и для следующего времени
+		ran, err := second.RunJob(ctx, Job{Name: JobSLA, Run: func(context.Context) error { runs++; return nil }}, slot.Add(time.Second))
+		require.NoError(t, err)
+		assert.False(t, ran)
+		return nil
+	}}
+
+	ran, err := first.RunJob(ctx, job, slot)
+	require.NoError(t, err)
+	assert.True(t, ran)
+	assert.Equal(t, 1, runs)
+
+	// Время запуска, уже выполненное другим экземпляром, не повторяется
+	ran, err = second.RunJob(ctx, job, slot)
+	require.NoError(t, err)
+	assert.False(t, ran)
+
+	// Следующее время запуска выполняет любой экземпляр, ошибка задачи записывается в историю
+	failure := errors.New("dispatch failed")
+	ran, err = second.RunJob(ctx, Job{Name: JobSLA, Run: func(context.Context) error { return failure }}, slot.Add(2*time.Second))
+	assert.True(t, ran)
+	require.ErrorIs(t, err, failure)
+
+	// Блокировки задач независимы
+	ran, err = second.RunJob(ctx, Job{Name: JobOutbox, Run: func(context.Context) error { return nil }}, slot)
+	require.NoError(t, err)
+	assert.True(t, ran)
+
+	history, err := store.JobRuns(ctx, JobSLA, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "second", history[0].Owner)
+	assert.Equal(t, JobFailed, history[0].Status)
+	assert.Equal(t, "dispatch failed", history[0].Error)
+	assert.Equal(t, "first", history[1].Owner)
+	assert.Equal(t, JobSucceeded, history[1].Status)
+	assert.Equal(t, slot.Format(time.RFC3339), history[1].ScheduledAt)
+	assert.NotEmpty(t, history[1].FinishedAt)
+
+	all, err := store.JobRuns(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	// Старая история удаляется
+	deleted, err := store.DeleteJobRuns(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+}
+
+// TestSchedulerRun - тест для проверки запуска задач по расписанию и ожидания выполняющихся задач при остановке
+func TestSchedulerRun(t *testing.T) {
+	store := openTestStore(t)
+	scheduler := NewScheduler(store, "test", time.Minute, time.Hour)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	scheduler.Add(JobOutbox, everySchedule(time.Second), func(ctx context.Context) error {
+		close(started)
+		<-release
+		// задача не прерывается остановкой планировщика
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not started")
+	}
+	cancel()
+
+	select {
+	case <-done:
+		t.Fatal("scheduler stopped before running job finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+
+	history, err := store.JobRuns(context.Background(), JobOutbox, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, JobSucceeded, history[0].Status)
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration - версия схемы базы данных с SQL-запросами для перехода на неё
+type migration struct {
+	version  int      // Номер версии, миграции применяются по возрастанию
+	name     string   // Краткое описание изменений
+	stmts    []string // SQL-запросы миграции
+	postgres []string // SQL-запросы для PostgreSQL, если они отличаются от stmts
+}
+
+// statements - SQL-запросы миграции для диалекта d
+func (m migration) statements(d dialect) []string {
+	if d == postgresDialect && m.postgres != nil {
+		return m.postgres
+	}
+	return m.stmts
+}
+
+// migrations - все миграции схемы. Новые миграции добавляются только в конец списка
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "parcel table",
+		stmts: []string{`CREATE TABLE IF NOT EXISTS parcel
+(
+    number     integer
+        constraint parcel_pk
+            primary key autoincrement,
+    client     integer      not null,
+    status     VARCHAR(128) not null,
+    address    VARCHAR(512) not null,
+    created_at text         not null
+)`},
+		postgres: []string{`CREATE TABLE IF NOT EXISTS parcel
+(
+    number     integer generated by default as identity
+        constraint parcel_pk
+            primary key,
+    client     integer      not null,
+    status     VARCHAR(128) not null,
+    address    VARCHAR(512) not null,
+    created_at text         not null
+)`},
+	},
+	{
+		version: 2,
+		name:    "webhook subscriptions and dead letters",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_subscription
+(
+    id         integer primary key autoincrement,
+    client     integer       not null,
+    url        VARCHAR(2048) not null,
+    secret     VARCHAR(256)  not null,
+    events     VARCHAR(512)  not null default '',
+    created_at text          not null
+)`,
+			`CREATE INDEX IF NOT EXISTS webhook_subscription_client_idx ON webhook_subscription (client)`,
+			`CREATE TABLE IF NOT EXISTS webhook_dead_letter
+(
+    id           integer primary key autoincrement,
+    subscription integer       not null,
+    url          VARCHAR(2048) not null,
+    event_type   VARCHAR(128)  not null,
+    payload      text          not null,
+    attempts     integer       not null,
+    last_error   text          not null,
+    failed_at    text          not null
+)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_subscription
+(
+    id         integer generated by default as identity primary key,
+    client     integer       not null,
+    url        VARCHAR(2048) not null,
+    secret     VARCHAR(256)  not null,
+    events     VARCHAR(512)  not null default '',
+    created_at text          not null
+)`,
+			`CREATE INDEX IF NOT EXISTS webhook_subscription_client_idx ON webhook_subscription (client)`,
+			`CREATE TABLE IF NOT EXISTS webhook_dead_letter
+(
+    id           integer generated by default as identity primary key,
+    subscription integer       not null,
+    url          VARCHAR(2048) not null,
+    event_type   VARCHAR(128)  not null,
+    payload      text          not null,
+    attempts     integer       not null,
+    last_error   text          not null,
+    failed_at    text          not null
+)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "transactional outbox",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS outbox
+(
+    id           integer primary key autoincrement,
+    event_type   VARCHAR(128) not null,
+    parcel       integer      not null,
+    client       integer      not null,
+    payload      text         not null,
+    created_at   text         not null,
+    delivered_at text,
+    attempts     integer      not null default 0,
+    last_error   text         not null default ''
+)`,
+			`CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (delivered_at, id)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS outbox
+(
+    id           bigint generated by default as identity primary key,
+    event_type   VARCHAR(128) not null,
+    parcel       integer      not null,
+    client       integer      not null,
+    payload      text         not null,
+    created_at   text         not null,
+    delivered_at text,
+    attempts     integer      not null default 0,
+    last_error   text         not null default ''
+)`,
+			`CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (delivered_at, id)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "parcel tracking codes",
+		stmts: []string{
+			`ALTER TABLE parcel ADD COLUMN tracking_code VARCHAR(16) not null default ''`,
+			// Уже зарегистрированные посылки получают случайный код из 12 шестнадцатеричных символов
+			`UPDATE parcel SET tracking_code = upper(hex(randomblob(6))) WHERE tracking_code = ''`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS parcel_tracking_code_idx ON parcel (tracking_code) WHERE tracking_code <> ''`,
+		},
+		postgres: []string{
+			`ALTER TABLE parcel ADD COLUMN tracking_code VARCHAR(16) not null default ''`,
+			`UPDATE parcel SET tracking_code = upper(substr(md5(random()::text || number::text), 1, 12)) WHERE tracking_code = ''`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS parcel_tracking_code_idx ON parcel (tracking_code) WHERE tracking_code <> ''`,
+		},
+	},
+	{
+		version: 5,
+		name:    "api keys",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS api_key
+(
+    id         integer primary key autoincrement,
+    key_hash   VARCHAR(64)  not null unique,
+    name       VARCHAR(128) not null,
+    role       VARCHAR(32)  not null,
+    client     integer      not null default 0,
+    created_at text         not null,
+    revoked_at text
+)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS api_key
+(
+    id         integer generated by default as identity primary key,
+    key_hash   VARCHAR(64)  not null unique,
+    name       VARCHAR(128) not null,
+    role       VARCHAR(32)  not null,
+    client     integer      not null default 0,
+    created_at text         not null,
+    revoked_at text
+)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "registration idempotency keys",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS idempotency_key
+(
+    client       integer      not null,
+    key          VARCHAR(255) not null,
+    request_hash VARCHAR(64)  not null,
+    parcel       integer      not null,
+    created_at   text         not null,
+    expires_at   text         not null,
+    primary key (client, key)
+)`,
+			`CREATE INDEX IF NOT EXISTS idempotency_key_expires_idx ON idempotency_key (expires_at)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "client registration quotas",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS client_quota
+(
+    client              integer primary key,
+    daily_registrations integer not null
+)`,
+			`CREATE TABLE IF NOT EXISTS registration_count
+(
+    client        integer     not null,
+    day           VARCHAR(10) not null,
+    registrations integer     not null,
+    primary key (client, day)
+)`,
+		},
+	},
+	{
+		version: 8,
+		name:    "parcel delivery and anonymisation time",
+		stmts: []string{
+			`ALTER TABLE parcel ADD COLUMN delivered_at text`,
+			`ALTER TABLE parcel ADD COLUMN anonymized_at text`,
+			// Временем доставки уже доставленных посылок считается последнее изменение статуса в outbox,
+			// а если событий нет - время регистрации
+			`UPDATE parcel SET delivered_at = COALESCE(
+    (SELECT MAX(created_at) FROM outbox WHERE outbox.parcel = parcel.number AND outbox.event_type = 'parcel.status_changed'),
+    created_at)
+WHERE status = 'delivered'`,
+			`CREATE INDEX IF NOT EXISTS parcel_delivered_idx ON parcel (delivered_at) WHERE delivered_at IS NOT NULL`,
+		},
+	},
+	{
+		version: 9,
+		name:    "audit log",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          integer primary key autoincrement,
+    occurred_at text         not null,
+    actor       VARCHAR(256) not null,
+    action      VARCHAR(64)  not null,
+    client      integer      not null,
+    details     text         not null default ''
+)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_client_idx ON audit_log (client)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          bigint generated by default as identity primary key,
+    occurred_at text         not null,
+    actor       VARCHAR(256) not null,
+    action      VARCHAR(64)  not null,
+    client      integer      not null,
+    details     text         not null default ''
+)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_client_idx ON audit_log (client)`,
+		},
+	},
+	{
+		version: 10,
+		name:    "address blind index",
+		stmts: []string{
+			`ALTER TABLE parcel ADD COLUMN address_index VARCHAR(64) not null default ''`,
+			`CREATE INDEX IF NOT EXISTS parcel_address_index_idx ON parcel (address_index) WHERE address_index <> ''`,
+		},
+		postgres: []string{
+			`ALTER TABLE parcel ADD COLUMN address_index VARCHAR(64) not null default ''`,
+			`CREATE INDEX IF NOT EXISTS parcel_address_index_idx ON parcel (address_index) WHERE address_index <> ''`,
+			// зашифрованный адрес длиннее открытого
+			`ALTER TABLE parcel ALTER COLUMN address TYPE text`,
+		},
+	},
+	{
+		version: 11,
+		name:    "address full-text search",
+		stmts: []string{
+			// таблица с внешним содержимым хранит только индекс, текст адреса читается из parcel;
+			// unicode61 приводит к нижнему регистру и кириллицу, remove_diacritics 2 убирает диакритику латиницы (é = e)
+			`CREATE VIRTUAL TABLE IF NOT EXISTS parcel_fts USING fts5(address, content='parcel', content_rowid='number', tokenize='unicode61 remove_diacritics 2')`,
+			`CREATE TRIGGER IF NOT EXISTS parcel_fts_insert AFTER INSERT ON parcel BEGIN
+				INSERT INTO parcel_fts (rowid, address) VALUES (new.number, new.address);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS parcel_fts_delete AFTER DELETE ON parcel BEGIN
+				INSERT INTO parcel_fts (parcel_fts, rowid, address) VALUES ('delete', old.number, old.address);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS parcel_fts_update AFTER UPDATE OF address ON parcel BEGIN
+				INSERT INTO parcel_fts (parcel_fts, rowid, address) VALUES ('delete', old.number, old.address);
+				INSERT INTO parcel_fts (rowid, address) VALUES (new.number, new.address);
+			END`,
+			`INSERT INTO parcel_fts (parcel_fts) VALUES ('rebuild')`,
+		},
+		postgres: []string{
+			`CREATE INDEX IF NOT EXISTS parcel_address_fts_idx ON parcel USING gin (to_tsvector('simple', address))`,
+		},
+	},
+	{
+		version: 12,
+		name:    "delivery sla",
+		stmts: []string{
+			`ALTER TABLE parcel ADD COLUMN sent_at text`,
+			`ALTER TABLE parcel ADD COLUMN service_level VARCHAR(32) not null default 'standard'`,
+			// Временем отправки уже отправленных посылок считается первое изменение статуса в outbox,
+			// а если событий нет - время регистрации
+			`UPDATE parcel SET sent_at = COALESCE(
+    (SELECT MIN(created_at) FROM outbox WHERE outbox.parcel = parcel.number AND outbox.event_type = 'parcel.status_changed'),
+    created_at)
+WHERE status IN ('sent', 'delivered')`,
+			`CREATE INDEX IF NOT EXISTS parcel_sla_idx ON parcel (service_level, status)`,
+			`CREATE TABLE IF NOT EXISTS parcel_sla_flag
+(
+    parcel        integer primary key,
+    service_level VARCHAR(32) not null,
+    state         VARCHAR(16) not null,
+    deadline      text        not null,
+    flagged_at    text        not null
+)`,
+		},
+	},
+	{
+		version: 13,
+		name:    "job scheduler",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS job_lock
+(
+    job          VARCHAR(64)  not null primary key,
+    owner        VARCHAR(128) not null,
+    scheduled_at text         not null,
+    locked_until text         not null
+)`,
+			`CREATE TABLE IF NOT EXISTS job_run
+(
+    id           integer primary key autoincrement,
+    job          VARCHAR(64)  not null,
+    owner        VARCHAR(128) not null,
+    scheduled_at text         not null,
+    started_at   text         not null,
+    finished_at  text,
+    status       VARCHAR(16)  not null,
+    error        text         not null default ''
+)`,
+			`CREATE INDEX IF NOT EXISTS job_run_job_idx ON job_run (job, id)`,
+			`CREATE INDEX IF NOT EXISTS job_run_started_idx ON job_run (started_at)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS job_lock
+(
+    job          VARCHAR(64)  not null primary key,
+    owner        VARCHAR(128) not null,
+    scheduled_at text         not null,
+    locked_until text         not null
+)`,
+			`CREATE TABLE IF NOT EXISTS job_run
+(
+    id           bigint generated by default as identity primary key,
+    job          VARCHAR(64)  not null,
+    owner        VARCHAR(128) not null,
+    scheduled_at text         not null,
+    started_at   text         not null,
+    finished_at  text,
+    status       VARCHAR(16)  not null,
+    error        text         not null default ''
+)`,
+			`CREATE INDEX IF NOT EXISTS job_run_job_idx ON job_run (job, id)`,
+			`CREATE INDEX IF NOT EXISTS job_run_started_idx ON job_run (started_at)`,
+		},
+	},
+	{
+		version: 14,
+		name:    "estimated delivery date",
+		stmts: []string{
+			`ALTER TABLE parcel ADD COLUMN eta text`,
+		},
+	},
+	{
+		version: 15,
+		name:    "durable webhook deliveries and outbox retries",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_delivery
+(
+    id              integer primary key autoincrement,
+    subscription    integer      not null,
+    parcel          integer      not null,
+    client          integer      not null,
+    event_type      VARCHAR(128) not null,
+    delivery_id     VARCHAR(64)  not null,
+    payload         text         not null,
+    attempts        integer      not null default 0,
+    last_error      text         not null default '',
+    next_attempt_at text         not null
+)`,
+			`CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON webhook_delivery (next_attempt_at, id)`,
+			`ALTER TABLE outbox ADD COLUMN next_attempt_at text`,
+			`ALTER TABLE outbox ADD COLUMN parked_at text`,
+			`CREATE INDEX IF NOT EXISTS outbox_parcel_idx ON outbox (parcel, id)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_delivery
+(
+    id              bigint generated by default as identity primary key,
+    subscription    integer      not null,
+    parcel          integer      not null,
+    client          integer      not null,
+    event_type      VARCHAR(128) not null,
+    delivery_id     VARCHAR(64)  not null,
+    payload         text         not null,
+    attempts        integer      not null default 0,
+    last_error      text         not null default '',
+    next_attempt_at text         not null
+)`,
+			`CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON webhook_delivery (next_attempt_at, id)`,
+			`ALTER TABLE outbox ADD COLUMN next_attempt_at text`,
+			`ALTER TABLE outbox ADD COLUMN parked_at text`,
+			`CREATE INDEX IF NOT EXISTS outbox_parcel_idx ON outbox (parcel, id)`,
+		},
+	},
+	{
+		version: 16,
+		name:    "dead letter parcel and client",
+		stmts: []string{
+			// 0 - уведомление записано до миграции, посылка и клиент заполняются из тела уведомления при обращении
+			`ALTER TABLE webhook_dead_letter ADD COLUMN parcel integer not null default 0`,
+			`ALTER TABLE webhook_dead_letter ADD COLUMN client integer not null default 0`,
+			`CREATE INDEX IF NOT EXISTS webhook_dead_letter_parcel_idx ON webhook_dead_letter (parcel)`,
+			`CREATE INDEX IF NOT EXISTS webhook_dead_letter_client_idx ON webhook_dead_letter (client)`,
+			`CREATE INDEX IF NOT EXISTS webhook_delivery_parcel_idx ON webhook_delivery (parcel)`,
+		},
+	},
+	{
+		version: 17,
+		name:    "address search without encrypted addresses",
+		// зашифрованные адреса (с префиксом encryptedPrefix) не индексируются: поиск по шифротексту бесполезен,
+		// а поиск при включённом шифровании недоступен
+		stmts: []string{
+			`DROP TRIGGER IF EXISTS parcel_fts_insert`,
+			`DROP TRIGGER IF EXISTS parcel_fts_delete`,
+			`DROP TRIGGER IF EXISTS parcel_fts_update`,
+			`CREATE TRIGGER IF NOT EXISTS parcel_fts_insert AFTER INSERT ON parcel BEGIN
+				INSERT INTO parcel_fts (rowid, address) SELECT new.number, new.address WHERE substr(new.address, 1, 7) <> 'enc:v1:';
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS parcel_fts_delete AFTER DELETE ON parcel BEGIN
+				INSERT INTO parcel_fts (parcel_fts, rowid, address) SELECT 'delete', old.number, old.address WHERE substr(old.address, 1, 7) <> 'enc:v1:';
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS parcel_fts_update AFTER UPDATE OF address ON parcel BEGIN
+				INSERT INTO parcel_fts (parcel_fts, rowid, address) SELECT 'delete', old.number, old.address WHERE substr(old.address, 1, 7) <> 'enc:v1:';
+				INSERT INTO parcel_fts (rowid, address) SELECT new.number, new.address WHERE substr(new.address, 1, 7) <> 'enc:v1:';
+			END`,
+			// rebuild читает все адреса из parcel, поэтому затем из индекса удаляются зашифрованные
+			`INSERT INTO parcel_fts (parcel_fts) VALUES ('rebuild')`,
+			`INSERT INTO parcel_fts (parcel_fts, rowid, address) SELECT 'delete', number, address FROM parcel WHERE substr(address, 1, 7) = 'enc:v1:'`,
+			`INSERT INTO parcel_fts (parcel_fts) VALUES ('optimize')`,
+		},
+		postgres: []string{
+			`DROP INDEX IF EXISTS parcel_address_fts_idx`,
+			`CREATE INDEX IF NOT EXISTS parcel_address_fts_idx ON parcel USING gin (to_tsvector('simple', address)) WHERE substr(address, 1, 7) <> 'enc:v1:'`,
+		},
+	},
+	{
+		version: 18,
+		name:    "outbox delivery per sink and unique webhook deliveries",
+		// приёмники, в которые событие уже опубликовано: повторная попытка публикует его только в остальные
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS outbox_sink_delivery
+(
+    event        integer      not null,
+    sink         VARCHAR(255) not null,
+    delivered_at text         not null,
+    PRIMARY KEY (event, sink)
+)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS webhook_delivery_id_idx ON webhook_delivery (delivery_id)`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS outbox_sink_delivery
+(
+    event        bigint       not null,
+    sink         VARCHAR(255) not null,
+    delivered_at text         not null,
+    PRIMARY KEY (event, sink)
+)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS webhook_delivery_id_idx ON webhook_delivery (delivery_id)`,
+		},
+	},
+}
+
+// Migrate - применяет к базе данных миграции, которые ещё не были применены.
+// Применённые версии хранятся в таблице schema_migrations, каждая миграция выполняется в отдельной транзакции.
+// Диалект SQL (SQLite или PostgreSQL) определяется по драйверу подключения
+func Migrate(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version integer primary key, applied_at text not null)")
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	d := dialectOf(db)
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(ctx, db, d, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration - выполняет запросы миграции и записывает её версию в одной транзакции
+func applyMigration(ctx context.Context, db *sql.DB, d dialect, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d (%s): %w", m.version, m.name, err)
+	}
+	defer tx.Rollback()
+	q := d.wrap(tx)
+
+	// Пока транзакция ждала блокировку, миграцию мог применить другой экземпляр сервиса
+	if d.migrationLock != "" {
+		if _, err := q.ExecContext(ctx, d.migrationLock); err != nil {
+			return fmt.Errorf("failed to lock migration %d (%s): %w", m.version, m.name, err)
+		}
+		var applied int
+		err = q.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = :version", sql.Named("version", m.version)).
+			Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %d (%s): %w", m.version, m.name, err)
+		}
+		if applied > 0 {
+			return nil
+		}
+	}
+
+	for _, stmt := range m.statements(d) {
+		if _, err := q.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+
+	_, err = q.ExecContext(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (:version, :applied_at)",
+		sql.Named("version", m.version),
+		sql.Named("applied_at", time.Now().UTC().Format(time.RFC3339)))
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d (%s): %w", m.version, m.name, err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Маркеры найденных слов во фрагменте адреса, который возвращает база данных: управляющие символы
+	// STX и ETX, которые после экранирования фрагмента для HTML заменяются на <mark> и </mark>
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+	// snippetTokens - сколько слов адреса показывается во фрагменте
+	snippetTokens = 12
+	// maxSearchTerms - сколько слов поискового запроса учитывается
+	maxSearchTerms = 10
+	// Количество результатов поиска по умолчанию и наибольшее
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// ErrInvalidSearchQuery - в поисковом запросе нет ни одного слова
+var ErrInvalidSearchQuery = errors.New("search query must contain letters or digits")
+
+// ErrSearchUnavailable - полнотекстовый поиск невозможен: адреса хранятся зашифрованными
+var ErrSearchUnavailable = errors.New("address search is unavailable when addresses are encrypted")
+
+// AddressMatch - посылка, найденная по адресу
+type AddressMatch struct {
+	Parcel  Parcel  `json:"parcel"`
+	Snippet string  `json:"snippet"` // Фрагмент адреса, экранированный для HTML, с найденными словами между <mark> и </mark>
+	Rank    float64 `json:"rank"`    // Релевантность, чем меньше - тем лучше
+}
+
+// searchTerms - слова поискового запроса: последовательности букв и цифр
+func searchTerms(query string) []string {
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// ftsQuery - запрос FTS5, в котором каждое слово ищется как начало слова адреса, а все слова обязательны.
+// Слова состоят только из букв и цифр, поэтому синтаксис FTS5 во вводе пользователя не действует
+func ftsQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = `"` + term + `"*`
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// snippetHTML - экранирует фрагмент адреса для HTML и заменяет маркеры найденных слов на <mark> и </mark>.
+// Адрес вводит пользователь, поэтому кроме тегов выделения во фрагменте нет разметки
+func snippetHTML(snippet string) string {
+	return strings.NewReplacer(snippetOpen, "<mark>", snippetClose, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// tsQuery - запрос tsquery PostgreSQL с тем же смыслом, что и ftsQuery
+func tsQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = "'" + strings.ToLower(term) + "':*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// SearchAddress - метод для полнотекстового поиска посылок по части адреса: названию улицы, города и т. п.
+// Каждое слово запроса ищется как начало слова адреса без учёта регистра, результаты
+// упорядочены по релевантности. client ограничивает поиск посылками клиента, 0 - все посылки.
+// В SQLite используется таблица FTS5 parcel_fts, в PostgreSQL - индекс tsvector
+func (s ParcelStore) SearchAddress(ctx context.Context, query string, client, limit int) (res []AddressMatch, err error) {
+	ctx, op := s.begin(ctx, "search_address", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	// зашифрованные адреса не индексируются
+	if s.cipher != nil {
+		return nil, ErrSearchUnavailable
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	stmt := `SELECT p.number, p.client, p.status, p.address, p.created_at, p.tracking_code, p.service_level, COALESCE(p.eta, ''),
+			snippet(parcel_fts, 0, :open, :close, '…', :tokens), bm25(parcel_fts)
+		FROM parcel_fts JOIN parcel p ON p.number = parcel_fts.rowid
+		WHERE parcel_fts MATCH :query AND (:client = 0 OR p.client = :client)
+		ORDER BY bm25(parcel_fts), p.number LIMIT :limit`
+	args := []any{
+		sql.Named("query", ftsQuery(terms)),
+		sql.Named("open", snippetOpen),
+		sql.Named("close", snippetClose),
+		sql.Named("tokens", snippetTokens),
+		sql.Named("client", client),
+		sql.Named("limit", limit),
+	}
+	if s.dialect == postgresDialect {
+		// ts_rank растёт с релевантностью, поэтому ранг берётся с обратным знаком, как у bm25
+		stmt = `SELECT p.number, p.client, p.status, p.address, p.created_at, p.tracking_code, p.service_level, COALESCE(p.eta, ''),
+				ts_headline('simple', p.address, q, :options), -ts_rank(to_tsvector('simple', p.address), q)
+			FROM parcel p, to_tsquery('simple', :query) q
+			WHERE to_tsvector('simple', p.address) @@ q AND substr(p.address, 1, 7) <> 'enc:v1:' AND (:client = 0 OR p.client = :client)
+			ORDER BY 10, p.number LIMIT :limit`
+		args = []any{
+			sql.Named("query", tsQuery(terms)),
+			sql.Named("options", fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=%d, MinWords=1", snippetOpen, snippetClose, snippetTokens)),
+			sql.Named("client", client),
+			sql.Named("limit", limit),
+		}
+	}
+
+	rows, err := s.reader().QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search parcels by address: error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m AddressMatch
+		if m.Parcel, err = s.scanParcel(rows, &m.Snippet, &m.Rank); err != nil {
+			return nil, fmt.Errorf("row scanning error while searching parcels by address: error: %w", err)
+		}
+		m.Snippet = snippetHTML(m.Snippet)
+		res = append(res, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while searching parcels by address: %w", err)
+	}
+	return res, nil
+}
+
+// compactSearchIndex - объединяет сегменты полнотекстового индекса SQLite. После изменения или удаления адреса
+// FTS5 только помечает прежние слова удалёнными, а из сегментов они пропадают при объединении, поэтому
+// индекс объединяется после стирания адресов. В PostgreSQL индекс очищает VACUUM
+func (s ParcelStore) compactSearchIndex(ctx context.Context) (err error) {
+	if s.dialect == postgresDialect {
+		return nil
+	}
+
+	ctx, op := s.begin(ctx, "compact_search_index", "INSERT")
+	defer func() {
+		op.end(err)
+	}()
+
+	if _, err = s.q.ExecContext(ctx, "INSERT INTO parcel_fts (parcel_fts) VALUES ('optimize')"); err != nil {
+		return fmt.Errorf("failed to compact address search index: error: %w", err)
+	}
+	return nil
+}
+
+// SearchAddress - ищет посылки по части адреса. Клиент находит только свои посылки.
+// limit ограничивается maxSearchLimit, 0 - defaultSearchLimit
+func (s ParcelService) SearchAddress(ctx context.Context, query string, limit int) (matches []AddressMatch, err error) {
+	ctx, op := s.begin(ctx, "search_address")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(matches)))
+	}()
+
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	client := 0
+	if id, ok := IdentityFromContext(ctx); ok && id.Role == RoleClient {
+		client = id.Client
+	}
+	return s.store.SearchAddress(ctx, query, client, limit)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSearchAddress - тест для проверки полнотекстового поиска посылок по части адреса
+func TestSearchAddress(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	register := func(client int, address string) int {
+		p, err := service.RegisterContext(ctx, client, address)
+		require.NoError(t, err)
+		return p.Number
+	}
+	lenina := register(1000, "г. Москва, ул. Ленина, д. 1")
+	ozernaya := register(1000, "г. Санкт-Петербург, ул. Озёрная, д. 5")
+	leninskiy := register(2000, "г. Москва, Ленинский проспект, д. 10")
+	berlin := register(2000, "Berlin, Friedrichstraße 43")
+	cafe := register(2000, "Paris, Cafe de Flore")
+
+	tests := []struct {
+		name   string
+		query  string
+		client int
+		want   []int
+	}{
+		{name: "prefix", query: "ленин", want: []int{lenina, leninskiy}},
+		{name: "all words required", query: "Москва Ленинский", want: []int{leninskiy}},
+		{name: "case insensitive cyrillic", query: "САНКТ-ПЕТЕРБУРГ", want: []int{ozernaya}},
+		{name: "cyrillic yo", query: "ОЗЁРН", want: []int{ozernaya}},
+		{name: "latin diacritics", query: "café", want: []int{cafe}},
+		{name: "latin", query: "friedrich", want: []int{berlin}},
+		{name: "client scope", query: "Москва", client: 2000, want: []int{leninskiy}},
+		{name: "fts syntax is ignored", query: `ленина" OR "berlin`, want: nil},
+		{name: "no matches", query: "Казань", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := store.SearchAddress(ctx, tt.query, tt.client, 10)
+			require.NoError(t, err)
+			var got []int
+			for _, m := range matches {
+				got = append(got, m.Parcel.Number)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	// Найденные слова выделяются во фрагменте адреса, а точное совпадение релевантнее
+	register(1000, "Ленинградская обл., ул. Ленина, Ленина 2")
+	matches, err := store.SearchAddress(ctx, "ленина", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Contains(t, matches[0].Snippet, "<mark>Ленина</mark>")
+	assert.LessOrEqual(t, matches[0].Rank, matches[1].Rank)
+	assert.Equal(t, "г. Москва, ул. <mark>Ленина</mark>, д. 1", snippetOf(matches, lenina))
+
+	// Разметка в адресе экранируется, выделяются только найденные слова
+	script := register(1000, `<script>alert(1)</script> Ленина & "Co"`)
+	matches, err = store.SearchAddress(ctx, "ленина", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "&lt;script&gt;alert(1)&lt;/script&gt; <mark>Ленина</mark> &amp; &#34;Co&#34;", snippetOf(matches, script))
+	require.NoError(t, service.DeleteContext(ctx, script))
+
+	// Индекс следует за изменением адреса, обезличиванием и удалением посылки
+	require.NoError(t, service.ChangeAddressContext(ctx, ozernaya, "г. Казань, ул. Баумана, д. 2"))
+	require.NoError(t, service.DeleteContext(ctx, berlin))
+	require.NoError(t, store.anonymizeParcels(ctx, []int{leninskiy}, time.Now(), false))
+	for query, want := range map[string][]int{"озёрная": nil, "казань": {ozernaya}, "berlin": nil, "проспект": nil} {
+		matches, err := store.SearchAddress(ctx, query, 0, 10)
+		require.NoError(t, err)
+		var got []int
+		for _, m := range matches {
+			got = append(got, m.Parcel.Number)
+		}
+		assert.Equal(t, want, got, query)
+	}
+
+	// Запрос без слов не выполняется
+	_, err = store.SearchAddress(ctx, " ,.-* ", 0, 10)
+	require.ErrorIs(t, err, ErrInvalidSearchQuery)
+
+	// Клиент находит только свои посылки
+	matches, err = service.SearchAddress(WithIdentity(ctx, Identity{Role: RoleClient, Client: 2000}), "ленина", 0)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+// snippetOf - фрагмент адреса посылки number среди найденных
+func snippetOf(matches []AddressMatch, number int) string {
+	for _, m := range matches {
+		if m.Parcel.Number == number {
+			return m.Snippet
+		}
+	}
+	return ""
+}
+
+// indexedAddresses - количество посылок в полнотекстовом индексе, адрес которых содержит слово term
+func indexedAddresses(t *testing.T, store ParcelStore, term string) int {
+	var n int
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM parcel_fts WHERE parcel_fts MATCH ?", `"`+term+`"`).Scan(&n))
+	return n
+}
+
+// TestSearchAddressEncrypted - тест для проверки отказа от поиска по зашифрованным адресам
+// и того, что зашифрованные адреса не попадают в полнотекстовый индекс
+func TestSearchAddressEncrypted(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "tracker.db")
+
+	// Адрес, записанный до включения шифрования, индексируется
+	plain := openEncryptedStore(t, dsn, nil)
+	_, err := plain.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+	assert.Equal(t, 1, indexedAddresses(t, plain, "test"))
+
+	store := openEncryptedStore(t, dsn, newTestCipher(t, testFieldKey("k1", 1)))
+	_, err = store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+	_, err = store.SearchAddress(ctx, "test", 0, 10)
+	require.ErrorIs(t, err, ErrSearchUnavailable)
+
+	// После перешифрования в индексе нет ни открытого адреса, ни шифротекста
+	_, err = store.Reencrypt(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, indexedAddresses(t, store, "test"))
+	assert.Zero(t, indexedAddresses(t, store, "enc"))
+	var docs int
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM parcel_fts_docsize").Scan(&docs))
+	assert.Zero(t, docs)
+}
+
+// TestAPISearchParcels - тест для проверки поиска посылок по адресу через HTTP API
+func TestAPISearchParcels(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	p, err := service.RegisterContext(context.Background(), 1000, "г. Москва, ул. Ленина, д. 1")
+	require.NoError(t, err)
+	operator := "Bearer " + testToken(t, RoleOperator, 0)
+
+	status, body := apiCall(t, http.MethodGet, server.URL+"/parcels/search?q="+url.QueryEscape("ул ленина"), "Authorization", operator, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var matches []AddressMatch
+	require.NoError(t, json.Unmarshal(body, &matches))
+	require.Len(t, matches, 1)
+	assert.Equal(t, p, matches[0].Parcel)
+
+	status, body = apiCall(t, http.MethodGet, server.URL+"/parcels/search?q=%D0%BA%D0%B0%D0%B7%D0%B0%D0%BD%D1%8C", "Authorization", operator, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `[]`, string(body))
+
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels/search?q=", "Authorization", operator, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels/search?q=test&limit=-1", "Authorization", operator, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
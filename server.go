@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// shutdownTimeout - время на завершение активных запросов при остановке сервера
+const shutdownTimeout = 10 * time.Second
+
+// Server - HTTP-интерфейс сервиса посылок
+type Server struct {
+	service ParcelService
+	broker  *EventBroker
+	metrics *Metrics
+	auth    *Authenticator
+	limiter *RateLimiter
+	logger  *slog.Logger
+}
+
+// NewServer - конструктор HTTP-интерфейса. metrics может быть nil, тогда эндпоинт /metrics не регистрируется.
+// auth может быть nil, тогда эндпоинты, требующие аутентификации, отвечают 401.
+// limiter может быть nil, тогда частота запросов не ограничивается
+func NewServer(service ParcelService, broker *EventBroker, metrics *Metrics, auth *Authenticator, limiter *RateLimiter) *Server {
+	return &Server{service: service, broker: broker, metrics: metrics, auth: auth, limiter: limiter, logger: service.logger}
+}
+
+// Handler - маршрутизатор запросов сервера
+func (srv *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// API посылок доступно только после аутентификации
+	mux.Handle("POST /parcels", srv.authenticated(srv.handleRegister))
+	mux.Handle("GET /parcels", srv.authenticated(srv.handleListParcels))
+	mux.Handle("GET /parcels/search", srv.authenticated(srv.handleSearchParcels))
+	mux.Handle("GET /parcels/overdue", srv.authenticated(srv.handleOverdueParcels))
+	mux.Handle("GET /parcels/{number}", srv.authenticated(srv.handleGetParcel))
+	mux.Handle("POST /parcels/{number}/next-status", srv.authenticated(srv.handleNextStatus))
+	mux.Handle("PUT /parcels/{number}/address", srv.authenticated(srv.handleChangeAddress))
+	mux.Handle("DELETE /parcels/{number}", srv.authenticated(srv.handleDeleteParcel))
+	mux.Handle("POST /api-keys", srv.authenticated(srv.handleCreateAPIKey))
+	mux.Handle("DELETE /api-keys/{id}", srv.authenticated(srv.handleRevokeAPIKey))
+	mux.Handle("PUT /clients/{client}/quota", srv.authenticated(srv.handleSetClientQuota))
+	mux.Handle("GET /clients/{client}/export", srv.authenticated(srv.handleExportClient))
+	mux.Handle("POST /clients/{client}/anonymize", srv.authenticated(srv.handleAnonymizeClient))
+	mux.Handle("POST /clients/{client}/webhooks", srv.authenticated(srv.handleCreateWebhook))
+	mux.Handle("GET /clients/{client}/webhooks", srv.authenticated(srv.handleListWebhooks))
+	mux.Handle("DELETE /clients/{client}/webhooks/{id}", srv.authenticated(srv.handleDeleteWebhook))
+	mux.Handle("GET /reports", srv.authenticated(srv.handleReport))
+	mux.Handle("GET /events", srv.authenticated(srv.handleEvents))
+
+	// Публичная страница отслеживания не раскрывает персональные данные
+	mux.HandleFunc("GET /track", srv.handleTrack)
+	mux.Handle("GET /static/", staticHandler())
+	if srv.metrics != nil {
+		mux.Handle("GET /metrics", srv.metrics.Handler())
+	}
+	return srv.traced(mux)
+}
+
+// traced - продолжает трассу вызывающего из заголовков traceparent и baggage запроса,
+// поэтому span операций становятся дочерними span вызывающего
+func (srv *Server) traced(h http.Handler) http.Handler {
+	tracer := srv.service.tracer
+	if tracer == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(tracer.Extract(r.Context(), propagation.HeaderCarrier(r.Header))))
+	})
+}
+
+// authenticated - пропускает к h только аутентифицированные запросы, не превысившие ограничение частоты
+func (srv *Server) authenticated(h http.HandlerFunc) http.Handler {
+	if srv.auth == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeError(w, http.StatusUnauthorized, ErrUnauthenticated.Error())
+		})
+	}
+	return srv.auth.Middleware(srv.rateLimited(h))
+}
+
+// ListenAndServe - обслуживает запросы на адресе addr, пока не отменён ctx, затем корректно останавливает сервер
+func (srv *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		srv.logger.Info("http server started", slog.String("addr", addr))
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("http server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("http server shutdown failed: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http server failed: %w", err)
+	}
+	return nil
+}
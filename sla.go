@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+)
+
+// ServiceLevelStandard - уровень обслуживания посылок по умолчанию
+const ServiceLevelStandard = "standard"
+
+// Состояния посылок, отмеченных проверкой SLA
+const (
+	SLAAtRisk   = "at_risk"  // Срок доставки истекает в пределах SLAPolicy.WarnBefore
+	SLABreached = "breached" // Срок доставки истёк, посылка не доставлена
+)
+
+// slaStartColumns - столбцы таблицы parcel со временем начала отсчёта срока для статусов, с которых он отсчитывается
+var slaStartColumns = map[string]string{
+	ParcelStatusRegistered: "created_at",
+	ParcelStatusSent:       "sent_at",
+}
+
+// SLARule - срок доставки посылок уровня обслуживания: длительность или количество рабочих дней
+type SLARule struct {
+	ServiceLevel string   `yaml:"service_level"`           // Уровень обслуживания посылок, к которым относится правило
+	From         string   `yaml:"from"`                    // Статус, с которого отсчитывается срок: registered или sent
+	Within       Duration `yaml:"within,omitempty"`        // Посылка должна быть доставлена за этот срок
+	BusinessDays int      `yaml:"business_days,omitempty"` // Или до конца рабочего времени через столько рабочих дней
+}
+
+// deadline - срок доставки посылки, отсчёт срока которой начался в момент started
+func (r SLARule) deadline(started time.Time, cal *calendar.Calendar) time.Time {
+	if r.BusinessDays > 0 {
+		return cal.EndOfBusiness(cal.AddBusinessDays(started, r.BusinessDays))
+	}
+	return started.Add(time.Duration(r.Within))
+}
+
+// minWithin - наименьшее время от начала отсчёта до срока доставки. Срок в n рабочих дней наступает не раньше
+// конца n-го календарного дня после начала, то есть не меньше чем через n-1 сутки, в том числе
+// короткие сутки перехода на летнее время
+func (r SLARule) minWithin() time.Duration {
+	if r.BusinessDays > 0 {
+		return time.Duration(r.BusinessDays-1) * 23 * time.Hour
+	}
+	return time.Duration(r.Within)
+}
+
+// SLAPolicy - сроки доставки по уровням обслуживания
+type SLAPolicy struct {
+	Rules      []SLARule
+	WarnBefore time.Duration      // За сколько до истечения срока посылка отмечается как at_risk
+	Calendar   *calendar.Calendar // Производственный календарь для сроков в рабочих днях
+}
+
+// Validate - проверяет правила: по одному правилу на уровень обслуживания, известный статус начала и положительный срок
+func (p SLAPolicy) Validate() error {
+	if p.WarnBefore < 0 {
+		return errors.New("sla warn_before must not be negative")
+	}
+	levels := map[string]bool{}
+	for _, rule := range p.Rules {
+		if rule.ServiceLevel == "" {
+			return errors.New("sla rule service level is required")
+		}
+		if levels[rule.ServiceLevel] {
+			return fmt.Errorf("duplicate sla rule for service level %q", rule.ServiceLevel)
+		}
+		levels[rule.ServiceLevel] = true
+		if _, ok := slaStartColumns[rule.From]; !ok {
+			return fmt.Errorf("sla rule for %q must start from %s or %s, got %q", rule.ServiceLevel, ParcelStatusRegistered, ParcelStatusSent, rule.From)
+		}
+		if (rule.Within > 0) == (rule.BusinessDays > 0) || rule.Within < 0 || rule.BusinessDays < 0 {
+			return fmt.Errorf("sla rule for %q must have either positive within or positive business_days", rule.ServiceLevel)
+		}
+		if rule.BusinessDays > 0 && p.Calendar == nil {
+			return fmt.Errorf("sla rule for %q in business days requires a calendar", rule.ServiceLevel)
+		}
+	}
+	return nil
+}
+
+// DefaultSLAPolicy - сроки по умолчанию: посылка стандартного уровня доставляется за 5 дней после отправки,
+// предупреждение - за сутки до истечения срока, рабочие дни - с понедельника по пятницу в UTC
+func DefaultSLAPolicy() SLAPolicy {
+	return SLAPolicy{
+		Rules:      []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, Within: Duration(5 * 24 * time.Hour)}},
+		WarnBefore: 24 * time.Hour,
+		Calendar:   calendar.New(time.UTC),
+	}
+}
+
+// SLAParcel - недоставленная посылка с истекающим или истекшим сроком доставки
+type SLAParcel struct {
+	Parcel       Parcel `json:"parcel"`
+	ServiceLevel string `json:"service_level"`
+	State        string `json:"state"`      // at_risk или breached
+	StartedAt    string `json:"started_at"` // Начало отсчёта срока
+	Deadline     string `json:"deadline"`   // Срок доставки
+}
+
+// SLAReport - результат проверки сроков доставки
+type SLAReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	AtRisk    []int     `json:"at_risk"`  // Посылки, впервые отмеченные как at_risk
+	Breached  []int     `json:"breached"` // Посылки, впервые отмеченные как breached
+	Resolved  int       `json:"resolved"` // Сняты отметки с доставленных и удалённых посылок
+}
+
+// WriteJSON - записывает отчёт одной строкой JSON
+func (r SLAReport) WriteJSON(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		return fmt.Errorf("failed to write sla report: error: %w", err)
+	}
+	return nil
+}
+
+// slaParcels - недоставленные посылки уровня rule.ServiceLevel, срок которых начался не позже startedBefore,
+// в порядке начала срока. client ограничивает выборку посылками клиента, 0 - все посылки
+func (s ParcelStore) slaParcels(ctx context.Context, rule SLARule, cal *calendar.Calendar, startedBefore, now time.Time, warnBefore time.Duration, client int) (res []SLAParcel, err error) {
+	ctx, op := s.begin(ctx, "sla_parcels", "SELECT")
+	defer func() {
+		op.end(err, slog.String("service_level", rule.ServiceLevel), slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	start := slaStartColumns[rule.From]
+	rows, err := s.reader().QueryContext(ctx, "SELECT "+parcelColumns+", "+start+" FROM parcel WHERE service_level = :level AND status <> :delivered AND "+start+" IS NOT NULL AND "+start+" <= :before AND (:client = 0 OR client = :client) ORDER BY "+start+", number",
+		sql.Named("level", rule.ServiceLevel),
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("before", startedBefore.UTC().Format(time.RFC3339)),
+		sql.Named("client", client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to select parcels for sla %q: error: %w", rule.ServiceLevel, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sp := SLAParcel{ServiceLevel: rule.ServiceLevel}
+		if sp.Parcel, err = s.scanParcel(rows, &sp.StartedAt); err != nil {
+			return nil, fmt.Errorf("row scanning error while selecting parcels for sla %q: error: %w", rule.ServiceLevel, err)
+		}
+		started, err := time.Parse(time.RFC3339, sp.StartedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sla start time %q of parcel %d: %w", sp.StartedAt, sp.Parcel.Number, err)
+		}
+		deadline := rule.deadline(started, cal)
+		sp.Deadline = deadline.UTC().Format(time.RFC3339)
+		switch {
+		case now.After(deadline):
+			sp.State = SLABreached
+		case now.Add(warnBefore).After(deadline):
+			sp.State = SLAAtRisk
+		default:
+			continue
+		}
+		res = append(res, sp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while selecting parcels for sla %q: %w", rule.ServiceLevel, err)
+	}
+	return res, nil
+}
+
+// CheckSLA - проверяет сроки доставки на момент now: отмечает в таблице parcel_sla_flag посылки с истекающим
+// (at_risk) и истекшим (breached) сроком и записывает в outbox событие о каждой новой отметке, откуда его получают
+// приёмники уведомлений. Посылка, уже отмеченная в том же состоянии, повторно не уведомляется.
+// Отметки доставленных и удалённых посылок снимаются
+func (s ParcelStore) CheckSLA(ctx context.Context, policy SLAPolicy, now time.Time) (report SLAReport, err error) {
+	ctx, op := s.begin(ctx, "check_sla", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int("at_risk", len(report.AtRisk)), slog.Int("breached", len(report.Breached)), slog.Int("resolved", report.Resolved))
+	}()
+
+	report = SLAReport{CheckedAt: now.UTC(), AtRisk: []int{}, Breached: []int{}}
+	for _, rule := range policy.Rules {
+		err = s.InTx(ctx, func(tx ParcelStore) error {
+			parcels, err := tx.slaParcels(ctx, rule, policy.Calendar, now.Add(policy.WarnBefore-rule.minWithin()), now, policy.WarnBefore, 0)
+			if err != nil {
+				return err
+			}
+			for _, sp := range parcels {
+				flagged, err := tx.flagSLA(ctx, sp, now)
+				if err != nil {
+					return err
+				}
+				if !flagged {
+					continue
+				}
+				if sp.State == SLABreached {
+					report.Breached = append(report.Breached, sp.Parcel.Number)
+				} else {
+					report.AtRisk = append(report.AtRisk, sp.Parcel.Number)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	res, err := s.q.ExecContext(ctx, "DELETE FROM parcel_sla_flag WHERE parcel NOT IN (SELECT number FROM parcel WHERE status <> :delivered)",
+		sql.Named("delivered", ParcelStatusDelivered))
+	if err != nil {
+		return report, fmt.Errorf("failed to resolve sla flags: error: %w", err)
+	}
+	resolved, err := res.RowsAffected()
+	if err != nil {
+		return report, fmt.Errorf("failed to resolve sla flags: error: %w", err)
+	}
+	report.Resolved = int(resolved)
+	return report, nil
+}
+
+// flagSLA - отмечает посылку в состоянии sp.State и записывает событие, если посылка ещё не была отмечена
+// в этом состоянии. Возвращает true, если отметка добавлена или изменена
+func (s ParcelStore) flagSLA(ctx context.Context, sp SLAParcel, now time.Time) (bool, error) {
+	var state string
+	err := s.q.QueryRowContext(ctx, "SELECT state FROM parcel_sla_flag WHERE parcel = :parcel", sql.Named("parcel", sp.Parcel.Number)).Scan(&state)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to retrieve sla flag of parcel %d: error: %w", sp.Parcel.Number, err)
+	}
+	if state == sp.State {
+		return false, nil
+	}
+
+	_, err = s.q.ExecContext(ctx, `INSERT INTO parcel_sla_flag (parcel, service_level, state, deadline, flagged_at) VALUES (:parcel, :service_level, :state, :deadline, :flagged_at)
+		ON CONFLICT (parcel) DO UPDATE SET service_level = excluded.service_level, state = excluded.state, deadline = excluded.deadline, flagged_at = excluded.flagged_at`,
+		sql.Named("parcel", sp.Parcel.Number),
+		sql.Named("service_level", sp.ServiceLevel),
+		sql.Named("state", sp.State),
+		sql.Named("deadline", sp.Deadline),
+		sql.Named("flagged_at", now.UTC().Format(time.RFC3339)))
+	if err != nil {
+		return false, fmt.Errorf("failed to flag parcel %d as %s: error: %w", sp.Parcel.Number, sp.State, err)
+	}
+
+	eventType := EventSLAAtRisk
+	if sp.State == SLABreached {
+		eventType = EventSLABreached
+	}
+	event := newParcelEvent(eventType, sp.Parcel, "")
+	event.Deadline = sp.Deadline
+	if _, err := s.AppendEvent(ctx, event); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// OverdueParcels - метод для получения недоставленных посылок с истекшим на момент now сроком доставки,
+// от самых просроченных. client ограничивает выборку посылками клиента, 0 - все посылки
+func (s ParcelStore) OverdueParcels(ctx context.Context, policy SLAPolicy, now time.Time, client int) ([]SLAParcel, error) {
+	res := []SLAParcel{}
+	for _, rule := range policy.Rules {
+		parcels, err := s.slaParcels(ctx, rule, policy.Calendar, now.Add(-rule.minWithin()), now, 0, client)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, parcels...)
+	}
+	// у правил разные сроки, поэтому общий порядок - по сроку доставки
+	slices.SortStableFunc(res, func(a, b SLAParcel) int {
+		return strings.Compare(a.Deadline, b.Deadline)
+	})
+	return res, nil
+}
+
+// OverdueParcels - возвращает недоставленные посылки с истекшим сроком доставки. Клиент получает только свои посылки
+func (s ParcelService) OverdueParcels(ctx context.Context) (parcels []SLAParcel, err error) {
+	ctx, op := s.begin(ctx, "overdue_parcels")
+	defer func() {
+		op.end(err, slog.Int(attrCount, len(parcels)))
+	}()
+
+	client := 0
+	if id, ok := IdentityFromContext(ctx); ok && id.Role == RoleClient {
+		client = id.Client
+	}
+	return s.store.OverdueParcels(ctx, s.slaPolicy, time.Now(), client)
+}
+
+// SLACheckJob - задача планировщика, проверяющая сроки доставки. Краткий итог проверки записывается в журнал
+func SLACheckJob(store ParcelStore, policy SLAPolicy) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		r, err := store.CheckSLA(ctx, policy, time.Now())
+		if err != nil {
+			return err
+		}
+		if len(r.AtRisk) > 0 || len(r.Breached) > 0 {
+			store.logger.Warn("parcels flagged by sla check", slog.Int("at_risk", len(r.AtRisk)), slog.Int("breached", len(r.Breached)))
+		}
+		return nil
+	}
+}
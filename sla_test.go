@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSLAPolicy - стандартные посылки доставляются за 5 дней после отправки, срочные - за сутки после регистрации
+func testSLAPolicy() SLAPolicy {
+	return SLAPolicy{
+		Rules: []SLARule{
+			{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, Within: Duration(5 * 24 * time.Hour)},
+			{ServiceLevel: "express", From: ParcelStatusRegistered, Within: Duration(24 * time.Hour)},
+		},
+		WarnBefore: 24 * time.Hour,
+	}
+}
+
+// TestCheckSLA - тест для проверки отметки посылок с истекающим и истекшим сроком доставки
+func TestCheckSLA(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	day := 24 * time.Hour
+
+	// sent - регистрирует отправленную age назад стандартную посылку клиента
+	sent := func(client int, age time.Duration) int {
+		p, err := service.RegisterContext(ctx, client, "test")
+		require.NoError(t, err)
+		require.NoError(t, service.NextStatusContext(ctx, p.Number))
+		_, err = store.db.ExecContext(ctx, "UPDATE parcel SET sent_at = ? WHERE number = ?", now.Add(-age).Format(time.RFC3339), p.Number)
+		require.NoError(t, err)
+		return p.Number
+	}
+	atRisk := sent(1000, 4*day+12*time.Hour)
+	breached := sent(1000, 6*day)
+	sent(1000, day)
+	// зарегистрированная стандартная посылка без отправки сроком не ограничена
+	_, err := service.RegisterContext(ctx, 1000, "test")
+	require.NoError(t, err)
+	express, err := service.RegisterContext(ctx, 2000, "test")
+	require.NoError(t, err)
+	_, err = store.db.ExecContext(ctx, "UPDATE parcel SET service_level = ?, created_at = ? WHERE number = ?",
+		"express", now.Add(-3*day).Format(time.RFC3339), express.Number)
+	require.NoError(t, err)
+
+	policy := testSLAPolicy()
+	report, err := store.CheckSLA(ctx, policy, now)
+	require.NoError(t, err)
+	assert.Equal(t, []int{atRisk}, report.AtRisk)
+	assert.Equal(t, []int{breached, express.Number}, report.Breached)
+
+	// О каждой отметке записано событие со сроком доставки
+	events, err := store.GetPendingEvents(ctx, 100)
+	require.NoError(t, err)
+	deadlines := map[int]string{}
+	for _, e := range events {
+		switch e.Type {
+		case EventSLAAtRisk, EventSLABreached:
+			deadlines[e.Number] = e.Deadline
+		}
+	}
+	assert.Equal(t, map[int]string{
+		atRisk:         now.Add(12 * time.Hour).Format(time.RFC3339),
+		breached:       now.Add(-day).Format(time.RFC3339),
+		express.Number: now.Add(-2 * day).Format(time.RFC3339),
+	}, deadlines)
+
+	// Повторная проверка не отмечает посылки заново
+	report, err = store.CheckSLA(ctx, policy, now)
+	require.NoError(t, err)
+	assert.Empty(t, report.AtRisk)
+	assert.Empty(t, report.Breached)
+
+	// Посылка с истекающим сроком становится просроченной
+	later := now.Add(13 * time.Hour)
+	report, err = store.CheckSLA(ctx, policy, later)
+	require.NoError(t, err)
+	assert.Empty(t, report.AtRisk)
+	assert.Equal(t, []int{atRisk}, report.Breached)
+
+	// Просроченные посылки упорядочены по сроку доставки, клиент видит только свои
+	overdue, err := store.OverdueParcels(ctx, policy, later, 0)
+	require.NoError(t, err)
+	var got []int
+	for _, sp := range overdue {
+		assert.Equal(t, SLABreached, sp.State)
+		got = append(got, sp.Parcel.Number)
+	}
+	assert.Equal(t, []int{express.Number, breached, atRisk}, got)
+	overdue, err = NewParcelService(store, WithOutput(io.Discard), WithSLAPolicy(policy)).
+		OverdueParcels(WithIdentity(ctx, Identity{Role: RoleClient, Client: 2000}))
+	require.NoError(t, err)
+	require.Len(t, overdue, 1)
+	assert.Equal(t, express.Number, overdue[0].Parcel.Number)
+
+	// Отметка доставленной посылки снимается
+	require.NoError(t, service.NextStatusContext(ctx, breached))
+	report, err = store.CheckSLA(ctx, policy, later)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Resolved)
+	var flags int
+	require.NoError(t, store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM parcel_sla_flag").Scan(&flags))
+	assert.Equal(t, 2, flags)
+}
+
+// TestCheckSLABusinessDays - тест для проверки сроков доставки в рабочих днях: срок истекает в конце рабочего
+// времени последнего дня, праздники и выходные не считаются
+func TestCheckSLABusinessDays(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+	cal := testCalendar(t)
+	msk := cal.Location()
+	policy := SLAPolicy{
+		Rules:      []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, BusinessDays: 2}},
+		WarnBefore: 24 * time.Hour,
+		Calendar:   cal,
+	}
+
+	// sent - регистрирует посылку, отправленную в 10:00 по Москве дня date
+	sent := func(date string) int {
+		p, err := service.RegisterContext(ctx, 1000, "test")
+		require.NoError(t, err)
+		require.NoError(t, service.NextStatusContext(ctx, p.Number))
+		at, err := time.ParseInLocation("2006-01-02 15:04", date+" 10:00", msk)
+		require.NoError(t, err)
+		_, err = store.db.ExecContext(ctx, "UPDATE parcel SET sent_at = ? WHERE number = ?", at.UTC().Format(time.RFC3339), p.Number)
+		require.NoError(t, err)
+		return p.Number
+	}
+	// срок - 11 июня, 18:00 по Москве
+	breached := sent("2025-06-09")
+	// 12 июня - праздник, 14 и 15 - выходные: срок - 16 июня, 18:00 по Москве
+	atRisk := sent("2025-06-11")
+	// срок - 17 июня, 18:00 по Москве
+	sent("2025-06-13")
+
+	report, err := store.CheckSLA(ctx, policy, time.Date(2025, 6, 16, 14, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []int{atRisk}, report.AtRisk)
+	assert.Equal(t, []int{breached}, report.Breached)
+
+	var deadline string
+	require.NoError(t, store.db.QueryRowContext(ctx, "SELECT deadline FROM parcel_sla_flag WHERE parcel = ?", atRisk).Scan(&deadline))
+	assert.Equal(t, "2025-06-16T15:00:00Z", deadline)
+}
+
+// TestSentAt - тест для проверки записи времени отправки посылки
+func TestSentAt(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	p, err := service.RegisterContext(ctx, 1000, "test")
+	require.NoError(t, err)
+	sentAt := func() *string {
+		var v *string
+		require.NoError(t, store.db.QueryRowContext(ctx, "SELECT sent_at FROM parcel WHERE number = ?", p.Number).Scan(&v))
+		return v
+	}
+	assert.Nil(t, sentAt())
+
+	require.NoError(t, service.NextStatusContext(ctx, p.Number))
+	first := sentAt()
+	require.NotNil(t, first)
+
+	// при доставке время отправки сохраняется
+	_, err = store.db.ExecContext(ctx, "UPDATE parcel SET sent_at = ? WHERE number = ?", "2025-01-01T00:00:00Z", p.Number)
+	require.NoError(t, err)
+	require.NoError(t, service.NextStatusContext(ctx, p.Number))
+	assert.Equal(t, "2025-01-01T00:00:00Z", *sentAt())
+}
+
+// TestSLAPolicyValidate - тест для проверки правил сроков доставки
+func TestSLAPolicyValidate(t *testing.T) {
+	day := Duration(24 * time.Hour)
+	tests := []struct {
+		name    string
+		policy  SLAPolicy
+		wantErr bool
+	}{
+		{name: "default", policy: DefaultSLAPolicy()},
+		{name: "no rules", policy: SLAPolicy{}},
+		{name: "two levels", policy: testSLAPolicy()},
+		{name: "negative warn", policy: SLAPolicy{WarnBefore: -time.Hour}, wantErr: true},
+		{name: "empty level", policy: SLAPolicy{Rules: []SLARule{{From: ParcelStatusSent, Within: day}}}, wantErr: true},
+		{name: "duplicate level", policy: SLAPolicy{Rules: []SLARule{
+			{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, Within: day},
+			{ServiceLevel: ServiceLevelStandard, From: ParcelStatusRegistered, Within: day},
+		}}, wantErr: true},
+		{name: "from delivered", policy: SLAPolicy{Rules: []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusDelivered, Within: day}}}, wantErr: true},
+		{name: "zero within", policy: SLAPolicy{Rules: []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent}}}, wantErr: true},
+		{name: "business days", policy: SLAPolicy{Rules: []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, BusinessDays: 3}}, Calendar: calendar.New(time.UTC)}},
+		{name: "business days without calendar", policy: SLAPolicy{Rules: []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, BusinessDays: 3}}}, wantErr: true},
+		{name: "within and business days", policy: SLAPolicy{Rules: []SLARule{{ServiceLevel: ServiceLevelStandard, From: ParcelStatusSent, Within: day, BusinessDays: 3}}, Calendar: calendar.New(time.UTC)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+// TestAPIOverdueParcels - тест для проверки списка просроченных посылок через HTTP API
+func TestAPIOverdueParcels(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard), WithSLAPolicy(testSLAPolicy()))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	ctx := context.Background()
+	for _, client := range []int{1000, 2000} {
+		p, err := service.RegisterContext(ctx, client, "test")
+		require.NoError(t, err)
+		require.NoError(t, service.NextStatusContext(ctx, p.Number))
+		_, err = store.db.ExecContext(ctx, "UPDATE parcel SET sent_at = ? WHERE number = ?",
+			time.Now().AddDate(0, 0, -6).UTC().Format(time.RFC3339), p.Number)
+		require.NoError(t, err)
+	}
+
+	status, body := apiCall(t, http.MethodGet, server.URL+"/parcels/overdue", "Authorization", "Bearer "+testToken(t, RoleOperator, 0), nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var overdue []SLAParcel
+	require.NoError(t, json.Unmarshal(body, &overdue))
+	assert.Len(t, overdue, 2)
+
+	status, body = apiCall(t, http.MethodGet, server.URL+"/parcels/overdue", "Authorization", "Bearer "+testToken(t, RoleClient, 2000), nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.NoError(t, json.Unmarshal(body, &overdue))
+	require.Len(t, overdue, 1)
+	assert.Equal(t, 2000, overdue[0].Parcel.Client)
+	assert.Equal(t, SLABreached, overdue[0].State)
+
+	status, _ = apiCall(t, http.MethodGet, server.URL+"/parcels/overdue", "", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenParcelStore - открывает базу данных по конфигурации cfg, применяет миграции и возвращает хранилище.
+// Строка подключения postgres:// выбирает PostgreSQL, любая другая - файл SQLite.
+// SQLite допускает одного писателя, поэтому запись идёт через пул из одного подключения: конкурирующие
+// запросы ждут своей очереди в database/sql, а не получают "database is locked". Транзакции начинаются
+// с BEGIN IMMEDIATE, чтобы писатель из другого процесса не мог перехватить блокировку посреди транзакции.
+// Чтение идёт через отдельный пул подключений с PRAGMA query_only, в режиме WAL писатель их не блокирует
+func OpenParcelStore(ctx context.Context, cfg DatabaseConfig, opts ...Option) (ParcelStore, error) {
+	if cfg.postgres() {
+		return openPostgres(ctx, cfg, opts...)
+	}
+
+	db, err := sql.Open("sqlite", cfg.dataSource(cfg.Pragmas, "_txlock=immediate"))
+	if err != nil {
+		return ParcelStore{}, fmt.Errorf("failed to open database %s: error: %w", cfg.DSN, err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return ParcelStore{}, fmt.Errorf("failed to migrate database %s: error: %w", cfg.DSN, err)
+	}
+
+	store := NewParcelStore(db, opts...)
+	// у базы в памяти каждое подключение видит свою базу, отдельный пул чтения для неё невозможен
+	if cfg.inMemory() {
+		return store, nil
+	}
+
+	read, err := sql.Open("sqlite", cfg.dataSource(cfg.readPragmas()))
+	if err != nil {
+		db.Close()
+		return ParcelStore{}, fmt.Errorf("failed to open database %s for reading: error: %w", cfg.DSN, err)
+	}
+	read.SetMaxOpenConns(cfg.MaxReadConns)
+	read.SetMaxIdleConns(cfg.MaxReadConns)
+
+	store.read = read
+	return store, nil
+}
+
+// Close - закрывает пулы подключений хранилища, открытого OpenParcelStore
+func (s ParcelStore) Close() error {
+	err := s.db.Close()
+	if s.read != nil {
+		err = errors.Join(err, s.read.Close())
+	}
+	return err
+}
+
+// inMemory - база данных находится в памяти и существует, пока открыто подключение
+func (d DatabaseConfig) inMemory() bool {
+	return strings.Contains(d.DSN, ":memory:") || strings.Contains(d.DSN, "mode=memory")
+}
+
+// readPragmas - PRAGMA подключений для чтения: режим журнала задаёт писатель, изменения запрещены
+func (d DatabaseConfig) readPragmas() map[string]string {
+	pragmas := maps.Clone(d.Pragmas)
+	if pragmas == nil {
+		pragmas = map[string]string{}
+	}
+	delete(pragmas, "journal_mode")
+	pragmas["query_only"] = "1"
+	return pragmas
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestStore - открывает хранилище во временной базе с настройками по умолчанию
+func openTestStore(t *testing.T) ParcelStore {
+	cfg := DefaultConfig().Database
+	cfg.DSN = filepath.Join(t.TempDir(), "tracker.db")
+
+	store, err := OpenParcelStore(context.Background(), cfg, WithOutput(io.Discard))
+	require.NoError(t, err, "failed to open store. Error: %v", err)
+	t.Cleanup(func() {
+		assert.NoError(t, store.Close())
+	})
+	return store
+}
+
+// TestOpenParcelStore - тест для проверки PRAGMA пулов записи и чтения
+func TestOpenParcelStore(t *testing.T) {
+	store := openTestStore(t)
+	ctx := context.Background()
+
+	var mode string
+	require.NoError(t, store.db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	var foreignKeys, synchronous, busyTimeout int
+	require.NoError(t, store.db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys))
+	require.NoError(t, store.db.QueryRowContext(ctx, "PRAGMA synchronous").Scan(&synchronous))
+	require.NoError(t, store.db.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout))
+	assert.Equal(t, 1, foreignKeys)
+	assert.Equal(t, 1, synchronous) // NORMAL
+	assert.Equal(t, 5000, busyTimeout)
+
+	// Пул чтения не может изменять данные
+	_, err := store.read.ExecContext(ctx, "DELETE FROM parcel")
+	require.Error(t, err)
+
+	// Записанное через пул записи сразу видно через пул чтения
+	number, err := store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+	_, err = store.GetContext(ctx, number)
+	require.NoError(t, err)
+}
+
+// TestParcelStoreConcurrentWrites - нагрузочный тест: одновременные запись и чтение из многих горутин
+// не должны приводить к ошибкам "database is locked"
+func TestParcelStoreConcurrentWrites(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	ctx := context.Background()
+
+	const (
+		workers = 32
+		parcels = 20
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*parcels)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			for i := 0; i < parcels; i++ {
+				p := getTestParcel()
+				p.Client = client
+				number, err := store.AddContext(ctx, p)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if err := store.SetStatusContext(ctx, number, ParcelStatusSent); err != nil {
+					errs <- err
+				}
+				// транзакции сервиса конкурируют с одиночными запросами
+				if _, err := service.RegisterContext(ctx, client, "test"); err != nil {
+					errs <- err
+				}
+				if _, err := store.GetByClientContext(ctx, client); err != nil {
+					errs <- err
+				}
+			}
+		}(1000 + w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	counts, err := store.CountByStatusContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, workers*parcels, counts[ParcelStatusSent])
+	assert.Equal(t, workers*parcels, counts[ParcelStatusRegistered])
+}
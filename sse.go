@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sseEventTypes - типы событий, которые по умолчанию передаются в потоке обновлений посылок
+var sseEventTypes = []string{EventStatusChanged, EventAddressChanged}
+
+// Параметры потока Server-Sent Events
+const (
+	sseBufferSize        = 64               // Размер буфера событий одного подписчика
+	sseHistoryBatch      = 100              // Количество событий истории, читаемых за один запрос
+	sseHeartbeatInterval = 15 * time.Second // Интервал комментариев, поддерживающих соединение
+)
+
+// EventBroker - приёмник событий, раздающий их подписчикам внутри процесса.
+// Подписчик, не успевающий читать события, отключается: клиент переподключится
+// с Last-Event-ID и получит пропущенные события из истории
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscription]struct{}
+}
+
+// eventSubscription - подписка на события, подходящие под фильтр
+type eventSubscription struct {
+	filter EventFilter
+	events chan ParcelEvent
+}
+
+// NewEventBroker - конструктор брокера событий
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: map[*eventSubscription]struct{}{}}
+}
+
+// Subscribe - подписывает на события, подходящие под фильтр.
+// Канал закрывается при отписке или отключении медленного подписчика
+func (b *EventBroker) Subscribe(filter EventFilter) (<-chan ParcelEvent, func()) {
+	sub := &eventSubscription{filter: filter, events: make(chan ParcelEvent, sseBufferSize)}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub.events, func() { b.remove(sub) }
+}
+
+// remove - удаляет подписку и закрывает её канал
+func (b *EventBroker) remove(sub *eventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Publish - передаёт событие всем подходящим подписчикам без ожидания
+func (b *EventBroker) Publish(_ context.Context, event ParcelEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return nil
+}
+
+// handleEvents - поток обновлений посылки (?parcel=N) или всех посылок клиента (?client=N) в формате Server-Sent Events.
+// При переподключении клиент передаёт Last-Event-ID и сначала получает пропущенные события из истории
+func (srv *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Клиент получает события только своих посылок
+	filter, err = scopeEventFilter(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	lastID, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Подписка оформляется до чтения истории, чтобы не потерять события между ними
+	live, unsubscribe := srv.broker.Subscribe(filter)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	err = followEvents(ctx, srv.service, live, filter, lastID,
+		func(event ParcelEvent) error {
+			if err := writeSSE(w, event); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+		func() error {
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		})
+	// Отключённый брокером подписчик переподключится с Last-Event-ID
+	if err != nil && !errors.Is(err, errSubscriberDropped) && ctx.Err() == nil {
+		srv.logger.ErrorContext(ctx, "event stream failed", slog.Any(attrError, err))
+	}
+}
+
+// errSubscriberDropped - подписчик отключён брокером, потому что не успевал читать события
+var errSubscriberDropped = errors.New("event subscriber is too slow and was dropped")
+
+// followEvents - передаёт в send события, подходящие под фильтр, начиная с события после lastID:
+// сначала пропущенные события из истории, затем новые события подписки live, пока не отменён ctx.
+// Подписка должна быть оформлена до вызова, чтобы не потерять события между историей и live.
+// heartbeat, если задан, вызывается каждые sseHeartbeatInterval для поддержания соединения
+func followEvents(ctx context.Context, service ParcelService, live <-chan ParcelEvent, filter EventFilter, lastID int64,
+	send func(ParcelEvent) error, heartbeat func() error) error {
+	for {
+		history, err := service.EventHistory(ctx, lastID, filter, sseHistoryBatch)
+		if err != nil {
+			return fmt.Errorf("failed to read event history: %w", err)
+		}
+		for _, event := range history {
+			if err := send(event); err != nil {
+				return err
+			}
+			lastID = event.ID
+		}
+		if len(history) < sseHistoryBatch {
+			break
+		}
+	}
+
+	var ticks <-chan time.Time
+	if heartbeat != nil {
+		ticker := time.NewTicker(sseHeartbeatInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticks:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		case event, ok := <-live:
+			if !ok {
+				return errSubscriberDropped
+			}
+			// События, уже отправленные из истории, пропускаются
+			if event.ID <= lastID {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+			lastID = event.ID
+		}
+	}
+}
+
+// parseEventFilter - читает фильтр потока из параметров запроса. Должен быть задан parcel или client
+func parseEventFilter(r *http.Request) (EventFilter, error) {
+	filter := EventFilter{Types: sseEventTypes}
+
+	var err error
+	if v := r.URL.Query().Get("parcel"); v != "" {
+		if filter.Parcel, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid parcel number %q", v)
+		}
+	}
+	if v := r.URL.Query().Get("client"); v != "" {
+		if filter.Client, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid client id %q", v)
+		}
+	}
+	if filter.Parcel == 0 && filter.Client == 0 {
+		return filter, fmt.Errorf("parcel or client query parameter is required")
+	}
+	return filter, nil
+}
+
+// parseLastEventID - читает номер последнего полученного события из заголовка Last-Event-ID
+// или параметра last_event_id (для клиентов, которые не могут задать заголовок)
+func parseLastEventID(r *http.Request) (int64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Last-Event-ID %q", v)
+	}
+	return id, nil
+}
+
+// writeSSE - записывает событие в формате Server-Sent Events
+func writeSSE(w http.ResponseWriter, event ParcelEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
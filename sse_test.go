@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSSE - читает из потока одно событие Server-Sent Events, пропуская комментарии
+func readSSE(t *testing.T, r *bufio.Reader) (string, ParcelEvent) {
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err, "failed to read event stream. Error: %v", err)
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "" && data != "":
+			var event ParcelEvent
+			require.NoError(t, json.Unmarshal([]byte(data), &event))
+			return id, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// openStream - открывает поток событий и возвращает читатель тела ответа
+func openStream(t *testing.T, url, token, lastEventID string) (*bufio.Reader, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "failed to open event stream. Error: %v", err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	return bufio.NewReader(resp.Body), func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+// TestEventStream - тест для проверки потока обновлений посылки и возобновления по Last-Event-ID
+func TestEventStream(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	broker := NewEventBroker()
+	dispatcher := NewOutboxDispatcher(store, DefaultOutboxConfig(), broker)
+
+	server := httptest.NewServer(NewServer(service, broker, nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+	token := testToken(t, RoleClient, 1000)
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.ChangeAddress(parcel.Number, "new test address"))
+	dispatchAll(t, dispatcher)
+
+	url := fmt.Sprintf("%s/events?parcel=%d", server.URL, parcel.Number)
+	stream, closeStream := openStream(t, url, token, "")
+
+	// Событие из истории: регистрация в поток не входит, смена адреса - входит
+	addressID, event := readSSE(t, stream)
+	assert.Equal(t, EventAddressChanged, event.Type)
+	assert.Equal(t, "new test address", event.Address)
+
+	// Новое событие приходит в открытый поток
+	require.NoError(t, service.NextStatus(parcel.Number))
+	dispatchAll(t, dispatcher)
+
+	statusID, event := readSSE(t, stream)
+	assert.Equal(t, EventStatusChanged, event.Type)
+	assert.Equal(t, ParcelStatusSent, event.Status)
+	closeStream()
+
+	// При переподключении с Last-Event-ID поток продолжается со следующего события
+	stream, closeStream = openStream(t, url, token, addressID)
+	defer closeStream()
+
+	id, event := readSSE(t, stream)
+	assert.Equal(t, statusID, id)
+	assert.Equal(t, EventStatusChanged, event.Type)
+}
+
+// TestEventStreamBadRequest - тест для проверки обязательных параметров потока и прав доступа
+func TestEventStreamBadRequest(t *testing.T) {
+	server := httptest.NewServer(NewServer(ParcelService{}, NewEventBroker(), nil, NewAuthenticator(ParcelStore{}, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		query  string
+		token  string
+		status int
+	}{
+		{name: "no filter", query: "", token: testToken(t, RoleOperator, 0), status: http.StatusBadRequest},
+		{name: "invalid parcel", query: "?parcel=abc", token: testToken(t, RoleOperator, 0), status: http.StatusBadRequest},
+		{name: "invalid last event id", query: "?client=1&last_event_id=x", token: testToken(t, RoleOperator, 0), status: http.StatusBadRequest},
+		{name: "no credentials", query: "?client=1", token: "", status: http.StatusUnauthorized},
+		{name: "foreign client", query: "?client=1", token: testToken(t, RoleClient, 2), status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/events"+tt.query, nil)
+			require.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
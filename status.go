@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// parcelStatuses - известные статусы посылки в порядке жизненного цикла
+var parcelStatuses = []string{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered}
+
+// StatusRules - правила жизненного цикла посылки
+type StatusRules struct {
+	Transitions map[string]string `yaml:"transitions"` // Следующий статус для каждого статуса, кроме конечного
+	Editable    []string          `yaml:"editable"`    // Статусы, в которых можно менять адрес и удалять посылку
+}
+
+// DefaultStatusRules - правила по умолчанию: registered -> sent -> delivered, изменять можно только зарегистрированную посылку
+func DefaultStatusRules() StatusRules {
+	return StatusRules{
+		Transitions: map[string]string{
+			ParcelStatusRegistered: ParcelStatusSent,
+			ParcelStatusSent:       ParcelStatusDelivered,
+		},
+		Editable: []string{ParcelStatusRegistered},
+	}
+}
+
+// Validate - проверяет, что правила используют только известные статусы, а переходы ведут
+// из статуса registered в конечный статус delivered без циклов
+func (r StatusRules) Validate() error {
+	for from, to := range r.Transitions {
+		if !slices.Contains(parcelStatuses, from) || !slices.Contains(parcelStatuses, to) {
+			return fmt.Errorf("invalid status transition %s -> %s: unknown status", from, to)
+		}
+	}
+	for _, status := range r.Editable {
+		if !slices.Contains(parcelStatuses, status) {
+			return fmt.Errorf("invalid editable status %q: unknown status", status)
+		}
+	}
+	if _, ok := r.Transitions[ParcelStatusDelivered]; ok {
+		return fmt.Errorf("status %s is final and cannot have a transition", ParcelStatusDelivered)
+	}
+
+	// Цепочка переходов от registered должна закончиться в delivered, каждый статус проходится не больше одного раза
+	status := ParcelStatusRegistered
+	for steps := 0; status != ParcelStatusDelivered; steps++ {
+		next, ok := r.Transitions[status]
+		if !ok {
+			return fmt.Errorf("status %s has no transition, parcels would never be delivered", status)
+		}
+		if steps == len(parcelStatuses) {
+			return fmt.Errorf("status transitions form a cycle through %s", status)
+		}
+		status = next
+	}
+	return nil
+}
+
+// next - следующий статус посылки. Для конечного статуса ok = false
+func (r StatusRules) next(status string) (next string, ok bool) {
+	next, ok = r.Transitions[status]
+	return next, ok
+}
+
+// editableCondition - условие SQL на статус посылки, которую можно изменить, и его параметры
+func (r StatusRules) editableCondition() (string, []any) {
+	if len(r.Editable) == 0 {
+		return "1 = 0", nil
+	}
+
+	names := make([]string, len(r.Editable))
+	args := make([]any, len(r.Editable))
+	for i, status := range r.Editable {
+		name := fmt.Sprintf("editable%d", i)
+		names[i] = ":" + name
+		args[i] = sql.Named(name, status)
+	}
+	return "status IN (" + strings.Join(names, ", ") + ")", args
+}
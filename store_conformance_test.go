@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSQLiteStoreConformance - набор проверок хранилища на SQLite
+func TestSQLiteStoreConformance(t *testing.T) {
+	testStoreConformance(t, openTestStore(t))
+}
+
+// TestPostgresStoreConformance - набор проверок хранилища на PostgreSQL.
+// База задаётся переменной TRACKER_TEST_POSTGRES_DSN, иначе запускается временный кластер,
+// если в PATH есть initdb и pg_ctl. Без PostgreSQL тест пропускается
+func TestPostgresStoreConformance(t *testing.T) {
+	cfg := DefaultConfig().Database
+	cfg.DSN = postgresTestDSN(t)
+
+	store, err := OpenParcelStore(context.Background(), cfg, WithOutput(io.Discard))
+	require.NoError(t, err, "failed to open postgres store. Error: %v", err)
+	defer store.Close()
+	require.Equal(t, postgresDialect, store.dialect)
+
+	// Повторное применение миграций ничего не меняет
+	require.NoError(t, Migrate(context.Background(), store.db))
+
+	testStoreConformance(t, store)
+}
+
+// postgresTestDSN - строка подключения к PostgreSQL для тестов
+func postgresTestDSN(t *testing.T) string {
+	if dsn := os.Getenv("TRACKER_TEST_POSTGRES_DSN"); dsn != "" {
+		return dsn
+	}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skip("postgres is not available: set TRACKER_TEST_POSTGRES_DSN or install initdb and pg_ctl")
+	}
+	pgctl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		t.Skip("postgres is not available: pg_ctl not found")
+	}
+
+	// Временный кластер с доступом без пароля на свободном порту
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust").CombinedOutput(); err != nil {
+		t.Skipf("failed to init postgres cluster: %v\n%s", err, out)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, dir)
+	if out, err := exec.Command(pgctl, "-D", data, "-o", opts, "-w", "start").CombinedOutput(); err != nil {
+		t.Skipf("failed to start postgres: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command(pgctl, "-D", data, "-m", "immediate", "stop").Run()
+	})
+
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+}
+
+// testStoreConformance - проверки поведения хранилища, общие для всех баз данных.
+// База может содержать данные других запусков, поэтому каждая проверка использует своих клиентов
+func testStoreConformance(t *testing.T, store ParcelStore) {
+	ctx := context.Background()
+	service := NewParcelService(store, WithOutput(io.Discard))
+	client := func() int {
+		return int(time.Now().UnixNano()%1_000_000_000) + randRange.Intn(1000)
+	}
+
+	t.Run("add get delete", func(t *testing.T) {
+		p := getTestParcel()
+		p.Client = client()
+		p.TrackingCode = fmt.Sprintf("T%011d", p.Client)
+
+		number, err := store.AddContext(ctx, p)
+		require.NoError(t, err)
+		require.NotZero(t, number)
+		p.Number = number
+
+		got, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+
+		got, err = store.GetByTrackingCodeContext(ctx, p.TrackingCode)
+		require.NoError(t, err)
+		assert.Equal(t, number, got.Number)
+
+		require.NoError(t, store.DeleteContext(ctx, number))
+		_, err = store.GetContext(ctx, number)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("status and address", func(t *testing.T) {
+		p := getTestParcel()
+		p.Client = client()
+		number, err := store.AddContext(ctx, p)
+		require.NoError(t, err)
+
+		require.NoError(t, store.SetAddressContext(ctx, number, "new test address"))
+		require.NoError(t, store.SetStatusContext(ctx, number, ParcelStatusSent))
+
+		// Отправленную посылку нельзя изменить или удалить
+		updated, err := store.setAddress(ctx, number, "other address")
+		require.NoError(t, err)
+		assert.False(t, updated)
+		deleted, err := store.delete(ctx, number)
+		require.NoError(t, err)
+		assert.False(t, deleted)
+
+		got, err := store.GetContext(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, "new test address", got.Address)
+		assert.Equal(t, ParcelStatusSent, got.Status)
+
+		counts, err := store.CountByStatusContext(ctx)
+		require.NoError(t, err)
+		assert.Positive(t, counts[ParcelStatusSent])
+	})
+
+	t.Run("get by client", func(t *testing.T) {
+		c := client()
+		want := map[int]bool{}
+		for i := 0; i < 3; i++ {
+			p := getTestParcel()
+			p.Client = c
+			number, err := store.AddContext(ctx, p)
+			require.NoError(t, err)
+			want[number] = true
+		}
+
+		parcels, err := store.GetByClientContext(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, parcels, len(want))
+		for _, p := range parcels {
+			assert.True(t, want[p.Number])
+		}
+	})
+
+	t.Run("address search", func(t *testing.T) {
+		c := client()
+		street := fmt.Sprintf("Проверочная%d", c)
+		number, err := store.AddContext(ctx, Parcel{Client: c, Status: ParcelStatusRegistered, Address: "г. Тверь, ул. " + street + ", д. 1", CreatedAt: time.Now().UTC().Format(time.RFC3339)})
+		require.NoError(t, err)
+
+		matches, err := store.SearchAddress(ctx, strings.ToLower(street[:len(street)-3])+" тверь", c, 10)
+		require.NoError(t, err)
+		require.Len(t, matches, 1)
+		assert.Equal(t, number, matches[0].Parcel.Number)
+		assert.Contains(t, matches[0].Snippet, "<mark>")
+	})
+
+	t.Run("report", func(t *testing.T) {
+		c := client()
+		for i := 0; i < 2; i++ {
+			parcel, err := service.RegisterContext(ctx, c, "test")
+			require.NoError(t, err)
+			require.NoError(t, store.SetStatusContext(ctx, parcel.Number, ParcelStatusDelivered))
+		}
+
+		filter, err := ParseReportFilter("", "", c, time.Now())
+		require.NoError(t, err)
+		report, err := BuildReport(ctx, store, filter, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{ParcelStatusDelivered: 2}, report.StatusCounts)
+		assert.Equal(t, []ClientVolume{{Client: c, Registered: 2, Delivered: 2}}, report.Clients)
+		assert.Equal(t, 2, report.Daily[len(report.Daily)-1].Count+report.Daily[len(report.Daily)-2].Count)
+		assert.Equal(t, 2, report.LeadTime.Count)
+	})
+
+	t.Run("job lock", func(t *testing.T) {
+		job := Job{Name: fmt.Sprintf("test-%d", client()), Run: func(context.Context) error { return nil }}
+		slot := time.Now().Truncate(time.Second)
+		ran, err := NewScheduler(store, "first", time.Minute, 0).RunJob(ctx, job, slot)
+		require.NoError(t, err)
+		assert.True(t, ran)
+		ran, err = NewScheduler(store, "second", time.Minute, 0).RunJob(ctx, job, slot)
+		require.NoError(t, err)
+		assert.False(t, ran)
+
+		runs, err := store.JobRuns(ctx, job.Name, 10)
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		assert.Equal(t, JobSucceeded, runs[0].Status)
+	})
+
+	t.Run("transaction rollback", func(t *testing.T) {
+		p := getTestParcel()
+		p.Client = client()
+		errRollback := errors.New("rollback")
+
+		var number int
+		err := store.InTx(ctx, func(tx ParcelStore) error {
+			var err error
+			number, err = tx.AddContext(ctx, p)
+			if err != nil {
+				return err
+			}
+			return errRollback
+		})
+		require.ErrorIs(t, err, errRollback)
+		_, err = store.GetContext(ctx, number)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("events", func(t *testing.T) {
+		parcel, err := service.RegisterContext(ctx, client(), "test")
+		require.NoError(t, err)
+		require.NoError(t, service.NextStatusContext(ctx, parcel.Number))
+
+		events, err := store.GetEventsAfter(ctx, 0, EventFilter{Parcel: parcel.Number}, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, EventRegistered, events[0].Type)
+		assert.Equal(t, EventStatusChanged, events[1].Type)
+
+		events, err = store.GetEventsAfter(ctx, events[0].ID, EventFilter{Client: parcel.Client, Types: []string{EventStatusChanged}}, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+
+		require.NoError(t, store.MarkEventDelivered(ctx, events[0].ID))
+	})
+
+	t.Run("idempotency and quota", func(t *testing.T) {
+		c := client()
+		require.NoError(t, store.SetClientQuota(ctx, c, 1))
+
+		first, replayed, err := service.RegisterIdempotent(ctx, "order-1", c, "test")
+		require.NoError(t, err)
+		assert.False(t, replayed)
+		again, replayed, err := service.RegisterIdempotent(ctx, "order-1", c, "test")
+		require.NoError(t, err)
+		assert.True(t, replayed)
+		assert.Equal(t, first, again)
+
+		_, _, err = service.RegisterIdempotent(ctx, "order-1", c, "other address")
+		require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+		_, err = service.RegisterContext(ctx, c, "test")
+		require.ErrorIs(t, err, ErrRateLimited)
+
+		_, err = store.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+		require.NoError(t, err)
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		key, err := newAPIKey()
+		require.NoError(t, err)
+		id, err := store.AddAPIKey(ctx, APIKey{Name: "shop", Role: RoleClient, Client: client()}, key)
+		require.NoError(t, err)
+
+		k, err := store.GetAPIKey(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, id, k.ID)
+		assert.Equal(t, RoleClient, k.Role)
+
+		require.NoError(t, store.RevokeAPIKey(ctx, id))
+		require.ErrorIs(t, store.RevokeAPIKey(ctx, id), sql.ErrNoRows)
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		c := client()
+		entry, err := newAuditEntry(ctx, AuditClientExport, c, map[string]int{"parcels": 0})
+		require.NoError(t, err)
+		id, err := store.AppendAudit(ctx, entry)
+		require.NoError(t, err)
+
+		audit, err := store.GetAuditLog(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, audit, 1)
+		entry.ID = id
+		assert.Equal(t, entry, audit[0])
+	})
+
+	t.Run("webhooks", func(t *testing.T) {
+		c := client()
+		id, err := store.AddWebhook(ctx, WebhookSubscription{Client: c, URL: "http://localhost/hook", Secret: "secret", Events: []string{EventRegistered}})
+		require.NoError(t, err)
+
+		hooks, err := store.GetWebhooksByClient(ctx, c)
+		require.NoError(t, err)
+		require.Len(t, hooks, 1)
+		assert.Equal(t, id, hooks[0].ID)
+
+		require.NoError(t, store.DeleteWebhook(ctx, id))
+	})
+}
+
+// TestBindPositional - тест для проверки замены именованных параметров позиционными
+func TestBindPositional(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+		want  string
+		vals  []any
+	}{
+		{
+			name:  "repeated names",
+			query: "SELECT * FROM outbox WHERE (:parcel = 0 OR parcel = :parcel) AND id > :after",
+			args:  []any{sql.Named("after", 5), sql.Named("parcel", 7)},
+			want:  "SELECT * FROM outbox WHERE ($1 = 0 OR parcel = $1) AND id > $2",
+			vals:  []any{7, 5},
+		},
+		{
+			name:  "casts and literals",
+			query: "SELECT ':skip', number::text FROM parcel WHERE client = :client",
+			args:  []any{sql.Named("client", 1)},
+			want:  "SELECT ':skip', number::text FROM parcel WHERE client = $1",
+			vals:  []any{1},
+		},
+		{
+			name:  "no args",
+			query: "SELECT COUNT(*) FROM parcel",
+			want:  "SELECT COUNT(*) FROM parcel",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, vals := bindPositional(tt.query, tt.args)
+			assert.Equal(t, tt.want, query)
+			assert.Equal(t, tt.vals, vals)
+		})
+	}
+}
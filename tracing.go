@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerScope - имя инструментирующей библиотеки и сервиса в экспортируемых span
+const tracerScope = "go-parcel-tracker"
+
+// Tracer - трассировщик OpenTelemetry, открывающий span операций сервиса и хранилища.
+// Контекст трассы принимается от вызывающего в заголовках W3C traceparent и baggage.
+// Методы безопасны для nil-трассировщика: span в этом случае не создаются
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer - конструктор трассировщика, получающего span у поставщика provider
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:     provider.Tracer(tracerScope),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
+
+// Span - незавершённый span. Методы безопасны для nil-span
+type Span struct {
+	span trace.Span
+}
+
+// Start - открывает span с именем name. Если в ctx уже есть span, в том числе полученный от вызывающего,
+// новый становится его дочерним
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(otelAttributes(attrs)...))
+	return ctx, &Span{span: span}
+}
+
+// Extract - продолжает в ctx трассу вызывающего из заголовков carrier
+func (t *Tracer) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return t.propagator.Extract(ctx, carrier)
+}
+
+// SetAttributes - добавляет атрибуты к span
+func (sp *Span) SetAttributes(attrs ...slog.Attr) {
+	if sp == nil {
+		return
+	}
+	sp.span.SetAttributes(otelAttributes(attrs)...)
+}
+
+// End - завершает span с результатом err
+func (sp *Span) End(err error) {
+	if sp == nil {
+		return
+	}
+	if err != nil {
+		sp.span.RecordError(err)
+		sp.span.SetStatus(codes.Error, err.Error())
+	} else {
+		sp.span.SetStatus(codes.Ok, "")
+	}
+	sp.span.End()
+}
+
+// otelAttributes - преобразует атрибуты slog в атрибуты OpenTelemetry
+func otelAttributes(attrs []slog.Attr) []attribute.KeyValue {
+	res := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value.Resolve(); v.Kind() {
+		case slog.KindInt64:
+			res = append(res, attribute.Int64(a.Key, v.Int64()))
+		case slog.KindBool:
+			res = append(res, attribute.Bool(a.Key, v.Bool()))
+		case slog.KindFloat64:
+			res = append(res, attribute.Float64(a.Key, v.Float64()))
+		default:
+			res = append(res, attribute.String(a.Key, v.String()))
+		}
+	}
+	return res
+}
+
+// OpenTracer - создаёт трассировщик по описанию экспортёра:
+// пустая строка - трассировка отключена, "stdout" - span в формате JSON в stdout,
+// "file:<путь>" - span в формате JSON в файл, "otlp" - экспорт по OTLP/HTTP на адрес из переменных
+// окружения OTEL_EXPORTER_OTLP_ENDPOINT или OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (по умолчанию localhost:4318).
+// Ошибки экспорта записываются в logger. Возвращаемая функция отправляет оставшиеся span и закрывает экспортёр
+func OpenTracer(ctx context.Context, spec string, logger *slog.Logger) (*Tracer, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	var exporter sdktrace.SpanExporter
+	closeFile := func() error { return nil }
+	var err error
+	switch {
+	case spec == "":
+		return nil, noop, nil
+	case spec == "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case strings.HasPrefix(spec, "file:"):
+		f, openErr := os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if openErr != nil {
+			return nil, noop, fmt.Errorf("failed to open trace file: %w", openErr)
+		}
+		closeFile = f.Close
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case spec == "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, noop, fmt.Errorf("unknown trace exporter %q", spec)
+	}
+	if err != nil {
+		closeFile()
+		return nil, noop, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Error("failed to export spans", slog.Any(attrError, err))
+	}))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracerScope))),
+	)
+	shutdown := func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closeFile())
+	}
+	return NewTracer(provider), shutdown, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/trackerpb"
+)
+
+// testTraceParent - заголовок W3C traceparent вызывающего с известными идентификаторами трассы и span
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentSpan  = "00f067aa0ba902b7"
+	testTraceParent = "00-" + testTraceID + "-" + testParentSpan + "-01"
+)
+
+// newTestTracer - трассировщик, синхронно сохраняющий завершённые span в памяти
+func newTestTracer() (*Tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))), exporter
+}
+
+// openTracedStore - открывает временное хранилище с трассировщиком tracer
+func openTracedStore(t *testing.T, tracer *Tracer) ParcelStore {
+	cfg := DefaultConfig().Database
+	cfg.DSN = filepath.Join(t.TempDir(), "tracker.db")
+
+	store, err := OpenParcelStore(context.Background(), cfg, WithOutput(io.Discard), WithTracer(tracer))
+	require.NoError(t, err, "failed to open store. Error: %v", err)
+	t.Cleanup(func() {
+		assert.NoError(t, store.Close())
+	})
+	return store
+}
+
+// spanAttrs - преобразует атрибуты span в мапу для удобной проверки
+func spanAttrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	res := map[attribute.Key]attribute.Value{}
+	for _, a := range span.Attributes {
+		res[a.Key] = a.Value
+	}
+	return res
+}
+
+// findSpan - возвращает завершённый span с именем name
+func findSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not found", "no span %q", name)
+	return tracetest.SpanStub{}
+}
+
+// TestServiceTracing - тест для проверки вложенности span сервиса и хранилища
+func TestServiceTracing(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	tracer, exporter := newTestTracer()
+	store := NewParcelStore(db, WithTracer(tracer))
+	service := NewParcelService(store, WithOutput(io.Discard), WithTracer(tracer))
+
+	parcel, err := service.Register(1000, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+
+	exporter.Reset()
+	require.NoError(t, service.NextStatusContext(context.Background(), parcel.Number))
+
+	// Span хранилища завершаются раньше span сервиса
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4, "expected get, set_status, append_event and next_status spans")
+	get, setStatus, appendEvent, root := spans[0], spans[1], spans[2], spans[3]
+
+	assert.Equal(t, "service.next_status", root.Name)
+	assert.False(t, root.Parent.IsValid(), "service span should be a root span")
+	assert.Equal(t, codes.Ok, root.Status.Code)
+
+	// Запросы хранилища - дочерние span операции сервиса в той же трассе
+	for _, tt := range []struct {
+		span  tracetest.SpanStub
+		name  string
+		sqlOp string
+	}{
+		{get, "store.get", "SELECT"},
+		{setStatus, "store.set_status", "UPDATE"},
+		{appendEvent, "store.append_event", "INSERT"},
+	} {
+		assert.Equal(t, tt.name, tt.span.Name)
+		assert.Equal(t, root.SpanContext.TraceID(), tt.span.SpanContext.TraceID())
+		assert.Equal(t, root.SpanContext.SpanID(), tt.span.Parent.SpanID())
+
+		attrs := spanAttrs(tt.span)
+		assert.Equal(t, tt.sqlOp, attrs[attrDBOperation].AsString())
+		assert.Equal(t, int64(parcel.Number), attrs[attrParcel].AsInt64())
+		assert.False(t, tt.span.EndTime.Before(tt.span.StartTime))
+	}
+}
+
+// TestSpanError - тест для проверки статуса span, завершённого с ошибкой
+func TestSpanError(t *testing.T) {
+	tracer, exporter := newTestTracer()
+
+	_, span := tracer.Start(context.Background(), "op", slog.Int(attrParcel, 42))
+	span.End(io.ErrUnexpectedEOF)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, io.ErrUnexpectedEOF.Error(), spans[0].Status.Description)
+	assert.Equal(t, int64(42), spanAttrs(spans[0])[attrParcel].AsInt64())
+	require.Len(t, spans[0].Events, 1, "error should be recorded as a span event")
+
+	// Nil-трассировщик не создаёт span
+	var disabled *Tracer
+	ctx, nilSpan := disabled.Start(context.Background(), "op")
+	assert.Nil(t, nilSpan)
+	nilSpan.End(nil)
+	assert.Equal(t, context.Background(), ctx)
+}
+
+// TestHTTPTracePropagation - тест для проверки продолжения трассы вызывающего из заголовка traceparent
+func TestHTTPTracePropagation(t *testing.T) {
+	tracer, exporter := newTestTracer()
+	store := openTracedStore(t, tracer)
+	service := NewParcelService(store, WithOutput(io.Discard), WithTracer(tracer))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	parcel, err := service.RegisterContext(context.Background(), 1000, "test")
+	require.NoError(t, err)
+	exporter.Reset()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/parcels/"+strconv.Itoa(parcel.Number), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, RoleAdmin, 0))
+	req.Header.Set("traceparent", testTraceParent)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Span операции - дочерний span вызывающего в его трассе
+	span := findSpan(t, exporter, "service.get")
+	assert.Equal(t, testTraceID, span.SpanContext.TraceID().String())
+	assert.Equal(t, testParentSpan, span.Parent.SpanID().String())
+	assert.True(t, span.Parent.IsRemote())
+}
+
+// TestGRPCTracePropagation - тест для проверки продолжения трассы вызывающего из метаданных traceparent
+func TestGRPCTracePropagation(t *testing.T) {
+	tracer, exporter := newTestTracer()
+	store := openTracedStore(t, tracer)
+	service := NewParcelService(store, WithOutput(io.Discard), WithTracer(tracer))
+	client := startGRPC(t, service, NewEventBroker(), nil)
+
+	parcel, err := service.RegisterContext(context.Background(), 1000, "test")
+	require.NoError(t, err)
+	exporter.Reset()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", testTraceParent)
+	_, err = client.GetParcel(ctx, &trackerpb.GetParcelRequest{Number: int64(parcel.Number)})
+	require.NoError(t, err)
+
+	span := findSpan(t, exporter, "service.get")
+	assert.Equal(t, testTraceID, span.SpanContext.TraceID().String())
+	assert.Equal(t, testParentSpan, span.Parent.SpanID().String())
+	assert.True(t, span.Parent.IsRemote())
+}
+
+// TestOpenTracerFile - тест для проверки экспорта span в файл
+func TestOpenTracerFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	logger, _ := newTestLogger()
+	tracer, shutdown, err := OpenTracer(context.Background(), "file:"+path, logger)
+	require.NoError(t, err)
+
+	_, span := tracer.Start(context.Background(), "op")
+	span.End(nil)
+	// Span отправляются пакетами и записываются при завершении
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var exported struct {
+		Name string
+	}
+	require.NoError(t, json.NewDecoder(bytes.NewReader(data)).Decode(&exported))
+	assert.Equal(t, "op", exported.Name)
+
+	_, _, err = OpenTracer(context.Background(), "zipkin", logger)
+	assert.Error(t, err)
+}
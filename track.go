@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/calendar"
+)
+
+// Код отслеживания посылки: символы алфавита Crockford base32 без похожих друг на друга букв
+const (
+	trackingAlphabet   = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	trackingCodeLength = 12
+)
+
+// trackingTimelineLimit - наибольшее количество событий в истории посылки на странице отслеживания
+const trackingTimelineLimit = 100
+
+// trackingStatuses - статусы посылки в порядке прохождения, по ним строится шкала прогресса
+var trackingStatuses = []string{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered}
+
+// trackingEventTypes - события, которые показываются в истории посылки
+var trackingEventTypes = []string{EventRegistered, EventStatusChanged, EventAddressChanged}
+
+//go:embed web
+var webFS embed.FS
+
+// trackTemplate - шаблон публичной страницы отслеживания
+var trackTemplate = template.Must(template.ParseFS(webFS, "web/templates/track.html"))
+
+// newTrackingCode - создаёт случайный код отслеживания
+func newTrackingCode() (string, error) {
+	size := big.NewInt(int64(len(trackingAlphabet)))
+	code := make([]byte, trackingCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate tracking code: error: %w", err)
+		}
+		code[i] = trackingAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// NormalizeTrackingCode - приводит введённый код к каноническому виду: пробелы и дефисы удаляются,
+// регистр не учитывается, O читается как 0, I и L - как 1. Возвращает false, если код некорректен
+func NormalizeTrackingCode(s string) (string, bool) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		switch r {
+		case ' ', '-':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		if !strings.ContainsRune(trackingAlphabet, r) {
+			return "", false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() != trackingCodeLength {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// maskClient - скрывает идентификатор клиента, оставляя последнюю цифру
+func maskClient(client int) string {
+	s := strconv.Itoa(client)
+	return "***" + s[len(s)-1:]
+}
+
+// maskAddress - скрывает адрес, оставляя только первую часть (обычно город)
+func maskAddress(address string) string {
+	city, _, found := strings.Cut(address, ",")
+	if !found {
+		return "***"
+	}
+	return strings.TrimSpace(city) + ", ***"
+}
+
+// trackLabels - надписи страницы отслеживания на одном языке
+type trackLabels struct {
+	Lang        string
+	Title       string
+	Prompt      string
+	Submit      string
+	InvalidCode string
+	NotFound    string
+	Client      string
+	Address     string
+	Status      string
+	ETA         string
+	Timeline    string
+	TimeFormat  string
+	DateFormat  string
+	Statuses    map[string]string
+	Events      map[string]string
+}
+
+// trackCatalogue - надписи страницы отслеживания по языкам
+var trackCatalogue = map[Locale]trackLabels{
+	LocaleRU: {
+		Lang:        "ru",
+		Title:       "Отслеживание посылки",
+		Prompt:      "Код отслеживания",
+		Submit:      "Найти",
+		InvalidCode: "Код отслеживания состоит из 12 букв и цифр",
+		NotFound:    "Посылка с таким кодом не найдена",
+		Client:      "Клиент",
+		Address:     "Адрес",
+		Status:      "Статус",
+		ETA:         "Ожидаемая дата доставки",
+		Timeline:    "История",
+		TimeFormat:  "02.01.2006 15:04 UTC",
+		DateFormat:  "02.01.2006",
+		Statuses: map[string]string{
+			ParcelStatusRegistered: "зарегистрирована",
+			ParcelStatusSent:       "отправлена",
+			ParcelStatusDelivered:  "доставлена",
+		},
+		Events: map[string]string{
+			EventRegistered:     "Посылка зарегистрирована",
+			EventStatusChanged:  "Новый статус",
+			EventAddressChanged: "Адрес доставки изменён",
+		},
+	},
+	LocaleEN: {
+		Lang:        "en",
+		Title:       "Parcel tracking",
+		Prompt:      "Tracking code",
+		Submit:      "Track",
+		InvalidCode: "A tracking code consists of 12 letters and digits",
+		NotFound:    "No parcel with this tracking code",
+		Client:      "Client",
+		Address:     "Address",
+		Status:      "Status",
+		ETA:         "Estimated delivery",
+		Timeline:    "History",
+		TimeFormat:  "Jan 2, 2006 15:04 UTC",
+		DateFormat:  "Jan 2, 2006",
+		Statuses: map[string]string{
+			ParcelStatusRegistered: "registered",
+			ParcelStatusSent:       "sent",
+			ParcelStatusDelivered:  "delivered",
+		},
+		Events: map[string]string{
+			EventRegistered:     "Parcel registered",
+			EventStatusChanged:  "New status",
+			EventAddressChanged: "Delivery address changed",
+		},
+	},
+}
+
+// trackView - данные шаблона страницы отслеживания
+type trackView struct {
+	L      trackLabels
+	Code   string       // Код, введённый пользователем
+	Error  string       // Сообщение об ошибке поиска
+	Parcel *trackParcel // Найденная посылка
+}
+
+// trackParcel - посылка на странице отслеживания со скрытыми персональными данными
+type trackParcel struct {
+	Code     string
+	Client   string
+	Address  string
+	Status   string
+	ETA      string // Ожидаемая дата доставки недоставленной посылки
+	Steps    []trackStep
+	Timeline []trackEntry
+}
+
+// trackStep - этап шкалы прогресса
+type trackStep struct {
+	Label   string
+	Done    bool // Этап пройден или является текущим
+	Current bool
+}
+
+// trackEntry - запись истории посылки
+type trackEntry struct {
+	Time string
+	Text string
+}
+
+// newTrackParcel - готовит посылку и её события к выводу на странице
+func newTrackParcel(l trackLabels, p Parcel, events []ParcelEvent) *trackParcel {
+	view := &trackParcel{
+		Code:    p.TrackingCode,
+		Client:  maskClient(p.Client),
+		Address: maskAddress(p.Address),
+		Status:  l.Statuses[p.Status],
+	}
+	if eta, err := time.Parse(calendar.DateFormat, p.ETA); err == nil && p.Status != ParcelStatusDelivered {
+		view.ETA = eta.Format(l.DateFormat)
+	}
+
+	current := -1
+	for i, status := range trackingStatuses {
+		if status == p.Status {
+			current = i
+		}
+	}
+	for i, status := range trackingStatuses {
+		view.Steps = append(view.Steps, trackStep{Label: l.Statuses[status], Done: i <= current, Current: i == current})
+	}
+
+	// У посылок, зарегистрированных до появления событий, история начинается с даты регистрации
+	if len(events) == 0 || events[0].Type != EventRegistered {
+		if createdAt, err := time.Parse(time.RFC3339, p.CreatedAt); err == nil {
+			view.Timeline = append(view.Timeline, trackEntry{Time: createdAt.Format(l.TimeFormat), Text: l.Events[EventRegistered]})
+		}
+	}
+	for _, event := range events {
+		text := l.Events[event.Type]
+		if event.Type == EventStatusChanged {
+			text += ": " + l.Statuses[event.Status]
+		}
+		view.Timeline = append(view.Timeline, trackEntry{Time: event.OccurredAt.UTC().Format(l.TimeFormat), Text: text})
+	}
+	return view
+}
+
+// handleTrack - публичная страница отслеживания посылки по коду (?code=...)
+func (srv *Server) handleTrack(w http.ResponseWriter, r *http.Request) {
+	labels, ok := trackCatalogue[srv.service.locale]
+	if !ok {
+		labels = trackCatalogue[LocaleRU]
+	}
+	view := trackView{L: labels}
+	status := http.StatusOK
+
+	if raw := r.URL.Query().Get("code"); raw != "" {
+		view.Code = raw
+		code, ok := NormalizeTrackingCode(raw)
+		if ok {
+			parcel, events, err := srv.service.Track(r.Context(), code)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				view.Error, status = labels.NotFound, http.StatusNotFound
+			case err != nil:
+				srv.logger.ErrorContext(r.Context(), "failed to track parcel", slog.Any(attrError, err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			default:
+				view.Parcel = newTrackParcel(labels, parcel, events)
+			}
+		} else {
+			view.Error, status = labels.InvalidCode, http.StatusBadRequest
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(status)
+	if err := trackTemplate.Execute(w, view); err != nil {
+		srv.logger.ErrorContext(r.Context(), "failed to render tracking page", slog.Any(attrError, err))
+	}
+}
+
+// staticHandler - файлы оформления публичной страницы
+func staticHandler() http.Handler {
+	static, err := fs.Sub(webFS, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/static/", http.FileServerFS(static))
+}
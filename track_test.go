@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getPage - запрашивает страницу и возвращает код ответа и тело
+func getPage(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err, "failed to request %s. Error: %v", url, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+// TestNormalizeTrackingCode - тест для проверки разбора введённого кода отслеживания
+func TestNormalizeTrackingCode(t *testing.T) {
+	code, err := newTrackingCode()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		ok    bool
+	}{
+		{name: "generated code", input: code, want: code, ok: true},
+		{name: "lower case with separators", input: "abcd-efgh 1234", want: "ABCDEFGH1234", ok: true},
+		{name: "ambiguous letters", input: "O1IL00000000", want: "011100000000", ok: true},
+		{name: "too short", input: "ABC", ok: false},
+		{name: "unsupported letter", input: "ABCDEFGH123U", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NormalizeTrackingCode(tt.input)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestTrackingPage - тест для проверки публичной страницы отслеживания
+func TestTrackingPage(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	store := NewParcelStore(db)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, nil, nil).Handler())
+	defer server.Close()
+
+	parcel, err := service.Register(7345, "Саратов, ул. Козлова, д. 25")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.Len(t, parcel.TrackingCode, trackingCodeLength)
+	require.NoError(t, service.NextStatus(parcel.Number))
+
+	// Код можно ввести в нижнем регистре
+	status, body := getPage(t, server.URL+"/track?code="+url.QueryEscape(strings.ToLower(parcel.TrackingCode)))
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, parcel.TrackingCode)
+	assert.Contains(t, body, "отправлена")
+	assert.Contains(t, body, "Посылка зарегистрирована")
+	assert.Contains(t, body, `class="done current" aria-current="step">отправлена`)
+
+	// Персональные данные скрыты
+	assert.Contains(t, body, "Саратов, ***")
+	assert.NotContains(t, body, "Козлова")
+	assert.NotContains(t, body, "7345")
+	assert.Contains(t, body, "***5")
+
+	// Неизвестный и некорректный код
+	status, body = getPage(t, server.URL+"/track?code=000000000000")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Contains(t, body, "Посылка с таким кодом не найдена")
+
+	status, _ = getPage(t, server.URL+"/track?code=abc")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Форма поиска и оформление
+	status, body = getPage(t, server.URL+"/track")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `<form method="get" action="/track">`)
+
+	status, _ = getPage(t, server.URL+"/static/track.css")
+	assert.Equal(t, http.StatusOK, status)
+}
@@ -0,0 +1,806 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: tracker.proto
+
+// Контракт gRPC-интерфейса сервиса посылок.
+// Код для Go генерируется командой `go generate ./...` (см. buf.gen.yaml)
+
+package trackerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ParcelStatus - статус посылки
+type ParcelStatus int32
+
+const (
+	ParcelStatus_PARCEL_STATUS_UNSPECIFIED ParcelStatus = 0
+	ParcelStatus_PARCEL_STATUS_REGISTERED  ParcelStatus = 1
+	ParcelStatus_PARCEL_STATUS_SENT        ParcelStatus = 2
+	ParcelStatus_PARCEL_STATUS_DELIVERED   ParcelStatus = 3
+)
+
+// Enum value maps for ParcelStatus.
+var (
+	ParcelStatus_name = map[int32]string{
+		0: "PARCEL_STATUS_UNSPECIFIED",
+		1: "PARCEL_STATUS_REGISTERED",
+		2: "PARCEL_STATUS_SENT",
+		3: "PARCEL_STATUS_DELIVERED",
+	}
+	ParcelStatus_value = map[string]int32{
+		"PARCEL_STATUS_UNSPECIFIED": 0,
+		"PARCEL_STATUS_REGISTERED":  1,
+		"PARCEL_STATUS_SENT":        2,
+		"PARCEL_STATUS_DELIVERED":   3,
+	}
+)
+
+func (x ParcelStatus) Enum() *ParcelStatus {
+	p := new(ParcelStatus)
+	*p = x
+	return p
+}
+
+func (x ParcelStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ParcelStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_tracker_proto_enumTypes[0].Descriptor()
+}
+
+func (ParcelStatus) Type() protoreflect.EnumType {
+	return &file_tracker_proto_enumTypes[0]
+}
+
+func (x ParcelStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ParcelStatus.Descriptor instead.
+func (ParcelStatus) EnumDescriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{0}
+}
+
+// Parcel - посылка
+type Parcel struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Number    int64                  `protobuf:"varint,1,opt,name=number,proto3" json:"number,omitempty"`
+	Client    int64                  `protobuf:"varint,2,opt,name=client,proto3" json:"client,omitempty"`
+	Status    ParcelStatus           `protobuf:"varint,3,opt,name=status,proto3,enum=tracker.v1.ParcelStatus" json:"status,omitempty"`
+	Address   string                 `protobuf:"bytes,4,opt,name=address,proto3" json:"address,omitempty"`
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// Код для отслеживания посылки на публичной странице /track
+	TrackingCode  string `protobuf:"bytes,6,opt,name=tracking_code,json=trackingCode,proto3" json:"tracking_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Parcel) Reset() {
+	*x = Parcel{}
+	mi := &file_tracker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Parcel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Parcel) ProtoMessage() {}
+
+func (x *Parcel) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Parcel.ProtoReflect.Descriptor instead.
+func (*Parcel) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Parcel) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+func (x *Parcel) GetClient() int64 {
+	if x != nil {
+		return x.Client
+	}
+	return 0
+}
+
+func (x *Parcel) GetStatus() ParcelStatus {
+	if x != nil {
+		return x.Status
+	}
+	return ParcelStatus_PARCEL_STATUS_UNSPECIFIED
+}
+
+func (x *Parcel) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Parcel) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Parcel) GetTrackingCode() string {
+	if x != nil {
+		return x.TrackingCode
+	}
+	return ""
+}
+
+// ParcelEvent - событие изменения посылки
+type ParcelEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Номер события, используется как last_event_id при переподключении
+	EventId int64 `protobuf:"varint,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	// Тип события: parcel.registered, parcel.status_changed, parcel.address_changed, parcel.deleted
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Number        int64                  `protobuf:"varint,3,opt,name=number,proto3" json:"number,omitempty"`
+	Client        int64                  `protobuf:"varint,4,opt,name=client,proto3" json:"client,omitempty"`
+	Status        ParcelStatus           `protobuf:"varint,5,opt,name=status,proto3,enum=tracker.v1.ParcelStatus" json:"status,omitempty"`
+	OldStatus     ParcelStatus           `protobuf:"varint,6,opt,name=old_status,json=oldStatus,proto3,enum=tracker.v1.ParcelStatus" json:"old_status,omitempty"`
+	Address       string                 `protobuf:"bytes,7,opt,name=address,proto3" json:"address,omitempty"`
+	OccurredAt    *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ParcelEvent) Reset() {
+	*x = ParcelEvent{}
+	mi := &file_tracker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ParcelEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ParcelEvent) ProtoMessage() {}
+
+func (x *ParcelEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ParcelEvent.ProtoReflect.Descriptor instead.
+func (*ParcelEvent) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ParcelEvent) GetEventId() int64 {
+	if x != nil {
+		return x.EventId
+	}
+	return 0
+}
+
+func (x *ParcelEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ParcelEvent) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+func (x *ParcelEvent) GetClient() int64 {
+	if x != nil {
+		return x.Client
+	}
+	return 0
+}
+
+func (x *ParcelEvent) GetStatus() ParcelStatus {
+	if x != nil {
+		return x.Status
+	}
+	return ParcelStatus_PARCEL_STATUS_UNSPECIFIED
+}
+
+func (x *ParcelEvent) GetOldStatus() ParcelStatus {
+	if x != nil {
+		return x.OldStatus
+	}
+	return ParcelStatus_PARCEL_STATUS_UNSPECIFIED
+}
+
+func (x *ParcelEvent) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ParcelEvent) GetOccurredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return nil
+}
+
+type RegisterParcelRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Client  int64                  `protobuf:"varint,1,opt,name=client,proto3" json:"client,omitempty"`
+	Address string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	// Ключ идемпотентности: повтор с тем же ключом и данными возвращает ранее зарегистрированную посылку,
+	// с другими данными - ALREADY_EXISTS
+	IdempotencyKey string `protobuf:"bytes,3,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *RegisterParcelRequest) Reset() {
+	*x = RegisterParcelRequest{}
+	mi := &file_tracker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterParcelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterParcelRequest) ProtoMessage() {}
+
+func (x *RegisterParcelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterParcelRequest.ProtoReflect.Descriptor instead.
+func (*RegisterParcelRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RegisterParcelRequest) GetClient() int64 {
+	if x != nil {
+		return x.Client
+	}
+	return 0
+}
+
+func (x *RegisterParcelRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *RegisterParcelRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+type GetParcelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        int64                  `protobuf:"varint,1,opt,name=number,proto3" json:"number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetParcelRequest) Reset() {
+	*x = GetParcelRequest{}
+	mi := &file_tracker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetParcelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetParcelRequest) ProtoMessage() {}
+
+func (x *GetParcelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetParcelRequest.ProtoReflect.Descriptor instead.
+func (*GetParcelRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetParcelRequest) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+type ListClientParcelsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Client        int64                  `protobuf:"varint,1,opt,name=client,proto3" json:"client,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClientParcelsRequest) Reset() {
+	*x = ListClientParcelsRequest{}
+	mi := &file_tracker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientParcelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientParcelsRequest) ProtoMessage() {}
+
+func (x *ListClientParcelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientParcelsRequest.ProtoReflect.Descriptor instead.
+func (*ListClientParcelsRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListClientParcelsRequest) GetClient() int64 {
+	if x != nil {
+		return x.Client
+	}
+	return 0
+}
+
+type AdvanceStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        int64                  `protobuf:"varint,1,opt,name=number,proto3" json:"number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdvanceStatusRequest) Reset() {
+	*x = AdvanceStatusRequest{}
+	mi := &file_tracker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdvanceStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdvanceStatusRequest) ProtoMessage() {}
+
+func (x *AdvanceStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdvanceStatusRequest.ProtoReflect.Descriptor instead.
+func (*AdvanceStatusRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AdvanceStatusRequest) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+type ChangeAddressRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        int64                  `protobuf:"varint,1,opt,name=number,proto3" json:"number,omitempty"`
+	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangeAddressRequest) Reset() {
+	*x = ChangeAddressRequest{}
+	mi := &file_tracker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangeAddressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeAddressRequest) ProtoMessage() {}
+
+func (x *ChangeAddressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeAddressRequest.ProtoReflect.Descriptor instead.
+func (*ChangeAddressRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ChangeAddressRequest) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+func (x *ChangeAddressRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type DeleteParcelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        int64                  `protobuf:"varint,1,opt,name=number,proto3" json:"number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteParcelRequest) Reset() {
+	*x = DeleteParcelRequest{}
+	mi := &file_tracker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteParcelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteParcelRequest) ProtoMessage() {}
+
+func (x *DeleteParcelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteParcelRequest.ProtoReflect.Descriptor instead.
+func (*DeleteParcelRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeleteParcelRequest) GetNumber() int64 {
+	if x != nil {
+		return x.Number
+	}
+	return 0
+}
+
+type DeleteParcelResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteParcelResponse) Reset() {
+	*x = DeleteParcelResponse{}
+	mi := &file_tracker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteParcelResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteParcelResponse) ProtoMessage() {}
+
+func (x *DeleteParcelResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteParcelResponse.ProtoReflect.Descriptor instead.
+func (*DeleteParcelResponse) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{8}
+}
+
+type WatchParcelsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Должен быть задан parcel или client
+	Parcel int64 `protobuf:"varint,1,opt,name=parcel,proto3" json:"parcel,omitempty"`
+	Client int64 `protobuf:"varint,2,opt,name=client,proto3" json:"client,omitempty"`
+	// Типы событий. Если не заданы - изменения статуса и адреса
+	Types []string `protobuf:"bytes,3,rep,name=types,proto3" json:"types,omitempty"`
+	// Номер последнего полученного события, при 0 передаётся вся история
+	LastEventId   int64 `protobuf:"varint,4,opt,name=last_event_id,json=lastEventId,proto3" json:"last_event_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchParcelsRequest) Reset() {
+	*x = WatchParcelsRequest{}
+	mi := &file_tracker_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchParcelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchParcelsRequest) ProtoMessage() {}
+
+func (x *WatchParcelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchParcelsRequest.ProtoReflect.Descriptor instead.
+func (*WatchParcelsRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *WatchParcelsRequest) GetParcel() int64 {
+	if x != nil {
+		return x.Parcel
+	}
+	return 0
+}
+
+func (x *WatchParcelsRequest) GetClient() int64 {
+	if x != nil {
+		return x.Client
+	}
+	return 0
+}
+
+func (x *WatchParcelsRequest) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+func (x *WatchParcelsRequest) GetLastEventId() int64 {
+	if x != nil {
+		return x.LastEventId
+	}
+	return 0
+}
+
+var File_tracker_proto protoreflect.FileDescriptor
+
+const file_tracker_proto_rawDesc = "" +
+	"\n" +
+	"\rtracker.proto\x12\n" +
+	"tracker.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe4\x01\n" +
+	"\x06Parcel\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\x03R\x06number\x12\x16\n" +
+	"\x06client\x18\x02 \x01(\x03R\x06client\x120\n" +
+	"\x06status\x18\x03 \x01(\x0e2\x18.tracker.v1.ParcelStatusR\x06status\x12\x18\n" +
+	"\aaddress\x18\x04 \x01(\tR\aaddress\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12#\n" +
+	"\rtracking_code\x18\x06 \x01(\tR\ftrackingCode\"\xae\x02\n" +
+	"\vParcelEvent\x12\x19\n" +
+	"\bevent_id\x18\x01 \x01(\x03R\aeventId\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x16\n" +
+	"\x06number\x18\x03 \x01(\x03R\x06number\x12\x16\n" +
+	"\x06client\x18\x04 \x01(\x03R\x06client\x120\n" +
+	"\x06status\x18\x05 \x01(\x0e2\x18.tracker.v1.ParcelStatusR\x06status\x127\n" +
+	"\n" +
+	"old_status\x18\x06 \x01(\x0e2\x18.tracker.v1.ParcelStatusR\toldStatus\x12\x18\n" +
+	"\aaddress\x18\a \x01(\tR\aaddress\x12;\n" +
+	"\voccurred_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"occurredAt\"r\n" +
+	"\x15RegisterParcelRequest\x12\x16\n" +
+	"\x06client\x18\x01 \x01(\x03R\x06client\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\x12'\n" +
+	"\x0fidempotency_key\x18\x03 \x01(\tR\x0eidempotencyKey\"*\n" +
+	"\x10GetParcelRequest\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\x03R\x06number\"2\n" +
+	"\x18ListClientParcelsRequest\x12\x16\n" +
+	"\x06client\x18\x01 \x01(\x03R\x06client\".\n" +
+	"\x14AdvanceStatusRequest\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\x03R\x06number\"H\n" +
+	"\x14ChangeAddressRequest\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\x03R\x06number\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\"-\n" +
+	"\x13DeleteParcelRequest\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\x03R\x06number\"\x16\n" +
+	"\x14DeleteParcelResponse\"\x7f\n" +
+	"\x13WatchParcelsRequest\x12\x16\n" +
+	"\x06parcel\x18\x01 \x01(\x03R\x06parcel\x12\x16\n" +
+	"\x06client\x18\x02 \x01(\x03R\x06client\x12\x14\n" +
+	"\x05types\x18\x03 \x03(\tR\x05types\x12\"\n" +
+	"\rlast_event_id\x18\x04 \x01(\x03R\vlastEventId*\x80\x01\n" +
+	"\fParcelStatus\x12\x1d\n" +
+	"\x19PARCEL_STATUS_UNSPECIFIED\x10\x00\x12\x1c\n" +
+	"\x18PARCEL_STATUS_REGISTERED\x10\x01\x12\x16\n" +
+	"\x12PARCEL_STATUS_SENT\x10\x02\x12\x1b\n" +
+	"\x17PARCEL_STATUS_DELIVERED\x10\x032\x95\x04\n" +
+	"\rParcelTracker\x12G\n" +
+	"\x0eRegisterParcel\x12!.tracker.v1.RegisterParcelRequest\x1a\x12.tracker.v1.Parcel\x12=\n" +
+	"\tGetParcel\x12\x1c.tracker.v1.GetParcelRequest\x1a\x12.tracker.v1.Parcel\x12O\n" +
+	"\x11ListClientParcels\x12$.tracker.v1.ListClientParcelsRequest\x1a\x12.tracker.v1.Parcel0\x01\x12E\n" +
+	"\rAdvanceStatus\x12 .tracker.v1.AdvanceStatusRequest\x1a\x12.tracker.v1.Parcel\x12E\n" +
+	"\rChangeAddress\x12 .tracker.v1.ChangeAddressRequest\x1a\x12.tracker.v1.Parcel\x12Q\n" +
+	"\fDeleteParcel\x12\x1f.tracker.v1.DeleteParcelRequest\x1a .tracker.v1.DeleteParcelResponse\x12J\n" +
+	"\fWatchParcels\x12\x1f.tracker.v1.WatchParcelsRequest\x1a\x17.tracker.v1.ParcelEvent0\x01BY\n" +
+	"\x1eru.yandex.practicum.tracker.v1P\x01Z5github.com/Yandex-Practicum/go-db-sql-final/trackerpbb\x06proto3"
+
+var (
+	file_tracker_proto_rawDescOnce sync.Once
+	file_tracker_proto_rawDescData []byte
+)
+
+func file_tracker_proto_rawDescGZIP() []byte {
+	file_tracker_proto_rawDescOnce.Do(func() {
+		file_tracker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tracker_proto_rawDesc), len(file_tracker_proto_rawDesc)))
+	})
+	return file_tracker_proto_rawDescData
+}
+
+var file_tracker_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_tracker_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_tracker_proto_goTypes = []any{
+	(ParcelStatus)(0),                // 0: tracker.v1.ParcelStatus
+	(*Parcel)(nil),                   // 1: tracker.v1.Parcel
+	(*ParcelEvent)(nil),              // 2: tracker.v1.ParcelEvent
+	(*RegisterParcelRequest)(nil),    // 3: tracker.v1.RegisterParcelRequest
+	(*GetParcelRequest)(nil),         // 4: tracker.v1.GetParcelRequest
+	(*ListClientParcelsRequest)(nil), // 5: tracker.v1.ListClientParcelsRequest
+	(*AdvanceStatusRequest)(nil),     // 6: tracker.v1.AdvanceStatusRequest
+	(*ChangeAddressRequest)(nil),     // 7: tracker.v1.ChangeAddressRequest
+	(*DeleteParcelRequest)(nil),      // 8: tracker.v1.DeleteParcelRequest
+	(*DeleteParcelResponse)(nil),     // 9: tracker.v1.DeleteParcelResponse
+	(*WatchParcelsRequest)(nil),      // 10: tracker.v1.WatchParcelsRequest
+	(*timestamppb.Timestamp)(nil),    // 11: google.protobuf.Timestamp
+}
+var file_tracker_proto_depIdxs = []int32{
+	0,  // 0: tracker.v1.Parcel.status:type_name -> tracker.v1.ParcelStatus
+	11, // 1: tracker.v1.Parcel.created_at:type_name -> google.protobuf.Timestamp
+	0,  // 2: tracker.v1.ParcelEvent.status:type_name -> tracker.v1.ParcelStatus
+	0,  // 3: tracker.v1.ParcelEvent.old_status:type_name -> tracker.v1.ParcelStatus
+	11, // 4: tracker.v1.ParcelEvent.occurred_at:type_name -> google.protobuf.Timestamp
+	3,  // 5: tracker.v1.ParcelTracker.RegisterParcel:input_type -> tracker.v1.RegisterParcelRequest
+	4,  // 6: tracker.v1.ParcelTracker.GetParcel:input_type -> tracker.v1.GetParcelRequest
+	5,  // 7: tracker.v1.ParcelTracker.ListClientParcels:input_type -> tracker.v1.ListClientParcelsRequest
+	6,  // 8: tracker.v1.ParcelTracker.AdvanceStatus:input_type -> tracker.v1.AdvanceStatusRequest
+	7,  // 9: tracker.v1.ParcelTracker.ChangeAddress:input_type -> tracker.v1.ChangeAddressRequest
+	8,  // 10: tracker.v1.ParcelTracker.DeleteParcel:input_type -> tracker.v1.DeleteParcelRequest
+	10, // 11: tracker.v1.ParcelTracker.WatchParcels:input_type -> tracker.v1.WatchParcelsRequest
+	1,  // 12: tracker.v1.ParcelTracker.RegisterParcel:output_type -> tracker.v1.Parcel
+	1,  // 13: tracker.v1.ParcelTracker.GetParcel:output_type -> tracker.v1.Parcel
+	1,  // 14: tracker.v1.ParcelTracker.ListClientParcels:output_type -> tracker.v1.Parcel
+	1,  // 15: tracker.v1.ParcelTracker.AdvanceStatus:output_type -> tracker.v1.Parcel
+	1,  // 16: tracker.v1.ParcelTracker.ChangeAddress:output_type -> tracker.v1.Parcel
+	9,  // 17: tracker.v1.ParcelTracker.DeleteParcel:output_type -> tracker.v1.DeleteParcelResponse
+	2,  // 18: tracker.v1.ParcelTracker.WatchParcels:output_type -> tracker.v1.ParcelEvent
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_tracker_proto_init() }
+func file_tracker_proto_init() {
+	if File_tracker_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tracker_proto_rawDesc), len(file_tracker_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tracker_proto_goTypes,
+		DependencyIndexes: file_tracker_proto_depIdxs,
+		EnumInfos:         file_tracker_proto_enumTypes,
+		MessageInfos:      file_tracker_proto_msgTypes,
+	}.Build()
+	File_tracker_proto = out.File
+	file_tracker_proto_goTypes = nil
+	file_tracker_proto_depIdxs = nil
+}
@@ -0,0 +1,379 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: tracker.proto
+
+// Контракт gRPC-интерфейса сервиса посылок.
+// Код для Go генерируется командой `go generate ./...` (см. buf.gen.yaml)
+
+package trackerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ParcelTracker_RegisterParcel_FullMethodName    = "/tracker.v1.ParcelTracker/RegisterParcel"
+	ParcelTracker_GetParcel_FullMethodName         = "/tracker.v1.ParcelTracker/GetParcel"
+	ParcelTracker_ListClientParcels_FullMethodName = "/tracker.v1.ParcelTracker/ListClientParcels"
+	ParcelTracker_AdvanceStatus_FullMethodName     = "/tracker.v1.ParcelTracker/AdvanceStatus"
+	ParcelTracker_ChangeAddress_FullMethodName     = "/tracker.v1.ParcelTracker/ChangeAddress"
+	ParcelTracker_DeleteParcel_FullMethodName      = "/tracker.v1.ParcelTracker/DeleteParcel"
+	ParcelTracker_WatchParcels_FullMethodName      = "/tracker.v1.ParcelTracker/WatchParcels"
+)
+
+// ParcelTrackerClient is the client API for ParcelTracker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ParcelTracker - регистрация посылок, смена статуса и адреса, поток обновлений
+type ParcelTrackerClient interface {
+	// Регистрирует новую посылку клиента
+	RegisterParcel(ctx context.Context, in *RegisterParcelRequest, opts ...grpc.CallOption) (*Parcel, error)
+	// Возвращает посылку по номеру. NOT_FOUND, если посылки нет
+	GetParcel(ctx context.Context, in *GetParcelRequest, opts ...grpc.CallOption) (*Parcel, error)
+	// Передаёт все посылки клиента по одной
+	ListClientParcels(ctx context.Context, in *ListClientParcelsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Parcel], error)
+	// Переводит посылку в следующий статус. FAILED_PRECONDITION, если посылка уже доставлена
+	AdvanceStatus(ctx context.Context, in *AdvanceStatusRequest, opts ...grpc.CallOption) (*Parcel, error)
+	// Меняет адрес посылки. FAILED_PRECONDITION, если посылка уже отправлена
+	ChangeAddress(ctx context.Context, in *ChangeAddressRequest, opts ...grpc.CallOption) (*Parcel, error)
+	// Удаляет посылку. FAILED_PRECONDITION, если посылка уже отправлена
+	DeleteParcel(ctx context.Context, in *DeleteParcelRequest, opts ...grpc.CallOption) (*DeleteParcelResponse, error)
+	// Поток событий посылки или всех посылок клиента.
+	// Передаёт события после last_event_id из истории, затем новые события
+	WatchParcels(ctx context.Context, in *WatchParcelsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ParcelEvent], error)
+}
+
+type parcelTrackerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewParcelTrackerClient(cc grpc.ClientConnInterface) ParcelTrackerClient {
+	return &parcelTrackerClient{cc}
+}
+
+func (c *parcelTrackerClient) RegisterParcel(ctx context.Context, in *RegisterParcelRequest, opts ...grpc.CallOption) (*Parcel, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Parcel)
+	err := c.cc.Invoke(ctx, ParcelTracker_RegisterParcel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *parcelTrackerClient) GetParcel(ctx context.Context, in *GetParcelRequest, opts ...grpc.CallOption) (*Parcel, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Parcel)
+	err := c.cc.Invoke(ctx, ParcelTracker_GetParcel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *parcelTrackerClient) ListClientParcels(ctx context.Context, in *ListClientParcelsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Parcel], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ParcelTracker_ServiceDesc.Streams[0], ParcelTracker_ListClientParcels_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ListClientParcelsRequest, Parcel]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ParcelTracker_ListClientParcelsClient = grpc.ServerStreamingClient[Parcel]
+
+func (c *parcelTrackerClient) AdvanceStatus(ctx context.Context, in *AdvanceStatusRequest, opts ...grpc.CallOption) (*Parcel, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Parcel)
+	err := c.cc.Invoke(ctx, ParcelTracker_AdvanceStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *parcelTrackerClient) ChangeAddress(ctx context.Context, in *ChangeAddressRequest, opts ...grpc.CallOption) (*Parcel, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Parcel)
+	err := c.cc.Invoke(ctx, ParcelTracker_ChangeAddress_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *parcelTrackerClient) DeleteParcel(ctx context.Context, in *DeleteParcelRequest, opts ...grpc.CallOption) (*DeleteParcelResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteParcelResponse)
+	err := c.cc.Invoke(ctx, ParcelTracker_DeleteParcel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *parcelTrackerClient) WatchParcels(ctx context.Context, in *WatchParcelsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ParcelEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ParcelTracker_ServiceDesc.Streams[1], ParcelTracker_WatchParcels_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchParcelsRequest, ParcelEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ParcelTracker_WatchParcelsClient = grpc.ServerStreamingClient[ParcelEvent]
+
+// ParcelTrackerServer is the server API for ParcelTracker service.
+// All implementations must embed UnimplementedParcelTrackerServer
+// for forward compatibility.
+//
+// ParcelTracker - регистрация посылок, смена статуса и адреса, поток обновлений
+type ParcelTrackerServer interface {
+	// Регистрирует новую посылку клиента
+	RegisterParcel(context.Context, *RegisterParcelRequest) (*Parcel, error)
+	// Возвращает посылку по номеру. NOT_FOUND, если посылки нет
+	GetParcel(context.Context, *GetParcelRequest) (*Parcel, error)
+	// Передаёт все посылки клиента по одной
+	ListClientParcels(*ListClientParcelsRequest, grpc.ServerStreamingServer[Parcel]) error
+	// Переводит посылку в следующий статус. FAILED_PRECONDITION, если посылка уже доставлена
+	AdvanceStatus(context.Context, *AdvanceStatusRequest) (*Parcel, error)
+	// Меняет адрес посылки. FAILED_PRECONDITION, если посылка уже отправлена
+	ChangeAddress(context.Context, *ChangeAddressRequest) (*Parcel, error)
+	// Удаляет посылку. FAILED_PRECONDITION, если посылка уже отправлена
+	DeleteParcel(context.Context, *DeleteParcelRequest) (*DeleteParcelResponse, error)
+	// Поток событий посылки или всех посылок клиента.
+	// Передаёт события после last_event_id из истории, затем новые события
+	WatchParcels(*WatchParcelsRequest, grpc.ServerStreamingServer[ParcelEvent]) error
+	mustEmbedUnimplementedParcelTrackerServer()
+}
+
+// UnimplementedParcelTrackerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedParcelTrackerServer struct{}
+
+func (UnimplementedParcelTrackerServer) RegisterParcel(context.Context, *RegisterParcelRequest) (*Parcel, error) {
+	return nil, status.Error(codes.Unimplemented, "method RegisterParcel not implemented")
+}
+func (UnimplementedParcelTrackerServer) GetParcel(context.Context, *GetParcelRequest) (*Parcel, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetParcel not implemented")
+}
+func (UnimplementedParcelTrackerServer) ListClientParcels(*ListClientParcelsRequest, grpc.ServerStreamingServer[Parcel]) error {
+	return status.Error(codes.Unimplemented, "method ListClientParcels not implemented")
+}
+func (UnimplementedParcelTrackerServer) AdvanceStatus(context.Context, *AdvanceStatusRequest) (*Parcel, error) {
+	return nil, status.Error(codes.Unimplemented, "method AdvanceStatus not implemented")
+}
+func (UnimplementedParcelTrackerServer) ChangeAddress(context.Context, *ChangeAddressRequest) (*Parcel, error) {
+	return nil, status.Error(codes.Unimplemented, "method ChangeAddress not implemented")
+}
+func (UnimplementedParcelTrackerServer) DeleteParcel(context.Context, *DeleteParcelRequest) (*DeleteParcelResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeleteParcel not implemented")
+}
+func (UnimplementedParcelTrackerServer) WatchParcels(*WatchParcelsRequest, grpc.ServerStreamingServer[ParcelEvent]) error {
+	return status.Error(codes.Unimplemented, "method WatchParcels not implemented")
+}
+func (UnimplementedParcelTrackerServer) mustEmbedUnimplementedParcelTrackerServer() {}
+func (UnimplementedParcelTrackerServer) testEmbeddedByValue()                       {}
+
+// UnsafeParcelTrackerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ParcelTrackerServer will
+// result in compilation errors.
+type UnsafeParcelTrackerServer interface {
+	mustEmbedUnimplementedParcelTrackerServer()
+}
+
+func RegisterParcelTrackerServer(s grpc.ServiceRegistrar, srv ParcelTrackerServer) {
+	// If the following call panics, it indicates UnimplementedParcelTrackerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ParcelTracker_ServiceDesc, srv)
+}
+
+func _ParcelTracker_RegisterParcel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterParcelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ParcelTrackerServer).RegisterParcel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ParcelTracker_RegisterParcel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ParcelTrackerServer).RegisterParcel(ctx, req.(*RegisterParcelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ParcelTracker_GetParcel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetParcelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ParcelTrackerServer).GetParcel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ParcelTracker_GetParcel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ParcelTrackerServer).GetParcel(ctx, req.(*GetParcelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ParcelTracker_ListClientParcels_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListClientParcelsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ParcelTrackerServer).ListClientParcels(m, &grpc.GenericServerStream[ListClientParcelsRequest, Parcel]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ParcelTracker_ListClientParcelsServer = grpc.ServerStreamingServer[Parcel]
+
+func _ParcelTracker_AdvanceStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdvanceStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ParcelTrackerServer).AdvanceStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ParcelTracker_AdvanceStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ParcelTrackerServer).AdvanceStatus(ctx, req.(*AdvanceStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ParcelTracker_ChangeAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeAddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ParcelTrackerServer).ChangeAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ParcelTracker_ChangeAddress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ParcelTrackerServer).ChangeAddress(ctx, req.(*ChangeAddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ParcelTracker_DeleteParcel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteParcelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ParcelTrackerServer).DeleteParcel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ParcelTracker_DeleteParcel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ParcelTrackerServer).DeleteParcel(ctx, req.(*DeleteParcelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ParcelTracker_WatchParcels_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchParcelsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ParcelTrackerServer).WatchParcels(m, &grpc.GenericServerStream[WatchParcelsRequest, ParcelEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ParcelTracker_WatchParcelsServer = grpc.ServerStreamingServer[ParcelEvent]
+
+// ParcelTracker_ServiceDesc is the grpc.ServiceDesc for ParcelTracker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ParcelTracker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tracker.v1.ParcelTracker",
+	HandlerType: (*ParcelTrackerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterParcel",
+			Handler:    _ParcelTracker_RegisterParcel_Handler,
+		},
+		{
+			MethodName: "GetParcel",
+			Handler:    _ParcelTracker_GetParcel_Handler,
+		},
+		{
+			MethodName: "AdvanceStatus",
+			Handler:    _ParcelTracker_AdvanceStatus_Handler,
+		},
+		{
+			MethodName: "ChangeAddress",
+			Handler:    _ParcelTracker_ChangeAddress_Handler,
+		},
+		{
+			MethodName: "DeleteParcel",
+			Handler:    _ParcelTracker_DeleteParcel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListClientParcels",
+			Handler:       _ParcelTracker_ListClientParcels_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchParcels",
+			Handler:       _ParcelTracker_WatchParcels_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tracker.proto",
+}
@@ -0,0 +1,855 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Заголовки запроса с уведомлением
+const (
+	WebhookHeaderEvent     = "X-Webhook-Event"
+	WebhookHeaderID        = "X-Webhook-ID"
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	// maxWebhookURLLength - наибольшая длина адреса уведомлений, как у столбца webhook_subscription.url
+	maxWebhookURLLength = 2048
+	// maxWebhookSecretLength - наибольшая длина ключа подписи: зашифрованный ключ помещается в столбец webhook_subscription.secret
+	maxWebhookSecretLength = 128
+)
+
+// sharedAddressSpace - адреса операторов связи за NAT (RFC 6598), недоступные подписчикам, как и частные сети
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// ErrInvalidWebhook - некорректный адрес или типы событий подписки
+var ErrInvalidWebhook = errors.New("invalid webhook subscription")
+
+// webhookEventTypes - типы событий, на которые можно подписаться
+var webhookEventTypes = map[string]bool{
+	EventRegistered:     true,
+	EventStatusChanged:  true,
+	EventAddressChanged: true,
+	EventDeleted:        true,
+	EventSLAAtRisk:      true,
+	EventSLABreached:    true,
+}
+
+// WebhookSubscription - подписка клиента на уведомления о событиях его посылок
+type WebhookSubscription struct {
+	ID        int      `json:"id"`
+	Client    int      `json:"client"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"` // Ключ HMAC-SHA256 для подписи тела уведомления
+	Events    []string `json:"events"`           // Типы событий, пустой список - все события
+	CreatedAt string   `json:"created_at"`
+}
+
+// accepts - проверяет, подписан ли получатель на события типа eventType
+func (w WebhookSubscription) accepts(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDeadLetter - уведомление, которое не удалось доставить за все попытки
+type WebhookDeadLetter struct {
+	ID           int    `json:"id"`
+	Subscription int    `json:"subscription"`
+	Parcel       int    `json:"parcel"`
+	Client       int    `json:"client"`
+	URL          string `json:"url"`
+	EventType    string `json:"event_type"`
+	Payload      string `json:"payload"` // Тело уведомления
+	Attempts     int    `json:"attempts"`
+	LastError    string `json:"last_error"`
+	FailedAt     string `json:"failed_at"`
+}
+
+// AddWebhook - метод для добавления подписки клиента на уведомления
+func (s ParcelStore) AddWebhook(ctx context.Context, w WebhookSubscription) (id int, err error) {
+	ctx, op := s.begin(ctx, "add_webhook", "INSERT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, w.Client))
+	}()
+
+	// ключ подписи шифруется так же, как адреса посылок
+	secret, err := s.cipher.Encrypt(fieldWebhookSecret, w.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.q.QueryRowContext(ctx, "INSERT INTO webhook_subscription (client, url, secret, events, created_at) VALUES (:client, :url, :secret, :events, :created_at) RETURNING id",
+		sql.Named("client", w.Client),
+		sql.Named("url", w.URL),
+		sql.Named("secret", secret),
+		sql.Named("events", strings.Join(w.Events, ",")),
+		sql.Named("created_at", time.Now().UTC().Format(time.RFC3339))).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add webhook for client %d: error: %w", w.Client, err)
+	}
+	return id, nil
+}
+
+// GetWebhooksByClient - метод для получения всех подписок клиента
+func (s ParcelStore) GetWebhooksByClient(ctx context.Context, client int) (res []WebhookSubscription, err error) {
+	ctx, op := s.begin(ctx, "get_webhooks_by_client", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT id, client, url, secret, events, created_at FROM webhook_subscription WHERE client = :client ORDER BY id",
+		sql.Named("client", client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhooks of client %d: error: %w", client, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w WebhookSubscription
+		var events string
+		if err = rows.Scan(&w.ID, &w.Client, &w.URL, &w.Secret, &events, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving webhooks of client %d: error: %w", client, err)
+		}
+		if events != "" {
+			w.Events = strings.Split(events, ",")
+		}
+		if w.Secret, err = s.cipher.Decrypt(fieldWebhookSecret, w.Secret); err != nil {
+			return nil, fmt.Errorf("webhook %d: %w", w.ID, err)
+		}
+		res = append(res, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving webhooks of client %d: %w", client, err)
+	}
+	return res, nil
+}
+
+// DeleteWebhook - метод для удаления подписки
+func (s ParcelStore) DeleteWebhook(ctx context.Context, id int) (err error) {
+	ctx, op := s.begin(ctx, "delete_webhook", "DELETE")
+	defer func() {
+		op.end(err, slog.Int("webhook", id))
+	}()
+
+	// недоставленные уведомления удалённой подписки больше некому отправлять
+	return s.InTx(ctx, func(tx ParcelStore) error {
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM webhook_delivery WHERE subscription = :id", sql.Named("id", id)); err != nil {
+			return fmt.Errorf("failed to delete deliveries of webhook %d: error: %w", id, err)
+		}
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM webhook_subscription WHERE id = :id", sql.Named("id", id)); err != nil {
+			return fmt.Errorf("webhook deletion error №%d: %w", id, err)
+		}
+		return nil
+	})
+}
+
+// getWebhook - метод для получения подписки по идентификатору
+func (s ParcelStore) getWebhook(ctx context.Context, id int) (w WebhookSubscription, err error) {
+	ctx, op := s.begin(ctx, "get_webhook", "SELECT")
+	defer func() {
+		op.end(err, slog.Int("webhook", id))
+	}()
+
+	var events string
+	err = s.reader().QueryRowContext(ctx, "SELECT id, client, url, secret, events, created_at FROM webhook_subscription WHERE id = :id",
+		sql.Named("id", id)).Scan(&w.ID, &w.Client, &w.URL, &w.Secret, &events, &w.CreatedAt)
+	if err != nil {
+		return w, fmt.Errorf("failed to retrieve webhook %d: error: %w", id, err)
+	}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	if w.Secret, err = s.cipher.Decrypt(fieldWebhookSecret, w.Secret); err != nil {
+		return w, fmt.Errorf("webhook %d: %w", id, err)
+	}
+	return w, nil
+}
+
+// addWebhookDeadLetter - метод для сохранения недоставленного уведомления
+func (s ParcelStore) addWebhookDeadLetter(ctx context.Context, d WebhookDeadLetter) (err error) {
+	ctx, op := s.begin(ctx, "add_webhook_dead_letter", "INSERT")
+	defer func() {
+		op.end(err, slog.Int("webhook", d.Subscription))
+	}()
+
+	// тело уведомления содержит адрес посылки
+	payload, err := s.cipher.Encrypt(fieldDeadLetterPayload, d.Payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.q.ExecContext(ctx, `INSERT INTO webhook_dead_letter (subscription, parcel, client, url, event_type, payload, attempts, last_error, failed_at)
+VALUES (:subscription, :parcel, :client, :url, :event_type, :payload, :attempts, :last_error, :failed_at)`,
+		sql.Named("subscription", d.Subscription),
+		sql.Named("parcel", d.Parcel),
+		sql.Named("client", d.Client),
+		sql.Named("url", d.URL),
+		sql.Named("event_type", d.EventType),
+		sql.Named("payload", payload),
+		sql.Named("attempts", d.Attempts),
+		sql.Named("last_error", d.LastError),
+		sql.Named("failed_at", d.FailedAt))
+	if err != nil {
+		return fmt.Errorf("failed to add webhook dead letter for subscription %d: error: %w", d.Subscription, err)
+	}
+	return nil
+}
+
+// GetWebhookDeadLetters - метод для получения недоставленных уведомлений подписки
+func (s ParcelStore) GetWebhookDeadLetters(ctx context.Context, subscription int) (res []WebhookDeadLetter, err error) {
+	ctx, op := s.begin(ctx, "get_webhook_dead_letters", "SELECT")
+	defer func() {
+		op.end(err, slog.Int("webhook", subscription), slog.Int(attrCount, len(res)))
+	}()
+
+	rows, err := s.reader().QueryContext(ctx, "SELECT "+deadLetterColumns+" FROM webhook_dead_letter WHERE subscription = :subscription ORDER BY id",
+		sql.Named("subscription", subscription))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve dead letters of webhook %d: error: %w", subscription, err)
+	}
+	defer rows.Close()
+
+	return s.scanDeadLetters(rows)
+}
+
+// GetWebhookDeadLettersByClient - метод для получения недоставленных уведомлений о посылках клиента
+func (s ParcelStore) GetWebhookDeadLettersByClient(ctx context.Context, client int) (res []WebhookDeadLetter, err error) {
+	ctx, op := s.begin(ctx, "get_webhook_dead_letters_by_client", "SELECT")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	if err = s.backfillDeadLetters(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.q.QueryContext(ctx, "SELECT "+deadLetterColumns+" FROM webhook_dead_letter WHERE client = :client ORDER BY id",
+		sql.Named("client", client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve dead letters of client %d: error: %w", client, err)
+	}
+	defer rows.Close()
+
+	return s.scanDeadLetters(rows)
+}
+
+// deadLetterColumns - столбцы webhook_dead_letter в порядке полей, которые читает scanDeadLetters
+const deadLetterColumns = "id, subscription, parcel, client, url, event_type, payload, attempts, last_error, failed_at"
+
+// scanDeadLetters - читает недоставленные уведомления из строк результата запроса и расшифровывает их тела
+func (s ParcelStore) scanDeadLetters(rows *sql.Rows) ([]WebhookDeadLetter, error) {
+	var res []WebhookDeadLetter
+	for rows.Next() {
+		var d WebhookDeadLetter
+		if err := rows.Scan(&d.ID, &d.Subscription, &d.Parcel, &d.Client, &d.URL, &d.EventType, &d.Payload, &d.Attempts, &d.LastError, &d.FailedAt); err != nil {
+			return nil, fmt.Errorf("row scanning error while retrieving dead letters: error: %w", err)
+		}
+		var err error
+		if d.Payload, err = s.cipher.Decrypt(fieldDeadLetterPayload, d.Payload); err != nil {
+			return nil, fmt.Errorf("dead letter %d: %w", d.ID, err)
+		}
+		res = append(res, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows while retrieving dead letters: %w", err)
+	}
+	return res, nil
+}
+
+// backfillDeadLetters - заполняет посылку и клиента у недоставленных уведомлений, записанных до появления
+// этих столбцов, по телу уведомления, чтобы их находили выгрузка, обезличивание и удаление посылок
+func (s ParcelStore) backfillDeadLetters(ctx context.Context) (err error) {
+	ctx, op := s.begin(ctx, "backfill_dead_letters", "UPDATE")
+	var count int
+	defer func() {
+		op.end(err, slog.Int(attrCount, count))
+	}()
+
+	rows, err := s.q.QueryContext(ctx, "SELECT "+deadLetterColumns+" FROM webhook_dead_letter WHERE parcel = 0")
+	if err != nil {
+		return fmt.Errorf("failed to select dead letters to backfill: error: %w", err)
+	}
+	letters, err := s.scanDeadLetters(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range letters {
+		var event ParcelEvent
+		if err := json.Unmarshal([]byte(d.Payload), &event); err != nil {
+			return fmt.Errorf("failed to decode dead letter %d: %w", d.ID, err)
+		}
+		_, err = s.q.ExecContext(ctx, "UPDATE webhook_dead_letter SET parcel = :parcel, client = :client WHERE id = :id",
+			sql.Named("parcel", event.Number), sql.Named("client", event.Client), sql.Named("id", d.ID))
+		if err != nil {
+			return fmt.Errorf("failed to backfill dead letter %d: error: %w", d.ID, err)
+		}
+		count++
+	}
+	return nil
+}
+
+// validate - проверяет адрес http(s), ключ подписи и типы событий подписки. Узел адреса должен разрешаться
+// только в публичные адреса, чтобы клиент не мог направить запросы сервиса во внутреннюю сеть
+func (w WebhookSubscription) validate(ctx context.Context) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || len(w.URL) > maxWebhookURLLength {
+		return fmt.Errorf("url must be an absolute http(s) url up to %d characters: %w", maxWebhookURLLength, ErrInvalidWebhook)
+	}
+	if err := checkWebhookHost(ctx, u.Hostname()); err != nil {
+		return err
+	}
+	if len(w.Secret) > maxWebhookSecretLength {
+		return fmt.Errorf("secret must not exceed %d characters: %w", maxWebhookSecretLength, ErrInvalidWebhook)
+	}
+	for _, e := range w.Events {
+		if !webhookEventTypes[e] {
+			return fmt.Errorf("unknown event type %q: %w", e, ErrInvalidWebhook)
+		}
+	}
+	return nil
+}
+
+// checkWebhookHost - проверяет, что узел host разрешается только в публичные адреса
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve webhook host %q: %v: %w", host, err, ErrInvalidWebhook)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return fmt.Errorf("webhook host %q resolves to non-public address %s: %w", host, addr, ErrInvalidWebhook)
+		}
+	}
+	return nil
+}
+
+// publicAddress - публичный ли адрес: адреса loopback, частных сетей, link-local (в том числе 169.254.169.254),
+// multicast и неопределённый адрес публичными не считаются
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// dialPublicOnly - проверка соединения перед подключением: уведомления отправляются только на публичные адреса.
+// Проверяется адрес, к которому действительно подключается клиент, поэтому её не обойти сменой записи DNS
+// после регистрации подписки или перенаправлением
+func dialPublicOnly(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid webhook address %q: %w", address, err)
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("webhook address %s is not public: %w", addrPort.Addr(), ErrInvalidWebhook)
+	}
+	return nil
+}
+
+// newWebhookSecret - случайный ключ подписи уведомлений
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: error: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddWebhook - подписывает клиента на уведомления о событиях его посылок. Клиент может подписать только себя.
+// Если ключ подписи не задан, он генерируется. Ключ возвращается только в ответе на создание подписки
+func (s ParcelService) AddWebhook(ctx context.Context, w WebhookSubscription) (res WebhookSubscription, err error) {
+	ctx, op := s.begin(ctx, "add_webhook")
+	defer func() {
+		op.end(err, slog.Int(attrClient, w.Client), slog.Int("webhook", res.ID))
+	}()
+
+	if err = authorizeClient(ctx, w.Client); err != nil {
+		return res, err
+	}
+	if err = w.validate(ctx); err != nil {
+		return res, err
+	}
+	if w.Secret == "" {
+		if w.Secret, err = newWebhookSecret(); err != nil {
+			return res, err
+		}
+	}
+
+	err = s.store.InTx(ctx, func(tx ParcelStore) error {
+		id, err := tx.AddWebhook(ctx, w)
+		if err != nil {
+			return err
+		}
+		res, err = tx.getWebhook(ctx, id)
+		return err
+	})
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	return res, nil
+}
+
+// Webhooks - возвращает подписки клиента без ключей подписи
+func (s ParcelService) Webhooks(ctx context.Context, client int) (res []WebhookSubscription, err error) {
+	ctx, op := s.begin(ctx, "webhooks")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int(attrCount, len(res)))
+	}()
+
+	if err = authorizeClient(ctx, client); err != nil {
+		return nil, err
+	}
+	if res, err = s.store.GetWebhooksByClient(ctx, client); err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Secret = ""
+	}
+	return res, nil
+}
+
+// DeleteWebhook - удаляет подписку клиента вместе с ожидающими доставки уведомлениями.
+// Подписка другого клиента считается ненайденной
+func (s ParcelService) DeleteWebhook(ctx context.Context, client, id int) (err error) {
+	ctx, op := s.begin(ctx, "delete_webhook")
+	defer func() {
+		op.end(err, slog.Int(attrClient, client), slog.Int("webhook", id))
+	}()
+
+	if err = authorizeClient(ctx, client); err != nil {
+		return err
+	}
+	return s.store.InTx(ctx, func(tx ParcelStore) error {
+		w, err := tx.getWebhook(ctx, id)
+		if err != nil {
+			return err
+		}
+		if w.Client != client {
+			return fmt.Errorf("webhook %d does not belong to client %d: %w", id, client, sql.ErrNoRows)
+		}
+		return tx.DeleteWebhook(ctx, id)
+	})
+}
+
+// webhookDeliveryID - идентификатор уведомления о событии outbox eventID для подписки subscription
+func webhookDeliveryID(eventID int64, subscription int) string {
+	return fmt.Sprintf("evt-%d-sub-%d", eventID, subscription)
+}
+
+// webhookDelivery - уведомление о событии, ожидающее доставки одному подписчику
+type webhookDelivery struct {
+	id           int64
+	subscription int
+	parcel       int
+	client       int
+	eventType    string
+	deliveryID   string // Идентификатор уведомления в заголовке X-Webhook-ID, общий для всех попыток
+	body         []byte
+	attempts     int // Количество уже выполненных попыток
+}
+
+// addWebhookDelivery - метод для записи уведомления, которое нужно доставить подписчику
+func (s ParcelStore) addWebhookDelivery(ctx context.Context, d webhookDelivery, due time.Time) (err error) {
+	ctx, op := s.begin(ctx, "add_webhook_delivery", "INSERT")
+	defer func() {
+		op.end(err, slog.Int("webhook", d.subscription), slog.Int(attrParcel, d.parcel))
+	}()
+
+	// тело уведомления содержит адрес посылки
+	payload, err := s.cipher.Encrypt(fieldWebhookPayload, string(d.body))
+	if err != nil {
+		return err
+	}
+
+	// повторная публикация того же события не создаёт второе уведомление подписчику
+	_, err = s.q.ExecContext(ctx, `INSERT INTO webhook_delivery (subscription, parcel, client, event_type, delivery_id, payload, next_attempt_at)
+VALUES (:subscription, :parcel, :client, :event_type, :delivery_id, :payload, :next_attempt_at)
+ON CONFLICT (delivery_id) DO NOTHING`,
+		sql.Named("subscription", d.subscription),
+		sql.Named("parcel", d.parcel),
+		sql.Named("client", d.client),
+		sql.Named("event_type", d.eventType),
+		sql.Named("delivery_id", d.deliveryID),
+		sql.Named("payload", payload),
+		sql.Named("next_attempt_at", due.UTC().Format(time.RFC3339)))
+	if err != nil {
+		return fmt.Errorf("failed to add delivery of event %s of parcel №%d to webhook %d: error: %w", d.eventType, d.parcel, d.subscription, err)
+	}
+	return nil
+}
+
+// claimWebhookDelivery - метод для захвата одного уведомления, время попытки которого наступило к now.
+// Время следующей попытки захваченного уведомления переносится на until, поэтому другие обработчики
+// его не получат, а если процесс завершится во время попытки, уведомление будет доставлено повторно после until.
+// Если доставлять нечего, возвращает false
+func (s ParcelStore) claimWebhookDelivery(ctx context.Context, now, until time.Time) (d webhookDelivery, ok bool, err error) {
+	ctx, op := s.begin(ctx, "claim_webhook_delivery", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int64("delivery", d.id))
+	}()
+
+	var payload string
+	err = s.q.QueryRowContext(ctx, `UPDATE webhook_delivery SET next_attempt_at = :until
+WHERE id = (SELECT id FROM webhook_delivery WHERE next_attempt_at <= :now ORDER BY next_attempt_at, id LIMIT 1)
+  AND next_attempt_at <= :now
+RETURNING id, subscription, parcel, client, event_type, delivery_id, payload, attempts`,
+		sql.Named("now", now.UTC().Format(time.RFC3339)),
+		sql.Named("until", until.UTC().Format(time.RFC3339))).
+		Scan(&d.id, &d.subscription, &d.parcel, &d.client, &d.eventType, &d.deliveryID, &payload, &d.attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, false, nil
+	}
+	if err != nil {
+		return d, false, fmt.Errorf("failed to claim webhook delivery: error: %w", err)
+	}
+
+	plain, err := s.cipher.Decrypt(fieldWebhookPayload, payload)
+	if err != nil {
+		return d, false, fmt.Errorf("webhook delivery %d: %w", d.id, err)
+	}
+	d.body = []byte(plain)
+	return d, true, nil
+}
+
+// retryWebhookDelivery - метод для записи неудачной попытки и времени следующей попытки
+func (s ParcelStore) retryWebhookDelivery(ctx context.Context, id int64, attempts int, cause error, next time.Time) (err error) {
+	ctx, op := s.begin(ctx, "retry_webhook_delivery", "UPDATE")
+	defer func() {
+		op.end(err, slog.Int64("delivery", id))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "UPDATE webhook_delivery SET attempts = :attempts, last_error = :last_error, next_attempt_at = :next_attempt_at WHERE id = :id",
+		sql.Named("attempts", attempts),
+		sql.Named("last_error", cause.Error()),
+		sql.Named("next_attempt_at", next.UTC().Format(time.RFC3339)),
+		sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("failed to schedule retry of webhook delivery %d: error: %w", id, err)
+	}
+	return nil
+}
+
+// deleteWebhookDelivery - метод для удаления доставленного или перенесённого в dead letter уведомления
+func (s ParcelStore) deleteWebhookDelivery(ctx context.Context, id int64) (err error) {
+	ctx, op := s.begin(ctx, "delete_webhook_delivery", "DELETE")
+	defer func() {
+		op.end(err, slog.Int64("delivery", id))
+	}()
+
+	_, err = s.q.ExecContext(ctx, "DELETE FROM webhook_delivery WHERE id = :id", sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook delivery %d: error: %w", id, err)
+	}
+	return nil
+}
+
+// SignWebhookPayload - вычисляет подпись тела уведомления в формате "sha256=<hex HMAC-SHA256>"
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookConfig - параметры доставки уведомлений
+type WebhookConfig struct {
+	Workers      int           // Количество параллельных обработчиков доставки
+	PollInterval time.Duration // Интервал опроса таблицы webhook_delivery
+	BatchSize    int           // Максимальное количество попыток доставки за один опрос
+	MaxAttempts  int           // Количество попыток доставки до переноса в dead letter
+	BaseDelay    time.Duration // Задержка перед второй попыткой, далее удваивается
+	MaxDelay     time.Duration // Максимальная задержка между попытками
+	Timeout      time.Duration // Таймаут одного HTTP-запроса
+	// AllowPrivate - разрешает отправку на адреса loopback и частных сетей, только для тестов
+	// с локальным получателем. По умолчанию уведомления отправляются только на публичные адреса
+	AllowPrivate bool
+}
+
+// DefaultWebhookConfig - параметры доставки по умолчанию
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Workers:      4,
+		PollInterval: time.Second,
+		BatchSize:    10,
+		MaxAttempts:  5,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		Timeout:      10 * time.Second,
+	}
+}
+
+// backoff - задержка перед попыткой номер attempt+1 (attempt начинается с 1)
+func (c WebhookConfig) backoff(attempt int) time.Duration {
+	return retryDelay(c.BaseDelay, c.MaxDelay, attempt)
+}
+
+// WebhookNotifier - приёмник событий, доставляющий подписанные уведомления подписчикам.
+// Publish записывает уведомления в таблицу webhook_delivery, поэтому событие outbox отмечается доставленным
+// только после того, как уведомления сохранены, и не теряется при сбое процесса. Обработчики в фоне
+// доставляют уведомления с повторными попытками; уведомление удаляется из таблицы после успешной доставки
+// или переноса в dead letter
+type WebhookNotifier struct {
+	store  ParcelStore
+	cfg    WebhookConfig
+	client *http.Client
+	logger *slog.Logger
+
+	// ctx отменяется при принудительной остановке и прерывает текущие запросы
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{} // Сигнал о новых уведомлениях
+	stop   chan struct{} // Закрывается при остановке, обработчики завершаются после текущей порции
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewWebhookNotifier - создаёт приёмник уведомлений и запускает обработчики доставки
+func NewWebhookNotifier(store ParcelStore, cfg WebhookConfig) *WebhookNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &WebhookNotifier{
+		store:  store,
+		cfg:    cfg,
+		client: newWebhookClient(cfg),
+		logger: store.logger,
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		n.wg.Add(1)
+		go n.worker()
+	}
+	return n
+}
+
+// newWebhookClient - HTTP-клиент уведомлений без прокси, подключающийся только к публичным адресам
+func newWebhookClient(cfg WebhookConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = dialPublicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// через прокси проверялся бы адрес прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// Publish - записывает уведомления о событии для всех подписок клиента, принимающих события этого типа.
+// Идентификатор уведомления определяется событием и подпиской, поэтому повторная публикация события
+// не создаёт уведомление, ещё ожидающее доставки, второй раз, а подписчик может отбросить повтор по X-Webhook-ID
+func (n *WebhookNotifier) Publish(ctx context.Context, event ParcelEvent) error {
+	subscriptions, err := n.store.GetWebhooksByClient(ctx, event.Client)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = n.store.InTx(ctx, func(tx ParcelStore) error {
+		for _, sub := range subscriptions {
+			if !sub.accepts(event.Type) {
+				continue
+			}
+			id := webhookDeliveryID(event.ID, sub.ID)
+			body, err := json.Marshal(struct {
+				ID string `json:"id"`
+				ParcelEvent
+			}{id, event})
+			if err != nil {
+				return fmt.Errorf("failed to encode webhook payload: %w", err)
+			}
+			d := webhookDelivery{
+				subscription: sub.ID,
+				parcel:       event.Number,
+				client:       event.Client,
+				eventType:    event.Type,
+				deliveryID:   id,
+				body:         body,
+			}
+			if err := tx.addWebhookDelivery(ctx, d, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// DeliverPending - выполняет попытки доставки уведомлений, время которых наступило, не больше BatchSize,
+// и возвращает количество выполненных попыток
+func (n *WebhookNotifier) DeliverPending(ctx context.Context) (int, error) {
+	for i := 0; i < n.cfg.BatchSize; i++ {
+		now := time.Now()
+		d, ok, err := n.store.claimWebhookDelivery(ctx, now, now.Add(2*n.cfg.Timeout))
+		if err != nil || !ok {
+			return i, err
+		}
+		if err := n.deliver(ctx, d); err != nil {
+			return i + 1, err
+		}
+	}
+	return n.cfg.BatchSize, nil
+}
+
+// Shutdown - останавливает обработчики и ждёт завершения текущих попыток доставки.
+// Если ctx завершается раньше, текущие запросы прерываются. Недоставленные уведомления остаются
+// в таблице webhook_delivery и доставляются после перезапуска
+func (n *WebhookNotifier) Shutdown(ctx context.Context) error {
+	n.once.Do(func() { close(n.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		n.cancel()
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// worker - обработчик, опрашивающий таблицу webhook_delivery до остановки
+func (n *WebhookNotifier) worker() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		count, err := n.DeliverPending(n.ctx)
+		if err != nil && n.ctx.Err() == nil {
+			n.logger.Warn("webhook delivery failed", slog.Any(attrError, err))
+		}
+
+		// полная порция - вероятно, есть ещё уведомления, которые пора доставить
+		if err == nil && count == n.cfg.BatchSize {
+			select {
+			case <-n.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-n.stop:
+			return
+		case <-n.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver - выполняет попытку доставки захваченного уведомления. После неудачной попытки следующая
+// назначается с экспоненциальной задержкой, после последней уведомление переносится в dead letter
+func (n *WebhookNotifier) deliver(ctx context.Context, d webhookDelivery) error {
+	sub, err := n.store.getWebhook(ctx, d.subscription)
+	if errors.Is(err, sql.ErrNoRows) {
+		// подписка удалена после записи уведомления
+		return n.store.deleteWebhookDelivery(ctx, d.id)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = n.send(ctx, sub, d)
+	if err == nil {
+		return n.store.deleteWebhookDelivery(ctx, d.id)
+	}
+	if ctx.Err() != nil {
+		// попытка прервана остановкой и будет повторена после окончания захвата
+		return ctx.Err()
+	}
+
+	attempt := d.attempts + 1
+	n.logger.Warn("webhook delivery attempt failed",
+		slog.Int("webhook", d.subscription),
+		slog.Int("attempt", attempt),
+		slog.Any(attrError, err))
+
+	if attempt < n.cfg.MaxAttempts {
+		return n.store.retryWebhookDelivery(ctx, d.id, attempt, err, time.Now().Add(n.cfg.backoff(attempt)))
+	}
+	return n.store.InTx(ctx, func(tx ParcelStore) error {
+		dlErr := tx.addWebhookDeadLetter(ctx, WebhookDeadLetter{
+			Subscription: sub.ID,
+			Parcel:       d.parcel,
+			Client:       d.client,
+			URL:          sub.URL,
+			EventType:    d.eventType,
+			Payload:      string(d.body),
+			Attempts:     attempt,
+			LastError:    err.Error(),
+			FailedAt:     time.Now().UTC().Format(time.RFC3339),
+		})
+		if dlErr != nil {
+			return dlErr
+		}
+		return tx.deleteWebhookDelivery(ctx, d.id)
+	})
+}
+
+// send - выполняет одну попытку доставки. Успешной считается доставка с кодом ответа 2xx
+func (n *WebhookNotifier) send(ctx context.Context, sub WebhookSubscription, d webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderEvent, d.eventType)
+	req.Header.Set(WebhookHeaderID, d.deliveryID)
+	req.Header.Set(WebhookHeaderSignature, SignWebhookPayload(sub.Secret, d.body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
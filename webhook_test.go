@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWebhookConfig - параметры доставки с короткими задержками для тестов.
+// Фоновые обработчики не запускаются, уведомления доставляет deliverWebhooks
+func testWebhookConfig() WebhookConfig {
+	cfg := DefaultWebhookConfig()
+	cfg.Workers = 0
+	cfg.MaxAttempts = 3
+	cfg.BaseDelay = time.Millisecond
+	cfg.MaxDelay = 5 * time.Millisecond
+	cfg.Timeout = time.Second
+	// получатели в тестах слушают на loopback
+	cfg.AllowPrivate = true
+	return cfg
+}
+
+// pendingWebhookDeliveries - количество уведомлений подписки, ожидающих доставки
+func pendingWebhookDeliveries(t *testing.T, db *sql.DB, subscription int) int {
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM webhook_delivery WHERE subscription = ?", subscription).Scan(&n))
+	return n
+}
+
+// deliverWebhooks - выполняет попытки доставки, пока у подписки есть ожидающие уведомления
+func deliverWebhooks(t *testing.T, db *sql.DB, n *WebhookNotifier, subscription int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for pendingWebhookDeliveries(t, db, subscription) > 0 {
+		require.True(t, time.Now().Before(deadline), "webhook deliveries are still pending")
+		count, err := n.DeliverPending(context.Background())
+		require.NoError(t, err, "failed to deliver webhooks. Error: %v", err)
+		if count == 0 {
+			// время следующей попытки хранится с точностью до секунды
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// TestWebhookDelivery - тест для проверки доставки подписанных уведомлений о смене статуса и адреса
+func TestWebhookDelivery(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	// Получатель уведомлений проверяет подпись и сохраняет полученные события
+	const secret = "test-secret"
+	received := make(chan ParcelEvent, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if r.Header.Get(WebhookHeaderSignature) != SignWebhookPayload(secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var event ParcelEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, event.Type, r.Header.Get(WebhookHeaderEvent))
+		received <- event
+	}))
+	defer receiver.Close()
+
+	store := NewParcelStore(db)
+	client := randRange.Intn(10_000_000)
+	id, err := store.AddWebhook(context.Background(), WebhookSubscription{Client: client, URL: receiver.URL, Secret: secret})
+	require.NoError(t, err, "failed to add webhook. Error: %v", err)
+
+	notifier := NewWebhookNotifier(store, testWebhookConfig())
+	service := NewParcelService(store, WithOutput(io.Discard))
+
+	parcel, err := service.Register(client, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	require.NoError(t, service.ChangeAddress(parcel.Number, "new test address"))
+	require.NoError(t, service.NextStatus(parcel.Number))
+	dispatchAll(t, NewOutboxDispatcher(store, DefaultOutboxConfig(), notifier))
+	deliverWebhooks(t, db, notifier, id)
+	require.NoError(t, notifier.Shutdown(context.Background()))
+
+	require.Len(t, received, 3)
+	event := <-received
+	assert.Equal(t, EventRegistered, event.Type)
+	assert.Equal(t, "test", event.Address)
+
+	event = <-received
+	assert.Equal(t, EventAddressChanged, event.Type)
+	assert.Equal(t, parcel.Number, event.Number)
+	assert.Equal(t, "new test address", event.Address)
+
+	event = <-received
+	assert.Equal(t, EventStatusChanged, event.Type)
+	assert.Equal(t, ParcelStatusRegistered, event.OldStatus)
+	assert.Equal(t, ParcelStatusSent, event.Status)
+}
+
+// TestWebhookDeadLetter - тест для проверки повторных попыток и переноса недоставленного уведомления в dead letter
+func TestWebhookDeadLetter(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	// Получатель всегда отвечает ошибкой
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	store := NewParcelStore(db)
+	client := randRange.Intn(10_000_000)
+	id, err := store.AddWebhook(context.Background(), WebhookSubscription{
+		Client: client,
+		URL:    receiver.URL,
+		Secret: "secret",
+		Events: []string{EventStatusChanged},
+	})
+	require.NoError(t, err, "failed to add webhook. Error: %v", err)
+
+	cfg := testWebhookConfig()
+	notifier := NewWebhookNotifier(store, cfg)
+	service := NewParcelService(store, WithOutput(io.Discard))
+
+	parcel, err := service.Register(client, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+	// Регистрация и смена адреса не входят в события подписки и не доставляются
+	require.NoError(t, service.ChangeAddress(parcel.Number, "new test address"))
+	require.NoError(t, service.NextStatus(parcel.Number))
+	dispatchAll(t, NewOutboxDispatcher(store, DefaultOutboxConfig(), notifier))
+	deliverWebhooks(t, db, notifier, id)
+	require.NoError(t, notifier.Shutdown(context.Background()))
+
+	assert.Equal(t, int32(cfg.MaxAttempts), attempts.Load())
+
+	letters, err := store.GetWebhookDeadLetters(context.Background(), id)
+	require.NoError(t, err, "failed to retrieve dead letters. Error: %v", err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, EventStatusChanged, letters[0].EventType)
+	assert.Equal(t, cfg.MaxAttempts, letters[0].Attempts)
+	assert.Contains(t, letters[0].LastError, "503")
+}
+
+// TestWebhookDurableDelivery - тест для проверки того, что уведомление, не доставленное до остановки процесса,
+// сохраняется в базе и доставляется после перезапуска с тем же идентификатором
+func TestWebhookDurableDelivery(t *testing.T) {
+	// Подготовка окружения и автоматическое закрытие БД после теста
+	db := setupDatabase(t)
+	defer db.Close()
+
+	// Получатель недоступен, пока не включён available
+	var available atomic.Bool
+	ids := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids <- r.Header.Get(WebhookHeaderID)
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	store := NewParcelStore(db)
+	cleanOutbox(t, store)
+	client := randRange.Intn(10_000_000)
+	id, err := store.AddWebhook(context.Background(), WebhookSubscription{
+		Client: client,
+		URL:    receiver.URL,
+		Secret: "secret",
+		Events: []string{EventRegistered},
+	})
+	require.NoError(t, err, "failed to add webhook. Error: %v", err)
+
+	cfg := testWebhookConfig()
+	cfg.BaseDelay = time.Hour
+	cfg.MaxDelay = time.Hour
+	service := NewParcelService(store, WithOutput(io.Discard))
+	_, err = service.Register(client, "test")
+	require.NoError(t, err, "failed to register parcel. Error: %v", err)
+
+	// Событие outbox отмечается доставленным, когда уведомление записано в webhook_delivery
+	notifier := NewWebhookNotifier(store, cfg)
+	dispatchAll(t, NewOutboxDispatcher(store, DefaultOutboxConfig(), notifier))
+	pending, err := store.GetPendingEvents(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.Equal(t, 1, pendingWebhookDeliveries(t, db, id))
+
+	// Неудачная попытка откладывает следующую, уведомление остаётся в базе после остановки
+	n, err := notifier.DeliverPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = notifier.DeliverPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "next attempt should be delayed")
+	require.NoError(t, notifier.Shutdown(context.Background()))
+	assert.Equal(t, 1, pendingWebhookDeliveries(t, db, id))
+
+	// После перезапуска и наступления времени попытки уведомление доставляется
+	_, err = db.Exec("UPDATE webhook_delivery SET next_attempt_at = ? WHERE subscription = ?",
+		time.Now().UTC().Format(time.RFC3339), id)
+	require.NoError(t, err)
+	available.Store(true)
+	notifier = NewWebhookNotifier(store, cfg)
+	deliverWebhooks(t, db, notifier, id)
+	require.NoError(t, notifier.Shutdown(context.Background()))
+
+	require.Len(t, ids, 2)
+	first, second := <-ids, <-ids
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, second)
+
+	letters, err := store.GetWebhookDeadLetters(context.Background(), id)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+// TestWebhookPrivateAddress - тест для проверки того, что уведомления не отправляются на адреса
+// внутренней сети, даже если такой адрес уже записан в подписке
+func TestWebhookPrivateAddress(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	store := openTestStore(t)
+	ctx := context.Background()
+	const client = 1000
+	id, err := store.AddWebhook(ctx, WebhookSubscription{Client: client, URL: receiver.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	cfg := testWebhookConfig()
+	cfg.AllowPrivate = false
+	cfg.MaxAttempts = 1
+	notifier := NewWebhookNotifier(store, cfg)
+	require.NoError(t, notifier.Publish(ctx, ParcelEvent{ID: 1, Type: EventRegistered, Number: 1, Client: client, OccurredAt: time.Now()}))
+	_, err = notifier.DeliverPending(ctx)
+	require.NoError(t, err)
+	require.NoError(t, notifier.Shutdown(ctx))
+
+	assert.Zero(t, calls.Load())
+	letters, err := store.GetWebhookDeadLetters(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Contains(t, letters[0].LastError, "is not public")
+}
+
+// TestWebhookRepublish - тест для проверки того, что повторная публикация события не создаёт
+// второе уведомление и идентификатор уведомления определяется событием и подпиской
+func TestWebhookRepublish(t *testing.T) {
+	store := openTestStore(t)
+	ctx := context.Background()
+	const client = 1000
+	id, err := store.AddWebhook(ctx, WebhookSubscription{Client: client, URL: "http://localhost/hook", Secret: "secret"})
+	require.NoError(t, err)
+
+	notifier := NewWebhookNotifier(store, testWebhookConfig())
+	event := ParcelEvent{ID: 42, Type: EventRegistered, Number: 7, Client: client, OccurredAt: time.Now()}
+	require.NoError(t, notifier.Publish(ctx, event))
+	require.NoError(t, notifier.Publish(ctx, event))
+	require.NoError(t, notifier.Shutdown(ctx))
+
+	var count int
+	var deliveryID string
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*), MAX(delivery_id) FROM webhook_delivery WHERE subscription = ?", id).Scan(&count, &deliveryID))
+	assert.Equal(t, 1, count)
+	assert.Equal(t, fmt.Sprintf("evt-42-sub-%d", id), deliveryID)
+}
+
+// TestWebhookBackoff - тест для проверки экспоненциального роста задержки между попытками
+func TestWebhookBackoff(t *testing.T) {
+	cfg := WebhookConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	assert.Equal(t, time.Second, cfg.backoff(1))
+	assert.Equal(t, 2*time.Second, cfg.backoff(2))
+	assert.Equal(t, 8*time.Second, cfg.backoff(4))
+	assert.Equal(t, 10*time.Second, cfg.backoff(5))
+}
+
+// TestWebhookAPI - тест для проверки подписки клиента на уведомления через API
+func TestWebhookAPI(t *testing.T) {
+	store := openTestStore(t)
+	service := NewParcelService(store, WithOutput(io.Discard))
+	server := httptest.NewServer(NewServer(service, NewEventBroker(), nil, NewAuthenticator(store, testJWTKey), nil).Handler())
+	defer server.Close()
+
+	const client = 1000
+	owner := "Bearer " + testToken(t, RoleClient, client)
+	other := "Bearer " + testToken(t, RoleClient, client+1)
+	hooks := fmt.Sprintf("%s/clients/%d/webhooks", server.URL, client)
+
+	// Клиент подписывается на уведомления, ключ подписи генерируется и возвращается один раз
+	status, body := apiCall(t, http.MethodPost, hooks, "Authorization", owner, webhookRequest{URL: "https://198.51.100.7/hook", Events: []string{EventStatusChanged}})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var created WebhookSubscription
+	require.NoError(t, json.Unmarshal(body, &created))
+	assert.NotZero(t, created.ID)
+	assert.Equal(t, client, created.Client)
+	assert.Len(t, created.Secret, 64)
+	assert.Equal(t, []string{EventStatusChanged}, created.Events)
+
+	// В списке подписок ключ подписи не показывается
+	status, body = apiCall(t, http.MethodGet, hooks, "Authorization", owner, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var list []WebhookSubscription
+	require.NoError(t, json.Unmarshal(body, &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, created.ID, list[0].ID)
+	assert.Empty(t, list[0].Secret)
+	assert.NotContains(t, string(body), created.Secret)
+
+	// Некорректный адрес и неизвестный тип события отклоняются
+	for _, req := range []webhookRequest{
+		{URL: "ftp://shop.example/hook"},
+		{URL: "/hook"},
+		{URL: "https://198.51.100.7/hook", Events: []string{"parcel.unknown"}},
+		{URL: "https://198.51.100.7/hook", Secret: strings.Repeat("s", maxWebhookSecretLength+1)},
+		// адреса внутренней сети недоступны клиентам
+		{URL: "http://127.0.0.1:8080/hook"},
+		{URL: "http://localhost/hook"},
+		{URL: "http://10.0.0.5/hook"},
+		{URL: "http://192.168.1.1/hook"},
+		{URL: "http://169.254.169.254/latest/meta-data"},
+		{URL: "http://100.64.0.1/hook"},
+		{URL: "http://[::1]/hook"},
+		{URL: "http://[::ffff:127.0.0.1]/hook"},
+		{URL: "http://0.0.0.0/hook"},
+	} {
+		status, _ = apiCall(t, http.MethodPost, hooks, "Authorization", owner, req)
+		assert.Equal(t, http.StatusBadRequest, status, req)
+	}
+
+	// Без учётных данных и для чужого клиента подписки недоступны
+	status, _ = apiCall(t, http.MethodGet, hooks, "", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = apiCall(t, http.MethodGet, hooks, "Authorization", other, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = apiCall(t, http.MethodPost, hooks, "Authorization", other, webhookRequest{URL: "https://198.51.100.8/hook"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Чужую подписку нельзя удалить ни по своему, ни по чужому пути
+	foreign := fmt.Sprintf("%s/clients/%d/webhooks/%d", server.URL, client+1, created.ID)
+	status, _ = apiCall(t, http.MethodDelete, foreign, "Authorization", other, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = apiCall(t, http.MethodDelete, fmt.Sprintf("%s/%d", hooks, created.ID), "Authorization", other, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Владелец удаляет подписку
+	status, _ = apiCall(t, http.MethodDelete, fmt.Sprintf("%s/%d", hooks, created.ID), "Authorization", owner, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = apiCall(t, http.MethodDelete, fmt.Sprintf("%s/%d", hooks, created.ID), "Authorization", owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, body = apiCall(t, http.MethodGet, hooks, "Authorization", owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, "[]", string(body))
+}